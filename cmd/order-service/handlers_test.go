@@ -4,22 +4,39 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"path"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
 
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
+	"github.com/MikeMC777/ordenes-ecom/internal/config"
+	"github.com/MikeMC777/ordenes-ecom/internal/httpx"
+	"github.com/MikeMC777/ordenes-ecom/internal/idempotency"
+	"github.com/MikeMC777/ordenes-ecom/internal/jwt"
 	ord "github.com/MikeMC777/ordenes-ecom/internal/order"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+	"github.com/MikeMC777/ordenes-ecom/internal/user"
 	userpb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 //
@@ -30,55 +47,537 @@ import (
 type stubRepo struct {
 	lastOrder *ord.Order
 	lastItems []ord.Item
+	payments  map[string]bool // provider_ref ya procesados
+	// restocks que no se pudieron aplicar
+	restockFailures []ord.RestockFailure
+	refunds         []ord.Refund
+	sagas           map[string]*ord.Saga
+	// falla la persistencia de la orden (simula un error tras descontar stock)
+	createErr error
+	// se ejecuta al persistir, antes de createErr (p. ej. para cancelar la solicitud)
+	onCreate func()
+	// todas las órdenes creadas, en orden de creación, y sus items por id de orden
+	history      []ord.Order
+	itemsByOrder map[string][]ord.Item
+	// tope de filas de ListByUser, como MAX_LIST_ROWS (0 usa ord.DefaultMaxListRows)
+	maxListRows int
+	// UpdateStatus falla para estas órdenes
+	statusErr map[string]error
+	// GetByID falla con este error (p. ej. la conexión a la base se cayó)
+	getErr error
+	// líneas pagadas por día (YYYY-MM-DD) y filas de daily_sales por "día|producto"
+	paidLines  map[string][]ord.SaleLine
+	dailySales map[string]ord.DailySale
 }
 
 func (s *stubRepo) Create(ctx context.Context, o *ord.Order, items []ord.Item) error {
+	if s.onCreate != nil {
+		s.onCreate()
+	}
+	if s.createErr != nil {
+		return s.createErr
+	}
 	// save to memory
 	cp := *o
 	s.lastOrder = &cp
 	s.lastItems = append([]ord.Item(nil), items...)
+	s.history = append(s.history, cp)
+	if s.itemsByOrder == nil {
+		s.itemsByOrder = map[string][]ord.Item{}
+	}
+	s.itemsByOrder[o.ID] = s.lastItems
+	return nil
+}
+
+func (s *stubRepo) StatusCounts(ctx context.Context, userID string) (map[ord.Status]int, error) {
+	counts := map[ord.Status]int{}
+	for _, st := range ord.Statuses {
+		counts[st] = 0
+	}
+	for _, o := range s.history {
+		if o.UserID == userID {
+			counts[o.Status]++
+		}
+	}
+	return counts, nil
+}
+
+func (s *stubRepo) ProductTotals(ctx context.Context, userID string) ([]ord.ProductTotal, error) {
+	out := []ord.ProductTotal{}
+	at := map[string]int{}
+	spent := map[string]decimal.Decimal{}
+	for _, o := range s.history {
+		if o.UserID != userID || !o.Status.Settled() {
+			continue
+		}
+		seen := map[string]bool{}
+		for _, it := range s.itemsByOrder[o.ID] {
+			i, ok := at[it.ProductID]
+			if !ok {
+				i = len(out)
+				at[it.ProductID] = i
+				out = append(out, ord.ProductTotal{ProductID: it.ProductID})
+			}
+			out[i].Quantity += it.Quantity
+			if !seen[it.ProductID] {
+				seen[it.ProductID] = true
+				out[i].Orders++
+			}
+			spent[it.ProductID] = spent[it.ProductID].Add(decimal.RequireFromString(it.LineTotal))
+		}
+	}
+	for i := range out {
+		out[i].Spent = spent[out[i].ProductID].StringFixed(2)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Quantity != out[j].Quantity {
+			return out[i].Quantity > out[j].Quantity
+		}
+		return out[i].ProductID < out[j].ProductID
+	})
+	return out, nil
+}
+
+func (s *stubRepo) ActiveQuantity(ctx context.Context, productID string) (int, error) {
+	n := 0
+	for _, o := range s.history {
+		if o.Status == ord.StatusCanceled {
+			continue
+		}
+		for _, it := range s.itemsByOrder[o.ID] {
+			if it.ProductID == productID {
+				n += it.Quantity
+			}
+		}
+	}
+	return n, nil
+}
+
+// RelatedProducts cuenta, por producto, las órdenes de history (salvo draft y canceled) que
+// comparte con productID.
+func (s *stubRepo) RelatedProducts(ctx context.Context, productID string, limit int) ([]ord.RelatedProduct, error) {
+	counts := map[string]int{}
+	for _, o := range s.history {
+		if o.Status == ord.StatusDraft || o.Status == ord.StatusCanceled {
+			continue
+		}
+		items, has := s.itemsByOrder[o.ID], false
+		for _, it := range items {
+			has = has || it.ProductID == productID
+		}
+		if !has {
+			continue
+		}
+		seen := map[string]bool{productID: true}
+		for _, it := range items {
+			if !seen[it.ProductID] {
+				seen[it.ProductID] = true
+				counts[it.ProductID]++
+			}
+		}
+	}
+	out := []ord.RelatedProduct{}
+	for id, n := range counts {
+		out = append(out, ord.RelatedProduct{ProductID: id, Orders: n})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Orders != out[j].Orders {
+			return out[i].Orders > out[j].Orders
+		}
+		return out[i].ProductID < out[j].ProductID
+	})
+	if limit < len(out) {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *stubRepo) LatestByUser(ctx context.Context, userID string) (*ord.Order, []ord.Item, error) {
+	for i := len(s.history) - 1; i >= 0; i-- {
+		if s.history[i].UserID == userID {
+			o := s.history[i]
+			items := []ord.Item{}
+			for _, it := range s.lastItems {
+				if it.OrderID == o.ID {
+					items = append(items, it)
+				}
+			}
+			return &o, items, nil
+		}
+	}
+	return nil, nil, ord.ErrNotFound
+}
+
+func (s *stubRepo) StartSaga(ctx context.Context, orderID string) error {
+	if s.sagas == nil {
+		s.sagas = map[string]*ord.Saga{}
+	}
+	s.sagas[orderID] = &ord.Saga{OrderID: orderID, State: ord.SagaStarted, UpdatedAt: time.Now()}
+	return nil
+}
+
+func (s *stubRepo) RecordSagaStep(ctx context.Context, orderID, productID string, qty int) (int64, error) {
+	sg := s.sagas[orderID]
+	id := int64(len(sg.Steps) + 1)
+	sg.Steps = append(sg.Steps, ord.SagaStep{ID: id, ProductID: productID, Quantity: qty})
+	sg.UpdatedAt = time.Now()
+	return id, nil
+}
+
+func (s *stubRepo) CompensateSagaStep(ctx context.Context, stepID int64) error {
+	for _, sg := range s.sagas {
+		for i := range sg.Steps {
+			if sg.Steps[i].ID == stepID {
+				sg.Steps[i].Compensated = true
+			}
+		}
+	}
+	return nil
+}
+
+func (s *stubRepo) FinishSaga(ctx context.Context, orderID string, state ord.SagaState) error {
+	if sg, ok := s.sagas[orderID]; ok && sg.State == ord.SagaStarted {
+		sg.State = state
+		sg.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (s *stubRepo) StaleSagas(ctx context.Context, olderThan time.Duration) ([]ord.Saga, error) {
+	var out []ord.Saga
+	for _, sg := range s.sagas {
+		if sg.State != ord.SagaStarted || time.Since(sg.UpdatedAt) < olderThan {
+			continue
+		}
+		cp := *sg
+		cp.Steps = append([]ord.SagaStep(nil), sg.Steps...)
+		cp.OrderExists = s.lastOrder != nil && s.lastOrder.ID == sg.OrderID
+		out = append(out, cp)
+	}
+	return out, nil
+}
+
+func (s *stubRepo) Tenants(ctx context.Context) ([]string, error) {
+	return []string{tenant.Default}, nil
+}
+
+func (s *stubRepo) SalesTenants(ctx context.Context, day time.Time) ([]string, error) {
+	return []string{tenant.Default}, nil
+}
+
+func (s *stubRepo) PaidLines(ctx context.Context, day time.Time) ([]ord.SaleLine, error) {
+	return s.paidLines[ord.Day(day).Format(ord.DateLayout)], nil
+}
+
+func (s *stubRepo) SaveDailySales(ctx context.Context, day time.Time, sales []ord.DailySale) error {
+	if s.dailySales == nil {
+		s.dailySales = map[string]ord.DailySale{}
+	}
+	date := ord.Day(day).Format(ord.DateLayout)
+	for k, v := range s.dailySales {
+		if v.Date == date {
+			delete(s.dailySales, k)
+		}
+	}
+	for _, v := range sales {
+		s.dailySales[v.Date+"|"+v.ProductID] = v
+	}
 	return nil
 }
 
+func (s *stubRepo) DailySales(ctx context.Context, from, to time.Time) ([]ord.DailySale, error) {
+	out := []ord.DailySale{}
+	for _, v := range s.dailySales {
+		if v.Date >= from.Format(ord.DateLayout) && v.Date <= to.Format(ord.DateLayout) {
+			out = append(out, v)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Date != out[j].Date {
+			return out[i].Date < out[j].Date
+		}
+		return out[i].ProductID < out[j].ProductID
+	})
+	return out, nil
+}
+
 func (s *stubRepo) GetByID(ctx context.Context, id string) (*ord.Order, []ord.Item, error) {
-	if s.lastOrder == nil || s.lastOrder.ID != id {
-		return nil, nil, fmt.Errorf("not found")
+	if s.getErr != nil {
+		return nil, nil, s.getErr
+	}
+	if s.lastOrder != nil && s.lastOrder.ID == id {
+		return s.lastOrder, s.lastItems, nil
 	}
-	return s.lastOrder, s.lastItems, nil
+	if o := s.historyOrder(id); o != nil {
+		return o, s.itemsByOrder[id], nil
+	}
+	return nil, nil, ord.ErrNotFound
 }
 
 func (s *stubRepo) GetItems(ctx context.Context, orderID string) ([]ord.Item, error) {
-	if s.lastOrder == nil || s.lastOrder.ID != orderID {
-		return nil, fmt.Errorf("not found")
+	_, items, err := s.GetByID(ctx, orderID)
+	return items, err
+}
+
+// historyOrder es la orden id de history (para modificarla en el lugar), o nil.
+func (s *stubRepo) historyOrder(id string) *ord.Order {
+	for i := range s.history {
+		if s.history[i].ID == id {
+			return &s.history[i]
+		}
+	}
+	return nil
+}
+
+// userOrders son las órdenes del usuario que pasan f, más recientes primero: history más
+// lastOrder si no está en ella.
+func (s *stubRepo) userOrders(userID string, f ord.ListFilter) []ord.Order {
+	out := []ord.Order{}
+	seen := false
+	for i := len(s.history) - 1; i >= 0; i-- {
+		o, items := s.history[i], s.itemsByOrder[s.history[i].ID]
+		if s.lastOrder != nil && o.ID == s.lastOrder.ID {
+			// lastOrder refleja los cambios posteriores (estado, total)
+			o, items, seen = *s.lastOrder, s.lastItems, true
+		}
+		if o.UserID == userID && f.Match(o) {
+			o.ItemCount = len(items)
+			out = append(out, o)
+		}
+	}
+	if s.lastOrder != nil && !seen && s.lastOrder.UserID == userID && f.Match(*s.lastOrder) {
+		o := *s.lastOrder
+		o.ItemCount = len(s.lastItems)
+		out = append([]ord.Order{o}, out...)
+	}
+	return out
+}
+
+func (s *stubRepo) ListByUser(ctx context.Context, userID string, f ord.ListFilter, limit, offset int) ([]ord.Order, bool, error) {
+	ceiling := s.maxListRows
+	if ceiling <= 0 {
+		ceiling = ord.DefaultMaxListRows
+	}
+	capped := limit > ceiling
+	if capped {
+		limit = ceiling
+	}
+	out := s.userOrders(userID, f)
+	if offset >= len(out) {
+		return []ord.Order{}, false, nil
+	}
+	out = out[offset:]
+	if limit < len(out) {
+		return out[:limit], capped, nil
+	}
+	return out, false, nil
+}
+
+func (s *stubRepo) CountByUser(ctx context.Context, userID string, f ord.ListFilter) (int, error) {
+	return len(s.userOrders(userID, f)), nil
+}
+
+func (s *stubRepo) HasOrders(ctx context.Context, userID string) (bool, error) {
+	return s.lastOrder != nil && s.lastOrder.UserID == userID, nil
+}
+
+func (s *stubRepo) ReassignUser(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	moved := 0
+	for i := range s.history {
+		if s.history[i].UserID == fromUserID {
+			s.history[i].UserID = toUserID
+			moved++
+		}
+	}
+	if s.lastOrder != nil && s.lastOrder.UserID == fromUserID {
+		s.lastOrder.UserID = toUserID
+	}
+	return moved, nil
+}
+
+func (s *stubRepo) UpdateStatus(ctx context.Context, id string, status ord.Status) error {
+	o, _, err := s.GetByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if s.statusErr[id] != nil {
+		return s.statusErr[id]
+	}
+	now := time.Now()
+	o.Status = status
+	o.UpdatedAt = now
+	switch {
+	case status == ord.StatusShipped && o.ShippedAt == nil:
+		o.ShippedAt = &now
+	case status == ord.StatusDelivered && o.DeliveredAt == nil:
+		o.DeliveredAt = &now
+	}
+	return nil
+}
+
+func (s *stubRepo) RecordRestockFailure(ctx context.Context, f *ord.RestockFailure) error {
+	f.At = time.Now()
+	s.restockFailures = append(s.restockFailures, *f)
+	return nil
+}
+
+func (s *stubRepo) CreateRefund(ctx context.Context, rf *ord.Refund, places int32) (string, error) {
+	o := s.lastOrder
+	if o == nil || o.ID != rf.OrderID {
+		return "", ord.ErrNotFound
+	}
+	if !o.Status.Settled() {
+		return "", ord.ErrNotPaid
+	}
+	refunded := decimal.Zero
+	for _, prev := range s.refunds {
+		refunded = refunded.Add(decimal.RequireFromString(prev.Amount))
+	}
+	after, err := ord.CheckRefundAmount(decimal.RequireFromString(o.Total), refunded, decimal.RequireFromString(rf.Amount))
+	if err != nil {
+		return "", err
+	}
+	for _, it := range rf.Items {
+		left := 0
+		for _, oi := range s.lastItems {
+			if oi.ProductID == it.ProductID {
+				left += oi.Quantity
+			}
+		}
+		for _, prev := range s.refunds {
+			for _, pi := range prev.Items {
+				if pi.ProductID == it.ProductID {
+					left -= pi.Quantity
+				}
+			}
+		}
+		if it.Quantity > left {
+			return "", ord.ErrRefundItems
+		}
+	}
+	rf.At = time.Now()
+	s.refunds = append(s.refunds, *rf)
+	return after.StringFixed(places), nil
+}
+
+func (s *stubRepo) ListRefunds(ctx context.Context, orderID string) ([]ord.Refund, error) {
+	return append([]ord.Refund{}, s.refunds...), nil
+}
+
+func (s *stubRepo) MarkPaid(ctx context.Context, id string) (bool, error) {
+	o := s.lastOrder
+	if o == nil || o.ID != id {
+		return false, ord.ErrNotFound
+	}
+	switch {
+	case o.Status.Settled():
+		return false, nil
+	case o.Status == ord.StatusPending,
+		o.Status == ord.StatusDraft && o.ExpiresAt != nil && o.ExpiresAt.After(time.Now()):
+		now := time.Now()
+		o.Status, o.PaidAt, o.ExpiresAt = ord.StatusPaid, &now, nil
+		return true, nil
 	}
-	return s.lastItems, nil
+	return false, ord.ErrInvalidTransition
 }
 
-func (s *stubRepo) ListByUser(ctx context.Context, userID string, limit, offset int) ([]ord.Order, error) {
-	if s.lastOrder != nil && s.lastOrder.UserID == userID {
-		return []ord.Order{*s.lastOrder}, nil
+func (s *stubRepo) RecomputeTotal(ctx context.Context, id string, places int32) (string, string, error) {
+	if s.lastOrder == nil || s.lastOrder.ID != id {
+		return "", "", ord.ErrNotFound
+	}
+	_, _, total, err := ord.ComputeOrderTotal(s.lastItems, "", "", places)
+	if err != nil {
+		return "", "", err
+	}
+	old := s.lastOrder.Total
+	s.lastOrder.Total = total
+	return old, s.lastOrder.Total, nil
+}
+
+func (s *stubRepo) AddItem(ctx context.Context, orderID string, it *ord.Item, places int32) (string, error) {
+	if s.lastOrder == nil || s.lastOrder.ID != orderID {
+		return "", ord.ErrNotFound
 	}
-	return []ord.Order{}, nil
+	it.OrderID = orderID
+	it.LineNo = len(s.lastItems) + 1
+	s.lastItems = append(s.lastItems, *it)
+	_, _, total, err := ord.ComputeOrderTotal(s.lastItems, "", "", places)
+	if err != nil {
+		return "", err
+	}
+	s.lastOrder.Total = total
+	return total, nil
 }
 
-func (s *stubRepo) UpdateStatus(ctx context.Context, id, status string) error {
+func (s *stubRepo) CommitDraft(ctx context.Context, id string) error {
 	if s.lastOrder == nil || s.lastOrder.ID != id {
-		return fmt.Errorf("not found")
+		return ord.ErrNotFound
+	}
+	if s.lastOrder.Status != ord.StatusDraft {
+		return ord.ErrNotDraft
 	}
-	s.lastOrder.Status = status
+	if s.lastOrder.ExpiresAt == nil || !s.lastOrder.ExpiresAt.After(time.Now()) {
+		return ord.ErrDraftExpired
+	}
+	s.lastOrder.Status = ord.StatusPending
+	s.lastOrder.ExpiresAt = nil
 	return nil
 }
 
+func (s *stubRepo) ExpireDrafts(ctx context.Context) ([]string, error) {
+	o := s.lastOrder
+	if o == nil || o.Status != ord.StatusDraft || o.ExpiresAt == nil || o.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+	o.Status = ord.StatusCanceled
+	return []string{o.ID}, nil
+}
+
+func (s *stubRepo) ApplyPayment(ctx context.Context, ev ord.PaymentEvent) (bool, error) {
+	if s.lastOrder == nil || s.lastOrder.ID != ev.OrderID {
+		return false, ord.ErrNotFound
+	}
+	if s.payments[ev.ProviderRef] {
+		return false, nil
+	}
+	if ev.Status == string(ord.StatusPaid) && !s.lastOrder.Status.Settled() {
+		if s.lastOrder.Status != ord.StatusPending {
+			return false, ord.ErrInvalidTransition
+		}
+		s.lastOrder.Status = ord.StatusPaid
+	}
+	if s.payments == nil {
+		s.payments = map[string]bool{}
+	}
+	s.payments[ev.ProviderRef] = true
+	return true, nil
+}
+
 // fakeUserClient implements userpb.UserServiceClient, but only uses ValidateUser.
+// RPCs not listed below are served by the nil embedded client (they are never called).
 type fakeUserClient struct {
+	userpb.UserServiceClient
 	ok bool
+	// si no es nil, ValidateUser falla con este error (user-service caído)
+	err error
+	// sesiones válidas: token -> user_id
+	sessions map[string]string
 }
 
 func (f *fakeUserClient) ValidateUser(ctx context.Context, in *userpb.ValidateUserRequest, opts ...grpc.CallOption) (*userpb.ValidateUserResponse, error) {
 	// Returns valid according to configuration
+	if f.err != nil {
+		return nil, f.err
+	}
 	return &userpb.ValidateUserResponse{Ok: f.ok}, nil
 }
 
+// VerifySession resuelve los tokens de sessions (token -> user_id); el resto no es válido.
+func (f *fakeUserClient) VerifySession(ctx context.Context, in *userpb.VerifySessionRequest, opts ...grpc.CallOption) (*userpb.VerifySessionResponse, error) {
+	uid, ok := f.sessions[in.GetSessionId()]
+	return &userpb.VerifySessionResponse{Ok: ok, UserId: uid}, nil
+}
+
 // The other methods are not used by the handler, but the interface requires them.
 // Minimum implementations for compilation:
 func (f *fakeUserClient) CreateUser(context.Context, *userpb.CreateUserRequest, ...grpc.CallOption) (*userpb.UserResponse, error) {
@@ -97,21 +596,58 @@ func (f *fakeUserClient) AuthenticateUser(context.Context, *userpb.AuthRequest,
 	return nil, fmt.Errorf("not implemented")
 }
 
+// fakeHealthClient responde el estado fijo de user-service; err simula que no contesta.
+type fakeHealthClient struct {
+	healthpb.HealthClient
+	status healthpb.HealthCheckResponse_ServingStatus
+	err    error
+}
+
+func (f *fakeHealthClient) Check(context.Context, *healthpb.HealthCheckRequest, ...grpc.CallOption) (*healthpb.HealthCheckResponse, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &healthpb.HealthCheckResponse{Status: f.status}, nil
+}
+
+// fakePinger simula el Ping del pool de Postgres.
+type fakePinger struct{ err error }
+
+func (f fakePinger) Ping(context.Context) error { return f.err }
+
 // productFake sirve GET /products/:id y PUT /products/:id manteniendo stock en memoria.
 type productState struct {
 	ID    string `json:"id"`
 	Name  string `json:"name"`
 	Price string `json:"price"`
 	Stock int    `json:"stock"`
+	// vacío: producto de un product-service sin status
+	Status string `json:"status,omitempty"`
+	// admite backorders: el stock puede quedar negativo
+	AllowBackorder bool `json:"allow_backorder,omitempty"`
+	// stock_reason de cada PUT recibido
+	Reasons []string `json:"-"`
 }
 
 func newProductServer(t *testing.T, initial productState) (*httptest.Server, *productState) {
 	t.Helper()
-	state := &productState{
-		ID:    initial.ID,
-		Name:  ifEmpty(initial.Name, "TestProd"),
-		Price: ifEmpty(initial.Price, "10.00"),
-		Stock: initial.Stock,
+	srv, states := newProductsServer(t, initial)
+	return srv, states[initial.ID]
+}
+
+// newProductsServer es newProductServer con varios productos.
+func newProductsServer(t *testing.T, initial ...productState) (*httptest.Server, map[string]*productState) {
+	t.Helper()
+	states := map[string]*productState{}
+	for _, in := range initial {
+		states[in.ID] = &productState{
+			ID:             in.ID,
+			Name:           ifEmpty(in.Name, "TestProd"),
+			Price:          ifEmpty(in.Price, "10.00"),
+			Stock:          in.Stock,
+			Status:         in.Status,
+			AllowBackorder: in.AllowBackorder,
+		}
 	}
 	mux := http.NewServeMux()
 
@@ -119,29 +655,117 @@ func newProductServer(t *testing.T, initial productState) (*httptest.Server, *pr
 		_, _ = w.Write([]byte("ok"))
 	})
 
+	var mu sync.Mutex           // serializa como lo haría la fila en Postgres
+	ledger := map[string]bool{} // restocks idempotentes ya aplicados (order_id/product_id)
+	taken := map[string]bool{}  // decrementos por línea ya aplicados (order_id/item_id)
+	var movements []ord.StockMovement
+	move := func(state *productState, delta int, reason, orderID string) {
+		movements = append(movements, ord.StockMovement{
+			ID: int64(len(movements) + 1), ProductID: state.ID, Delta: delta, Reason: reason,
+			ResultingStock: state.Stock, OrderID: orderID, At: time.Now(),
+		})
+	}
+	mux.HandleFunc("/products/stock-movements", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		orderID := r.URL.Query().Get("order_id")
+		items := []ord.StockMovement{}
+		for _, m := range movements {
+			if m.OrderID == orderID {
+				items = append(items, m)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]any{"order_id": orderID, "items": items})
+	})
 	mux.HandleFunc("/products/", func(w http.ResponseWriter, r *http.Request) {
-		id := path.Base(r.URL.Path)
-		if id != state.ID {
+		mu.Lock()
+		defer mu.Unlock()
+		id, restock := strings.CutSuffix(r.URL.Path, "/restock")
+		id, decrement := strings.CutSuffix(id, "/decrement")
+		id, increment := strings.CutSuffix(id, "/increment")
+		state, ok := states[path.Base(id)]
+		if !ok {
 			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
 			return
 		}
+		if (decrement || increment) && r.Method == http.MethodPost {
+			var body struct {
+				Qty     int    `json:"qty"`
+				Reason  string `json:"reason"`
+				OrderID string `json:"order_id"`
+				ItemID  string `json:"item_id"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Qty <= 0 {
+				http.Error(w, `{"error":"qty must be positive"}`, http.StatusUnprocessableEntity)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			key := body.OrderID + "/" + body.ItemID
+			if decrement && body.ItemID != "" && taken[key] {
+				_ = json.NewEncoder(w).Encode(map[string]any{"id": state.ID, "stock": state.Stock, "applied": false})
+				return
+			}
+			if decrement {
+				if state.Stock < body.Qty && !state.AllowBackorder {
+					w.WriteHeader(http.StatusConflict)
+					_ = json.NewEncoder(w).Encode(map[string]any{"error": "insufficient stock", "available": state.Stock})
+					return
+				}
+				state.Stock -= body.Qty
+				if body.ItemID != "" {
+					taken[key] = true
+				}
+				move(state, -body.Qty, body.Reason, body.OrderID)
+			} else {
+				state.Stock += body.Qty
+				move(state, body.Qty, body.Reason, body.OrderID)
+			}
+			state.Reasons = append(state.Reasons, body.Reason)
+			_ = json.NewEncoder(w).Encode(map[string]any{"id": state.ID, "stock": state.Stock})
+			return
+		}
+		if restock && r.Method == http.MethodPost {
+			var body struct {
+				OrderID string `json:"order_id"`
+				Qty     int    `json:"qty"`
+				Reason  string `json:"reason"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Qty <= 0 {
+				http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
+				return
+			}
+			key := body.OrderID + "/" + state.ID
+			applied := !ledger[key]
+			if applied {
+				ledger[key] = true
+				state.Stock += body.Qty
+				state.Reasons = append(state.Reasons, body.Reason)
+				move(state, body.Qty, body.Reason, body.OrderID)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{"id": state.ID, "stock": state.Stock, "applied": applied})
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(state)
 		case http.MethodPut:
 			var body struct {
-				Stock *int `json:"stock"`
+				Stock       *int   `json:"stock"`
+				StockReason string `json:"stock_reason"`
 			}
 			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Stock == nil {
 				http.Error(w, `{"error":"invalid json"}`, http.StatusBadRequest)
 				return
 			}
-			if *body.Stock < 0 {
+			if *body.Stock < 0 && !state.AllowBackorder {
 				http.Error(w, `{"error":"stock must be non-negative"}`, http.StatusBadRequest)
 				return
 			}
 			state.Stock = *body.Stock
+			state.Reasons = append(state.Reasons, body.StockReason)
 			w.Header().Set("Content-Type", "application/json")
 			_ = json.NewEncoder(w).Encode(state)
 		default:
@@ -150,7 +774,7 @@ func newProductServer(t *testing.T, initial productState) (*httptest.Server, *pr
 	})
 
 	srv := httptest.NewServer(mux)
-	return srv, state
+	return srv, states
 }
 
 func ifEmpty(s, def string) string {
@@ -188,7 +812,7 @@ func TestCreateOrder_HappyPath(t *testing.T) {
 	// Router con el handler real
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.POST("/orders", createOrderHandler(repo, ext))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
 
 	// Body: 2 unidades => descuenta stock
 	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), prodID)
@@ -209,6 +833,85 @@ func TestCreateOrder_HappyPath(t *testing.T) {
 	if pstate.Stock != 3 {
 		t.Fatalf("stock esperado=3, real=%d", pstate.Stock)
 	}
+	// y el movimiento se informa como "order"
+	if len(pstate.Reasons) != 1 || pstate.Reasons[0] != ord.StockReasonOrder {
+		t.Fatalf("stock_reason=%v, esperaba [order]", pstate.Reasons)
+	}
+	// la saga queda cerrada
+	if sg := repo.sagas[repo.lastOrder.ID]; sg == nil || sg.State != ord.SagaCompleted {
+		t.Fatalf("saga=%+v, esperaba completed", sg)
+	}
+}
+
+// ===== GET /orders/:id/stock-movements =====
+func TestOrderStockMovements_FromCreation(t *testing.T) {
+	t.Parallel()
+
+	psrv, states := newProductsServer(t,
+		productState{ID: uuid.NewString(), Price: "15.00", Stock: 5},
+		productState{ID: uuid.NewString(), Price: "4.00", Stock: 9},
+	)
+	defer psrv.Close()
+	ids := make([]string, 0, len(states))
+	for id := range states {
+		ids = append(ids, id)
+	}
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+	repo := &stubRepo{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.GET("/orders/:id/stock-movements", getOrderStockMovementsHandler(repo, ext))
+
+	create := func() string {
+		t.Helper()
+		body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2},{"product_id":%q,"quantity":3}]}`, uuid.NewString(), ids[0], ids[1])
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create: status=%d body=%s", w.Code, w.Body.String())
+		}
+		return repo.lastOrder.ID
+	}
+	// el stub solo resuelve la última orden: la otra se crea antes
+	other := create()
+	first := create()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+first+"/stock-movements", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Items []ord.StockMovement `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	// los dos descuentos de la creación, y ninguno de la otra orden
+	want := map[string]int{ids[0]: -2, ids[1]: -3}
+	if len(resp.Items) != len(want) {
+		t.Fatalf("movimientos=%d, esperaba %d: %s", len(resp.Items), len(want), w.Body.String())
+	}
+	for _, m := range resp.Items {
+		if m.OrderID != first || m.Reason != ord.StockReasonOrder || want[m.ProductID] != m.Delta {
+			t.Fatalf("movimiento inesperado %+v (orden %s, otra %s)", m, first, other)
+		}
+	}
+
+	// orden inexistente
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+uuid.NewString()+"/stock-movements", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status=%d, esperaba 404", w.Code)
+	}
 }
 
 func TestCreateOrder_InsufficientStock(t *testing.T) {
@@ -232,7 +935,7 @@ func TestCreateOrder_InsufficientStock(t *testing.T) {
 
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.POST("/orders", createOrderHandler(repo, ext))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
 
 	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), prodID)
 	w := httptest.NewRecorder()
@@ -247,257 +950,3585 @@ func TestCreateOrder_InsufficientStock(t *testing.T) {
 	}
 }
 
-// ===== GET /orders/:id (not found) =====
-func TestGetOrder_NotFound(t *testing.T) {
+func TestCreateOrder_InsufficientStockBody(t *testing.T) {
 	t.Parallel()
 
-	repo := &stubRepo{} // vacío
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-	r.GET("/orders/:id", getOrderHandler(repo))
+	type conflict struct {
+		Error     string `json:"error"`
+		ProductID string `json:"product_id"`
+		Requested int    `json:"requested"`
+		Available int    `json:"available"`
+	}
+	post := func(t *testing.T, psrvURL string, items string) *httptest.ResponseRecorder {
+		t.Helper()
+		ext := &ord.Ext{
+			HTTP:           &http.Client{Timeout: 2 * time.Second},
+			User:           &fakeUserClient{ok: true},
+			ProductBaseURL: psrvURL,
+		}
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/orders", createOrderHandler(&stubRepo{}, ext, defaultOrderOptions()))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(fmt.Sprintf(`{"user_id":%q,"items":%s}`, uuid.NewString(), items)))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	check := func(t *testing.T, w *httptest.ResponseRecorder, want conflict) {
+		t.Helper()
+		var got conflict
+		if w.Code != http.StatusConflict || json.Unmarshal(w.Body.Bytes(), &got) != nil || got != want {
+			t.Fatalf("status=%d body=%s, esperaba 409 %+v", w.Code, w.Body.String(), want)
+		}
+	}
 
-	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/orders/"+uuid.NewString(), nil)
-	r.ServeHTTP(w, req)
+	t.Run("pre-flight suma las líneas", func(t *testing.T) {
+		t.Parallel()
+		a := uuid.NewString()
+		psrv, state := newProductServer(t, productState{ID: a, Stock: 3})
+		defer psrv.Close()
 
-	if w.Code != http.StatusNotFound {
-		t.Fatalf("status=%d body=%s (esperaba 404)", w.Code, w.Body.String())
-	}
+		w := post(t, psrv.URL, fmt.Sprintf(`[{"product_id":%q,"quantity":2},{"product_id":%q,"quantity":2}]`, a, a))
+		check(t, w, conflict{"insufficient stock", a, 4, 3})
+		if state.Stock != 3 {
+			t.Fatalf("stock=%d, esperaba 3", state.Stock)
+		}
+	})
+
+	t.Run("stock vendido entre pre-flight y descuento", func(t *testing.T) {
+		t.Parallel()
+		a := uuid.NewString()
+		psrv, state := newProductServer(t, productState{ID: a, Stock: 5})
+		defer psrv.Close()
+		// otro comprador se lleva 3 unidades justo después del primer descuento
+		inner, stolen := psrv.Config.Handler, false
+		psrv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inner.ServeHTTP(w, r)
+			if strings.HasSuffix(r.URL.Path, "/decrement") && !stolen {
+				stolen = true
+				state.Stock -= 3
+			}
+		})
+
+		w := post(t, psrv.URL, fmt.Sprintf(`[{"product_id":%q,"quantity":1},{"product_id":%q,"quantity":2}]`, a, a))
+		// tras el rollback quedan 2: lo que se informa como disponible
+		check(t, w, conflict{"insufficient stock", a, 3, 2})
+		if state.Stock != 2 {
+			t.Fatalf("stock=%d, esperaba 2 tras el rollback", state.Stock)
+		}
+	})
 }
 
-// ===== GET /orders/:id/items =====
-func TestGetOrderItems_OK(t *testing.T) {
+func TestAdjustStock_ConcurrentDecrements(t *testing.T) {
 	t.Parallel()
 
-	oid := uuid.NewString()
-	repo := &stubRepo{
-		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
-		lastItems: []ord.Item{{
-			ID:        uuid.NewString(),
-			OrderID:   oid,
-			ProductID: uuid.NewString(),
-			Quantity:  2,
-			Price:     "10.00",
-		}},
+	// 20 compradores a la vez sobre 5 unidades: solo 5 se llevan una, nunca stock negativo
+	id := uuid.NewString()
+	psrv, state := newProductServer(t, productState{ID: id, Stock: 5})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	const n = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		ok, sold int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := ext.AdjustStock(context.Background(), id, -1, ord.StockReasonOrder, uuid.NewString())
+			var se *ord.StockError
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				ok++
+			case errors.As(err, &se):
+				sold++
+			default:
+				t.Errorf("error inesperado: %v", err)
+			}
+		}()
 	}
+	wg.Wait()
 
-	gin.SetMode(gin.TestMode)
-	r := gin.New()
-	r.GET("/orders/:id/items", getOrderItemsHandler(repo))
+	if ok != 5 || sold != n-5 {
+		t.Fatalf("aceptados=%d sin stock=%d, esperaba 5 y %d", ok, sold, n-5)
+	}
+	if state.Stock != 0 {
+		t.Fatalf("stock=%d, esperaba 0", state.Stock)
+	}
+}
 
-	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/items", nil)
-	r.ServeHTTP(w, req)
+func TestCreateOrder_Backorder(t *testing.T) {
+	t.Parallel()
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	// mismo pedido por encima del stock: solo el producto con backorders lo acepta
+	back, strict := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t,
+		productState{ID: back, Stock: 2, AllowBackorder: true},
+		productState{ID: strict, Stock: 2},
+	)
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
 	}
 
-	// 1) intenta []ord.Item
-	var arr []ord.Item
-	if err := json.Unmarshal(w.Body.Bytes(), &arr); err == nil {
-		if len(arr) != 1 {
-			t.Fatalf("items len=%d, esperaba 1 (formato array)", len(arr))
-		}
-		return
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(&stubRepo{}, ext, defaultOrderOptions()))
+	post := func(id string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":5}]}`, uuid.NewString(), id)))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
 	}
-	// 2) intenta {"items":[...]}
-	var wrap struct {
-		Items []ord.Item `json:"items"`
+
+	if w := post(back); w.Code != http.StatusCreated {
+		t.Fatalf("backorder: status=%d body=%s, esperaba 201", w.Code, w.Body.String())
 	}
-	if err := json.Unmarshal(w.Body.Bytes(), &wrap); err != nil {
-		t.Fatalf("json inválido: %v", err)
+	if got := states[back].Stock; got != -3 {
+		t.Fatalf("backorder: stock=%d, esperaba -3", got)
 	}
-	if len(wrap.Items) != 1 {
-		t.Fatalf("items len=%d, esperaba 1 (formato objeto)", len(wrap.Items))
+
+	if w := post(strict); w.Code != http.StatusConflict {
+		t.Fatalf("sin backorder: status=%d body=%s, esperaba 409", w.Code, w.Body.String())
+	}
+	if got := states[strict].Stock; got != 2 {
+		t.Fatalf("sin backorder: stock=%d, esperaba 2 intacto", got)
 	}
 }
 
-// ===== GET /orders/user/:user_id =====
-func TestListOrdersByUser_OK(t *testing.T) {
+// ===== line_no: numeración de líneas para la factura =====
+func TestCreateOrder_LineNumbers(t *testing.T) {
 	t.Parallel()
 
-	uid := uuid.NewString()
-	repo := &stubRepo{
-		lastOrder: &ord.Order{ID: uuid.NewString(), UserID: uid, Status: "pending", Total: "50.00"},
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, _ := newProductsServer(t, productState{ID: a, Price: "10.00", Stock: 10}, productState{ID: b, Price: "5.00", Stock: 10})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
 	}
 
 	gin.SetMode(gin.TestMode)
+	repo := &stubRepo{}
 	r := gin.New()
-	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, ext))
 
+	// el mismo producto en dos líneas: cada una conserva su número
+	want := []string{b, a, b}
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1},{"product_id":%q,"quantity":2},{"product_id":%q,"quantity":3}]}`, uuid.NewString(), b, a, b)
 	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+"?limit=10&offset=0", nil)
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
-
-	if w.Code != http.StatusOK {
-		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created struct {
+		Order ord.Order  `json:"order"`
+		Items []ord.Item `json:"items"`
 	}
+	if err := json.Unmarshal(w.Body.Bytes(), &created); err != nil {
+		t.Fatal(err)
+	}
+	check := func(step string, items []ord.Item) {
+		t.Helper()
+		if len(items) != len(want) {
+			t.Fatalf("%s: %d ítems, esperaba %d", step, len(items), len(want))
+		}
+		for i, it := range items {
+			if it.LineNo != i+1 || it.ProductID != want[i] {
+				t.Fatalf("%s: línea %d con line_no=%d producto=%s, esperaba %d y %s", step, i, it.LineNo, it.ProductID, i+1, want[i])
+			}
+		}
+	}
+	check("creación", created.Items)
 
-	// 1) intenta []ord.Order
-	var arr []ord.Order
-	if err := json.Unmarshal(w.Body.Bytes(), &arr); err == nil {
-		if len(arr) != 1 {
-			t.Fatalf("len=%d, esperaba 1 (formato array). body=%s", len(arr), w.Body.String())
+	// estable entre lecturas
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+created.Order.ID+"/items", nil))
+		var got struct {
+			Items []ord.Item `json:"items"`
 		}
-		return
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil {
+			t.Fatalf("GET items: status=%d body=%s", w.Code, w.Body.String())
+		}
+		check(fmt.Sprintf("lectura %d", i+1), got.Items)
 	}
+}
 
-	// 2) intenta {"orders":[...]}
-	var wrapOrders struct {
-		Orders []ord.Order `json:"orders"`
+func TestCreateOrder_UnavailableProduct(t *testing.T) {
+	t.Parallel()
+
+	// con stock de sobra, pero descontinuado
+	off, ok := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t,
+		productState{ID: off, Stock: 10, Status: "discontinued"},
+		productState{ID: ok, Stock: 10, Status: "active"},
+	)
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
 	}
-	if err := json.Unmarshal(w.Body.Bytes(), &wrapOrders); err == nil && len(wrapOrders.Orders) > 0 {
-		if len(wrapOrders.Orders) != 1 {
-			t.Fatalf("len=%d, esperaba 1 (formato objeto: orders). body=%s", len(wrapOrders.Orders), w.Body.String())
+	repo := &stubRepo{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
+
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1},{"product_id":%q,"quantity":1}]}`, uuid.NewString(), ok, off)
+	for _, url := range []string{"/orders", "/orders/validate"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		switch url {
+		case "/orders":
+			if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "product unavailable") {
+				t.Fatalf("status=%d body=%s (esperaba 409 product unavailable)", w.Code, w.Body.String())
+			}
+		case "/orders/validate":
+			if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":"product_unavailable"`) || !strings.Contains(w.Body.String(), `"status":"discontinued"`) {
+				t.Fatalf("validate: status=%d body=%s", w.Code, w.Body.String())
+			}
 		}
-		return
 	}
+	if states[off].Stock != 10 || states[ok].Stock != 10 {
+		t.Fatalf("stock modificado: off=%d ok=%d", states[off].Stock, states[ok].Stock)
+	}
+	if repo.lastOrder != nil || len(repo.sagas) != 0 {
+		t.Fatalf("no debía crearse la orden: order=%+v sagas=%d", repo.lastOrder, len(repo.sagas))
+	}
+}
 
-	// 3) intenta {"items":[...]}  (muchos handlers devuelven items)
-	var wrapItems struct {
-		Items []ord.Order `json:"items"`
+// ===== POST /orders con product-service caído: 502, no 400 "product not found" =====
+func TestCreateOrder_ProductServiceDown(t *testing.T) {
+	t.Parallel()
+
+	var calls int32
+	psrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
 	}
-	if err := json.Unmarshal(w.Body.Bytes(), &wrapItems); err == nil && len(wrapItems.Items) > 0 {
-		if len(wrapItems.Items) != 1 {
-			t.Fatalf("len=%d, esperaba 1 (formato objeto: items). body=%s", len(wrapItems.Items), w.Body.String())
+	repo := &stubRepo{}
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
+
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, uuid.NewString(), uuid.NewString())
+	for _, url := range []string{"/orders", "/orders/validate"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "product service error") {
+			t.Fatalf("%s: status=%d body=%s, esperaba 502 product service error", url, w.Code, w.Body.String())
 		}
-		return
 	}
-
-	t.Fatalf("respuesta no coincide con formatos esperados. body=%s", w.Body.String())
+	if atomic.LoadInt32(&calls) == 0 {
+		t.Fatal("no se llamó a product-service")
+	}
+	if repo.lastOrder != nil || len(repo.sagas) != 0 {
+		t.Fatalf("no debía crearse la orden: order=%+v sagas=%d", repo.lastOrder, len(repo.sagas))
+	}
 }
 
-// ===== PUT /orders/:id/status → canceled (restock) =====
-func TestUpdateOrderStatus_PendingToCanceled_Restocks(t *testing.T) {
+// ===== POST /orders con user-service caído: 502, no 422 "invalid user" =====
+func TestCreateOrder_UserServiceDown(t *testing.T) {
 	t.Parallel()
 
 	prodID := uuid.NewString()
-	// stock inicial 3; orden tiene qty=2 → tras cancel debe subir a 5
-	psrv, pstate := newProductServer(t, productState{
-		ID:    prodID,
-		Price: "10.00",
-		Stock: 3,
-	})
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Stock: 5})
 	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{err: status.Error(codes.Unavailable, "connection refused")},
+		ProductBaseURL: psrv.URL,
+	}
+	repo := &stubRepo{}
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
 
-	oid := uuid.NewString()
-	repo := &stubRepo{
-		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
-		lastItems: []ord.Item{{
-			ID:        uuid.NewString(),
-			OrderID:   oid,
-			ProductID: prodID,
-			Quantity:  2,
-			Price:     "10.00",
-		}},
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, uuid.NewString(), prodID)
+	for _, url := range []string{"/orders", "/orders/validate"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "user service error") {
+			t.Fatalf("%s: status=%d body=%s, esperaba 502 \"user service error\"", url, w.Code, w.Body.String())
+		}
+	}
+	if pstate.Stock != 5 || repo.lastOrder != nil {
+		t.Fatalf("no debía moverse stock ni crearse la orden: stock=%d order=%+v", pstate.Stock, repo.lastOrder)
 	}
+}
+
+// ===== POST /orders: JSON mal formado => 400, reglas de negocio => 422 =====
+func TestCreateOrder_MalformedVsInvalid(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 5})
+	defer psrv.Close()
 
 	ext := &ord.Ext{
 		HTTP:           &http.Client{Timeout: 2 * time.Second},
 		User:           &fakeUserClient{ok: true},
 		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
 	}
+	repo := &stubRepo{}
 
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
 
-	body := `{"status":"canceled"}`
-	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
-	req.Header.Set("Content-Type", "application/json")
-	r.ServeHTTP(w, req)
+	uid := uuid.NewString()
+	cases := []struct {
+		name string
+		body string
+		want int
+	}{
+		{"json roto", `{"user_id":`, http.StatusBadRequest},
+		{"tipo incorrecto", fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":"2"}]}`, uid, prodID), http.StatusBadRequest},
+		{"sin items", fmt.Sprintf(`{"user_id":%q,"items":[]}`, uid), http.StatusUnprocessableEntity},
+		{"cantidad inválida", fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":0}]}`, uid, prodID), http.StatusUnprocessableEntity},
+		{"producto inexistente", fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, uid, uuid.NewString()), http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("%s: status=%d body=%s (esperaba %d)", tc.name, w.Code, w.Body.String(), tc.want)
+		}
+	}
+	// nada se persistió ni se tocó el stock
+	if repo.lastOrder != nil || pstate.Stock != 5 {
+		t.Fatalf("no debía mutar: order=%v stock=%d", repo.lastOrder, pstate.Stock)
+	}
+}
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+// ===== POST /orders sin campos obligatorios: 422 missing_fields =====
+func TestCreateOrder_MissingFields(t *testing.T) {
+	t.Parallel()
+
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, User: &fakeUserClient{ok: true}}
+	repo := &stubRepo{}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
+
+	item := fmt.Sprintf(`[{"product_id":%q,"quantity":1}]`, uuid.NewString())
+	cases := []struct {
+		name string
+		body string
+		want []string
+	}{
+		{"sin user_id", `{"items":` + item + `}`, []string{"user_id"}},
+		{"sin items", fmt.Sprintf(`{"user_id":%q}`, uuid.NewString()), []string{"items"}},
+		{"items vacío", fmt.Sprintf(`{"user_id":%q,"items":[]}`, uuid.NewString()), []string{"items"}},
+		{"sin ninguno", `{}`, []string{"user_id", "items"}},
 	}
-	if pstate.Stock != 5 {
-		t.Fatalf("restock falló: stock=%d, esperado=5", pstate.Stock)
+	for _, path := range []string{"/orders", "/orders/validate"} {
+		for _, tc := range cases {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+			var got MissingFields
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || w.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("%s %s: status=%d body=%s (esperaba 422)", path, tc.name, w.Code, w.Body.String())
+			}
+			if got.Error.Code != "missing_fields" || !reflect.DeepEqual(got.Error.Fields, tc.want) {
+				t.Fatalf("%s %s: error=%+v, esperaba missing_fields %v", path, tc.name, got.Error, tc.want)
+			}
+		}
 	}
-	if repo.lastOrder.Status != "canceled" {
-		t.Fatalf("estado final=%s, esperado=canceled", repo.lastOrder.Status)
+	if repo.lastOrder != nil {
+		t.Fatalf("no debía persistir: %+v", repo.lastOrder)
 	}
 }
 
-// ===== PUT /orders/:id/status → shipped (sin restock) =====
-func TestUpdateOrderStatus_PendingToShipped_NoRestock(t *testing.T) {
+// ===== saga de creación: crash a mitad de camino =====
+func TestSaga_RecoversCrashAfterPartialDecrements(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Stock: 5}, productState{ID: b, Stock: 5})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+	repo := &stubRepo{}
+	ctx := context.Background()
+
+	// el handler descontó A y B y el proceso murió antes de persistir la orden
+	orderID := uuid.NewString()
+	if err := repo.StartSaga(ctx, orderID); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range []struct {
+		id  string
+		qty int
+	}{{a, 2}, {b, 1}} {
+		if err := ext.AdjustStock(ctx, step.id, -step.qty, ord.StockReasonOrder, orderID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := repo.RecordSagaStep(ctx, orderID, step.id, step.qty); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if states[a].Stock != 3 || states[b].Stock != 4 {
+		t.Fatalf("setup: stock a=%d b=%d", states[a].Stock, states[b].Stock)
+	}
+
+	// todavía en curso: no se toca
+	opts := defaultOrderOptions()
+	opts.SagaTimeout = time.Hour
+	if n, err := recoverSagas(ctx, repo, ext, opts); err != nil || n != 0 {
+		t.Fatalf("saga en curso: n=%d err=%v", n, err)
+	}
+
+	// vencida: se devuelve el stock y la saga queda compensada
+	opts.SagaTimeout = 0
+	if n, err := recoverSagas(ctx, repo, ext, opts); err != nil || n != 1 {
+		t.Fatalf("recover: n=%d err=%v", n, err)
+	}
+	if states[a].Stock != 5 || states[b].Stock != 5 {
+		t.Fatalf("stock no restaurado: a=%d b=%d", states[a].Stock, states[b].Stock)
+	}
+	if got := repo.sagas[orderID].State; got != ord.SagaCompensated {
+		t.Fatalf("saga state=%s, esperaba compensated", got)
+	}
+	if r := states[a].Reasons; len(r) != 2 || r[1] != ord.StockReasonCancel {
+		t.Fatalf("stock_reason=%v, esperaba [order cancel]", r)
+	}
+
+	// idempotente: otra pasada no devuelve stock dos veces
+	if n, err := recoverSagas(ctx, repo, ext, opts); err != nil || n != 0 {
+		t.Fatalf("segunda pasada: n=%d err=%v", n, err)
+	}
+	if states[a].Stock != 5 || states[b].Stock != 5 {
+		t.Fatalf("stock duplicado: a=%d b=%d", states[a].Stock, states[b].Stock)
+	}
+}
+
+func TestSaga_PersistedOrderIsCompletedNotCompensated(t *testing.T) {
 	t.Parallel()
 
 	prodID := uuid.NewString()
-	psrv, pstate := newProductServer(t, productState{
-		ID:    prodID,
-		Price: "10.00",
-		Stock: 3,
-	})
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Stock: 5})
 	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+	ctx := context.Background()
 
-	oid := uuid.NewString()
-	repo := &stubRepo{
-		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
-		lastItems: []ord.Item{{
-			ID:        uuid.NewString(),
-			OrderID:   oid,
-			ProductID: prodID,
-			Quantity:  2,
-			Price:     "10.00",
-		}},
+	// crash entre persistir la orden y cerrar la saga
+	orderID := uuid.NewString()
+	repo := &stubRepo{lastOrder: &ord.Order{ID: orderID, Status: ord.StatusPending, Total: "20.00"}}
+	_ = repo.StartSaga(ctx, orderID)
+	if err := ext.AdjustStock(ctx, prodID, -2, ord.StockReasonOrder, orderID); err != nil {
+		t.Fatal(err)
+	}
+	_, _ = repo.RecordSagaStep(ctx, orderID, prodID, 2)
+
+	opts := defaultOrderOptions()
+	opts.SagaTimeout = 0
+	if n, err := recoverSagas(ctx, repo, ext, opts); err != nil || n != 0 {
+		t.Fatalf("recover: n=%d err=%v", n, err)
 	}
+	if pstate.Stock != 3 || repo.sagas[orderID].State != ord.SagaCompleted {
+		t.Fatalf("stock=%d state=%s, esperaba 3 y completed", pstate.Stock, repo.sagas[orderID].State)
+	}
+}
+
+func TestCreateOrder_PersistFailureCompensatesSaga(t *testing.T) {
+	t.Parallel()
 
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Stock: 5}, productState{ID: b, Stock: 5})
+	defer psrv.Close()
 	ext := &ord.Ext{
 		HTTP:           &http.Client{Timeout: 2 * time.Second},
 		User:           &fakeUserClient{ok: true},
-		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+		ProductBaseURL: psrv.URL,
 	}
+	repo := &stubRepo{createErr: fmt.Errorf("db down")}
 
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
 
-	body := `{"status":"paid"}`
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2},{"product_id":%q,"quantity":1}]}`, uuid.NewString(), a, b)
 	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status=%d body=%s (esperaba 500)", w.Code, w.Body.String())
 	}
-	if pstate.Stock != 3 { // no cambia
-		t.Fatalf("stock cambió y no debía: stock=%d", pstate.Stock)
+	if states[a].Stock != 5 || states[b].Stock != 5 {
+		t.Fatalf("stock no restaurado: a=%d b=%d", states[a].Stock, states[b].Stock)
 	}
-	if repo.lastOrder.Status != "paid" {
-		t.Fatalf("estado final=%s, esperado=paid", repo.lastOrder.Status)
+	if len(repo.sagas) != 1 {
+		t.Fatalf("sagas=%d, esperaba 1", len(repo.sagas))
+	}
+	for _, sg := range repo.sagas {
+		if sg.State != ord.SagaCompensated || len(sg.Steps) != 2 {
+			t.Fatalf("saga=%+v, esperaba compensated con 2 pasos", sg)
+		}
 	}
 }
 
-// ===== PUT /orders/:id/status → estado inválido =====
-func TestUpdateOrderStatus_InvalidStatus(t *testing.T) {
+func TestCreateOrder_ClientDisconnectStillRollsBack(t *testing.T) {
 	t.Parallel()
 
-	oid := uuid.NewString()
-	repo := &stubRepo{
-		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
+	a := uuid.NewString()
+	psrv, state := newProductServer(t, productState{ID: a, Stock: 5})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
 	}
-	ext := &ord.Ext{} // no se usa en esta ruta para validar estado
+	// el cliente se desconecta después del descuento de stock, antes de persistir la orden
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := &stubRepo{onCreate: cancel, createErr: context.Canceled}
 
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
-	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext))
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
 
-	body := `{"status":"wtf"}` // inválido
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), a)
 	w := httptest.NewRecorder()
-	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body)).WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
 	r.ServeHTTP(w, req)
 
-	if w.Code != http.StatusBadRequest {
-		t.Fatalf("status=%d body=%s (esperaba 400)", w.Code, w.Body.String())
+	if ctx.Err() == nil {
+		t.Fatal("el contexto de la solicitud no se canceló")
+	}
+	if state.Stock != 5 {
+		t.Fatalf("stock=%d, esperaba 5 (rollback con la solicitud cancelada)", state.Stock)
+	}
+	for _, sg := range repo.sagas {
+		if sg.State != ord.SagaCompensated {
+			t.Fatalf("saga=%+v, esperaba compensated", sg)
+		}
 	}
 }
 
-func init() {
-	gin.SetMode(gin.TestMode)
-	gin.DefaultWriter = io.Discard
+func TestCreateOrder_DryRun(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Price: "10.00", Stock: 5}, productState{ID: b, Price: "3.50", Stock: 1})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
+	}
+	repo := &stubRepo{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	post := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders?dry_run=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// el mismo producto en dos líneas: el stock restante descuenta la suma
+	w := post(fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2},{"product_id":%q,"quantity":1},{"product_id":%q,"quantity":1,"discount":{"percent":"10"}}]}`,
+		uuid.NewString(), a, b, a))
+	if w.Code != http.StatusOK {
+		t.Fatalf("code=%d body=%s", w.Code, w.Body.String())
+	}
+	var out struct {
+		DryRun bool              `json:"dry_run"`
+		Order  ord.Order         `json:"order"`
+		Items  []ord.Item        `json:"items"`
+		Stock  []ord.StockImpact `json:"stock"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &out); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if !out.DryRun || out.Order.ID != "" || out.Order.Status != ord.StatusPending || out.Order.Total != "32.50" {
+		t.Fatalf("order=%+v dry_run=%v, esperaba pending sin id y total 32.50", out.Order, out.DryRun)
+	}
+	if len(out.Items) != 3 || out.Items[0].Price != "10.00" || out.Items[2].Discount != "1.00" || out.Items[2].LineTotal != "9.00" {
+		t.Fatalf("items=%+v", out.Items)
+	}
+	want := []ord.StockImpact{
+		{ProductID: a, Stock: 5, Requested: 3, WouldRemaining: 2},
+		{ProductID: b, Stock: 1, Requested: 1, WouldRemaining: 0},
+	}
+	if len(out.Stock) != len(want) || out.Stock[0] != want[0] || out.Stock[1] != want[1] {
+		t.Fatalf("stock=%+v, esperaba %+v", out.Stock, want)
+	}
+
+	// nada se movió ni se guardó
+	if states[a].Stock != 5 || states[b].Stock != 1 {
+		t.Fatalf("stock a=%d b=%d, esperaba 5 y 1", states[a].Stock, states[b].Stock)
+	}
+	if len(repo.history) != 0 || len(repo.sagas) != 0 {
+		t.Fatalf("dry run persistió: órdenes=%d sagas=%d", len(repo.history), len(repo.sagas))
+	}
+
+	// los mismos errores que la creación real
+	if w := post(fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), b)); w.Code != http.StatusConflict {
+		t.Fatalf("sin stock: code=%d, esperaba 409", w.Code)
+	}
+}
+
+func TestCreateOrder_IdempotencyKeyReplays(t *testing.T) {
+	t.Parallel()
+
+	a := uuid.NewString()
+	psrv, state := newProductServer(t, productState{ID: a, Stock: 5})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
+	}
+	repo := &stubRepo{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", httpx.Idempotency(idempotency.NewMemory(time.Hour), orderCaller(ext, defaultOrderOptions())), createOrderHandler(repo, ext, defaultOrderOptions()))
+
+	user := uuid.NewString()
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, user, a)
+	post := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if key != "" {
+			req.Header.Set(httpx.IdempotencyHeader, key)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	first := post("k-1")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("code=%d body=%s", first.Code, first.Body.String())
+	}
+	// el reintento con la misma clave devuelve la misma respuesta sin tocar stock
+	second := post("k-1")
+	if second.Code != http.StatusCreated || second.Body.String() != first.Body.String() {
+		t.Fatalf("replay code=%d body=%s, esperaba %s", second.Code, second.Body.String(), first.Body.String())
+	}
+	if second.Header().Get(httpx.IdempotencyReplayedHeader) != "true" {
+		t.Fatal("falta Idempotency-Replayed en la respuesta repetida")
+	}
+	if state.Stock != 3 {
+		t.Fatalf("stock=%d, esperaba 3 (un solo descuento)", state.Stock)
+	}
+	// otra clave es otra orden
+	if w := post("k-2"); w.Code != http.StatusCreated || state.Stock != 1 {
+		t.Fatalf("code=%d stock=%d, esperaba 201 y 1", w.Code, state.Stock)
+	}
+	if w := post(strings.Repeat("x", 256)); w.Code != http.StatusBadRequest {
+		t.Fatalf("clave larga: code=%d, esperaba 400", w.Code)
+	}
+	if len(repo.history) != 2 {
+		t.Fatalf("órdenes=%d, esperaba 2 (una por clave)", len(repo.history))
+	}
+
+	// la misma clave de otro usuario es otra orden, no la respuesta del primero
+	state.Stock = 5
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), a)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(httpx.IdempotencyHeader, "k-1")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || w.Header().Get(httpx.IdempotencyReplayedHeader) != "" || state.Stock != 3 {
+		t.Fatalf("otro usuario: code=%d replayed=%q stock=%d", w.Code, w.Header().Get(httpx.IdempotencyReplayedHeader), state.Stock)
+	}
+}
+
+func TestCreateOrder_IdempotencyKeyInFlight(t *testing.T) {
+	t.Parallel()
+
+	a := uuid.NewString()
+	psrv, state := newProductServer(t, productState{ID: a, Stock: 5})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
+	}
+	repo := &stubRepo{}
+
+	// el primer intento queda retenido hasta que el segundo haya respondido
+	entered, release := make(chan struct{}), make(chan struct{})
+	create := createOrderHandler(repo, ext, defaultOrderOptions())
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", httpx.Idempotency(idempotency.NewMemory(time.Hour), orderCaller(ext, defaultOrderOptions())), func(c *gin.Context) {
+		if c.GetHeader("X-Hold") != "" {
+			close(entered)
+			<-release
+		}
+		create(c)
+	})
+
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), a)
+	post := func(hold bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(httpx.IdempotencyHeader, "k-1")
+		if hold {
+			req.Header.Set("X-Hold", "1")
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	firstDone := make(chan *httptest.ResponseRecorder)
+	go func() { firstDone <- post(true) }()
+	<-entered
+
+	w := post(false)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "idempotent request in progress") {
+		t.Fatalf("en curso: code=%d body=%s, esperaba 409", w.Code, w.Body.String())
+	}
+	close(release)
+	first := <-firstDone
+	if first.Code != http.StatusCreated {
+		t.Fatalf("primero: code=%d body=%s", first.Code, first.Body.String())
+	}
+
+	// terminado, el reintento repite la respuesta
+	if w := post(false); w.Code != http.StatusCreated || w.Body.String() != first.Body.String() {
+		t.Fatalf("replay code=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(repo.history) != 1 || state.Stock != 3 {
+		t.Fatalf("órdenes=%d stock=%d, esperaba una orden y un solo descuento", len(repo.history), state.Stock)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	psrv, _ := newProductServer(t, productState{ID: uuid.NewString()})
+	defer psrv.Close()
+	// product-service caído: nadie escucha en esa URL
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL
+	down.Close()
+
+	serving := &fakeHealthClient{status: healthpb.HealthCheckResponse_SERVING}
+	cases := []struct {
+		name       string
+		db         error
+		user       *fakeHealthClient
+		productURL string
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{"todo ok", nil, serving, psrv.URL, http.StatusOK, httpx.HealthOK,
+			map[string]string{"db": "ok", "user_service": "ok", "product_service": "ok"}},
+		{"db caída", fmt.Errorf("connection refused"), serving, psrv.URL, http.StatusServiceUnavailable, httpx.HealthDown,
+			map[string]string{"db": "down", "user_service": "ok", "product_service": "ok"}},
+		{"user-service no sirve", nil, &fakeHealthClient{status: healthpb.HealthCheckResponse_NOT_SERVING}, psrv.URL, http.StatusOK, httpx.HealthDegraded,
+			map[string]string{"db": "ok", "user_service": "down", "product_service": "ok"}},
+		{"product-service caído", nil, serving, downURL, http.StatusOK, httpx.HealthDegraded,
+			map[string]string{"db": "ok", "user_service": "ok", "product_service": "down"}},
+		{"user-service timeout", nil, &fakeHealthClient{err: context.DeadlineExceeded}, downURL, http.StatusOK, httpx.HealthDegraded,
+			map[string]string{"db": "ok", "user_service": "down", "product_service": "down"}},
+	}
+	for _, tc := range cases {
+		ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, UserHealth: tc.user, ProductBaseURL: tc.productURL}
+		r := gin.New()
+		r.GET("/healthz", healthHandler(fakePinger{tc.db}, ext))
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+		var got struct {
+			Status string            `json:"status"`
+			Checks map[string]string `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s: %v body=%s", tc.name, err, w.Body.String())
+		}
+		if w.Code != tc.wantCode || got.Status != tc.wantStatus {
+			t.Fatalf("%s: status=%d %q, esperaba %d %q", tc.name, w.Code, got.Status, tc.wantCode, tc.wantStatus)
+		}
+		for k, v := range tc.wantChecks {
+			if got.Checks[k] != v {
+				t.Fatalf("%s: checks=%v, esperaba %s=%s", tc.name, got.Checks, k, v)
+			}
+		}
+	}
+}
+
+// ===== descuentos por ítem =====
+func TestCreateOrder_ItemDiscounts(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Price: "10.00", Stock: 10}, productState{ID: b, Price: "5.00", Stock: 10})
+	defer psrv.Close()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: psrv.URL,
+	}
+	repo := &stubRepo{}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
+	post := func(url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	uid := uuid.NewString()
+
+	// A x2 con 10% (18.00) + B x3 sin descuento (15.00) + A x1 con 1.50 off (8.50) = 41.50
+	body := fmt.Sprintf(`{"user_id":%q,"items":[
+		{"product_id":%q,"quantity":2,"discount":{"percent":"10"}},
+		{"product_id":%q,"quantity":3},
+		{"product_id":%q,"quantity":1,"discount":{"amount":"1.50"}}]}`, uid, a, b, a)
+	w := post("/orders", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if repo.lastOrder.Total != "41.50" {
+		t.Fatalf("total=%s, esperaba 41.50", repo.lastOrder.Total)
+	}
+	want := []struct{ price, discount, line string }{
+		{"10.00", "2.00", "18.00"},
+		{"5.00", "", "15.00"},
+		{"10.00", "1.50", "8.50"},
+	}
+	for i, it := range repo.lastItems {
+		if it.Price != want[i].price || it.Discount != want[i].discount || it.LineTotal != want[i].line {
+			t.Fatalf("item %d = %+v, esperaba %+v", i, it, want[i])
+		}
+	}
+	if states[a].Stock != 7 || states[b].Stock != 7 {
+		t.Fatalf("stock a=%d b=%d", states[a].Stock, states[b].Stock)
+	}
+
+	// un descuento mayor que la línea (B x1 = 5.00) se rechaza sin tocar stock
+	bad := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1},{"product_id":%q,"quantity":1,"discount":{"amount":"5.01"}}]}`, uid, a, b)
+	if w := post("/orders", bad); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("descuento excesivo status=%d body=%s (esperaba 422)", w.Code, w.Body.String())
+	}
+	if states[a].Stock != 7 || states[b].Stock != 7 {
+		t.Fatalf("stock cambió: a=%d b=%d", states[a].Stock, states[b].Stock)
+	}
+	w = post("/orders/validate", bad)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"code":"invalid_discount"`) {
+		t.Fatalf("validate status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+// ===== POST /orders con PRICE_DECIMALS=4 =====
+func TestCreateOrder_PriceDecimals(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	// producto por gramo (4 decimales) y otro en centavos
+	psrv, _ := newProductsServer(t, productState{ID: a, Price: "0.0125", Stock: 1000}, productState{ID: b, Price: "2.50", Stock: 10})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, User: &fakeUserClient{ok: true}, ProductBaseURL: psrv.URL}
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":333},{"product_id":%q,"quantity":1}]}`, uuid.NewString(), a, b)
+
+	create := func(decimals int32) *stubRepo {
+		opts := defaultOrderOptions()
+		opts.PriceDecimals = decimals
+		repo := &stubRepo{}
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/orders", createOrderHandler(repo, ext, opts))
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("decimals=%d status=%d body=%s", decimals, w.Code, w.Body.String())
+		}
+		return repo
+	}
+
+	// 333 x 0.0125 = 4.1625 + 2.50 = 6.6625: el precio y el total conservan los 4 decimales
+	repo := create(4)
+	if repo.lastItems[0].Price != "0.0125" || repo.lastItems[0].LineTotal != "4.1625" {
+		t.Fatalf("item=%+v, esperaba price 0.0125 y line_total 4.1625", repo.lastItems[0])
+	}
+	if repo.lastItems[1].Price != "2.5000" {
+		t.Fatalf("price=%s, esperaba 2.5000", repo.lastItems[1].Price)
+	}
+	if repo.lastOrder.Total != "6.6625" {
+		t.Fatalf("total=%s, esperaba 6.6625", repo.lastOrder.Total)
+	}
+
+	// por defecto se congela en centavos
+	repo = create(ord.DefaultPriceDecimals)
+	if repo.lastItems[0].Price != "0.01" || repo.lastOrder.Total != "6.66" {
+		t.Fatalf("price=%s total=%s, esperaba 0.01 y 6.66", repo.lastItems[0].Price, repo.lastOrder.Total)
+	}
+}
+
+// ===== POST /orders con REJECT_ZERO_TOTAL =====
+func TestCreateOrder_RejectZeroTotal(t *testing.T) {
+	t.Parallel()
+
+	free, paid := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: free, Price: "0.00", Stock: 10}, productState{ID: paid, Price: "10.00", Stock: 10})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, User: &fakeUserClient{ok: true}, ProductBaseURL: psrv.URL}
+	uid := uuid.NewString()
+
+	route := func(reject bool) *gin.Engine {
+		opts := defaultOrderOptions()
+		opts.RejectZeroTotal = reject
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/orders", createOrderHandler(&stubRepo{}, ext, opts))
+		r.POST("/orders/validate", validateCartHandler(ext, opts))
+		return r
+	}
+	post := func(r *gin.Engine, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+	freeBody := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uid, free)
+
+	// producto de precio 0.00 -> 422 sin tocar stock
+	r := route(true)
+	if w := post(r, "/orders", freeBody); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("total cero status=%d body=%s (esperaba 422)", w.Code, w.Body.String())
+	}
+	if states[free].Stock != 10 {
+		t.Fatalf("stock=%d, no debía cambiar", states[free].Stock)
+	}
+	if w := post(r, "/orders/validate", freeBody); !strings.Contains(w.Body.String(), `"code":"zero_total"`) {
+		t.Fatalf("validate body=%s, esperaba zero_total", w.Body.String())
+	}
+
+	// descontado al 100% a propósito -> se permite
+	discounted := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1,"discount":{"percent":"100"}}]}`, uid, paid)
+	if w := post(r, "/orders", discounted); w.Code != http.StatusCreated {
+		t.Fatalf("descuento total status=%d body=%s (esperaba 201)", w.Code, w.Body.String())
+	}
+
+	// sin la guarda, el producto gratis se acepta como antes
+	if w := post(route(false), "/orders", freeBody); w.Code != http.StatusCreated {
+		t.Fatalf("sin guarda status=%d body=%s (esperaba 201)", w.Code, w.Body.String())
+	}
+}
+
+// ===== POST /orders con ids de ítem del cliente =====
+func TestCreateOrder_DuplicateItemIDs(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Stock: 10}, productState{ID: b, Stock: 10})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, User: &fakeUserClient{ok: true}, ProductBaseURL: psrv.URL}
+	repo := &stubRepo{}
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+
+	uid, itemID := uuid.NewString(), uuid.NewString()
+	cases := []struct {
+		body string
+		code int
+	}{
+		// mismo id en dos líneas: 400 antes de tocar stock o abrir la saga
+		{fmt.Sprintf(`{"user_id":%q,"items":[{"id":%q,"product_id":%q,"quantity":1},{"id":%q,"product_id":%q,"quantity":1}]}`, uid, itemID, a, itemID, b), http.StatusBadRequest},
+		{fmt.Sprintf(`{"user_id":%q,"items":[{"id":"nope","product_id":%q,"quantity":1}]}`, uid, a), http.StatusBadRequest},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Fatalf("status=%d body=%s, esperaba %d", w.Code, w.Body.String(), tc.code)
+		}
+	}
+	if len(repo.sagas) != 0 || states[a].Stock != 10 || states[b].Stock != 10 {
+		t.Fatalf("se empezó la creación: sagas=%d stock a=%d b=%d", len(repo.sagas), states[a].Stock, states[b].Stock)
+	}
+
+	// ids distintos se respetan
+	other := uuid.NewString()
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"id":%q,"product_id":%q,"quantity":1},{"product_id":%q,"quantity":1}]}`, uid, other, a, b)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusCreated || repo.lastItems[0].ID != other || repo.lastItems[1].ID == "" {
+		t.Fatalf("status=%d items=%+v", w.Code, repo.lastItems)
+	}
+}
+
+// ===== GET /orders/user/:user_id/status-counts =====
+func TestStatusCounts(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	repo := &stubRepo{history: []ord.Order{
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPaid},
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPaid},
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPending},
+		// de otro usuario: no cuenta
+		{ID: uuid.NewString(), UserID: uuid.NewString(), Status: ord.StatusCanceled},
+	}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/user/:user_id/status-counts", statusCountsHandler(repo))
+	get := func(userID string) (*httptest.ResponseRecorder, map[string]int) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+userID+"/status-counts", nil))
+		var counts map[string]int
+		_ = json.Unmarshal(w.Body.Bytes(), &counts)
+		return w, counts
+	}
+
+	w, counts := get(uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	want := map[string]int{"draft": 0, "pending": 1, "paid": 2, "shipped": 0, "delivered": 0, "refunded": 0, "canceled": 0}
+	if len(counts) != len(want) {
+		t.Fatalf("counts=%v, esperaba todos los estados %v", counts, want)
+	}
+	for st, n := range want {
+		if got, ok := counts[st]; !ok || got != n {
+			t.Fatalf("counts[%s]=%d (presente=%v), esperaba %d", st, got, ok, n)
+		}
+	}
+
+	// sin órdenes: todos los estados en cero
+	_, counts = get(uuid.NewString())
+	for _, st := range ord.Statuses {
+		if n, ok := counts[string(st)]; !ok || n != 0 {
+			t.Fatalf("usuario sin órdenes: counts=%v", counts)
+		}
+	}
+
+	if w, _ := get("nope"); w.Code != http.StatusBadRequest {
+		t.Fatalf("user_id inválido: status=%d (esperaba 400)", w.Code)
+	}
+}
+
+// ===== GET /orders/user/:user_id/product-totals =====
+func TestProductTotals(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	a, b, c := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{itemsByOrder: map[string][]ord.Item{}}
+	seed := func(userID string, status ord.Status, items ...ord.Item) {
+		o := ord.Order{ID: uuid.NewString(), UserID: userID, Status: status}
+		repo.history = append(repo.history, o)
+		repo.itemsByOrder[o.ID] = items
+	}
+	line := func(pid string, qty int, total string) ord.Item {
+		return ord.Item{ID: uuid.NewString(), ProductID: pid, Quantity: qty, LineTotal: total}
+	}
+	// a aparece en tres órdenes (dos veces en la misma), b en dos
+	seed(uid, ord.StatusPaid, line(a, 2, "20.00"), line(b, 1, "5.00"))
+	seed(uid, ord.StatusShipped, line(a, 1, "10.00"), line(a, 3, "27.00"))
+	seed(uid, ord.StatusPaid, line(b, 1, "5.00"), line(a, 1, "10.00"))
+	// no cuentan: sin pagar, canceladas, reembolsadas o de otro usuario
+	seed(uid, ord.StatusPending, line(c, 9, "90.00"))
+	seed(uid, ord.StatusCanceled, line(a, 5, "50.00"))
+	seed(uid, ord.StatusRefunded, line(b, 4, "20.00"))
+	seed(uuid.NewString(), ord.StatusPaid, line(a, 7, "70.00"))
+
+	psrv, _ := newProductsServer(t, productState{ID: a, Name: "Mouse"})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/user/:user_id/product-totals", productTotalsHandler(repo, ext))
+	get := func(url string) (*httptest.ResponseRecorder, []ord.ProductTotal) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		var body struct {
+			Items []ord.ProductTotal `json:"items"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w, body.Items
+	}
+
+	w, items := get("/orders/user/" + uid + "/product-totals")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	want := []ord.ProductTotal{
+		{ProductID: a, Quantity: 7, Orders: 3, Spent: "67.00"},
+		{ProductID: b, Quantity: 2, Orders: 2, Spent: "10.00"},
+	}
+	if len(items) != len(want) {
+		t.Fatalf("items=%+v, esperaba %+v", items, want)
+	}
+	for i := range want {
+		if items[i] != want[i] {
+			t.Fatalf("items[%d]=%+v, esperaba %+v", i, items[i], want[i])
+		}
+	}
+
+	// expand=product: nombre del producto; b ya no existe en product-service
+	w, items = get("/orders/user/" + uid + "/product-totals?expand=product")
+	if w.Code != http.StatusOK {
+		t.Fatalf("expand: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(items) != 2 || items[0].ProductName == nil || *items[0].ProductName != "Mouse" || items[1].ProductName != nil {
+		t.Fatalf("expand: items=%s", w.Body.String())
+	}
+
+	if w, items := get("/orders/user/" + uuid.NewString() + "/product-totals"); w.Code != http.StatusOK || items == nil || len(items) != 0 {
+		t.Fatalf("usuario sin compras: %s", w.Body.String())
+	}
+	if w, _ := get("/orders/user/nope/product-totals"); w.Code != http.StatusBadRequest {
+		t.Fatalf("user_id inválido: status=%d (esperaba 400)", w.Code)
+	}
+}
+
+// ===== GET /orders/products/:product_id/active-quantity =====
+func TestActiveQuantity(t *testing.T) {
+	t.Parallel()
+
+	p, other := uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{}
+	seed := func(status ord.Status, items ...ord.Item) {
+		o := ord.Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: status}
+		repo.history = append(repo.history, o)
+		if repo.itemsByOrder == nil {
+			repo.itemsByOrder = map[string][]ord.Item{}
+		}
+		repo.itemsByOrder[o.ID] = items
+	}
+	seed(ord.StatusPending, ord.Item{ProductID: p, Quantity: 2}, ord.Item{ProductID: other, Quantity: 7})
+	seed(ord.StatusPaid, ord.Item{ProductID: p, Quantity: 3}, ord.Item{ProductID: p, Quantity: 1})
+	seed(ord.StatusDraft, ord.Item{ProductID: p, Quantity: 4})
+	seed(ord.StatusCanceled, ord.Item{ProductID: p, Quantity: 50}) // ya devolvió su stock
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/products/:product_id/active-quantity", activeQuantityHandler(repo))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/products/"+p+"/active-quantity", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Quantity int `json:"quantity"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if body.Quantity != 10 {
+		t.Fatalf("quantity=%d, esperaba 10 (2+3+1+4, sin la cancelada)", body.Quantity)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/products/nope/active-quantity", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("product_id inválido: status=%d (esperaba 400)", w.Code)
+	}
+}
+
+// ===== GET /orders/product/:product_id/related =====
+func TestRelatedProducts(t *testing.T) {
+	t.Parallel()
+
+	p, a, b, c := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{itemsByOrder: map[string][]ord.Item{}}
+	seed := func(status ord.Status, products ...string) {
+		o := ord.Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: status}
+		repo.history = append(repo.history, o)
+		for _, id := range products {
+			repo.itemsByOrder[o.ID] = append(repo.itemsByOrder[o.ID], ord.Item{ProductID: id, Quantity: 1})
+		}
+	}
+	seed(ord.StatusPaid, p, a, b)
+	seed(ord.StatusShipped, p, a, a) // a dos veces en la misma orden cuenta una
+	seed(ord.StatusPending, p, a, c)
+	seed(ord.StatusPaid, p, b)
+	seed(ord.StatusCanceled, p, c, c) // ni la cancelada
+	seed(ord.StatusDraft, p, c)       // ni el borrador
+	seed(ord.StatusPaid, a, b, c)     // sin p: no cuenta
+
+	r := gin.New()
+	r.GET("/orders/product/:product_id/related", relatedProductsHandler(repo))
+	r.GET("/orders/products/:product_id/related", relatedProductsHandler(repo))
+	getAt := func(prefix, query string) (int, []ord.RelatedProduct) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, prefix+query, nil))
+		var body struct {
+			Items []ord.RelatedProduct `json:"items"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body.Items
+	}
+	get := func(query string) (int, []ord.RelatedProduct) { return getAt("/orders/product/", query) }
+
+	code, items := get(p + "/related")
+	want := []ord.RelatedProduct{{ProductID: a, Orders: 3}, {ProductID: b, Orders: 2}, {ProductID: c, Orders: 1}}
+	if code != http.StatusOK || !reflect.DeepEqual(items, want) {
+		t.Fatalf("status=%d items=%+v, esperaba %+v", code, items, want)
+	}
+	// la ruta en plural sigue respondiendo lo mismo
+	if code, items := getAt("/orders/products/", p+"/related"); code != http.StatusOK || !reflect.DeepEqual(items, want) {
+		t.Fatalf("/orders/products: status=%d items=%+v, esperaba %+v", code, items, want)
+	}
+	if _, items := get(p + "/related?limit=1"); len(items) != 1 || items[0].ProductID != a {
+		t.Fatalf("limit=1: %+v, esperaba solo %s", items, a)
+	}
+	if code, items := get(uuid.NewString() + "/related"); code != http.StatusOK || items == nil || len(items) != 0 {
+		t.Fatalf("producto sin órdenes: status=%d items=%v, esperaba 200 con []", code, items)
+	}
+	if code, _ := get("nope/related"); code != http.StatusBadRequest {
+		t.Fatalf("product_id inválido: status=%d (esperaba 400)", code)
+	}
+}
+
+// ===== rollup nocturno de ventas + GET /reports/daily =====
+func TestDailySalesRollup(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	day := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+	repo := &stubRepo{paidLines: map[string][]ord.SaleLine{
+		"2025-09-01": {
+			{ProductID: a, Quantity: 2, LineTotal: "18.00"},
+			{ProductID: b, Quantity: 1, LineTotal: "5.00"},
+			{ProductID: a, Quantity: 1, LineTotal: "8.50"},
+		},
+		"2025-09-02": {{ProductID: b, Quantity: 4, LineTotal: "20.00"}},
+	}}
+	ctx := context.Background()
+
+	// dos corridas del mismo día dejan las mismas filas
+	for run := 0; run < 2; run++ {
+		if n, err := rollupDailySales(ctx, repo, day.Add(13*time.Hour)); err != nil || n != 2 {
+			t.Fatalf("corrida %d: n=%d err=%v", run, n, err)
+		}
+	}
+	if _, err := rollupDailySales(ctx, repo, day.AddDate(0, 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.dailySales) != 3 {
+		t.Fatalf("filas=%d, esperaba 3: %+v", len(repo.dailySales), repo.dailySales)
+	}
+	if got := repo.dailySales["2025-09-01|"+a]; got.Units != 3 || got.Revenue != "26.5" {
+		t.Fatalf("a el 01 = %+v, esperaba 3 unidades y 26.5", got)
+	}
+
+	// si b ya no cuenta ese día (p. ej. se canceló), la re-corrida quita su fila
+	repo.paidLines["2025-09-01"] = repo.paidLines["2025-09-01"][:1]
+	if _, err := rollupDailySales(ctx, repo, day); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := repo.dailySales["2025-09-01|"+b]; ok {
+		t.Fatalf("fila de b del 01 sigue tras re-correr")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/reports/daily", dailySalesHandler(repo))
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/reports/daily?"+query, nil))
+		return w
+	}
+
+	w := get("from=2025-09-01&to=2025-09-02")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Items []ord.DailySale `json:"items"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &body)
+	if len(body.Items) != 2 || body.Items[0].Date != "2025-09-01" || body.Items[1].Date != "2025-09-02" || body.Items[1].Units != 4 {
+		t.Fatalf("items=%+v", body.Items)
+	}
+	if w := get("from=2025-09-02&to=2025-09-02"); !strings.Contains(w.Body.String(), `"revenue":"20"`) {
+		t.Fatalf("solo el 02: body=%s", w.Body.String())
+	}
+
+	for _, q := range []string{"", "from=2025-09-01", "from=2025-09-02&to=2025-09-01", "from=01/09/2025&to=2025-09-02", "from=2024-01-01&to=2025-09-01"} {
+		if w := get(q); w.Code != http.StatusBadRequest {
+			t.Fatalf("%q: status=%d, esperaba 400", q, w.Code)
+		}
+	}
+}
+
+func TestDailySalesExport_Range(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{paidLines: map[string][]ord.SaleLine{
+		"2025-09-01": {{ProductID: a, Quantity: 2, LineTotal: "18.00"}},
+		"2025-09-02": {{ProductID: b, Quantity: 4, LineTotal: "20.00"}},
+	}}
+	for _, day := range []string{"2025-09-01", "2025-09-02"} {
+		d, _ := time.Parse(ord.DateLayout, day)
+		if _, err := rollupDailySales(context.Background(), repo, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(httpx.JSONCase())
+	r.GET("/reports/daily/export", dailySalesExportHandler(repo))
+	get := func(query string, hdr map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/reports/daily/export?from=2025-09-01&to=2025-09-02"+query, nil)
+		for k, v := range hdr {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	full := get("", nil)
+	if full.Code != http.StatusOK || full.Header().Get("Accept-Ranges") != "bytes" || full.Header().Get("ETag") == "" {
+		t.Fatalf("status=%d headers=%v", full.Code, full.Header())
+	}
+	want := "date,product_id,units,revenue\n2025-09-01," + a + ",2,18\n2025-09-02," + b + ",4,20\n"
+	if full.Body.String() != want {
+		t.Fatalf("csv=%q, esperaba %q", full.Body.String(), want)
+	}
+	etag := full.Header().Get("ETag")
+
+	// reanudar desde el byte 10
+	w := get("", map[string]string{"Range": "bytes=10-"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != want[10:] {
+		t.Fatalf("status=%d body=%q, esperaba 206 con %q", w.Code, w.Body.String(), want[10:])
+	}
+	if cr := w.Header().Get("Content-Range"); cr != fmt.Sprintf("bytes 10-%d/%d", len(want)-1, len(want)) {
+		t.Fatalf("Content-Range=%q", cr)
+	}
+	w = get("", map[string]string{"Range": "bytes=0-3"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "date" {
+		t.Fatalf("status=%d body=%q, esperaba 206 con \"date\"", w.Code, w.Body.String())
+	}
+
+	// If-Range: con el ETag vigente es parcial, con uno viejo se manda todo de nuevo
+	if w := get("", map[string]string{"Range": "bytes=10-", "If-Range": etag}); w.Code != http.StatusPartialContent {
+		t.Fatalf("If-Range vigente: status=%d, esperaba 206", w.Code)
+	}
+	if w := get("", map[string]string{"Range": "bytes=10-", "If-Range": `"viejo"`}); w.Code != http.StatusOK || w.Body.String() != want {
+		t.Fatalf("If-Range viejo: status=%d, esperaba 200 con el archivo completo", w.Code)
+	}
+	if w := get("", map[string]string{"Range": fmt.Sprintf("bytes=%d-", len(want)+5)}); w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("rango fuera del archivo: status=%d, esperaba 416", w.Code)
+	}
+
+	// el JSON sale tal cual (sin re-casear) y sus rangos calzan con la descarga completa
+	fullJSON := get("&format=json", map[string]string{"Accept": "application/json; case=camel"})
+	if fullJSON.Code != http.StatusOK || !strings.Contains(fullJSON.Body.String(), `"product_id"`) {
+		t.Fatalf("status=%d body=%s", fullJSON.Code, fullJSON.Body.String())
+	}
+	w = get("&format=json", map[string]string{"Range": "bytes=5-", "Accept": "application/json; case=camel"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != fullJSON.Body.String()[5:] {
+		t.Fatalf("json parcial: status=%d body=%q", w.Code, w.Body.String())
+	}
+
+	if w := get("&format=xml", nil); w.Code != http.StatusBadRequest {
+		t.Fatalf("format=xml: status=%d, esperaba 400", w.Code)
+	}
+}
+
+func TestNextRollup(t *testing.T) {
+	at := func(h, m int) time.Time { return time.Date(2025, 9, 1, h, m, 0, 0, time.UTC) }
+	if got := nextRollup(at(0, 2)); !got.Equal(at(0, 5)) {
+		t.Fatalf("00:02 -> %s, esperaba 00:05 del mismo día", got)
+	}
+	if got := nextRollup(at(0, 5)); !got.Equal(at(0, 5).AddDate(0, 0, 1)) {
+		t.Fatalf("00:05 -> %s, esperaba 00:05 del día siguiente", got)
+	}
+	if got := nextRollup(at(18, 30)); !got.Equal(at(0, 5).AddDate(0, 0, 1)) {
+		t.Fatalf("18:30 -> %s, esperaba 00:05 del día siguiente", got)
+	}
+}
+
+// ===== GET /orders/user/:user_id/latest =====
+func TestLatestOrder(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	oldest, newest := uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{
+		history: []ord.Order{
+			{ID: oldest, UserID: uid, Status: "paid", Total: "10.00"},
+			{ID: uuid.NewString(), UserID: uid, Status: "paid", Total: "15.00"},
+			{ID: newest, UserID: uid, Status: "pending", Total: "20.00"},
+			// otro usuario compra después: no cuenta
+			{ID: uuid.NewString(), UserID: uuid.NewString(), Status: "pending", Total: "5.00"},
+		},
+		lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: newest, ProductID: uuid.NewString(), Quantity: 2, Price: "10.00"}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/user/:user_id/latest", latestOrderHandler(repo))
+
+	get := func(userID string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+userID+"/latest", nil))
+		return w
+	}
+
+	// usuario con varias órdenes -> la más reciente, con sus items
+	w := get(uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	var body struct {
+		Order ord.Order  `json:"order"`
+		Items []ord.Item `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if body.Order.ID != newest {
+		t.Fatalf("order=%s, esperaba la más reciente %s", body.Order.ID, newest)
+	}
+	if len(body.Items) != 1 || body.Items[0].OrderID != newest {
+		t.Fatalf("items=%+v, esperaba los de la orden más reciente", body.Items)
+	}
+
+	// usuario sin órdenes -> 404
+	if w := get(uuid.NewString()); w.Code != http.StatusNotFound {
+		t.Fatalf("sin órdenes: status=%d (esperaba 404)", w.Code)
+	}
+	// user_id inválido -> 400
+	if w := get("nope"); w.Code != http.StatusBadRequest {
+		t.Fatalf("user_id inválido: status=%d (esperaba 400)", w.Code)
+	}
+}
+
+// ===== GET /orders/user/:user_id/exists =====
+func TestUserHasOrders(t *testing.T) {
+	t.Parallel()
+
+	withOrders := uuid.NewString()
+	repo := &stubRepo{lastOrder: &ord.Order{ID: uuid.NewString(), UserID: withOrders, Status: "pending", Total: "10.00"}}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+	r.GET("/orders/user/:user_id/exists", userHasOrdersHandler(repo))
+
+	cases := []struct {
+		userID string
+		code   int
+		body   string
+	}{
+		{withOrders, http.StatusOK, `{"has_orders":true}`},
+		{uuid.NewString(), http.StatusOK, `{"has_orders":false}`},
+		{"no-es-uuid", http.StatusBadRequest, ""},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+tc.userID+"/exists", nil))
+		if w.Code != tc.code || (tc.body != "" && w.Body.String() != tc.body) {
+			t.Fatalf("%s: status=%d body=%s (esperaba %d %s)", tc.userID, w.Code, w.Body.String(), tc.code, tc.body)
+		}
+	}
+}
+
+// ===== restock de cancelación idempotente =====
+func TestCancelRestock_ReplayIsNoop(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a, Stock: 0}, productState{ID: b, Stock: 0})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+	repo := &stubRepo{}
+	ctx := context.Background()
+
+	// A aparece en dos líneas: se devuelve la suma en un solo restock
+	orderID := uuid.NewString()
+	items := []ord.Item{
+		{ProductID: a, Quantity: 2},
+		{ProductID: b, Quantity: 1},
+		{ProductID: a, Quantity: 1},
+	}
+	for i := 0; i < 3; i++ { // cancel + dos reintentos
+		restockItems(ctx, repo, ext, defaultOrderOptions(), orderID, ord.StockReasonCancel, items)
+	}
+	if states[a].Stock != 3 || states[b].Stock != 1 {
+		t.Fatalf("stock a=%d b=%d, esperaba 3 y 1", states[a].Stock, states[b].Stock)
+	}
+	if r := states[a].Reasons; len(r) != 1 || r[0] != ord.StockReasonCancel {
+		t.Fatalf("stock_reason=%v, esperaba un solo cancel", r)
+	}
+
+	// saga: si marcar los pasos falló tras restockear, el reintento no duplica
+	sagaID := uuid.NewString()
+	sg := &ord.Saga{OrderID: sagaID, State: ord.SagaStarted, Steps: []ord.SagaStep{{ProductID: a, Quantity: 4}}}
+	if _, err := ext.RestockOnce(ctx, a, 4, sagaID); err != nil { // primer intento, pasos sin marcar
+		t.Fatal(err)
+	}
+	if err := compensateSaga(ctx, repo, ext, sg); err != nil {
+		t.Fatal(err)
+	}
+	if states[a].Stock != 7 {
+		t.Fatalf("stock a=%d, esperaba 7", states[a].Stock)
+	}
+}
+
+// ===== GET /orders/:id (not found) =====
+func TestGetOrder_NotFound(t *testing.T) {
+	t.Parallel()
+
+	repo := &stubRepo{} // vacío
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/:id", getOrderHandler(repo))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/"+uuid.NewString(), nil)
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status=%d body=%s (esperaba 404)", w.Code, w.Body.String())
+	}
+}
+
+// ===== GET /orders/:id (error de la base) =====
+func TestGetOrder_DBError(t *testing.T) {
+	t.Parallel()
+
+	repo := &stubRepo{getErr: errors.New("conn closed")}
+	ext := &ord.Ext{}
+	opts := defaultOrderOptions()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/:id", getOrderHandler(repo))
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, opts))
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, ext))
+	r.POST("/orders/:id/items", addOrderItemHandler(repo, ext, opts))
+	r.GET("/orders/:id/stock-movements", getOrderStockMovementsHandler(repo, ext))
+	r.GET("/orders/:id/refunds", listRefundsHandler(repo, opts))
+	r.POST("/orders/:id/recompute-total", recomputeTotalHandler(repo, opts))
+
+	id := uuid.NewString()
+	for _, tc := range []struct{ method, url, body string }{
+		{http.MethodGet, "/orders/" + id, ""},
+		{http.MethodPut, "/orders/" + id + "/status", `{"status":"paid"}`},
+		{http.MethodGet, "/orders/" + id + "/items", ""},
+		{http.MethodPost, "/orders/" + id + "/items", fmt.Sprintf(`{"product_id":%q,"quantity":1}`, uuid.NewString())},
+		{http.MethodGet, "/orders/" + id + "/stock-movements", ""},
+		{http.MethodGet, "/orders/" + id + "/refunds", ""},
+		{http.MethodPost, "/orders/" + id + "/recompute-total", ""},
+	} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(tc.method, tc.url, bytes.NewBufferString(tc.body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+
+		// una falla transitoria no es un 404: el cliente puede reintentar
+		if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "not found") {
+			t.Fatalf("%s %s: status=%d body=%s (esperaba 500)", tc.method, tc.url, w.Code, w.Body.String())
+		}
+	}
+
+	// una orden que no existe sigue siendo 404
+	repo.getErr = nil
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+id+"/refunds", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("orden inexistente: status=%d, esperaba 404", w.Code)
+	}
+}
+
+// ===== GET /orders/:id/items =====
+func TestGetOrderItems_OK(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
+		lastItems: []ord.Item{{
+			ID:        uuid.NewString(),
+			OrderID:   oid,
+			ProductID: uuid.NewString(),
+			Quantity:  2,
+			Price:     "10.00",
+		}},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, &ord.Ext{}))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/items", nil)
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+
+	// 1) intenta []ord.Item
+	var arr []ord.Item
+	if err := json.Unmarshal(w.Body.Bytes(), &arr); err == nil {
+		if len(arr) != 1 {
+			t.Fatalf("items len=%d, esperaba 1 (formato array)", len(arr))
+		}
+		return
+	}
+	// 2) intenta {"items":[...]}
+	var wrap struct {
+		Items []ord.Item `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &wrap); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	if len(wrap.Items) != 1 {
+		t.Fatalf("items len=%d, esperaba 1 (formato objeto)", len(wrap.Items))
+	}
+}
+
+// ===== POST /orders/validate =====
+func TestValidateCart_ReportsAllProblems(t *testing.T) {
+	t.Parallel()
+
+	cheap, scarce := uuid.NewString(), uuid.NewString()
+	stock := map[string]*productState{
+		cheap:  {ID: cheap, Name: "Cheap", Price: "12.00", Stock: 10},
+		scarce: {ID: scarce, Name: "Scarce", Price: "5.00", Stock: 1},
+	}
+	// fake product-service con varios productos; cualquier PUT es una mutación
+	var puts int32
+	psrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			atomic.AddInt32(&puts, 1)
+		}
+		p, ok := stock[path.Base(r.URL.Path)]
+		if !ok {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(p)
+	}))
+	defer psrv.Close()
+
+	newRouter := func(userOK bool) *gin.Engine {
+		ext := &ord.Ext{
+			HTTP:           &http.Client{Timeout: 2 * time.Second},
+			User:           &fakeUserClient{ok: userOK},
+			ProductBaseURL: psrv.URL,
+		}
+		gin.SetMode(gin.TestMode)
+		r := gin.New()
+		r.POST("/orders/validate", validateCartHandler(ext, defaultOrderOptions()))
+		return r
+	}
+	post := func(r *gin.Engine, body string) (int, ord.CartReport) {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders/validate", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		var rep ord.CartReport
+		if err := json.Unmarshal(w.Body.Bytes(), &rep); err != nil {
+			t.Fatalf("json inválido: %v (%s)", err, w.Body.String())
+		}
+		return w.Code, rep
+	}
+
+	// usuario inválido + precio cambiado + producto inexistente + stock insuficiente (2 líneas del mismo producto)
+	missing := uuid.NewString()
+	body := fmt.Sprintf(`{"user_id":%q,"items":[
+		{"product_id":%q,"quantity":1,"expected_price":"10.00"},
+		{"product_id":%q,"quantity":1},
+		{"product_id":%q,"quantity":1},
+		{"product_id":%q,"quantity":1}]}`, uuid.NewString(), cheap, missing, scarce, scarce)
+	code, rep := post(newRouter(false), body)
+	if code != http.StatusOK || rep.Valid {
+		t.Fatalf("status=%d valid=%v", code, rep.Valid)
+	}
+	got := map[string]ord.CartProblem{}
+	for _, p := range rep.Problems {
+		if _, dup := got[p.Code]; dup {
+			t.Fatalf("problema %s reportado dos veces: %+v", p.Code, rep.Problems)
+		}
+		got[p.Code] = p
+	}
+	if len(got) != 4 {
+		t.Fatalf("problemas=%+v, esperaba 4 distintos", rep.Problems)
+	}
+	if _, ok := got[ord.ProblemInvalidUser]; !ok {
+		t.Fatalf("falta invalid_user")
+	}
+	if p := got[ord.ProblemPriceChanged]; p.ProductID != cheap || p.ExpectedPrice != "10.00" || p.CurrentPrice != "12.00" {
+		t.Fatalf("price_changed incorrecto: %+v", p)
+	}
+	if p := got[ord.ProblemProductNotFound]; p.ProductID != missing {
+		t.Fatalf("product_not_found incorrecto: %+v", p)
+	}
+	if p := got[ord.ProblemInsufficientStock]; p.ProductID != scarce || p.Requested != 2 || p.Available == nil || *p.Available != 1 {
+		t.Fatalf("insufficient_stock incorrecto: %+v", p)
+	}
+
+	// carrito correcto => valid con total
+	body = fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2,"expected_price":"12"}]}`, uuid.NewString(), cheap)
+	code, rep = post(newRouter(true), body)
+	if code != http.StatusOK || !rep.Valid || rep.Total != "24.00" || len(rep.Problems) != 0 {
+		t.Fatalf("carrito válido: status=%d rep=%+v", code, rep)
+	}
+
+	// nada se modificó
+	if n := atomic.LoadInt32(&puts); n != 0 || stock[cheap].Stock != 10 || stock[scarce].Stock != 1 {
+		t.Fatalf("validate mutó stock: puts=%d", n)
+	}
+}
+
+// ===== GET /orders/:id/items?expand=product =====
+func TestGetOrderItems_ExpandProduct(t *testing.T) {
+	t.Parallel()
+
+	same, changed, deleted := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	current := map[string]string{same: "10.00", changed: "12.50"}
+
+	// Fake product-service con varios productos; el borrado responde 404
+	var calls int32
+	psrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		id := path.Base(r.URL.Path)
+		price, ok := current[id]
+		if !ok {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(productState{ID: id, Name: "P", Price: price, Stock: 1})
+	}))
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	item := func(pid, price string) ord.Item {
+		return ord.Item{ID: uuid.NewString(), OrderID: oid, ProductID: pid, Quantity: 1, Price: price}
+	}
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "40.00"},
+		// el producto "same" aparece dos veces: debe pedirse una sola vez
+		lastItems: []ord.Item{item(same, "10"), item(changed, "10.00"), item(deleted, "5.00"), item(same, "10.00")},
+	}
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, ext))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/items?expand=product", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Items []ord.ItemWithProduct `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Items) != 4 {
+		t.Fatalf("json=%v len=%d body=%s", err, len(resp.Items), w.Body.String())
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Fatalf("llamadas a product-service=%d, esperaba 3", n)
+	}
+
+	byProduct := map[string]ord.ItemWithProduct{}
+	for _, it := range resp.Items {
+		byProduct[it.ProductID] = it
+	}
+	if it := byProduct[same]; it.CurrentPrice == nil || *it.CurrentPrice != "10.00" || it.PriceChanged {
+		t.Fatalf("precio sin cambio mal reportado: %+v", it)
+	}
+	if it := byProduct[changed]; it.CurrentPrice == nil || *it.CurrentPrice != "12.50" || !it.PriceChanged || it.Price != "10.00" {
+		t.Fatalf("precio cambiado mal reportado: %+v", it)
+	}
+	if it := byProduct[deleted]; it.CurrentPrice != nil || it.PriceChanged {
+		t.Fatalf("producto borrado debería tener current_price null: %+v", it)
+	}
+	if !strings.Contains(w.Body.String(), `"current_price":null`) {
+		t.Fatalf("falta current_price null en %s", w.Body.String())
+	}
+}
+
+func TestGetOrderItems_ExpandSoftDeletedProduct(t *testing.T) {
+	t.Parallel()
+
+	// producto con soft delete: 404 salvo que se pida include_deleted=true
+	pid := uuid.NewString()
+	psrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path.Base(r.URL.Path) != pid || r.URL.Query().Get("include_deleted") != "true" {
+			http.Error(w, `{"error":"not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"id":%q,"name":"Teclado viejo","price":"18.00","stock":0,"deleted_at":"2025-08-01T10:00:00Z"}`, pid)
+	}))
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "20.00"},
+		lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: pid, Quantity: 1, Price: "20.00"}},
+	}
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, ext))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/items?expand=product", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Items []ord.ItemWithProduct `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Items) != 1 {
+		t.Fatalf("json=%v body=%s", err, w.Body.String())
+	}
+	it := resp.Items[0]
+	if it.ProductName == nil || *it.ProductName != "Teclado viejo" || it.CurrentPrice == nil || *it.CurrentPrice != "18.00" {
+		t.Fatalf("producto borrado no se expandió: %s", w.Body.String())
+	}
+	if !it.ProductDeleted || !it.PriceChanged {
+		t.Fatalf("product_deleted=%v price_changed=%v", it.ProductDeleted, it.PriceChanged)
+	}
+}
+
+// ===== GET /orders/user/:user_id =====
+func TestListOrdersByUser_OK(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: uuid.NewString(), UserID: uid, Status: "pending", Total: "50.00"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+"?limit=10&offset=0", nil)
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+
+	// 1) intenta []ord.Order
+	var arr []ord.Order
+	if err := json.Unmarshal(w.Body.Bytes(), &arr); err == nil {
+		if len(arr) != 1 {
+			t.Fatalf("len=%d, esperaba 1 (formato array). body=%s", len(arr), w.Body.String())
+		}
+		return
+	}
+
+	// 2) intenta {"orders":[...]}
+	var wrapOrders struct {
+		Orders []ord.Order `json:"orders"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &wrapOrders); err == nil && len(wrapOrders.Orders) > 0 {
+		if len(wrapOrders.Orders) != 1 {
+			t.Fatalf("len=%d, esperaba 1 (formato objeto: orders). body=%s", len(wrapOrders.Orders), w.Body.String())
+		}
+		return
+	}
+
+	// 3) intenta {"items":[...]}  (muchos handlers devuelven items)
+	var wrapItems struct {
+		Items []ord.Order `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &wrapItems); err == nil && len(wrapItems.Items) > 0 {
+		if len(wrapItems.Items) != 1 {
+			t.Fatalf("len=%d, esperaba 1 (formato objeto: items). body=%s", len(wrapItems.Items), w.Body.String())
+		}
+		return
+	}
+
+	t.Fatalf("respuesta no coincide con formatos esperados. body=%s", w.Body.String())
+}
+
+// ===== GET /orders/user/:user_id?min_total=&max_total= =====
+func TestListOrdersByUser_TotalFilter(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: uuid.NewString(), UserID: uid, Status: "pending", Total: "100.00"},
+	}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	cases := []struct {
+		query string
+		code  int
+		n     int
+	}{
+		{"min_total=99.50", http.StatusOK, 1}, // como texto "100.00" < "99.50"
+		{"max_total=100", http.StatusOK, 1},
+		{"min_total=100.01", http.StatusOK, 0},
+		{"min_total=50&max_total=99.99", http.StatusOK, 0},
+		{"min_total=abc", http.StatusBadRequest, 0},
+		{"min_total=10&max_total=5", http.StatusBadRequest, 0},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+"?"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: status=%d, esperaba %d: %s", tc.query, w.Code, tc.code, w.Body.String())
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Items []ord.Order `json:"items"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		if len(body.Items) != tc.n {
+			t.Fatalf("%s: %d órdenes, esperaba %d", tc.query, len(body.Items), tc.n)
+		}
+	}
+}
+
+// ===== GET /orders/user/:user_id?status=&from=&to= =====
+func TestListOrdersByUser_StatusAndDates(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	at := func(s string) time.Time {
+		ts, _ := time.Parse(time.RFC3339, s)
+		return ts
+	}
+	repo := &stubRepo{history: []ord.Order{
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPaid, Total: "1.00", CreatedAt: at("2024-01-01T00:00:00Z")}, // justo en from: entra
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPaid, Total: "1.00", CreatedAt: at("2024-01-20T15:30:00Z")},
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPaid, Total: "1.00", CreatedAt: at("2024-02-01T00:00:00Z")}, // justo en to: no entra
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPending, Total: "1.00", CreatedAt: at("2024-01-10T00:00:00Z")},
+		{ID: uuid.NewString(), UserID: uid, Status: ord.StatusCanceled, Total: "1.00", CreatedAt: at("2023-12-31T23:59:59Z")},
+	}}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	cases := []struct {
+		query string
+		code  int
+		n     int
+	}{
+		{"status=paid&from=2024-01-01&to=2024-02-01", http.StatusOK, 2},
+		{"status=PAID", http.StatusOK, 3},
+		{"from=2024-01-01&to=2024-02-01", http.StatusOK, 3},
+		{"from=2024-01-20T15:30:00Z", http.StatusOK, 2},
+		{"to=2024-01-01", http.StatusOK, 1},
+		{"from=2024-01-01T00:00:00%2B01:00&to=2024-01-01", http.StatusOK, 1}, // 23:00 UTC del 31
+		{"status=canceled&from=2024-01-01", http.StatusOK, 0},
+		{"status=lost", http.StatusBadRequest, 0},
+		{"from=01/01/2024", http.StatusBadRequest, 0},
+		{"to=2024-13-01", http.StatusBadRequest, 0},
+		{"from=2024-02-01&to=2024-01-01", http.StatusBadRequest, 0},
+		{"from=2024-01-01&to=2024-01-01", http.StatusBadRequest, 0},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+"?"+tc.query, nil))
+		if w.Code != tc.code {
+			t.Fatalf("%s: status=%d, esperaba %d: %s", tc.query, w.Code, tc.code, w.Body.String())
+		}
+		if tc.code != http.StatusOK {
+			continue
+		}
+		var body struct {
+			Items []ord.Order `json:"items"`
+			Total int         `json:"total"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &body)
+		if len(body.Items) != tc.n || body.Total != tc.n {
+			t.Fatalf("%s: %d órdenes (total=%d), esperaba %d", tc.query, len(body.Items), body.Total, tc.n)
+		}
+	}
+}
+
+func TestListOrdersByUser_ItemCount(t *testing.T) {
+	t.Parallel()
+
+	uid, orderID := uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: orderID, UserID: uid, Status: ord.StatusPending, Total: "45.00"},
+		lastItems: []ord.Item{
+			{ID: uuid.NewString(), OrderID: orderID, ProductID: uuid.NewString(), Quantity: 1, Price: "10.00"},
+			{ID: uuid.NewString(), OrderID: orderID, ProductID: uuid.NewString(), Quantity: 2, Price: "5.00"},
+			{ID: uuid.NewString(), OrderID: orderID, ProductID: uuid.NewString(), Quantity: 1, Price: "25.00"},
+		},
+	}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+uid, nil))
+	var body struct {
+		Items []map[string]any `json:"items"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil || len(body.Items) != 1 {
+		t.Fatalf("status=%d body=%s, esperaba 200 con una orden", w.Code, w.Body.String())
+	}
+	// "3 ítems, $45" sin pedir los ítems de cada orden
+	if got := body.Items[0]; got["item_count"] != float64(len(repo.lastItems)) || got["total"] != "45.00" {
+		t.Fatalf("fila=%v, esperaba item_count=3 y total=45.00", got)
+	}
+}
+
+// ===== GET /orders/user/:user_id (envelope) =====
+func TestListOrdersByUser_Envelope(t *testing.T) {
+	t.Parallel()
+
+	repo := &stubRepo{}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/user/"+uuid.NewString(), nil)
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	var body struct {
+		GeneratedAt string `json:"generated_at"`
+		Version     string `json:"version"`
+		Limit       *int   `json:"limit"`
+		Offset      *int   `json:"offset"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	if _, err := time.Parse(time.RFC3339, body.GeneratedAt); err != nil {
+		t.Fatalf("generated_at=%q no es RFC3339: %v", body.GeneratedAt, err)
+	}
+	if body.Version == "" || body.Limit == nil || body.Offset == nil {
+		t.Fatalf("faltan campos en la respuesta: %s", w.Body.String())
+	}
+}
+
+// ===== GET /orders/user/:user_id (MAX_LIST_ROWS) =====
+func TestListOrdersByUser_Truncated(t *testing.T) {
+	t.Parallel()
+
+	uid := uuid.NewString()
+	repo := &stubRepo{maxListRows: 3}
+	for i := 0; i < 5; i++ {
+		repo.history = append(repo.history, ord.Order{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPending, Total: "1.00", CreatedAt: time.Now().Add(time.Duration(i) * time.Minute)})
+	}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	cases := []struct {
+		query     string
+		rows      int
+		limit     int
+		truncated bool
+	}{
+		{"limit=1000", 3, 3, true}, // pide todo: se corta en el tope y lo avisa
+		{"limit=3", 3, 3, false},
+		{"limit=2", 2, 2, false},
+		{"limit=1000&offset=2", 3, 1000, false}, // lo que queda cabe bajo el tope
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+"?"+tc.query, nil))
+		var body struct {
+			Items     []ord.Order `json:"items"`
+			Total     int         `json:"total"`
+			Limit     int         `json:"limit"`
+			Truncated *bool       `json:"truncated"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil || body.Truncated == nil {
+			t.Fatalf("%s: status=%d body=%s, esperaba 200 con truncated", tc.query, w.Code, w.Body.String())
+		}
+		if len(body.Items) != tc.rows || body.Limit != tc.limit || *body.Truncated != tc.truncated || body.Total != 5 {
+			t.Fatalf("%s: filas=%d limit=%d truncated=%v total=%d, esperaba %d, %d, %v y 5", tc.query, len(body.Items), body.Limit, *body.Truncated, body.Total, tc.rows, tc.limit, tc.truncated)
+		}
+	}
+}
+
+func TestListOrdersByUser_Total(t *testing.T) {
+	t.Parallel()
+
+	// 5 órdenes del usuario (una fuera del filtro) y una de otro usuario
+	uid := uuid.NewString()
+	repo := &stubRepo{}
+	for i, total := range []string{"10.00", "20.00", "30.00", "40.00", "500.00"} {
+		repo.history = append(repo.history, ord.Order{ID: uuid.NewString(), UserID: uid, Status: ord.StatusPending, Total: total, CreatedAt: time.Now().Add(time.Duration(i) * time.Minute)})
+	}
+	repo.history = append(repo.history, ord.Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: ord.StatusPending, Total: "15.00"})
+
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+
+	cases := []struct {
+		query       string
+		page, total int
+	}{
+		{"?limit=2", 2, 5},
+		{"?limit=2&offset=4", 1, 5},
+		{"?limit=2&offset=10", 0, 5},
+		{"?limit=2&max_total=100", 2, 4}, // el total respeta los filtros
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/user/"+uid+tc.query, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s (esperaba 200)", tc.query, w.Code, w.Body.String())
+		}
+		var body struct {
+			Items []ord.Order `json:"items"`
+			Total *int        `json:"total"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json inválido: %v", err)
+		}
+		if len(body.Items) != tc.page || body.Total == nil || *body.Total != tc.total {
+			t.Fatalf("%s: página=%d total=%v, esperaba %d y %d. body=%s", tc.query, len(body.Items), body.Total, tc.page, tc.total, w.Body.String())
+		}
+	}
+}
+
+func TestListEndpoints_EmptyIsArray(t *testing.T) {
+	t.Parallel()
+
+	// una orden existente sin reembolsos
+	orderID := uuid.NewString()
+	repo := &stubRepo{lastOrder: &ord.Order{ID: orderID, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "10.00"}}
+	r := gin.New()
+	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+	r.GET("/orders/:id/refunds", listRefundsHandler(repo, defaultOrderOptions()))
+	r.GET("/reports/daily", dailySalesHandler(repo))
+
+	for _, url := range []string{
+		"/orders/user/" + uuid.NewString(),
+		"/orders/" + orderID + "/refunds",
+		"/reports/daily?from=2025-01-01&to=2025-01-31",
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", url, w.Code, w.Body.String())
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s json inválido: %v", url, err)
+		}
+		if string(body["items"]) != "[]" {
+			t.Fatalf("%s items=%s, esperaba []", url, body["items"])
+		}
+	}
+}
+
+// ===== PUT /orders/:id/status → canceled (restock) =====
+func TestUpdateOrderStatus_PendingToCanceled_Restocks(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	// stock inicial 3; orden tiene qty=2 → tras cancel debe subir a 5
+	psrv, pstate := newProductServer(t, productState{
+		ID:    prodID,
+		Price: "10.00",
+		Stock: 3,
+	})
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
+		lastItems: []ord.Item{{
+			ID:        uuid.NewString(),
+			OrderID:   oid,
+			ProductID: prodID,
+			Quantity:  2,
+			Price:     "10.00",
+		}},
+	}
+
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, defaultOrderOptions()))
+
+	body := `{"status":"canceled"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	if pstate.Stock != 5 {
+		t.Fatalf("restock falló: stock=%d, esperado=5", pstate.Stock)
+	}
+	if len(pstate.Reasons) != 1 || pstate.Reasons[0] != ord.StockReasonCancel {
+		t.Fatalf("stock_reason=%v, esperaba [cancel]", pstate.Reasons)
+	}
+	if repo.lastOrder.Status != "canceled" {
+		t.Fatalf("estado final=%s, esperado=canceled", repo.lastOrder.Status)
+	}
+}
+
+// ===== PUT /orders/:id/status → shipped (sin restock) =====
+func TestUpdateOrderStatus_Cancel_DeletedProduct(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []ord.RestockPolicy{ord.RestockRecord, ord.RestockSkip} {
+		policy := policy
+		t.Run(string(policy), func(t *testing.T) {
+			t.Parallel()
+
+			// un producto existe; el otro fue borrado (404 en product-service)
+			prodID, deletedID := uuid.NewString(), uuid.NewString()
+			psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 3})
+			defer psrv.Close()
+
+			oid := uuid.NewString()
+			repo := &stubRepo{
+				lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "35.00"},
+				lastItems: []ord.Item{
+					{ID: uuid.NewString(), OrderID: oid, ProductID: deletedID, Quantity: 3, Price: "5.00"},
+					{ID: uuid.NewString(), OrderID: oid, ProductID: prodID, Quantity: 2, Price: "10.00"},
+				},
+			}
+			ext := &ord.Ext{
+				HTTP:           &http.Client{Timeout: 2 * time.Second},
+				ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+			}
+			opts := defaultOrderOptions()
+			opts.RestockNotFound = policy
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, opts))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(`{"status":"canceled"}`))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			// la cancelación procede igual
+			if w.Code != http.StatusOK || repo.lastOrder.Status != ord.StatusCanceled {
+				t.Fatalf("status=%d estado=%s body=%s", w.Code, repo.lastOrder.Status, w.Body.String())
+			}
+			if pstate.Stock != 5 {
+				t.Fatalf("el producto existente no se repuso: stock=%d", pstate.Stock)
+			}
+
+			switch policy {
+			case ord.RestockRecord:
+				if len(repo.restockFailures) != 1 {
+					t.Fatalf("fallos registrados=%d, esperaba 1", len(repo.restockFailures))
+				}
+				f := repo.restockFailures[0]
+				if f.OrderID != oid || f.ProductID != deletedID || f.Quantity != 3 {
+					t.Fatalf("fallo registrado incorrecto: %+v", f)
+				}
+			case ord.RestockSkip:
+				if len(repo.restockFailures) != 0 {
+					t.Fatalf("skip no debería registrar: %+v", repo.restockFailures)
+				}
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatus_PendingToShipped_NoRestock(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{
+		ID:    prodID,
+		Price: "10.00",
+		Stock: 3,
+	})
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
+		lastItems: []ord.Item{{
+			ID:        uuid.NewString(),
+			OrderID:   oid,
+			ProductID: prodID,
+			Quantity:  2,
+			Price:     "10.00",
+		}},
+	}
+
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, defaultOrderOptions()))
+
+	body := `{"status":"paid"}`
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	if pstate.Stock != 3 { // no cambia
+		t.Fatalf("stock cambió y no debía: stock=%d", pstate.Stock)
+	}
+	if repo.lastOrder.Status != "paid" {
+		t.Fatalf("estado final=%s, esperado=paid", repo.lastOrder.Status)
+	}
+}
+
+// ===== PUT /orders/:id/status → estado inválido =====
+func TestUpdateOrderStatus_InvalidStatus(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: "pending", Total: "20.00"},
+	}
+	ext := &ord.Ext{} // no se usa en esta ruta para validar estado
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, defaultOrderOptions()))
+
+	body := `{"status":"wtf"}` // inválido
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status=%d body=%s (esperaba 422)", w.Code, w.Body.String())
+	}
+}
+
+// ===== PUT /orders/:id/status → shipped / refunded =====
+func TestUpdateOrderStatus_ExtendedTransitions(t *testing.T) {
+	t.Parallel()
+
+	noShipping, err := ord.ParseTransitions("pending:paid,canceled;paid:refunded")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name        string
+		transitions ord.Transitions
+		from, to    ord.Status
+		wantCode    int
+		wantStock   int
+	}{
+		{"paid → shipped", ord.DefaultTransitions, ord.StatusPaid, ord.StatusShipped, http.StatusOK, 3},
+		{"shipped → refunded sin restock", ord.DefaultTransitions, ord.StatusShipped, ord.StatusRefunded, http.StatusOK, 3},
+		{"shipped → delivered", ord.DefaultTransitions, ord.StatusShipped, ord.StatusDelivered, http.StatusOK, 3},
+		{"delivered → refunded sin restock", ord.DefaultTransitions, ord.StatusDelivered, ord.StatusRefunded, http.StatusOK, 3},
+		{"paid → delivered sin enviar", ord.DefaultTransitions, ord.StatusPaid, ord.StatusDelivered, http.StatusConflict, 3},
+		{"delivered → canceled", ord.DefaultTransitions, ord.StatusDelivered, ord.StatusCanceled, http.StatusConflict, 3},
+		{"delivered → shipped no retrocede", ord.DefaultTransitions, ord.StatusDelivered, ord.StatusShipped, http.StatusConflict, 3},
+		{"paid → refunded sin restock", ord.DefaultTransitions, ord.StatusPaid, ord.StatusRefunded, http.StatusOK, 3},
+		{"pending → canceled repone", ord.DefaultTransitions, ord.StatusPending, ord.StatusCanceled, http.StatusOK, 5},
+		{"paid → canceled repone", ord.DefaultTransitions, ord.StatusPaid, ord.StatusCanceled, http.StatusOK, 5},
+		{"paid → pending no retrocede", ord.DefaultTransitions, ord.StatusPaid, ord.StatusPending, http.StatusConflict, 3},
+		{"canceled → paid", ord.DefaultTransitions, ord.StatusCanceled, ord.StatusPaid, http.StatusConflict, 3},
+		{"pending → shipped sin pagar", ord.DefaultTransitions, ord.StatusPending, ord.StatusShipped, http.StatusConflict, 3},
+		{"shipped → canceled", ord.DefaultTransitions, ord.StatusShipped, ord.StatusCanceled, http.StatusConflict, 3},
+		{"refunded es final", ord.DefaultTransitions, ord.StatusRefunded, ord.StatusPaid, http.StatusConflict, 3},
+		{"canceled no revive", ord.DefaultTransitions, ord.StatusCanceled, ord.StatusPending, http.StatusConflict, 3},
+		{"shipped deshabilitado", noShipping, ord.StatusPaid, ord.StatusShipped, http.StatusUnprocessableEntity, 3},
+		{"paid → canceled no configurado", noShipping, ord.StatusPaid, ord.StatusCanceled, http.StatusConflict, 3},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			prodID := uuid.NewString()
+			psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 3})
+			defer psrv.Close()
+
+			oid := uuid.NewString()
+			repo := &stubRepo{
+				lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: tc.from, Total: "20.00"},
+				lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: prodID, Quantity: 2, Price: "10.00"}},
+			}
+			ext := &ord.Ext{
+				HTTP:           &http.Client{Timeout: 2 * time.Second},
+				ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+			}
+			opts := defaultOrderOptions()
+			opts.Transitions = tc.transitions
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, opts))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(fmt.Sprintf(`{"status":%q}`, tc.to)))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.wantCode {
+				t.Fatalf("status=%d body=%s (esperaba %d)", w.Code, w.Body.String(), tc.wantCode)
+			}
+			if tc.wantCode == http.StatusConflict {
+				var body HTTPError
+				want := fmt.Sprintf("illegal transition %s->%s", tc.from, tc.to)
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error != want {
+					t.Fatalf("body=%s, esperaba error %q", w.Body.String(), want)
+				}
+			}
+			wantStatus := tc.from
+			if tc.wantCode == http.StatusOK {
+				wantStatus = tc.to
+			}
+			if repo.lastOrder.Status != wantStatus {
+				t.Fatalf("estado final=%s, esperado=%s", repo.lastOrder.Status, wantStatus)
+			}
+			if pstate.Stock != tc.wantStock {
+				t.Fatalf("stock=%d, esperado=%d", pstate.Stock, tc.wantStock)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatus_PaidCancelSkipsRefundedRestock(t *testing.T) {
+	t.Parallel()
+
+	// 3 unidades vendidas; un reembolso con restock ya devolvió 1
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 1})
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "30.00"},
+		lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: prodID, Quantity: 3, Price: "10.00"}},
+		refunds:   []ord.Refund{{ID: uuid.NewString(), OrderID: oid, Amount: "10.00", Restock: true, Items: []ord.RefundItem{{ProductID: prodID, Quantity: 1}}}},
+	}
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	r := gin.New()
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, defaultOrderOptions()))
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(`{"status":"canceled"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	if pstate.Stock != 3 {
+		t.Fatalf("stock=%d, esperaba 3 (solo las 2 unidades no reembolsadas)", pstate.Stock)
+	}
+}
+
+func TestUpdateOrderStatus_SameStatus(t *testing.T) {
+	t.Parallel()
+
+	before := time.Date(2025, 9, 1, 10, 0, 0, 0, time.UTC)
+	cases := []struct {
+		policy   ord.NoopPolicy
+		wantCode int
+		touched  bool
+	}{
+		{ord.NoopIgnore, http.StatusOK, false},
+		{ord.NoopConflict, http.StatusConflict, false},
+		{ord.NoopTouch, http.StatusOK, true},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(string(tc.policy), func(t *testing.T) {
+			t.Parallel()
+
+			oid := uuid.NewString()
+			repo := &stubRepo{
+				lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "20.00", UpdatedAt: before},
+			}
+			opts := defaultOrderOptions()
+			opts.StatusNoop = tc.policy
+
+			gin.SetMode(gin.TestMode)
+			r := gin.New()
+			r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, &ord.Ext{}, opts))
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(`{"status":"paid"}`))
+			req.Header.Set("Content-Type", "application/json")
+			r.ServeHTTP(w, req)
+
+			if w.Code != tc.wantCode {
+				t.Fatalf("status=%d body=%s (esperaba %d)", w.Code, w.Body.String(), tc.wantCode)
+			}
+			if repo.lastOrder.Status != ord.StatusPaid {
+				t.Fatalf("estado=%s, esperaba paid sin cambios", repo.lastOrder.Status)
+			}
+			if touched := repo.lastOrder.UpdatedAt.After(before); touched != tc.touched {
+				t.Fatalf("updated_at=%s, esperaba actualizado=%v", repo.lastOrder.UpdatedAt, tc.touched)
+			}
+		})
+	}
+}
+
+func TestUpdateOrderStatus_FulfillmentTimestamps(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "20.00"},
+	}
+	opts := defaultOrderOptions()
+	opts.StatusNoop = ord.NoopTouch
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, &ord.Ext{}, opts))
+	put := func(status ord.Status) ord.Order {
+		t.Helper()
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(fmt.Sprintf(`{"status":%q}`, status)))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", status, w.Code, w.Body.String())
+		}
+		var body struct {
+			Order ord.Order `json:"order"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Order
+	}
+
+	o := put(ord.StatusShipped)
+	if o.ShippedAt == nil || o.DeliveredAt != nil {
+		t.Fatalf("shipped_at=%v delivered_at=%v, esperaba solo shipped_at", o.ShippedAt, o.DeliveredAt)
+	}
+	shippedAt := *o.ShippedAt
+
+	// repetir shipped (touch) no mueve shipped_at
+	if o = put(ord.StatusShipped); o.ShippedAt == nil || !o.ShippedAt.Equal(shippedAt) {
+		t.Fatalf("shipped_at=%v cambió, esperaba %s", o.ShippedAt, shippedAt)
+	}
+
+	o = put(ord.StatusDelivered)
+	if o.DeliveredAt == nil || o.ShippedAt == nil || !o.ShippedAt.Equal(shippedAt) {
+		t.Fatalf("shipped_at=%v delivered_at=%v tras delivered", o.ShippedAt, o.DeliveredAt)
+	}
+	deliveredAt := *o.DeliveredAt
+
+	// un reembolso posterior conserva ambas marcas
+	o = put(ord.StatusRefunded)
+	if o.ShippedAt == nil || !o.ShippedAt.Equal(shippedAt) || o.DeliveredAt == nil || !o.DeliveredAt.Equal(deliveredAt) {
+		t.Fatalf("shipped_at=%v delivered_at=%v borrados o cambiados tras refunded", o.ShippedAt, o.DeliveredAt)
+	}
+}
+
+// fakeAudit guarda las entradas de auditoría registradas.
+type fakeAudit struct{ entries []audit.Entry }
+
+func (f *fakeAudit) Record(ctx context.Context, e audit.Entry) error {
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func TestUpdateOrderStatus_Audited(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "20.00"},
+	}
+	rec := &fakeAudit{}
+	opts := defaultOrderOptions()
+	opts.Audit = rec
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(httpx.AuditActor())
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, &ord.Ext{}, opts))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPut, "/orders/"+oid+"/status", bytes.NewBufferString(`{"status":"paid"}`))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	if len(rec.entries) != 1 {
+		t.Fatalf("entradas=%d, esperaba 1: %+v", len(rec.entries), rec.entries)
+	}
+	e := rec.entries[0]
+	if e.Action != audit.ActionStatusChange || e.ResourceType != audit.ResourceOrder || e.ResourceID != oid {
+		t.Fatalf("entrada inesperada: %+v", e)
+	}
+	if !strings.HasPrefix(e.Actor, "ip:") {
+		t.Fatalf("actor=%q, esperaba ip:...", e.Actor)
+	}
+	ch := e.Detail.(map[string]audit.Change)["status"]
+	if ch.From != ord.StatusPending || ch.To != ord.StatusPaid {
+		t.Fatalf("cambio=%+v, esperaba pending->paid", ch)
+	}
+}
+
+// ===== POST /orders/merge-users =====
+func TestMergeUsers(t *testing.T) {
+	t.Parallel()
+
+	dup, keep, other := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{history: []ord.Order{
+		{ID: uuid.NewString(), UserID: dup},
+		{ID: uuid.NewString(), UserID: keep},
+		{ID: uuid.NewString(), UserID: dup},
+		{ID: uuid.NewString(), UserID: other},
+	}}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders/merge-users", mergeUsersHandler(repo))
+	post := func(from, to string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders/merge-users", bytes.NewBufferString(fmt.Sprintf(`{"from_user_id":%q,"to_user_id":%q}`, from, to)))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := post(dup, keep)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"moved":2`) {
+		t.Fatalf("status=%d body=%s, esperaba 200 con moved=2", w.Code, w.Body.String())
+	}
+	for _, o := range repo.history {
+		if o.UserID == dup {
+			t.Fatalf("la orden %s sigue en el usuario duplicado", o.ID)
+		}
+	}
+	if got := []string{repo.history[0].UserID, repo.history[1].UserID, repo.history[2].UserID, repo.history[3].UserID}; got[0] != keep || got[1] != keep || got[2] != keep || got[3] != other {
+		t.Fatalf("usuarios=%v, esperaba las 3 primeras en keep y la última intacta", got)
+	}
+
+	// consigo mismo (también con otra capitalización) o ids inválidos: 422 y nada se mueve
+	for _, tc := range [][2]string{{keep, keep}, {keep, strings.ToUpper(keep)}, {"nope", keep}, {keep, ""}} {
+		if w := post(tc[0], tc[1]); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s -> %s: status=%d body=%s, esperaba 422", tc[0], tc[1], w.Code, w.Body.String())
+		}
+	}
+	if repo.history[3].UserID != other {
+		t.Fatal("un merge rechazado movió órdenes")
+	}
+}
+
+// ===== POST /orders/user/:user_id/cancel-pending =====
+func TestCancelPending(t *testing.T) {
+	t.Parallel()
+
+	a, b := uuid.NewString(), uuid.NewString()
+	psrv, states := newProductsServer(t, productState{ID: a}, productState{ID: b})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	uid, other := uuid.NewString(), uuid.NewString()
+	p1, p2, p3, broken := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	paid, shipped, foreign := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := &stubRepo{
+		history: []ord.Order{
+			{ID: p1, UserID: uid, Status: ord.StatusPending, Total: "0.00"},
+			{ID: paid, UserID: uid, Status: ord.StatusPaid, Total: "0.00"},
+			{ID: p2, UserID: uid, Status: ord.StatusPending, Total: "0.00"},
+			{ID: shipped, UserID: uid, Status: ord.StatusShipped, Total: "0.00"},
+			{ID: p3, UserID: uid, Status: ord.StatusPending, Total: "0.00"},
+			{ID: broken, UserID: uid, Status: ord.StatusPending, Total: "0.00"},
+			{ID: foreign, UserID: other, Status: ord.StatusPending, Total: "0.00"},
+		},
+		itemsByOrder: map[string][]ord.Item{
+			p1:      {{ProductID: a, Quantity: 2}},
+			p2:      {{ProductID: b, Quantity: 1}},
+			p3:      {{ProductID: a, Quantity: 1}, {ProductID: b, Quantity: 3}},
+			paid:    {{ProductID: a, Quantity: 7}},
+			foreign: {{ProductID: b, Quantity: 9}},
+		},
+		// páginas de 2: las pendientes se recorren en varias
+		maxListRows: 2,
+		statusErr:   map[string]error{broken: errors.New("db down")},
+	}
+	gin.SetMode(gin.TestMode)
+	post := func(opts orderOptions, userID string) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.POST("/orders/user/:user_id/cancel-pending", cancelPendingHandler(repo, ext, opts))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/user/"+userID+"/cancel-pending", nil))
+		return w
+	}
+
+	w := post(defaultOrderOptions(), uid)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s, esperaba 200", w.Code, w.Body.String())
+	}
+	var res struct {
+		Pending  int             `json:"pending"`
+		Canceled int             `json:"canceled"`
+		Failures []cancelFailure `json:"failures"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Pending != 4 || res.Canceled != 3 || len(res.Failures) != 1 || res.Failures[0].OrderID != broken {
+		t.Fatalf("respuesta=%+v, esperaba 4 pendientes, 3 canceladas y la falla de %s", res, broken)
+	}
+
+	// solo las pendientes del usuario se cancelan; la que falló sigue pendiente
+	want := map[string]ord.Status{
+		p1: ord.StatusCanceled, p2: ord.StatusCanceled, p3: ord.StatusCanceled, broken: ord.StatusPending,
+		paid: ord.StatusPaid, shipped: ord.StatusShipped, foreign: ord.StatusPending,
+	}
+	for _, o := range repo.history {
+		if o.Status != want[o.ID] {
+			t.Fatalf("orden %s en %s, esperaba %s", o.ID, o.Status, want[o.ID])
+		}
+	}
+	// y solo su stock vuelve: a = 2+1, b = 1+3
+	if states[a].Stock != 3 || states[b].Stock != 4 {
+		t.Fatalf("stock a=%d b=%d, esperaba 3 y 4", states[a].Stock, states[b].Stock)
+	}
+
+	// si releer la orden falla por la base, la falla lo dice (no es un "not found")
+	repo.getErr = errors.New("conn closed")
+	w = post(defaultOrderOptions(), other)
+	repo.getErr = nil
+	res.Failures = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || res.Pending != 1 || res.Canceled != 0 || len(res.Failures) != 1 || res.Failures[0].Error != "get order error" {
+		t.Fatalf("status=%d respuesta=%+v, esperaba la falla \"get order error\"", w.Code, res)
+	}
+
+	// user_id que no es UUID, o un ciclo de vida sin pending->canceled: nada se toca
+	if w := post(defaultOrderOptions(), other+"x"); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("user_id inválido: status=%d, esperaba 422", w.Code)
+	}
+	noCancel, err := ord.ParseTransitions("pending:paid;paid:shipped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := defaultOrderOptions()
+	opts.Transitions = noCancel
+	if w := post(opts, other); w.Code != http.StatusConflict || repo.history[6].Status != ord.StatusPending {
+		t.Fatalf("pending->canceled deshabilitado: status=%d, esperaba 409 sin cambios", w.Code)
+	}
+}
+
+// ===== POST /orders/:id/recompute-total =====
+func TestRecomputeTotal_FixesStaleTotal(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "99.99"}, // total erróneo
+		lastItems: []ord.Item{
+			{ID: uuid.NewString(), OrderID: oid, ProductID: uuid.NewString(), Quantity: 2, Price: "10.00"},
+			{ID: uuid.NewString(), OrderID: oid, ProductID: uuid.NewString(), Quantity: 1, Price: "5.50"},
+		},
+	}
+
+	r := gin.New()
+	r.POST("/orders/:id/recompute-total", recomputeTotalHandler(repo, defaultOrderOptions()))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/recompute-total", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	var body struct {
+		OldTotal string `json:"old_total"`
+		NewTotal string `json:"new_total"`
+		Changed  bool   `json:"changed"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	if body.OldTotal != "99.99" || body.NewTotal != "25.50" || !body.Changed {
+		t.Fatalf("respuesta inesperada: %s", w.Body.String())
+	}
+	if repo.lastOrder.Total != "25.50" {
+		t.Fatalf("total persistido=%s, esperado=25.50", repo.lastOrder.Total)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+uuid.NewString()+"/recompute-total", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status=%d body=%s (esperaba 404)", w.Code, w.Body.String())
+	}
+}
+
+func TestAddOrderItem_LockedAfterPayment(t *testing.T) {
+	t.Parallel()
+
+	pid := uuid.NewString()
+	for _, tc := range []struct {
+		name   string
+		status ord.Status
+		locked string
+		want   int
+	}{
+		{"pendiente", ord.StatusPending, "", http.StatusCreated},
+		{"pagado", ord.StatusPaid, "", http.StatusConflict},
+		{"enviado", ord.StatusShipped, "", http.StatusConflict},
+		{"pagado sin bloqueo", ord.StatusPaid, "none", http.StatusCreated},
+		{"pagado con solo total bloqueado", ord.StatusPaid, "total", http.StatusCreated},
+		{"cancelado", ord.StatusCanceled, "none", http.StatusConflict},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			psrv, state := newProductServer(t, productState{ID: pid, Stock: 5, Price: "2.50"})
+			defer psrv.Close()
+			ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+			oid := uuid.NewString()
+			repo := &stubRepo{
+				lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: tc.status, Total: "10.00"},
+				lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, LineNo: 1, ProductID: pid, Quantity: 1, Price: "10.00", LineTotal: "10.00"}},
+			}
+			opts := defaultOrderOptions()
+			locked, err := ord.ParseLockedFields(tc.locked)
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts.LockedFields = locked
+
+			r := gin.New()
+			r.POST("/orders/:id/items", addOrderItemHandler(repo, ext, opts))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/items",
+				bytes.NewBufferString(fmt.Sprintf(`{"product_id":%q,"quantity":2}`, pid))))
+			if w.Code != tc.want {
+				t.Fatalf("status=%d body=%s (esperaba %d)", w.Code, w.Body.String(), tc.want)
+			}
+
+			if tc.want != http.StatusCreated {
+				// rechazado: ni stock ni líneas ni total cambian
+				if state.Stock != 5 || len(repo.lastItems) != 1 || repo.lastOrder.Total != "10.00" {
+					t.Fatalf("stock=%d líneas=%d total=%s tras un 409", state.Stock, len(repo.lastItems), repo.lastOrder.Total)
+				}
+				if tc.status == ord.StatusPaid && !strings.Contains(w.Body.String(), `"field":"items"`) {
+					t.Fatalf("el 409 no nombra el campo bloqueado: %s", w.Body.String())
+				}
+				return
+			}
+			if state.Stock != 3 || len(repo.lastItems) != 2 || repo.lastItems[1].LineNo != 2 {
+				t.Fatalf("stock=%d líneas=%+v, esperaba 3 y una segunda línea", state.Stock, repo.lastItems)
+			}
+			if repo.lastOrder.Total != "15.00" || !strings.Contains(w.Body.String(), `"total":"15.00"`) {
+				t.Fatalf("total=%s body=%s, esperaba 15.00", repo.lastOrder.Total, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAddOrderItem_NoStock(t *testing.T) {
+	t.Parallel()
+
+	pid := uuid.NewString()
+	psrv, state := newProductServer(t, productState{ID: pid, Stock: 1})
+	defer psrv.Close()
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+	oid := uuid.NewString()
+	repo := &stubRepo{lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "0.00"}}
+
+	r := gin.New()
+	r.POST("/orders/:id/items", addOrderItemHandler(repo, ext, defaultOrderOptions()))
+	cases := []struct {
+		id, body string
+		want     int
+	}{
+		{oid, fmt.Sprintf(`{"product_id":%q,"quantity":2}`, pid), http.StatusConflict},
+		{oid, fmt.Sprintf(`{"product_id":%q,"quantity":0}`, pid), http.StatusUnprocessableEntity},
+		{oid, fmt.Sprintf(`{"product_id":%q,"quantity":1}`, uuid.NewString()), http.StatusUnprocessableEntity},
+		{uuid.NewString(), fmt.Sprintf(`{"product_id":%q,"quantity":1}`, pid), http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+tc.id+"/items", bytes.NewBufferString(tc.body)))
+		if w.Code != tc.want {
+			t.Fatalf("%s: status=%d body=%s (esperaba %d)", tc.body, w.Code, w.Body.String(), tc.want)
+		}
+	}
+	if state.Stock != 1 || len(repo.lastItems) != 0 {
+		t.Fatalf("stock=%d líneas=%d, nada debía cambiar", state.Stock, len(repo.lastItems))
+	}
+}
+
+func TestRecomputeTotal_LockedAfterPayment(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	newRepo := func() *stubRepo {
+		return &stubRepo{
+			lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "99.99"},
+			lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: uuid.NewString(), Quantity: 2, Price: "10.00"}},
+		}
+	}
+
+	repo := newRepo()
+	r := gin.New()
+	r.POST("/orders/:id/recompute-total", recomputeTotalHandler(repo, defaultOrderOptions()))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/recompute-total", nil))
+	if w.Code != http.StatusConflict || repo.lastOrder.Total != "99.99" {
+		t.Fatalf("status=%d total=%s (esperaba 409 y el total intacto)", w.Code, repo.lastOrder.Total)
+	}
+
+	// con ORDER_LOCKED_FIELDS=items el total vuelve a poder corregirse
+	repo = newRepo()
+	opts := defaultOrderOptions()
+	opts.LockedFields = ord.LockedFields{ord.FieldItems: true}
+	r = gin.New()
+	r.POST("/orders/:id/recompute-total", recomputeTotalHandler(repo, opts))
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/recompute-total", nil))
+	if w.Code != http.StatusOK || repo.lastOrder.Total != "20.00" {
+		t.Fatalf("status=%d total=%s (esperaba 200 y 20.00)", w.Code, repo.lastOrder.Total)
+	}
+}
+
+// ===== ?case=camel / Accept: application/json; case=camel =====
+func TestGetOrder_CamelCase(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "20.00"},
+		lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: uuid.NewString(), Quantity: 2, Price: "10.00"}},
+	}
+
+	r := gin.New()
+	r.Use(httpx.JSONCase())
+	r.GET("/orders/:id", getOrderHandler(repo))
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/orders/"+oid, nil)
+	req.Header.Set("Accept", "application/json; case=camel")
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s (esperaba 200)", w.Code, w.Body.String())
+	}
+	body := w.Body.String()
+	for _, k := range []string{`"userId"`, `"createdAt"`, `"orderId"`, `"productId"`} {
+		if !strings.Contains(body, k) {
+			t.Fatalf("falta la clave %s: %s", k, body)
+		}
+	}
+	if strings.Contains(body, `"user_id"`) {
+		t.Fatalf("quedó una clave snake_case: %s", body)
+	}
+}
+
+// ===== Drafts: POST /orders?draft=true, /commit y expiración =====
+func TestDraftOrder_HoldCommitAndExpiry(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 5})
+	defer psrv.Close()
+
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+	repo := &stubRepo{}
+
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	r.POST("/orders/:id/commit", commitDraftHandler(repo, defaultOrderOptions()))
+
+	newDraft := func() {
+		body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":2}]}`, uuid.NewString(), prodID)
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders?draft=true", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+
+	// 1) el draft reserva stock
+	newDraft()
+	if repo.lastOrder.Status != ord.StatusDraft || repo.lastOrder.ExpiresAt == nil {
+		t.Fatalf("esperaba un draft con expires_at, status=%s", repo.lastOrder.Status)
+	}
+	if pstate.Stock != 3 {
+		t.Fatalf("stock esperado=3 (reservado), real=%d", pstate.Stock)
+	}
+
+	// 2) commit => pending, la reserva se mantiene
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+repo.lastOrder.ID+"/commit", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("commit status=%d body=%s", w.Code, w.Body.String())
+	}
+	if repo.lastOrder.Status != ord.StatusPending || pstate.Stock != 3 {
+		t.Fatalf("tras commit: status=%s stock=%d", repo.lastOrder.Status, pstate.Stock)
+	}
+	// un segundo commit ya no es un draft
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+repo.lastOrder.ID+"/commit", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("commit repetido status=%d (esperaba 409)", w.Code)
+	}
+
+	// 3) un draft vencido libera su reserva
+	newDraft()
+	if pstate.Stock != 1 {
+		t.Fatalf("stock esperado=1, real=%d", pstate.Stock)
+	}
+	past := time.Now().Add(-time.Minute)
+	repo.lastOrder.ExpiresAt = &past
+
+	n, err := releaseExpiredDrafts(context.Background(), repo, ext, defaultOrderOptions())
+	if err != nil || n != 1 {
+		t.Fatalf("releaseExpiredDrafts n=%d err=%v", n, err)
+	}
+	if pstate.Stock != 3 {
+		t.Fatalf("stock tras expirar=%d, esperado=3", pstate.Stock)
+	}
+	if repo.lastOrder.Status != ord.StatusCanceled {
+		t.Fatalf("estado tras expirar=%s, esperado=canceled", repo.lastOrder.Status)
+	}
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+repo.lastOrder.ID+"/commit", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("commit de draft expirado status=%d (esperaba 409)", w.Code)
+	}
+}
+
+// ===== POST /orders/:id/pay =====
+func TestPayOrder(t *testing.T) {
+	t.Parallel()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "20.00"},
+	}
+	r := gin.New()
+	r.POST("/orders/:id/pay", payOrderHandler(repo, defaultOrderOptions()))
+
+	pay := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/pay", nil))
+		return w
+	}
+
+	w := pay()
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"changed":true`) {
+		t.Fatalf("pay status=%d body=%s", w.Code, w.Body.String())
+	}
+	if repo.lastOrder.Status != ord.StatusPaid || repo.lastOrder.PaidAt == nil {
+		t.Fatalf("estado=%s paid_at=%v", repo.lastOrder.Status, repo.lastOrder.PaidAt)
+	}
+	paidAt := *repo.lastOrder.PaidAt
+
+	// doble pago => 200 no-op, paid_at intacto
+	w = pay()
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"changed":false`) {
+		t.Fatalf("double pay status=%d body=%s", w.Code, w.Body.String())
+	}
+	if !repo.lastOrder.PaidAt.Equal(paidAt) {
+		t.Fatalf("paid_at cambió en el doble pago")
+	}
+
+	// orden cancelada => 409
+	repo.lastOrder.Status = ord.StatusCanceled
+	if w := pay(); w.Code != http.StatusConflict {
+		t.Fatalf("pay canceled status=%d body=%s (esperaba 409)", w.Code, w.Body.String())
+	}
+}
+
+// ===== POST/GET /orders/:id/refunds =====
+func TestRefunds(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 0})
+	defer psrv.Close()
+
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "30.00"},
+		lastItems: []ord.Item{{ID: uuid.NewString(), OrderID: oid, ProductID: prodID, Quantity: 3, Price: "10.00"}},
+	}
+	ext := &ord.Ext{HTTP: &http.Client{Timeout: 2 * time.Second}, ProductBaseURL: psrv.URL}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.POST("/orders/:id/refunds", createRefundHandler(repo, ext, defaultOrderOptions()))
+	r.GET("/orders/:id/refunds", listRefundsHandler(repo, defaultOrderOptions()))
+
+	refund := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/refunds", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// orden no pagada => 409
+	if w := refund(`{"amount":"5.00"}`); w.Code != http.StatusConflict {
+		t.Fatalf("refund pending status=%d (esperaba 409)", w.Code)
+	}
+	repo.lastOrder.Status = ord.StatusPaid
+
+	// reembolso parcial válido con reposición de 1 unidad
+	w := refund(fmt.Sprintf(`{"amount":"10.00","reason":"dañado","restock":true,"items":[{"product_id":%q,"quantity":1}]}`, prodID))
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"refunded_total":"10.00"`) {
+		t.Fatalf("refund 1 status=%d body=%s", w.Code, w.Body.String())
+	}
+	if pstate.Stock != 1 || len(pstate.Reasons) != 1 || pstate.Reasons[0] != ord.StockReasonRefund {
+		t.Fatalf("restock: stock=%d reasons=%v", pstate.Stock, pstate.Reasons)
+	}
+
+	// acumulado: 10 + 15 = 25
+	if w := refund(`{"amount":"15"}`); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"refunded_total":"25.00"`) {
+		t.Fatalf("refund 2 status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// quedan 5.00: 5.01 se rechaza, montos inválidos => 422
+	if w := refund(`{"amount":"5.01"}`); w.Code != http.StatusConflict {
+		t.Fatalf("over-refund status=%d (esperaba 409)", w.Code)
+	}
+	for _, bad := range []string{`{"amount":"-1"}`, `{"amount":"0"}`, `{"amount":"1.001"}`, `{"amount":"x"}`} {
+		if w := refund(bad); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s status=%d (esperaba 422)", bad, w.Code)
+		}
+	}
+	// más unidades que las pedidas (3 - 1 ya reembolsada)
+	if w := refund(fmt.Sprintf(`{"amount":"1","items":[{"product_id":%q,"quantity":3}]}`, prodID)); w.Code != http.StatusConflict {
+		t.Fatalf("items over status=%d (esperaba 409)", w.Code)
+	}
+
+	// el resto exacto entra
+	if w := refund(`{"amount":"5.00"}`); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"refunded_total":"30.00"`) {
+		t.Fatalf("refund 3 status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/refunds", nil))
+	var list struct {
+		Items         []ord.Refund `json:"items"`
+		RefundedTotal string       `json:"refunded_total"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || len(list.Items) != 3 || list.RefundedTotal != "30.00" {
+		t.Fatalf("list status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestRefunds_PriceDecimals(t *testing.T) {
+	t.Parallel()
+
+	// PRICE_DECIMALS=4: el total de 10.1234 se puede reembolsar entero
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPaid, Total: "10.1234"},
+	}
+	opts := defaultOrderOptions()
+	opts.PriceDecimals = 4
+	r := gin.New()
+	r.POST("/orders/:id/refunds", createRefundHandler(repo, &ord.Ext{}, opts))
+	r.GET("/orders/:id/refunds", listRefundsHandler(repo, opts))
+	refund := func(amount string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders/"+oid+"/refunds", bytes.NewBufferString(fmt.Sprintf(`{"amount":%q}`, amount)))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := refund("0.00001"); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "at most 4 decimals") {
+		t.Fatalf("5 decimales: status=%d body=%s, esperaba 422", w.Code, w.Body.String())
+	}
+	if w := refund("0.1234"); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"amount":"0.1234"`) || !strings.Contains(w.Body.String(), `"refunded_total":"0.1234"`) {
+		t.Fatalf("refund 1 status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := refund("10.0001"); w.Code != http.StatusConflict {
+		t.Fatalf("sobre el resto: status=%d, esperaba 409", w.Code)
+	}
+	if w := refund("10"); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"refunded_total":"10.1234"`) {
+		t.Fatalf("refund 2 status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/orders/"+oid+"/refunds", nil))
+	if !strings.Contains(w.Body.String(), `"refunded_total":"10.1234"`) || !strings.Contains(w.Body.String(), `"amount":"10.0000"`) {
+		t.Fatalf("list status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+// ===== POST /orders/webhook/payment =====
+func TestPaymentWebhook(t *testing.T) {
+	t.Parallel()
+
+	const secret = "s3cr3t"
+	oid := uuid.NewString()
+	repo := &stubRepo{
+		lastOrder: &ord.Order{ID: oid, UserID: uuid.NewString(), Status: ord.StatusPending, Total: "20.00"},
+	}
+	opts := defaultOrderOptions()
+	opts.PaymentSecret = secret
+
+	r := gin.New()
+	r.POST("/orders/webhook/payment", paymentWebhookHandler(repo, opts))
+
+	send := func(body, sig string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/orders/webhook/payment", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Signature", sig)
+		r.ServeHTTP(w, req)
+		return w
+	}
+	body := fmt.Sprintf(`{"order_id":%q,"status":"paid","provider_ref":"pay_123"}`, oid)
+
+	// firma inválida => 401, sin cambios
+	if w := send(body, ord.SignPayload("otro", []byte(body))); w.Code != http.StatusUnauthorized {
+		t.Fatalf("status=%d body=%s (esperaba 401)", w.Code, w.Body.String())
+	}
+	if repo.lastOrder.Status != ord.StatusPending {
+		t.Fatalf("el estado cambió con firma inválida: %s", repo.lastOrder.Status)
+	}
+
+	// callback válido => paid
+	w := send(body, ord.SignPayload(secret, []byte(body)))
+	if w.Code != http.StatusOK || repo.lastOrder.Status != ord.StatusPaid {
+		t.Fatalf("status=%d estado=%s body=%s", w.Code, repo.lastOrder.Status, w.Body.String())
+	}
+
+	// replay => 200 no-op
+	w = send(body, "sha256="+ord.SignPayload(secret, []byte(body)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"replayed":true`) {
+		t.Fatalf("replay status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// orden desconocida => 404
+	unknown := fmt.Sprintf(`{"order_id":%q,"status":"paid","provider_ref":"pay_999"}`, uuid.NewString())
+	if w := send(unknown, ord.SignPayload(secret, []byte(unknown))); w.Code != http.StatusNotFound {
+		t.Fatalf("status=%d body=%s (esperaba 404)", w.Code, w.Body.String())
+	}
+}
+
+// fakeUserServer is a minimal gRPC UserService that only answers ValidateUser.
+type fakeUserServer struct {
+	userpb.UnimplementedUserServiceServer
+}
+
+func (fakeUserServer) ValidateUser(ctx context.Context, in *userpb.ValidateUserRequest) (*userpb.ValidateUserResponse, error) {
+	return &userpb.ValidateUserResponse{Ok: true}, nil
+}
+
+// ===== X-Request-ID propagado a user-service (gRPC) y product-service (HTTP) =====
+// No es paralelo: captura la salida del log global.
+func TestCreateOrder_PropagatesRequestID(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(io.Discard)
+
+	// user-service real (gRPC) con el interceptor de request ID
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer(grpc.UnaryInterceptor(user.RequestIDInterceptor()))
+	userpb.RegisterUserServiceServer(gs, fakeUserServer{})
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	// product fake que registra el header recibido
+	prodID := uuid.NewString()
+	psrv, _ := newProductServer(t, productState{ID: prodID, Price: "10.00", Stock: 5})
+	defer psrv.Close()
+	var seen []string
+	var mu sync.Mutex
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.Header.Get("X-Request-ID"))
+		mu.Unlock()
+		psrv.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	ext, err := ord.NewExt(lis.Addr().String(), proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(httpx.RequestID(), httpx.Logger())
+	r.POST("/orders", createOrderHandler(&stubRepo{}, ext, defaultOrderOptions()))
+
+	const rid = "test-rid-123"
+	body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, uuid.NewString(), prodID)
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Request-ID", rid)
+	r.ServeHTTP(w, req)
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	out := logs.String()
+	if !strings.Contains(out, "[grpc] rid="+rid) {
+		t.Fatalf("el log de user-service no contiene rid=%s:\n%s", rid, out)
+	}
+	if !strings.Contains(out, "[http] rid="+rid) {
+		t.Fatalf("el log de order-service no contiene rid=%s:\n%s", rid, out)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) == 0 {
+		t.Fatalf("product-service no recibió solicitudes")
+	}
+	for _, h := range seen {
+		if h != rid {
+			t.Fatalf("product-service recibió X-Request-ID=%q, esperado=%q", h, rid)
+		}
+	}
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
 	log.SetOutput(io.Discard)
 }
+
+func TestCreateOrder_AuthTrustBoundary(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Stock: 10})
+	defer psrv.Close()
+
+	owner, other := uuid.NewString(), uuid.NewString()
+	ext := &ord.Ext{
+		HTTP:           &http.Client{Timeout: 2 * time.Second},
+		User:           &fakeUserClient{ok: true, sessions: map[string]string{"tok-owner": owner}},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+	post := func(r http.Handler, token, userID string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, userID, prodID)
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	opts := defaultOrderOptions()
+	opts.Auth = true
+	repo := &stubRepo{}
+	r := gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, opts))
+
+	// user_id del cuerpo igual al de la sesión, o vacío: la orden es del usuario autenticado
+	for _, bodyUser := range []string{owner, ""} {
+		repo.lastOrder = nil
+		if w := post(r, "tok-owner", bodyUser); w.Code != http.StatusCreated {
+			t.Fatalf("usuario del cuerpo %q: status=%d body=%s", bodyUser, w.Code, w.Body.String())
+		}
+		if repo.lastOrder == nil || repo.lastOrder.UserID != owner {
+			t.Fatalf("usuario del cuerpo %q: order=%+v, esperaba que fuera de %s", bodyUser, repo.lastOrder, owner)
+		}
+	}
+
+	// otro user_id en el cuerpo: 403 y no se toca el stock
+	stock := pstate.Stock
+	repo.lastOrder = nil
+	if w := post(r, "tok-owner", other); w.Code != http.StatusForbidden {
+		t.Fatalf("usuario distinto: status=%d, esperaba 403", w.Code)
+	}
+	// sin token o con uno inválido: 401
+	for _, tok := range []string{"", "tok-unknown"} {
+		if w := post(r, tok, owner); w.Code != http.StatusUnauthorized {
+			t.Fatalf("token %q: status=%d, esperaba 401", tok, w.Code)
+		}
+	}
+	if repo.lastOrder != nil || pstate.Stock != stock {
+		t.Fatalf("solicitudes rechazadas crearon una orden o movieron stock (stock %d -> %d)", stock, pstate.Stock)
+	}
+
+	// autenticación deshabilitada (desarrollo local): se usa el user_id del cuerpo
+	r = gin.New()
+	r.POST("/orders", createOrderHandler(repo, ext, defaultOrderOptions()))
+	if w := post(r, "", other); w.Code != http.StatusCreated || repo.lastOrder.UserID != other {
+		t.Fatalf("autenticación deshabilitada: status=%d order=%+v", w.Code, repo.lastOrder)
+	}
+}
+
+// ===== POST /orders detrás de httpx.RequireAuth (AUTH_ENABLED + JWT_SECRET) =====
+func TestCreateOrder_JWT(t *testing.T) {
+	t.Parallel()
+
+	prodID := uuid.NewString()
+	psrv, pstate := newProductServer(t, productState{ID: prodID, Stock: 10})
+	defer psrv.Close()
+
+	const secret = "test-secret"
+	owner := uuid.NewString()
+	ext := &ord.Ext{
+		HTTP: &http.Client{Timeout: 2 * time.Second},
+		// sin sesiones: el JWT se valida sin preguntarle a user-service
+		User:           &fakeUserClient{ok: true, sessions: map[string]string{}},
+		ProductBaseURL: strings.TrimRight(psrv.URL, "/"),
+	}
+	opts := defaultOrderOptions()
+	opts.Auth = true
+	repo := &stubRepo{}
+	r := gin.New()
+	r.POST("/orders", httpx.RequireAuth(secret, 0), createOrderHandler(repo, ext, opts))
+
+	post := func(token, userID string) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"user_id":%q,"items":[{"product_id":%q,"quantity":1}]}`, userID, prodID)
+		req := httptest.NewRequest(http.MethodPost, "/orders", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(key string, c jwt.Claims) string {
+		tok, err := jwt.Sign([]byte(key), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	valid := sign(secret, jwt.New(owner, time.Now(), time.Minute))
+	if w := post(valid, ""); w.Code != http.StatusCreated || repo.lastOrder == nil || repo.lastOrder.UserID != owner {
+		t.Fatalf("token válido: status=%d order=%+v body=%s", w.Code, repo.lastOrder, w.Body.String())
+	}
+	if w := post(valid, uuid.NewString()); w.Code != http.StatusForbidden {
+		t.Fatalf("otro user_id en el body: status=%d, esperaba 403", w.Code)
+	}
+
+	// sin token, vencido o con la firma alterada: 401 antes de tocar stock
+	forged := sign("otro-secreto", jwt.New(owner, time.Now(), time.Minute))
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + strings.Split(sign(secret, jwt.New(uuid.NewString(), time.Now(), time.Minute)), ".")[1] + "." + parts[2]
+	stock := pstate.Stock
+	repo.lastOrder = nil
+	for name, tok := range map[string]string{
+		"sin token":  "",
+		"vencido":    sign(secret, jwt.New(owner, time.Now().Add(-time.Hour), time.Minute)),
+		"alterado":   tampered,
+		"otra clave": forged,
+	} {
+		if w := post(tok, owner); w.Code != http.StatusUnauthorized {
+			t.Fatalf("%s: status=%d, esperaba 401", name, w.Code)
+		}
+	}
+	if repo.lastOrder != nil || pstate.Stock != stock {
+		t.Fatalf("un token rechazado creó una orden o movió stock (stock %d -> %d)", stock, pstate.Stock)
+	}
+}
+
+func TestNewHTTPServer_UsesOrderSvcAddr(t *testing.T) {
+	cfg := config.Config{OrderSvcAddr: "127.0.0.1:0", ProductSvcBaseURL: "http://product:8081"}
+	srv := newHTTPServer(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	if srv.Addr != cfg.OrderSvcAddr {
+		t.Fatalf("Addr=%q, esperaba %q (ORDER_SERVICE_ADDR)", srv.Addr, cfg.OrderSvcAddr)
+	}
+
+	// escucha donde dice la config y responde en el puerto que realmente tomó
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() { _ = srv.Serve(ln) }()
+	defer srv.Close()
+	resp, err := http.Get("http://" + ln.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("status=%d, esperaba 204", resp.StatusCode)
+	}
+}
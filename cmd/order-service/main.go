@@ -6,8 +6,14 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -20,11 +26,16 @@ import (
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/shopspring/decimal"
+	"golang.org/x/sync/errgroup"
 
 	_ "github.com/MikeMC777/ordenes-ecom/docs-order"
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
 	"github.com/MikeMC777/ordenes-ecom/internal/config"
 	"github.com/MikeMC777/ordenes-ecom/internal/httpx"
+	"github.com/MikeMC777/ordenes-ecom/internal/idempotency"
 	ord "github.com/MikeMC777/ordenes-ecom/internal/order"
+	"github.com/MikeMC777/ordenes-ecom/internal/ratelimit"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -33,258 +44,1814 @@ type HTTPError struct {
 	Error string `json:"error"`
 }
 
+// StockConflict is the 409 body of an order asking for more units of a product than it has.
+type StockConflict struct {
+	Error     string `json:"error"`
+	ProductID string `json:"product_id"`
+	Requested int    `json:"requested"`
+	Available int    `json:"available"`
+}
+
+// MissingFields is the 422 body of an order request lacking required fields, naming each one
+// so clients can point at them: {"error":{"code":"missing_fields","fields":["user_id"]}}.
+type MissingFields struct {
+	Error MissingFieldsError `json:"error"`
+}
+
+type MissingFieldsError struct {
+	Code   string   `json:"code" example:"missing_fields"`
+	Fields []string `json:"fields"`
+}
+
+// requireOrderFields answers 422 MissingFields and returns false when in lacks user_id or items.
+func requireOrderFields(c *gin.Context, in ord.CreateOrderRequest) bool {
+	missing := in.MissingFields()
+	if len(missing) == 0 {
+		return true
+	}
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, MissingFields{MissingFieldsError{Code: "missing_fields", Fields: missing}})
+	return false
+}
+
+// orderOptions holds the configurable behavior of the order handlers.
+type orderOptions struct {
+	// DraftTTL is how long a draft holds its stock before it expires.
+	DraftTTL time.Duration
+	// PaymentSecret is the HMAC key of the payment provider callbacks.
+	PaymentSecret string
+	// RestockNotFound is what a cancel does when an item's product was deleted.
+	RestockNotFound ord.RestockPolicy
+	// SagaTimeout is how long an order creation may make no progress before it is recovered.
+	SagaTimeout time.Duration
+	// Auth makes order creation require a session and place the order for its user.
+	Auth bool
+	// PriceDecimals is the precision item prices, discounts and totals are frozen with.
+	PriceDecimals int32
+	// RejectZeroTotal refuses orders that total zero unless a discount brought them there.
+	RejectZeroTotal bool
+	// Transitions are the status changes PUT /orders/{id}/status accepts.
+	Transitions ord.Transitions
+	// StatusNoop is what PUT /orders/{id}/status does when the status would not change.
+	StatusNoop ord.NoopPolicy
+	// Audit records order creations and status changes; nil records nothing.
+	Audit audit.Recorder
+	// LockedFields can no longer change once an order is paid (see mutable).
+	LockedFields ord.LockedFields
+}
+
+func defaultOrderOptions() orderOptions {
+	return orderOptions{
+		DraftTTL: 15 * time.Minute, RestockNotFound: ord.RestockRecord, SagaTimeout: 2 * time.Minute,
+		PriceDecimals: ord.DefaultPriceDecimals, Transitions: ord.DefaultTransitions,
+		StatusNoop: ord.NoopIgnore, LockedFields: ord.DefaultLockedFields,
+	}
+}
+
+// mutable is the check every handler that changes an order consults before touching field:
+// once the order is paid, a field in opts.LockedFields answers 409 and false.
+func mutable(c *gin.Context, opts orderOptions, o *ord.Order, field string) bool {
+	if opts.LockedFields.Mutable(o, field) {
+		return true
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": "order field locked after payment", "field": field})
+	return false
+}
+
+// sessionSubject resolves the caller from "Authorization: Bearer <session_id>" via user-service.
+// Otherwise it answers 401 (502 if user-service fails) and returns false.
+func sessionSubject(c *gin.Context, ext *ord.Ext) (string, bool) {
+	token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	token = strings.TrimSpace(token)
+	if !found || token == "" {
+		c.JSON(http.StatusUnauthorized, HTTPError{"authentication required"})
+		return "", false
+	}
+	userID, ok, err := ext.VerifySession(c.Request.Context(), token)
+	if err != nil {
+		log.Printf("[auth] verify session error: %v", err)
+		c.JSON(http.StatusBadGateway, HTTPError{"user service error"})
+		return "", false
+	}
+	if !ok {
+		c.JSON(http.StatusUnauthorized, HTTPError{"invalid or expired session"})
+		return "", false
+	}
+	// from here on the caller's changes are audited as the session's user
+	c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), "user:"+userID))
+	return userID, true
+}
+
+// orderCaller names who places an order, scoping its Idempotency-Key: the JWT's user when
+// httpx.RequireAuth ran, the session's user with AUTH_ENABLED (the handler verifies the
+// session again), otherwise the body's user_id. A caller it cannot resolve is "" and the
+// handler answers the error.
+func orderCaller(ext *ord.Ext, opts orderOptions) func(*gin.Context) string {
+	return func(c *gin.Context) string {
+		if sub, ok := httpx.AuthUser(c); ok {
+			return sub
+		}
+		if opts.Auth {
+			token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			token = strings.TrimSpace(token)
+			if !found || token == "" {
+				return ""
+			}
+			userID, ok, err := ext.VerifySession(c.Request.Context(), token)
+			if err != nil || !ok {
+				return ""
+			}
+			return userID
+		}
+		// the handler decodes the body again
+		body, err := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		if err != nil {
+			return ""
+		}
+		var in struct {
+			UserID string `json:"user_id"`
+		}
+		_ = json.Unmarshal(body, &in)
+		return in.UserID
+	}
+}
+
 // createOrderHandler godoc
 // @Summary      Create order
-// @Description  Validates user, checks stock, decrements inventory, and stores order & items.
+// @Description  Validates user, checks stock, decrements inventory, and stores order & items. A product whose status is not active is 409.
+// @Description  With draft=true the order is stored as 'draft': stock is held until it expires or is committed.
+// @Description  With dry_run=true it validates and freezes prices but neither moves stock nor persists: 200 with the would-be order, its items and each product's would_remaining stock.
+// @Tags         orders
+// @Accept       json
+// @Produce      json
+// @Description  With AUTH_ENABLED=true a session is required and the order belongs to its user: a different body user_id is 403. With JWT_SECRET set as well, the bearer is the JWT from AuthenticateUser instead; a missing, tampered or expired one is 401.
+// @Description  A body without user_id or items is 422 {"error":{"code":"missing_fields","fields":["user_id","items"]}}, listing exactly the missing ones.
+// @Param        draft query     bool                      false "Create as draft (holds stock, expires)"
+// @Param        dry_run query   bool                      false "Preview only: nothing is stored and stock is not touched"
+// @Param        Authorization header string               false "Bearer <session_id>, or Bearer <jwt> with JWT_SECRET (required with AUTH_ENABLED=true)"
+// @Param        Idempotency-Key header string             false "Retries by the same user with the same key replay the first response (IDEMPOTENCY_TTL); 409 while the first is still running"
+// @Param        body  body      order.CreateOrderRequest  true  "user_id & items"
+// @Success      200   {object}  map[string]interface{}  "dry_run=true"
+// @Success      201   {object}  map[string]interface{}
+// @Failure      400   {object}  HTTPError
+// @Failure      401   {object}  HTTPError
+// @Failure      403   {object}  HTTPError
+// @Failure      409   {object}  StockConflict  "insufficient stock (product_id, requested, available) or product unavailable"
+// @Failure      422   {object}  HTTPError
+// @Failure      500   {object}  HTTPError
+// @Failure      502   {object}  HTTPError
+// @Router       /orders [post]
+func createOrderHandler(repo ord.Repository, ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		draft, _ := strconv.ParseBool(c.DefaultQuery("draft", "false"))
+		dryRun, _ := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+
+		var in ord.CreateOrderRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		// a JWT already checked by httpx.RequireAuth, else the session with AUTH_ENABLED
+		sub, authed := httpx.AuthUser(c)
+		if !authed && opts.Auth {
+			if sub, authed = sessionSubject(c, ext); !authed {
+				return
+			}
+		}
+		if authed {
+			// the body cannot place an order for someone else
+			if in.UserID != "" && in.UserID != sub {
+				c.JSON(http.StatusForbidden, HTTPError{"user_id does not match the authenticated user"})
+				return
+			}
+			in.UserID = sub
+		}
+		if !requireOrderFields(c, in) {
+			return
+		}
+		if err := ord.CheckDuplicateItems(in.Items); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{err.Error()})
+			return
+		}
+
+		// user, items, products and stock; nothing is mutated yet
+		report, lines, stock, err := preflight(c.Request.Context(), ext, in, opts)
+		if err != nil {
+			// a dependency down or failing: not the client's fault, and nothing was mutated
+			log.Printf("[order] preflight error: %v", err)
+			preflightFailed(c, err)
+			return
+		}
+		for _, p := range report.Problems {
+			switch p.Code {
+			case ord.ProblemInvalidUser:
+				httpx.Unprocessable(c, "invalid user")
+				return
+			case ord.ProblemInvalidItem:
+				httpx.Unprocessable(c, "invalid item")
+				return
+			case ord.ProblemProductNotFound:
+				httpx.Unprocessable(c, "product not found")
+				return
+			case ord.ProblemInsufficientStock:
+				c.JSON(http.StatusConflict, StockConflict{"insufficient stock", p.ProductID, p.Requested, *p.Available})
+				return
+			case ord.ProblemProductUnavailable:
+				c.JSON(http.StatusConflict, HTTPError{"product unavailable"})
+				return
+			case ord.ProblemInvalidDiscount:
+				httpx.Unprocessable(c, "invalid discount")
+				return
+			case ord.ProblemZeroTotal:
+				httpx.Unprocessable(c, "order total is zero")
+				return
+			}
+		}
+
+		// The order + items (unit price “frozen”, discount applied) as they would be stored
+		items := make([]ord.Item, 0, len(lines))
+		for i, it := range lines {
+			it.ID = in.Items[i].ID
+			it.LineNo = i + 1
+			items = append(items, it)
+		}
+		o := &ord.Order{
+			UserID: in.UserID,
+			Status: ord.StatusPending,
+			Total:  report.Total,
+		}
+		if draft {
+			exp := time.Now().Add(opts.DraftTTL)
+			o.Status = ord.StatusDraft
+			o.ExpiresAt = &exp
+		}
+
+		if dryRun {
+			// preview only: no id is assigned, no stock moves and nothing is stored
+			now := time.Now()
+			o.CreatedAt, o.UpdatedAt = now, now
+			c.JSON(http.StatusOK, gin.H{"dry_run": true, "order": o, "items": items, "stock": stockImpact(in.Items, stock)})
+			return
+		}
+
+		// id up front so the stock movements in product-service reference the order
+		orderID := uuid.NewString()
+
+		// every decrement is recorded in the order's saga so a creation interrupted
+		// half-way can be compensated by recoverSagas
+		if err := repo.StartSaga(c.Request.Context(), orderID); err != nil {
+			log.Printf("[order] start saga error: %v", err)
+			c.JSON(http.StatusInternalServerError, HTTPError{"create order error"})
+			return
+		}
+		saga := ord.Saga{OrderID: orderID, State: ord.SagaStarted}
+
+		// units asked and already taken per product, for the 409 if stock ran out meanwhile
+		requested := make(map[string]int, len(in.Items))
+		for _, it := range in.Items {
+			requested[it.ProductID] += it.Quantity
+		}
+		taken := make(map[string]int, len(in.Items))
+
+		// line ids up front too: each line takes its stock keyed by (order, line), so a
+		// decrement retried after a lost response is not applied twice
+		for i := range items {
+			if items[i].ID == "" {
+				items[i].ID = uuid.NewString()
+			}
+			items[i].OrderID = orderID
+		}
+
+		// take stock (automatic); the price was frozen by the pre-flight
+		for _, it := range items {
+			if err := ext.TakeStock(c.Request.Context(), it.ProductID, it.Quantity, orderID, it.ID); err != nil {
+				log.Printf("[order] adjust stock %s error: %v", it.ProductID, err)
+				rollbackCreate(c.Request.Context(), repo, ext, &saga)
+				var se *ord.StockError
+				if errors.As(err, &se) {
+					// the rollback gave back what earlier lines had taken of this product
+					c.JSON(http.StatusConflict, StockConflict{"insufficient stock", it.ProductID, requested[it.ProductID], se.Available + taken[it.ProductID]})
+					return
+				}
+				c.JSON(http.StatusBadRequest, HTTPError{"product not found"})
+				return
+			}
+			taken[it.ProductID] += it.Quantity
+			stepID, err := repo.RecordSagaStep(c.Request.Context(), orderID, it.ProductID, it.Quantity)
+			saga.Steps = append(saga.Steps, ord.SagaStep{ID: stepID, ProductID: it.ProductID, Quantity: it.Quantity})
+			if err != nil {
+				log.Printf("[order] record saga step error: %v", err)
+				rollbackCreate(c.Request.Context(), repo, ext, &saga)
+				c.JSON(http.StatusInternalServerError, HTTPError{"create order error"})
+				return
+			}
+		}
+
+		// The order + items persist.
+		o.ID = orderID
+
+		if err := repo.Create(c.Request.Context(), o, items); err != nil {
+			// rollback stock if persistence fails
+			rollbackCreate(c.Request.Context(), repo, ext, &saga)
+			c.JSON(http.StatusInternalServerError, HTTPError{"create order error"})
+			return
+		}
+		if err := repo.FinishSaga(c.Request.Context(), orderID, ord.SagaCompleted); err != nil {
+			// the order exists: recovery will just close the saga
+			log.Printf("[order] finish saga %s error: %v", orderID, err)
+		}
+
+		audit.Log(c.Request.Context(), opts.Audit, audit.Entry{
+			Action: audit.ActionCreate, ResourceType: audit.ResourceOrder, ResourceID: o.ID,
+			Detail: map[string]audit.Change{"status": {To: o.Status}, "total": {To: o.Total}, "items": {To: len(items)}},
+		})
+		outOrder, outItems, _ := repo.GetByID(c.Request.Context(), o.ID)
+		c.JSON(http.StatusCreated, gin.H{"order": outOrder, "items": outItems})
+	}
+}
+
+// preflightFailed answers a preflight error: user-service or product-service failed, so 502
+// naming which one.
+func preflightFailed(c *gin.Context, err error) {
+	msg := "product service error"
+	if errors.Is(err, ord.ErrUserService) {
+		msg = "user service error"
+	}
+	c.JSON(http.StatusBadGateway, HTTPError{msg})
+}
+
+// preflight runs every check order creation needs without mutating anything and
+// collects all the problems instead of stopping at the first one. It also returns each
+// line priced (current unit price, discount and line total, frozen at opts.PriceDecimals), aligned
+// with in.Items, and the current stock of every product found; they are only complete when the
+// report is valid. err is only for infrastructure failures.
+func preflight(ctx context.Context, ext *ord.Ext, in ord.CreateOrderRequest, opts orderOptions) (ord.CartReport, []ord.Item, map[string]int, error) {
+	report := ord.CartReport{Problems: []ord.CartProblem{}}
+	places := opts.PriceDecimals
+
+	ids := make([]string, 0, len(in.Items))
+	requested := make(map[string]int, len(in.Items))
+	var itemProblems []ord.CartProblem
+	for _, it := range in.Items {
+		if it.ProductID == "" || it.Quantity <= 0 {
+			itemProblems = append(itemProblems, ord.CartProblem{Code: ord.ProblemInvalidItem, ProductID: it.ProductID})
+			continue
+		}
+		ids = append(ids, it.ProductID)
+		requested[it.ProductID] += it.Quantity
+	}
+
+	// user (gRPC) and products (HTTP) in one bounded batch, before any stock mutation
+	userOK, products, err := ext.PreCheck(ctx, in.UserID, ids)
+	if err != nil {
+		return report, nil, nil, err
+	}
+	if !userOK {
+		report.Problems = append(report.Problems, ord.CartProblem{Code: ord.ProblemInvalidUser})
+	}
+	report.Problems = append(report.Problems, itemProblems...)
+
+	discounted := false
+	lines := make([]ord.Item, len(in.Items))
+	priced := make([]ord.Item, 0, len(in.Items))
+	stock := make(map[string]int, len(products))
+	reported := make(map[string]bool, len(ids))
+	for i, it := range in.Items {
+		if it.ProductID == "" || it.Quantity <= 0 {
+			continue
+		}
+		p, ok := products[it.ProductID]
+		if !ok {
+			if !reported[it.ProductID] {
+				reported[it.ProductID] = true
+				report.Problems = append(report.Problems, ord.CartProblem{Code: ord.ProblemProductNotFound, ProductID: it.ProductID})
+			}
+			continue
+		}
+		if !p.Orderable() {
+			if !reported[it.ProductID] {
+				reported[it.ProductID] = true
+				report.Problems = append(report.Problems, ord.CartProblem{Code: ord.ProblemProductUnavailable, ProductID: it.ProductID, Status: p.Status})
+			}
+			continue
+		}
+		stock[it.ProductID] = p.Stock
+		price, err := decimal.NewFromString(p.Price)
+		if err != nil {
+			return report, nil, nil, fmt.Errorf("product %s: invalid price %q", p.ID, p.Price)
+		}
+		// freeze price and apply the line discount; the total is summed once below
+		off, line, err := ord.ApplyDiscount(price, it.Quantity, it.Discount, places)
+		if err != nil {
+			report.Problems = append(report.Problems, ord.CartProblem{Code: ord.ProblemInvalidDiscount, ProductID: it.ProductID})
+		}
+		lines[i] = ord.Item{ProductID: it.ProductID, Quantity: it.Quantity, Price: price.StringFixed(places), LineTotal: line.StringFixed(places)}
+		if off.IsPositive() {
+			lines[i].Discount = off.StringFixed(places)
+			discounted = true
+		}
+		priced = append(priced, lines[i])
+
+		if it.ExpectedPrice != "" && priceChanged(it.ExpectedPrice, p.Price) {
+			report.Problems = append(report.Problems, ord.CartProblem{
+				Code: ord.ProblemPriceChanged, ProductID: it.ProductID,
+				ExpectedPrice: it.ExpectedPrice, CurrentPrice: price.StringFixed(places),
+			})
+		}
+		// the same product may appear in several lines: check the sum once
+		if !reported[it.ProductID] && requested[it.ProductID] > p.Stock && !p.AllowBackorder {
+			reported[it.ProductID] = true
+			available := p.Stock
+			report.Problems = append(report.Problems, ord.CartProblem{
+				Code: ord.ProblemInsufficientStock, ProductID: it.ProductID,
+				Requested: requested[it.ProductID], Available: &available,
+			})
+		}
+	}
+
+	_, _, total, err := ord.ComputeOrderTotal(priced, "", "", places)
+	if err != nil {
+		return report, nil, nil, err
+	}
+
+	// a free cart is almost always a catalog or rounding bug, unless a discount explains it
+	if opts.RejectZeroTotal && len(report.Problems) == 0 && decimal.RequireFromString(total).IsZero() && !discounted {
+		report.Problems = append(report.Problems, ord.CartProblem{Code: ord.ProblemZeroTotal})
+	}
+
+	report.Total = total
+	report.Valid = len(report.Problems) == 0
+	return report, lines, stock, nil
+}
+
+// stockImpact sums what items take from each product (in first-seen order) against the
+// stock preflight saw, i.e. what would remain if the order were created now.
+func stockImpact(items []ord.CreateOrderItem, stock map[string]int) []ord.StockImpact {
+	out := make([]ord.StockImpact, 0, len(stock))
+	at := make(map[string]int, len(stock))
+	for _, it := range items {
+		i, ok := at[it.ProductID]
+		if !ok {
+			i = len(out)
+			at[it.ProductID] = i
+			out = append(out, ord.StockImpact{ProductID: it.ProductID, Stock: stock[it.ProductID]})
+		}
+		out[i].Requested += it.Quantity
+		out[i].WouldRemaining = out[i].Stock - out[i].Requested
+	}
+	return out
+}
+
+// validateCartHandler godoc
+// @Summary      Validate cart
+// @Description  Runs the same checks as order creation (user, items, products and their status, stock, expected_price) without mutating anything, reporting every problem at once.
+// @Tags         orders
+// @Accept       json
+// @Produce      json
+// @Param        body  body      order.CreateOrderRequest  true  "user_id & items"
+// @Success      200   {object}  order.CartReport
+// @Failure      400   {object}  HTTPError
+// @Failure      502   {object}  HTTPError
+// @Failure      422   {object}  HTTPError
+// @Router       /orders/validate [post]
+func validateCartHandler(ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in ord.CreateOrderRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		if !requireOrderFields(c, in) {
+			return
+		}
+		report, _, _, err := preflight(c.Request.Context(), ext, in, opts)
+		if err != nil {
+			log.Printf("[order] validate cart error: %v", err)
+			preflightFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// getOrderHandler godoc
+// @Summary      Get order by ID
+// @Tags         orders
+// @Param        id   path      string  true  "Order ID (UUID)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Router       /orders/{id} [get]
+func getOrderHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		o, items, ok := loadOrder(c, repo, c.Param("id"))
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+	}
+}
+
+// loadOrder reads order id for a handler. An unknown order answers 404; any other failure
+// is logged and answers 500, so a database outage doesn't pass for a missing order.
+func loadOrder(c *gin.Context, repo ord.Repository, id string) (*ord.Order, []ord.Item, bool) {
+	o, items, err := repo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, ord.ErrNotFound) {
+			c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			return nil, nil, false
+		}
+		log.Printf("[order] get %s error: %v", id, err)
+		c.JSON(http.StatusInternalServerError, HTTPError{"get order error"})
+		return nil, nil, false
+	}
+	return o, items, true
+}
+
+// userHasOrdersHandler godoc
+// @Summary      Whether a user has orders
+// @Description  Cheap yes/no (EXISTS) for showing an "orders" tab, without listing or counting.
+// @Tags         orders
+// @Param        user_id  path      string  true  "User ID (UUID)"
+// @Success      200      {object}  map[string]bool
+// @Failure      400      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Router       /orders/user/{user_id}/exists [get]
+func userHasOrdersHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+		if _, err := uuid.Parse(userID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"user_id must be a UUID"})
+			return
+		}
+		ok, err := repo.HasOrders(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"exists error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"has_orders": ok})
+	}
+}
+
+// statusCountsHandler godoc
+// @Summary      Order counts per status for a user
+// @Description  One GROUP BY query for profile badges; every status is present, 0 when the user has none.
+// @Tags         orders
+// @Param        user_id  path      string  true  "User ID (UUID)"
+// @Success      200      {object}  map[string]int
+// @Failure      400      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Router       /orders/user/{user_id}/status-counts [get]
+func statusCountsHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+		if _, err := uuid.Parse(userID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"user_id must be a UUID"})
+			return
+		}
+		counts, err := repo.StatusCounts(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"status counts error"})
+			return
+		}
+		c.JSON(http.StatusOK, counts)
+	}
+}
+
+// productTotalsHandler godoc
+// @Summary      Units bought per product by a user
+// @Description  One GROUP BY over the user's paid, shipped and delivered orders: quantity, number of orders and amount spent per product, most bought first.
+// @Description  With expand=product each entry also carries product_name (soft-deleted products still resolve).
+// @Tags         orders
+// @Param        user_id  path      string  true   "User ID (UUID)"
+// @Param        expand   query     string  false  "product"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Failure      502      {object}  HTTPError
+// @Router       /orders/user/{user_id}/product-totals [get]
+func productTotalsHandler(repo ord.Repository, ext *ord.Ext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+		if _, err := uuid.Parse(userID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"user_id must be a UUID"})
+			return
+		}
+		totals, err := repo.ProductTotals(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"product totals error"})
+			return
+		}
+		if c.Query("expand") == "product" && len(totals) > 0 {
+			ids := make([]string, 0, len(totals))
+			for _, t := range totals {
+				ids = append(ids, t.ProductID)
+			}
+			products, err := ext.FetchProducts(c.Request.Context(), ids, true)
+			if err != nil {
+				log.Printf("[order] expand products error: %v", err)
+				c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+				return
+			}
+			for i := range totals {
+				if p, ok := products[totals[i].ProductID]; ok {
+					name := p.Name
+					totals[i].ProductName = &name
+				}
+			}
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"items": totals}))
+	}
+}
+
+// activeQuantityHandler godoc
+// @Summary      Units of a product held by orders
+// @Description  Sum of the product's quantities over orders that are not canceled. Used by product-service to recalculate stock.
+// @Tags         orders
+// @Param        product_id  path      string  true  "Product ID (UUID)"
+// @Success      200         {object}  map[string]interface{}
+// @Failure      400         {object}  HTTPError
+// @Failure      500         {object}  HTTPError
+// @Router       /orders/products/{product_id}/active-quantity [get]
+func activeQuantityHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID := c.Param("product_id")
+		if _, err := uuid.Parse(productID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"product_id must be a UUID"})
+			return
+		}
+		n, err := repo.ActiveQuantity(c.Request.Context(), productID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"active quantity error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"product_id": productID, "quantity": n})
+	}
+}
+
+// relatedProductsHandler godoc
+// @Summary      Products bought together with a product
+// @Description  The products most often in the same orders as this one (drafts and canceled orders aside), with how many orders they share, most shared first. Also served at /orders/products/{product_id}/related.
+// @Tags         orders
+// @Param        product_id  path      string  true   "Product ID (UUID)"
+// @Param        limit       query     int     false  "How many products (1-50)"  minimum(1) maximum(50) default(10)
+// @Success      200         {object}  map[string]interface{}
+// @Failure      400         {object}  HTTPError
+// @Failure      500         {object}  HTTPError
+// @Router       /orders/product/{product_id}/related [get]
+// @Router       /orders/products/{product_id}/related [get]
+func relatedProductsHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		productID := c.Param("product_id")
+		if _, err := uuid.Parse(productID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"product_id must be a UUID"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if limit <= 0 || limit > 50 {
+			limit = 10
+		}
+		related, err := repo.RelatedProducts(c.Request.Context(), productID, limit)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"related products error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"product_id": productID, "items": related}))
+	}
+}
+
+// dailySalesHandler godoc
+// @Summary      Daily sales report
+// @Description  Units and revenue per product and day (UTC), from paid orders. Read from the nightly rollup, so the current day is not included.
+// @Tags         reports
+// @Produce      json
+// @Param        from  query     string  true  "First day (YYYY-MM-DD)"
+// @Param        to    query     string  true  "Last day, inclusive (YYYY-MM-DD)"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  HTTPError
+// @Failure      500   {object}  HTTPError
+// @Router       /reports/daily [get]
+func dailySalesHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := ord.ParseReportRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{err.Error()})
+			return
+		}
+		sales, err := repo.DailySales(c.Request.Context(), from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"report error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{
+			"items": sales, "from": from.Format(ord.DateLayout), "to": to.Format(ord.DateLayout),
+		}))
+	}
+}
+
+// dailySalesExportHandler godoc
+// @Summary      Export the daily sales report
+// @Description  The daily sales report of [from, to] as a CSV (default) or JSON download. Honors Range and If-Range against the ETag (206 Partial Content), so an interrupted download can be resumed.
+// @Tags         reports
+// @Produce      text/csv
+// @Produce      json
+// @Param        from    query     string  true   "First day (YYYY-MM-DD)"
+// @Param        to      query     string  true   "Last day, inclusive (YYYY-MM-DD)"
+// @Param        format  query     string  false  "csv (default) or json"
+// @Param        Range   header    string  false  "Byte range, e.g. bytes=1024-"
+// @Success      200     {file}    file
+// @Success      206     {file}    file
+// @Failure      400     {object}  HTTPError
+// @Failure      416     {string}  string
+// @Failure      500     {object}  HTTPError
+// @Router       /reports/daily/export [get]
+func dailySalesExportHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		from, to, err := ord.ParseReportRange(c.Query("from"), c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{err.Error()})
+			return
+		}
+		format := c.DefaultQuery("format", "csv")
+		if format != "csv" && format != "json" {
+			c.JSON(http.StatusBadRequest, HTTPError{"format must be csv or json"})
+			return
+		}
+		sales, err := repo.DailySales(c.Request.Context(), from, to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"report error"})
+			return
+		}
+		name := "daily-sales_" + from.Format(ord.DateLayout) + "_" + to.Format(ord.DateLayout) + "." + format
+		if format == "json" {
+			// no generated_at: the same range must give the same bytes for a resume to line up
+			body, err := json.Marshal(gin.H{"from": from.Format(ord.DateLayout), "to": to.Format(ord.DateLayout), "items": sales})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, HTTPError{"report error"})
+				return
+			}
+			httpx.ServeExport(c, name, "application/json; charset=utf-8", body)
+			return
+		}
+		body, err := ord.DailySalesCSV(sales)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"report error"})
+			return
+		}
+		httpx.ServeExport(c, name, "text/csv; charset=utf-8", body)
+	}
+}
+
+// latestOrderHandler godoc
+// @Summary      Most recent order of a user
+// @Description  The user's newest order with its items, for "reorder last purchase".
+// @Tags         orders
+// @Param        user_id  path      string  true  "User ID (UUID)"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  HTTPError
+// @Failure      404      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Router       /orders/user/{user_id}/latest [get]
+func latestOrderHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+		if _, err := uuid.Parse(userID); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"user_id must be a UUID"})
+			return
+		}
+		o, items, err := repo.LatestByUser(c.Request.Context(), userID)
+		if err != nil {
+			if errors.Is(err, ord.ErrNotFound) {
+				c.JSON(http.StatusNotFound, HTTPError{"no orders"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, HTTPError{"latest order error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+	}
+}
+
+// listOrdersByUserHandler godoc
+// @Summary      List orders by user
+// @Description  One page of the user's orders, newest first, plus 'total': how many orders match the filters across all pages.
+// @Description  A limit above MAX_LIST_ROWS (default 100) is cut down to it; 'truncated' is true when that cut left orders out, and 'limit' then reports the ceiling.
+// @Tags         orders
+// @Param        user_id  path   string  true   "User ID (UUID)"
+// @Param        limit    query  int     false  "Limit (>=1, capped at MAX_LIST_ROWS)"  minimum(1) default(20)
+// @Param        offset   query  int     false  "Offset (>=0)"   minimum(0) default(0)
+// @Param        min_total query string  false  "Only orders with total >= this decimal"
+// @Param        max_total query string  false  "Only orders with total <= this decimal"
+// @Param        status   query  string  false  "Only orders in this status"  Enums(draft, pending, paid, shipped, delivered, refunded, canceled)
+// @Param        from     query  string  false  "Only orders created at or after this RFC3339 time or YYYY-MM-DD date"
+// @Param        to       query  string  false  "Only orders created before this RFC3339 time or YYYY-MM-DD date"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Router       /orders/user/{user_id} [get]
+func listOrdersByUserHandler(repo ord.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if limit <= 0 {
+			limit = 20
+		}
+		if offset < 0 {
+			offset = 0
+		}
+		tf, err := ord.ParseTotalFilter(c.Query("min_total"), c.Query("max_total"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{err.Error()})
+			return
+		}
+		f := ord.ListFilter{Total: tf}
+		if s := c.Query("status"); s != "" {
+			if f.Status, err = ord.ParseStatus(s); err != nil {
+				c.JSON(http.StatusBadRequest, HTTPError{"invalid status"})
+				return
+			}
+		}
+		if f.From, f.To, err = ord.ParseDateRange(c.Query("from"), c.Query("to")); err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{err.Error()})
+			return
+		}
+		// the page and the count for the pager run side by side, not one after the other
+		userID := c.Param("user_id")
+		var (
+			list      []ord.Order
+			truncated bool
+			total     int
+		)
+		g, ctx := errgroup.WithContext(c.Request.Context())
+		g.Go(func() (err error) {
+			list, truncated, err = repo.ListByUser(ctx, userID, f, limit, offset)
+			return err
+		})
+		g.Go(func() (err error) {
+			total, err = repo.CountByUser(ctx, userID, f)
+			return err
+		})
+		if err := g.Wait(); err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"list error"})
+			return
+		}
+		if truncated {
+			// the repo's ceiling, so offset+limit still points at the next page
+			limit = len(list)
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"items": list, "total": total, "limit": limit, "offset": offset, "truncated": truncated}))
+	}
+}
+
+// updateOrderStatusHandler godoc
+// @Summary      Update order status
+// @Description  Moves the order along ORDER_STATUS_TRANSITIONS (default pending->paid|canceled, paid->shipped|refunded|canceled, shipped->delivered|refunded, delivered->refunded); any other change is 409 and a status outside them is 422. Entering shipped / delivered stamps shipped_at / delivered_at once.
+// @Description  Canceling an order that still holds stock (draft, pending, or paid and not shipped) restocks its items; for a paid order, minus what its refunds already restocked. An illegal move is 409 {"error":"illegal transition paid->pending"}.
+// @Description  Asking for the status the order already has follows STATUS_NOOP: ignore (200, unchanged; default), conflict (409) or touch (200, updated_at bumped).
+// @Tags         orders
+// @Accept       json
+// @Produce      json
+// @Param        id    path   string              true  "Order ID (UUID)"
+// @Param        body  body   map[string]string   true  "status: pending|paid|shipped|delivered|refunded|canceled (drafts: canceled only)"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  HTTPError
+// @Failure      404   {object}  HTTPError
+// @Failure      409   {object}  HTTPError
+// @Failure      422   {object}  HTTPError
+// @Failure      500   {object}  HTTPError
+// @Router       /orders/{id}/status [put]
+func updateOrderStatusHandler(repo ord.Repository, ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var in struct {
+			Status string `json:"status"`
+		}
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+
+		// normalize and validate
+		newStatus, err := ord.ParseStatus(in.Status)
+		if err != nil {
+			httpx.Unprocessable(c, "invalid status")
+			return
+		}
+
+		// current status + items
+		o, items, ok := loadOrder(c, repo, id)
+		if !ok {
+			return
+		}
+		if o.Status == newStatus {
+			switch opts.StatusNoop {
+			case ord.NoopConflict:
+				c.JSON(http.StatusConflict, HTTPError{fmt.Sprintf("order is already %s", o.Status)})
+				return
+			case ord.NoopTouch:
+				// same status again: only updated_at moves (paid_at is kept)
+				if err := repo.UpdateStatus(c.Request.Context(), id, newStatus); err != nil {
+					c.JSON(http.StatusInternalServerError, HTTPError{"update status error"})
+					return
+				}
+				o, items, _ = repo.GetByID(c.Request.Context(), id)
+			}
+			// nothing else to change
+			c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+			return
+		}
+		// drafts only leave their state via /commit (-> pending), cancel or expiry
+		if newStatus == ord.StatusDraft {
+			httpx.Unprocessable(c, "invalid status")
+			return
+		}
+		if o.Status == ord.StatusDraft && newStatus != ord.StatusCanceled {
+			c.JSON(http.StatusConflict, HTTPError{"draft must be committed first"})
+			return
+		}
+		if o.Status != ord.StatusDraft {
+			// statuses this deployment doesn't use are unknown to it
+			if !opts.Transitions.Enabled(newStatus) {
+				httpx.Unprocessable(c, "invalid status")
+				return
+			}
+			if !opts.Transitions.Allows(o.Status, newStatus) {
+				c.JSON(http.StatusConflict, HTTPError{fmt.Sprintf("illegal transition %s->%s", o.Status, newStatus)})
+				return
+			}
+		}
+
+		// restock if canceling, then update status in DB
+		if err := setStatus(c.Request.Context(), repo, ext, opts, o, items, newStatus); err != nil {
+			if err == ord.ErrNotFound {
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, HTTPError{"update status error"})
+			return
+		}
+
+		// returns the updated order
+		o2, items2, _ := repo.GetByID(c.Request.Context(), id)
+		c.JSON(http.StatusOK, gin.H{"order": o2, "items": items2})
+	}
+}
+
+// setStatus moves o to status to and audits it. Canceling an order still holding stock
+// (draft, pending, or paid and not shipped) first gives that stock back; a paid order's
+// refunds may already have restocked part of it. Callers check the transition is allowed.
+func setStatus(ctx context.Context, repo ord.Repository, ext *ord.Ext, opts orderOptions, o *ord.Order, items []ord.Item, to ord.Status) error {
+	from := o.Status
+	if ord.Restocks(from, to) {
+		restock := items
+		if from == ord.StatusPaid {
+			refunds, err := repo.ListRefunds(ctx, o.ID)
+			if err != nil {
+				return err
+			}
+			restock = ord.UnrestockedItems(items, refunds)
+		}
+		restockItems(ctx, repo, ext, opts, o.ID, ord.StockReasonCancel, restock)
+	}
+	if err := repo.UpdateStatus(ctx, o.ID, to); err != nil {
+		return err
+	}
+	auditStatus(ctx, opts, o.ID, from, to)
+	return nil
+}
+
+// cancelFailure is an order POST /orders/user/{user_id}/cancel-pending could not cancel.
+type cancelFailure struct {
+	OrderID string `json:"order_id"`
+	Error   string `json:"error"`
+}
+
+// cancelPendingHandler godoc
+// @Summary      Cancel a user's pending orders (admin)
+// @Description  For account closure or a fraud response: cancels every pending order of the user one by one, restocking each like PUT /orders/{id}/status does; orders in any other status are left alone.
+// @Description  'failures' lists the orders that could not be canceled and why; the others still are. 409 when ORDER_STATUS_TRANSITIONS does not allow pending->canceled. Served only on the admin listener (ORDER_ADMIN_ADDR).
+// @Tags         orders
+// @Produce      json
+// @Param        user_id  path      string  true  "User ID (UUID)"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      409      {object}  HTTPError
+// @Failure      422      {object}  HTTPError
+// @Failure      500      {object}  HTTPError
+// @Router       /orders/user/{user_id}/cancel-pending [post]
+func cancelPendingHandler(repo ord.Repository, ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.Param("user_id")
+		if _, err := uuid.Parse(userID); err != nil {
+			httpx.Unprocessable(c, "user_id must be a UUID")
+			return
+		}
+		if !opts.Transitions.Allows(ord.StatusPending, ord.StatusCanceled) {
+			c.JSON(http.StatusConflict, HTTPError{fmt.Sprintf("illegal transition %s->%s", ord.StatusPending, ord.StatusCanceled)})
+			return
+		}
+
+		// collect the ids first: canceling shrinks the pending set being paged through
+		ctx := c.Request.Context()
+		f := ord.ListFilter{Status: ord.StatusPending}
+		var ids []string
+		for offset := 0; ; {
+			page, _, err := repo.ListByUser(ctx, userID, f, ord.DefaultMaxListRows, offset)
+			if err != nil {
+				log.Printf("[order] cancel pending of %s: list error: %v", userID, err)
+				c.JSON(http.StatusInternalServerError, HTTPError{"list error"})
+				return
+			}
+			if len(page) == 0 {
+				break
+			}
+			for _, o := range page {
+				ids = append(ids, o.ID)
+			}
+			offset += len(page)
+		}
+
+		canceled, failures := 0, []cancelFailure{}
+		for _, id := range ids {
+			// re-read: the order may have been paid or canceled since it was listed
+			o, items, err := repo.GetByID(ctx, id)
+			if errors.Is(err, ord.ErrNotFound) {
+				failures = append(failures, cancelFailure{id, "not found"})
+				continue
+			}
+			if err != nil {
+				log.Printf("[order] cancel pending %s: get order error: %v", id, err)
+				failures = append(failures, cancelFailure{id, "get order error"})
+				continue
+			}
+			if o.Status != ord.StatusPending {
+				failures = append(failures, cancelFailure{id, fmt.Sprintf("order is %s", o.Status)})
+				continue
+			}
+			if err := setStatus(ctx, repo, ext, opts, o, items, ord.StatusCanceled); err != nil {
+				log.Printf("[order] cancel pending %s error: %v", id, err)
+				failures = append(failures, cancelFailure{id, "update status error"})
+				continue
+			}
+			canceled++
+		}
+		c.JSON(http.StatusOK, gin.H{"user_id": userID, "pending": len(ids), "canceled": canceled, "failures": failures})
+	}
+}
+
+// getOrderItemsHandler godoc
+// @Summary      Order items
+// @Description  With expand=product each item also carries the product's product_name and current_price (soft-deleted products still resolve, flagged product_deleted; null if the product is gone) and price_changed.
+// @Tags         orders
+// @Param        id      path   string  true   "Order ID (UUID)"
+// @Param        expand  query  string  false  "product"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Failure      502  {object}  HTTPError
+// @Router       /orders/{id}/items [get]
+func getOrderItemsHandler(repo ord.Repository, ext *ord.Ext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// validate order existence
+		if _, _, ok := loadOrder(c, repo, c.Param("id")); !ok {
+			return
+		}
+		items, err := repo.GetItems(c.Request.Context(), c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, HTTPError{"items error"})
+			return
+		}
+		if c.Query("expand") != "product" {
+			c.JSON(http.StatusOK, gin.H{"items": items})
+			return
+		}
+
+		ids := make([]string, 0, len(items))
+		for _, it := range items {
+			ids = append(ids, it.ProductID)
+		}
+		// historical orders may reference soft-deleted products: resolve them too
+		products, err := ext.FetchProducts(c.Request.Context(), ids, true)
+		if err != nil {
+			log.Printf("[order] expand products error: %v", err)
+			c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+			return
+		}
+
+		out := make([]ord.ItemWithProduct, 0, len(items))
+		for _, it := range items {
+			v := ord.ItemWithProduct{Item: it}
+			if p, ok := products[it.ProductID]; ok {
+				name, cur := p.Name, p.Price
+				v.ProductName = &name
+				v.CurrentPrice = &cur
+				v.PriceChanged = priceChanged(it.Price, cur)
+				v.ProductDeleted = p.DeletedAt != nil
+			}
+			out = append(out, v)
+		}
+		c.JSON(http.StatusOK, gin.H{"items": out})
+	}
+}
+
+// getOrderStockMovementsHandler godoc
+// @Summary      Stock movements caused by an order
+// @Description  The stock movements product-service recorded with this order_id, oldest first: the decrements of its creation and added items, then any cancel or refund restocks.
+// @Tags         orders
+// @Param        id   path      string  true  "Order ID (UUID)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Failure      502  {object}  HTTPError
+// @Router       /orders/{id}/stock-movements [get]
+func getOrderStockMovementsHandler(repo ord.Repository, ext *ord.Ext) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, _, ok := loadOrder(c, repo, id); !ok {
+			return
+		}
+		movements, err := ext.OrderStockMovements(c.Request.Context(), id)
+		if err != nil {
+			log.Printf("[order] stock movements of %s error: %v", id, err)
+			c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"order_id": id, "items": movements})
+	}
+}
+
+// priceChanged compares prices numerically ("10" == "10.00"), falling back to the raw strings.
+func priceChanged(frozen, current string) bool {
+	a, errA := decimal.NewFromString(frozen)
+	b, errB := decimal.NewFromString(current)
+	if errA != nil || errB != nil {
+		return frozen != current
+	}
+	return !a.Equal(b)
+}
+
+// commitDraftHandler godoc
+// @Summary      Commit draft order
+// @Description  Turns a non-expired draft into a pending order, keeping its stock hold.
+// @Tags         orders
+// @Param        id   path      string  true  "Order ID (UUID)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  HTTPError
+// @Failure      409  {object}  HTTPError
+// @Failure      410  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Router       /orders/{id}/commit [post]
+func commitDraftHandler(repo ord.Repository, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if err := repo.CommitDraft(c.Request.Context(), id); err != nil {
+			switch err {
+			case ord.ErrNotFound:
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			case ord.ErrNotDraft:
+				c.JSON(http.StatusConflict, HTTPError{"order is not a draft"})
+			case ord.ErrDraftExpired:
+				c.JSON(http.StatusGone, HTTPError{"draft expired"})
+			default:
+				c.JSON(http.StatusInternalServerError, HTTPError{"commit error"})
+			}
+			return
+		}
+		auditStatus(c.Request.Context(), opts, id, ord.StatusDraft, ord.StatusPending)
+		o, items, _ := repo.GetByID(c.Request.Context(), id)
+		c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+	}
+}
+
+// compensateSaga gives back the saga's decrements not compensated yet and closes it as
+// compensated. Steps are restocked per product through the idempotent restock, so a retry
+// after a partial failure cannot add stock twice; a product whose restock fails stays pending
+// for the next recovery sweep, and one that no longer exists has nothing to give back.
+func compensateSaga(ctx context.Context, repo ord.Repository, ext *ord.Ext, s *ord.Saga) error {
+	var pending []ord.Item
+	for _, st := range s.Steps {
+		if !st.Compensated {
+			pending = append(pending, ord.Item{ProductID: st.ProductID, Quantity: st.Quantity})
+		}
+	}
+	var failed error
+	for _, it := range mergeItems(pending) {
+		_, err := ext.RestockOnce(ctx, it.ProductID, it.Quantity, s.OrderID)
+		if err != nil && !errors.Is(err, ord.ErrProductNotFound) {
+			log.Printf("[saga] %s: restock %s error: %v", s.OrderID, it.ProductID, err)
+			failed = err
+			continue
+		}
+		for i := range s.Steps {
+			st := &s.Steps[i]
+			if st.ProductID != it.ProductID || st.Compensated {
+				continue
+			}
+			st.Compensated = true
+			if st.ID == 0 {
+				continue // never recorded
+			}
+			if err := repo.CompensateSagaStep(ctx, st.ID); err != nil {
+				log.Printf("[saga] %s: mark step %d error: %v", s.OrderID, st.ID, err)
+				failed = err
+			}
+		}
+	}
+	if failed != nil {
+		return failed
+	}
+	return repo.FinishSaga(ctx, s.OrderID, ord.SagaCompensated)
+}
+
+// rollbackTimeout bounds the compensation of a failed order creation.
+const rollbackTimeout = 5 * time.Second
+
+// rollbackCreate compensates a failed order creation. It runs detached from the request's
+// cancellation (keeping its tenant and request id): a client that disconnects mid-order
+// cancels c.Request.Context(), and the restocks would otherwise be skipped, leaking the
+// decremented stock until the recovery sweep.
+func rollbackCreate(ctx context.Context, repo ord.Repository, ext *ord.Ext, s *ord.Saga) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), rollbackTimeout)
+	defer cancel()
+	if err := compensateSaga(ctx, repo, ext, s); err != nil {
+		log.Printf("[order] %s: rollback incomplete, recovery will retry: %v", s.OrderID, err)
+	}
+}
+
+// recoverSagas finishes order creations interrupted for longer than opts.SagaTimeout:
+// if the order was persisted the saga is closed, otherwise its stock is given back.
+// Returns how many sagas were compensated.
+func recoverSagas(ctx context.Context, repo ord.Repository, ext *ord.Ext, opts orderOptions) (int, error) {
+	sagas, err := repo.StaleSagas(ctx, opts.SagaTimeout)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for i := range sagas {
+		s := &sagas[i]
+		if s.OrderExists {
+			if err := repo.FinishSaga(ctx, s.OrderID, ord.SagaCompleted); err != nil {
+				log.Printf("[saga] %s: finish error: %v", s.OrderID, err)
+			}
+			continue
+		}
+		if err := compensateSaga(ctx, repo, ext, s); err != nil {
+			log.Printf("[saga] %s: compensation incomplete, will retry: %v", s.OrderID, err)
+			continue
+		}
+		log.Printf("[saga] %s: compensated %d step(s)", s.OrderID, len(s.Steps))
+		n++
+	}
+	return n, nil
+}
+
+// sweepTenants runs the background sweeps once per tenant with pending work, with the
+// tenant on the context so the repository and product-service calls stay scoped to it.
+func sweepTenants(ctx context.Context, repo ord.Repository, ext *ord.Ext, opts orderOptions) {
+	tenants, err := repo.Tenants(ctx)
+	if err != nil {
+		log.Printf("[sweep] tenants error: %v", err)
+		return
+	}
+	for _, t := range tenants {
+		tctx := tenant.With(ctx, t)
+		if _, err := releaseExpiredDrafts(tctx, repo, ext, opts); err != nil {
+			log.Printf("[drafts] tenant %s sweep error: %v", t, err)
+		}
+		if _, err := recoverSagas(tctx, repo, ext, opts); err != nil {
+			log.Printf("[saga] tenant %s recovery error: %v", t, err)
+		}
+	}
+}
+
+// rollupSales rebuilds the daily_sales rows of day for every tenant that sold (or had sold) on it.
+func rollupSales(ctx context.Context, repo ord.Repository, day time.Time) {
+	tenants, err := repo.SalesTenants(ctx, day)
+	if err != nil {
+		log.Printf("[sales] tenants error: %v", err)
+		return
+	}
+	for _, t := range tenants {
+		n, err := rollupDailySales(tenant.With(ctx, t), repo, day)
+		if err != nil {
+			log.Printf("[sales] tenant %s rollup %s error: %v", t, ord.Day(day).Format(ord.DateLayout), err)
+			continue
+		}
+		log.Printf("[sales] tenant %s rolled up %s: %d products", t, ord.Day(day).Format(ord.DateLayout), n)
+	}
+}
+
+// rollupDailySales aggregates the orders paid on day into daily_sales for the tenant in ctx.
+// It replaces the day's rows, so running it again for the same day changes nothing.
+// Returns how many products sold that day.
+func rollupDailySales(ctx context.Context, repo ord.Repository, day time.Time) (int, error) {
+	lines, err := repo.PaidLines(ctx, day)
+	if err != nil {
+		return 0, err
+	}
+	sales, err := ord.AggregateDailySales(day, lines)
+	if err != nil {
+		return 0, err
+	}
+	if err := repo.SaveDailySales(ctx, day, sales); err != nil {
+		return 0, err
+	}
+	return len(sales), nil
+}
+
+// nextRollup is when the nightly sales rollup runs after now: 00:05 UTC, once the previous
+// day is over.
+func nextRollup(now time.Time) time.Time {
+	next := ord.Day(now).Add(5 * time.Minute)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// releaseExpiredDrafts cancels expired drafts and gives their held stock back.
+// Returns how many drafts were released.
+func releaseExpiredDrafts(ctx context.Context, repo ord.Repository, ext *ord.Ext, opts orderOptions) (int, error) {
+	ids, err := repo.ExpireDrafts(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range ids {
+		items, err := repo.GetItems(ctx, id)
+		if err != nil {
+			log.Printf("[drafts] items %s error: %v", id, err)
+			continue
+		}
+		// same as a manual cancel
+		restockItems(ctx, repo, ext, opts, id, ord.StockReasonCancel, items)
+		auditStatus(audit.WithActor(ctx, "system:draft-expiry"), opts, id, ord.StatusDraft, ord.StatusCanceled)
+		log.Printf("[drafts] expired %s", id)
+	}
+	return len(ids), nil
+}
+
+// auditStatus records an order status change; from is empty when it is not known.
+func auditStatus(ctx context.Context, opts orderOptions, orderID string, from, to ord.Status) {
+	ch := audit.Change{To: to}
+	if from != "" {
+		ch.From = from
+	}
+	audit.Log(ctx, opts.Audit, audit.Entry{
+		Action: audit.ActionStatusChange, ResourceType: audit.ResourceOrder, ResourceID: orderID,
+		Detail: map[string]audit.Change{"status": ch},
+	})
+}
+
+// restockItems gives an order's stock back (cancel, refund), best-effort: failures never
+// fail the operation. A product deleted meanwhile is handled by opts.RestockNotFound.
+func restockItems(ctx context.Context, repo ord.Repository, ext *ord.Ext, opts orderOptions, orderID, reason string, items []ord.Item) {
+	for _, it := range mergeItems(items) {
+		var err error
+		if reason == ord.StockReasonCancel {
+			// idempotent per (order, product): a retried cancel cannot add the stock twice
+			_, err = ext.RestockOnce(ctx, it.ProductID, it.Quantity, orderID)
+		} else {
+			err = ext.AdjustStock(ctx, it.ProductID, +it.Quantity, reason, orderID)
+		}
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, ord.ErrProductNotFound) {
+			log.Printf("[restock] order %s product %s error: %v", orderID, it.ProductID, err)
+			continue
+		}
+		if opts.RestockNotFound == ord.RestockSkip {
+			continue
+		}
+		log.Printf("[restock] order %s product %s not found, %d units not restocked", orderID, it.ProductID, it.Quantity)
+		f := &ord.RestockFailure{OrderID: orderID, ProductID: it.ProductID, Quantity: it.Quantity, Reason: err.Error()}
+		if err := repo.RecordRestockFailure(ctx, f); err != nil {
+			log.Printf("[restock] record failure for order %s error: %v", orderID, err)
+		}
+	}
+}
+
+// mergeItems sums the quantities of lines for the same product, keeping first-seen order.
+func mergeItems(items []ord.Item) []ord.Item {
+	idx := make(map[string]int, len(items))
+	out := make([]ord.Item, 0, len(items))
+	for _, it := range items {
+		if i, ok := idx[it.ProductID]; ok {
+			out[i].Quantity += it.Quantity
+			continue
+		}
+		idx[it.ProductID] = len(out)
+		out = append(out, it)
+	}
+	return out
+}
+
+// payOrderHandler godoc
+// @Summary      Mark order paid
+// @Description  Idempotent: paying an already paid order is a 200 no-op. Stamps paid_at; a live draft is committed and paid.
+// @Tags         orders
+// @Param        id   path      string  true  "Order ID (UUID)"
+// @Success      200  {object}  map[string]interface{}
+// @Failure      404  {object}  HTTPError
+// @Failure      409  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Router       /orders/{id}/pay [post]
+func payOrderHandler(repo ord.Repository, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		changed, err := repo.MarkPaid(c.Request.Context(), id)
+		if err != nil {
+			switch err {
+			case ord.ErrNotFound:
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			case ord.ErrInvalidTransition:
+				c.JSON(http.StatusConflict, HTTPError{"order cannot be paid"})
+			default:
+				c.JSON(http.StatusInternalServerError, HTTPError{"pay error"})
+			}
+			return
+		}
+		if changed {
+			// pending, or a live draft committed and paid at once
+			auditStatus(c.Request.Context(), opts, id, "", ord.StatusPaid)
+		}
+		o, items, _ := repo.GetByID(c.Request.Context(), id)
+		c.JSON(http.StatusOK, gin.H{"order": o, "items": items, "changed": changed})
+	}
+}
+
+// paymentWebhookHandler godoc
+// @Summary      Payment provider callback
+// @Description  Verifies the HMAC-SHA256 of the raw body (hex, header X-Signature) and marks the order paid.
+// @Description  Idempotent on provider_ref: a replayed callback is a 200 no-op.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
-// @Param        body  body      order.CreateOrderRequest  true  "user_id & items"
-// @Success      201   {object}  map[string]interface{}
-// @Failure      400   {object}  HTTPError
-// @Failure      409   {object}  HTTPError
-// @Failure      500   {object}  HTTPError
-// @Router       /orders [post]
-func createOrderHandler(repo ord.Repository, ext *ord.Ext) gin.HandlerFunc {
+// @Param        X-Signature  header    string             true  "hex HMAC-SHA256 of the body"
+// @Param        body         body      order.PaymentEvent  true  "order_id, status, provider_ref"
+// @Success      200          {object}  map[string]interface{}
+// @Failure      400          {object}  HTTPError
+// @Failure      401          {object}  HTTPError
+// @Failure      422          {object}  HTTPError
+// @Failure      404          {object}  HTTPError
+// @Failure      409          {object}  HTTPError
+// @Failure      500          {object}  HTTPError
+// @Router       /orders/webhook/payment [post]
+func paymentWebhookHandler(repo ord.Repository, opts orderOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var in struct {
-			UserID string `json:"user_id"`
-			Items  []struct {
-				ProductID string `json:"product_id"`
-				Quantity  int    `json:"quantity"`
-			} `json:"items"`
+		raw, err := io.ReadAll(io.LimitReader(c.Request.Body, 64<<10))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, HTTPError{"invalid body"})
+			return
+		}
+		if !ord.VerifySignature(opts.PaymentSecret, raw, c.GetHeader("X-Signature")) {
+			c.JSON(http.StatusUnauthorized, HTTPError{"invalid signature"})
+			return
 		}
-		if err := c.BindJSON(&in); err != nil {
+		var ev ord.PaymentEvent
+		if err := json.Unmarshal(raw, &ev); err != nil {
 			c.JSON(http.StatusBadRequest, HTTPError{"invalid json"})
 			return
 		}
-		if in.UserID == "" || len(in.Items) == 0 {
-			c.JSON(http.StatusBadRequest, HTTPError{"user_id & items required"})
+		ev.Status = strings.ToLower(strings.TrimSpace(ev.Status))
+		if ev.OrderID == "" || ev.ProviderRef == "" || ev.Status == "" {
+			httpx.Unprocessable(c, "order_id, status & provider_ref required")
 			return
 		}
 
-		// validate user (gRPC)
-		ok, err := ext.ValidateUser(c.Request.Context(), in.UserID)
-		if err != nil || !ok {
-			c.JSON(http.StatusBadRequest, HTTPError{"invalid user"})
+		applied, err := repo.ApplyPayment(c.Request.Context(), ev)
+		if err != nil {
+			switch err {
+			case ord.ErrNotFound:
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			case ord.ErrInvalidTransition:
+				c.JSON(http.StatusConflict, HTTPError{"order cannot be paid"})
+			default:
+				c.JSON(http.StatusInternalServerError, HTTPError{"payment error"})
+			}
 			return
 		}
+		o, _, _ := repo.GetByID(c.Request.Context(), ev.OrderID)
+		c.JSON(http.StatusOK, gin.H{"order": o, "replayed": !applied})
+	}
+}
 
-		// calculate total, freeze price, and adjust stock (automatic)
-		total := decimal.Zero
-		type decRec struct {
-			ProductID string
-			Qty       int
+// createRefundHandler godoc
+// @Summary      Refund a paid, shipped or delivered order (partial)
+// @Description  Records a refund; the order total minus prior refunds must cover 'amount', which may have up to PRICE_DECIMALS decimals like the order's prices. With restock=true the listed items' stock is given back.
+// @Tags         orders
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string                     true  "Order ID (UUID)"
+// @Param        body  body      order.CreateRefundRequest  true  "amount, reason, items, restock"
+// @Success      201   {object}  map[string]interface{}
+// @Failure      400   {object}  HTTPError
+// @Failure      404   {object}  HTTPError
+// @Failure      422   {object}  HTTPError
+// @Failure      409   {object}  HTTPError
+// @Failure      500   {object}  HTTPError
+// @Router       /orders/{id}/refunds [post]
+func createRefundHandler(repo ord.Repository, ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var in ord.CreateRefundRequest
+		if !httpx.BindJSON(c, &in) {
+			return
 		}
-		var toRollback []decRec
-		priceByProduct := make(map[string]string, len(in.Items)) // freeze unit price by product_id
-
+		places := opts.PriceDecimals
+		amount, err := decimal.NewFromString(in.Amount)
+		if err != nil || !amount.IsPositive() || !amount.Equal(amount.Round(places)) {
+			httpx.Unprocessable(c, fmt.Sprintf("amount must be a positive number with at most %d decimals", places))
+			return
+		}
+		if in.Restock && len(in.Items) == 0 {
+			httpx.Unprocessable(c, "restock requires items")
+			return
+		}
+		// one line per product
+		qty := map[string]int{}
+		var pids []string
 		for _, it := range in.Items {
 			if it.ProductID == "" || it.Quantity <= 0 {
-				c.JSON(http.StatusBadRequest, HTTPError{"invalid item"})
-				return
-			}
-
-			// 1) Bring product (price/current stock)
-			p, err := ext.FetchProduct(c.Request.Context(), it.ProductID)
-			if err != nil {
-				log.Printf("[order] fetch product %s error: %v", it.ProductID, err)
-				c.JSON(http.StatusBadRequest, HTTPError{"product not found"})
-				return
-			}
-
-			// 2) Freeze price and accumulate total
-			priceDec, err := decimal.NewFromString(p.Price)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, HTTPError{"invalid product price"})
+				httpx.Unprocessable(c, "invalid item")
 				return
 			}
-			line := priceDec.Mul(decimal.NewFromInt(int64(it.Quantity)))
-			total = total.Add(line)
-			priceByProduct[it.ProductID] = priceDec.StringFixed(2)
-
-			// 3) Automatically adjust stock with PUT /products/{id} (negative delta)
-			if err := ext.AdjustStock(c.Request.Context(), it.ProductID, -it.Quantity); err != nil {
-				log.Printf("[order] adjust stock %s error: %v", it.ProductID, err)
-				// rollback ...
-				if strings.Contains(err.Error(), "insufficient stock") {
-					c.JSON(http.StatusConflict, HTTPError{"insufficient stock"})
-					return
-				}
-				c.JSON(http.StatusBadRequest, HTTPError{"product not found"})
-				return
+			if _, ok := qty[it.ProductID]; !ok {
+				pids = append(pids, it.ProductID)
 			}
-			toRollback = append(toRollback, decRec{ProductID: it.ProductID, Qty: it.Quantity})
-		}
-
-		// The order + items (unit price “frozen”) persists.
-		var items []ord.Item
-		for _, it := range in.Items {
-			items = append(items, ord.Item{
-				ID:        uuid.NewString(),
-				OrderID:   "", // set below
-				ProductID: it.ProductID,
-				Quantity:  it.Quantity,
-				Price:     priceByProduct[it.ProductID], // <- we keep the price frozen
-			})
+			qty[it.ProductID] += it.Quantity
 		}
-		o := &ord.Order{
-			ID:     uuid.NewString(),
-			UserID: in.UserID,
-			Status: "pending",
-			Total:  total.StringFixed(2),
+		rf := &ord.Refund{
+			ID:      uuid.NewString(),
+			OrderID: id,
+			Amount:  amount.StringFixed(places),
+			Reason:  strings.TrimSpace(in.Reason),
+			Restock: in.Restock,
 		}
-		for i := range items {
-			items[i].OrderID = o.ID
+		for _, pid := range pids {
+			rf.Items = append(rf.Items, ord.RefundItem{ProductID: pid, Quantity: qty[pid]})
 		}
 
-		if err := repo.Create(c.Request.Context(), o, items); err != nil {
-			// rollback stock if persistence fails
-			for i := len(toRollback) - 1; i >= 0; i-- {
-				_ = ext.AdjustStock(c.Request.Context(), toRollback[i].ProductID, +toRollback[i].Qty)
+		refunded, err := repo.CreateRefund(c.Request.Context(), rf, places)
+		if err != nil {
+			switch err {
+			case ord.ErrNotFound:
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			case ord.ErrNotPaid:
+				c.JSON(http.StatusConflict, HTTPError{"only paid, shipped or delivered orders can be refunded"})
+			case ord.ErrRefundExceedsTotal:
+				c.JSON(http.StatusConflict, HTTPError{"refund exceeds the remaining refundable amount"})
+			case ord.ErrRefundItems:
+				c.JSON(http.StatusConflict, HTTPError{"refund items exceed the ordered quantities"})
+			default:
+				c.JSON(http.StatusInternalServerError, HTTPError{"refund error"})
 			}
-			c.JSON(http.StatusInternalServerError, HTTPError{"create order error"})
 			return
 		}
 
-		outOrder, outItems, _ := repo.GetByID(c.Request.Context(), o.ID)
-		c.JSON(http.StatusCreated, gin.H{"order": outOrder, "items": outItems})
+		if rf.Restock {
+			items := make([]ord.Item, 0, len(rf.Items))
+			for _, it := range rf.Items {
+				items = append(items, ord.Item{OrderID: id, ProductID: it.ProductID, Quantity: it.Quantity})
+			}
+			restockItems(c.Request.Context(), repo, ext, opts, id, ord.StockReasonRefund, items)
+		}
+		c.JSON(http.StatusCreated, gin.H{"refund": rf, "refunded_total": refunded})
 	}
 }
 
-// getOrderHandler godoc
-// @Summary      Get order by ID
+// listRefundsHandler godoc
+// @Summary      Order refunds
 // @Tags         orders
 // @Param        id   path      string  true  "Order ID (UUID)"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      404  {object}  HTTPError
-// @Router       /orders/{id} [get]
-func getOrderHandler(repo ord.Repository) gin.HandlerFunc {
+// @Failure      500  {object}  HTTPError
+// @Router       /orders/{id}/refunds [get]
+func listRefundsHandler(repo ord.Repository, opts orderOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		o, items, err := repo.GetByID(c.Request.Context(), c.Param("id"))
+		id := c.Param("id")
+		if _, _, ok := loadOrder(c, repo, id); !ok {
+			return
+		}
+		refunds, err := repo.ListRefunds(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusNotFound, HTTPError{"not found"})
+			c.JSON(http.StatusInternalServerError, HTTPError{"refunds error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+		total := decimal.Zero
+		for _, rf := range refunds {
+			if a, err := decimal.NewFromString(rf.Amount); err == nil {
+				total = total.Add(a)
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"items": refunds, "refunded_total": total.StringFixed(opts.PriceDecimals)})
 	}
 }
 
-// listOrdersByUserHandler godoc
-// @Summary      List orders by user
+// mergeUsersHandler godoc
+// @Summary      Merge two users' order history (admin)
+// @Description  Moves every order of 'from_user_id' to 'to_user_id' in one transaction, when duplicate accounts are merged. Merging a user into itself is rejected. Served only on the admin listener (ORDER_ADMIN_ADDR).
 // @Tags         orders
-// @Param        user_id  path   string  true   "User ID (UUID)"
-// @Param        limit    query  int     false  "Limit (1-100)"  minimum(1) maximum(100) default(20)
-// @Param        offset   query  int     false  "Offset (>=0)"   minimum(0) default(0)
-// @Success      200      {object}  map[string]interface{}
-// @Failure      500      {object}  HTTPError
-// @Router       /orders/user/{user_id} [get]
-func listOrdersByUserHandler(repo ord.Repository) gin.HandlerFunc {
+// @Accept       json
+// @Produce      json
+// @Param        body  body      ord.MergeUsersRequest  true  "from_user_id, to_user_id (UUIDs)"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  HTTPError
+// @Failure      422   {object}  HTTPError
+// @Failure      500   {object}  HTTPError
+// @Router       /orders/merge-users [post]
+func mergeUsersHandler(repo ord.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
-		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
-		if limit <= 0 || limit > 100 {
-			limit = 20
+		var in ord.MergeUsersRequest
+		if !httpx.BindJSON(c, &in) {
+			return
 		}
-		if offset < 0 {
-			offset = 0
+		from, errFrom := uuid.Parse(in.FromUserID)
+		to, errTo := uuid.Parse(in.ToUserID)
+		if errFrom != nil || errTo != nil {
+			httpx.Unprocessable(c, "from_user_id and to_user_id must be UUIDs")
+			return
+		}
+		if from == to {
+			httpx.Unprocessable(c, "cannot merge a user into itself")
+			return
 		}
-		list, err := repo.ListByUser(c.Request.Context(), c.Param("user_id"), limit, offset)
+		moved, err := repo.ReassignUser(c.Request.Context(), in.FromUserID, in.ToUserID)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, HTTPError{"list error"})
+			log.Printf("[order] merge users %s -> %s error: %v", in.FromUserID, in.ToUserID, err)
+			c.JSON(http.StatusInternalServerError, HTTPError{"merge users error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"items": list, "limit": limit, "offset": offset})
+		c.JSON(http.StatusOK, gin.H{"from_user_id": in.FromUserID, "to_user_id": in.ToUserID, "moved": moved})
 	}
 }
 
-// updateOrderStatusHandler godoc
-// @Summary      Update order status
+// addOrderItemHandler godoc
+// @Summary      Add an item to an order
+// @Description  Prices the line like order creation (current price, optional discount, PRICE_DECIMALS), takes its stock and appends it after the last line; the total is recomputed. A canceled order takes no new lines, and a paid order's items are locked (409) while ORDER_LOCKED_FIELDS includes items.
 // @Tags         orders
 // @Accept       json
 // @Produce      json
-// @Param        id    path   string              true  "Order ID (UUID)"
-// @Param        body  body   map[string]string   true  "status: pending|paid|canceled"
-// @Success      200   {object}  map[string]interface{}
+// @Param        id    path      string               true  "Order ID (UUID)"
+// @Param        body  body      ord.CreateOrderItem  true  "product_id, quantity, optional discount"
+// @Success      201   {object}  map[string]interface{}
 // @Failure      400   {object}  HTTPError
 // @Failure      404   {object}  HTTPError
+// @Failure      409   {object}  StockConflict
+// @Failure      422   {object}  HTTPError
 // @Failure      500   {object}  HTTPError
-// @Router       /orders/{id}/status [put]
-func updateOrderStatusHandler(repo ord.Repository, ext *ord.Ext) gin.HandlerFunc {
+// @Router       /orders/{id}/items [post]
+func addOrderItemHandler(repo ord.Repository, ext *ord.Ext, opts orderOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		var in struct {
-			Status string `json:"status"`
+		var in ord.CreateOrderItem
+		if !httpx.BindJSON(c, &in) {
+			return
 		}
-		if err := c.BindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, HTTPError{"invalid json"})
+		if in.ProductID == "" || in.Quantity <= 0 {
+			httpx.Unprocessable(c, "product_id & quantity > 0 required")
 			return
 		}
-
-		// normalize and validate
-		newStatus := strings.ToLower(strings.TrimSpace(in.Status))
-		allowed := map[string]bool{"pending": true, "paid": true, "canceled": true}
-		if !allowed[newStatus] {
-			c.JSON(http.StatusBadRequest, HTTPError{"invalid status"})
+		if in.ID == "" {
+			in.ID = uuid.NewString()
+		} else if _, err := uuid.Parse(in.ID); err != nil {
+			httpx.Unprocessable(c, "id must be a UUID")
 			return
 		}
 
-		// current status + items
-		o, items, err := repo.GetByID(c.Request.Context(), id)
-		if err != nil {
-			c.JSON(http.StatusNotFound, HTTPError{"not found"})
+		o, _, ok := loadOrder(c, repo, id)
+		if !ok {
 			return
 		}
-		if o.Status == newStatus {
-			// nothing to change
-			c.JSON(http.StatusOK, gin.H{"order": o, "items": items})
+		if !mutable(c, opts, o, ord.FieldItems) {
+			return
+		}
+		if o.Status == ord.StatusCanceled {
+			c.JSON(http.StatusConflict, HTTPError{"order is canceled"})
 			return
 		}
 
-		// rollback stock only if we go from pending to canceled
-		if o.Status == "pending" && newStatus == "canceled" {
-			for _, it := range items {
-				// best-effort: if any setting fails, we continue
-				_ = ext.AdjustStock(c.Request.Context(), it.ProductID, +it.Quantity)
+		p, err := ext.FetchProduct(c.Request.Context(), in.ProductID)
+		if err != nil {
+			if errors.Is(err, ord.ErrProductNotFound) {
+				httpx.Unprocessable(c, "product not found")
+				return
 			}
+			log.Printf("[order] add item: fetch product %s error: %v", in.ProductID, err)
+			c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+			return
+		}
+		if !p.Orderable() {
+			c.JSON(http.StatusConflict, HTTPError{"product unavailable"})
+			return
+		}
+		price, err := decimal.NewFromString(p.Price)
+		if err != nil {
+			log.Printf("[order] add item: product %s invalid price %q", p.ID, p.Price)
+			c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+			return
+		}
+		places := opts.PriceDecimals
+		off, line, err := ord.ApplyDiscount(price, in.Quantity, in.Discount, places)
+		if err != nil {
+			httpx.Unprocessable(c, "invalid discount")
+			return
+		}
+		it := ord.Item{ID: in.ID, ProductID: in.ProductID, Quantity: in.Quantity, Price: price.StringFixed(places), LineTotal: line.StringFixed(places)}
+		if off.IsPositive() {
+			it.Discount = off.StringFixed(places)
 		}
 
-		// update status in DB
-		if err := repo.UpdateStatus(c.Request.Context(), id, newStatus); err != nil {
-			if err == ord.ErrNotFound {
-				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+		if err := ext.TakeStock(c.Request.Context(), in.ProductID, in.Quantity, id, it.ID); err != nil {
+			var se *ord.StockError
+			if errors.As(err, &se) {
+				c.JSON(http.StatusConflict, StockConflict{"insufficient stock", in.ProductID, in.Quantity, se.Available})
 				return
 			}
-			c.JSON(http.StatusInternalServerError, HTTPError{"update status error"})
+			log.Printf("[order] add item: adjust stock %s error: %v", in.ProductID, err)
+			c.JSON(http.StatusBadGateway, HTTPError{"product service error"})
+			return
+		}
+		total, err := repo.AddItem(c.Request.Context(), id, &it, places)
+		if err != nil {
+			log.Printf("[order] add item to %s error: %v", id, err)
+			// the line was not stored: give its units back
+			if err := ext.AdjustStock(c.Request.Context(), in.ProductID, in.Quantity, ord.StockReasonCancel, id); err != nil {
+				log.Printf("[order] add item: restock %s error: %v", in.ProductID, err)
+			}
+			c.JSON(http.StatusInternalServerError, HTTPError{"add item error"})
 			return
 		}
 
-		// returns the updated order
-		o2, items2, _ := repo.GetByID(c.Request.Context(), id)
-		c.JSON(http.StatusOK, gin.H{"order": o2, "items": items2})
+		audit.Log(c.Request.Context(), opts.Audit, audit.Entry{
+			Action: audit.ActionUpdate, ResourceType: audit.ResourceOrder, ResourceID: id,
+			Detail: map[string]audit.Change{"total": {From: o.Total, To: total}, "item": {To: it}},
+		})
+		c.JSON(http.StatusCreated, gin.H{"item": it, "total": total})
 	}
 }
 
-// getOrderItemsHandler godoc
-// @Summary      Order items
+// recomputeTotalHandler godoc
+// @Summary      Recompute order total (admin)
+// @Description  Recalculates the total from the persisted items (same rounding as order creation, at PRICE_DECIMALS) and fixes the stored value. A paid order's total is locked (409) while ORDER_LOCKED_FIELDS includes total. Served only on the admin listener (ORDER_ADMIN_ADDR).
 // @Tags         orders
-// @Param        id   path   string  true  "Order ID (UUID)"
+// @Param        id   path      string  true  "Order ID (UUID)"
 // @Success      200  {object}  map[string]interface{}
 // @Failure      404  {object}  HTTPError
-// @Router       /orders/{id}/items [get]
-func getOrderItemsHandler(repo ord.Repository) gin.HandlerFunc {
+// @Failure      409  {object}  HTTPError
+// @Failure      500  {object}  HTTPError
+// @Router       /orders/{id}/recompute-total [post]
+func recomputeTotalHandler(repo ord.Repository, opts orderOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// validate order existence
-		if o, _, err := repo.GetByID(c.Request.Context(), c.Param("id")); err != nil || o == nil {
-			c.JSON(http.StatusNotFound, HTTPError{"not found"})
+		id := c.Param("id")
+		o, _, ok := loadOrder(c, repo, id)
+		if !ok {
 			return
 		}
-		items, err := repo.GetItems(c.Request.Context(), c.Param("id"))
+		if !mutable(c, opts, o, ord.FieldTotal) {
+			return
+		}
+		oldTotal, newTotal, err := repo.RecomputeTotal(c.Request.Context(), id, opts.PriceDecimals)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, HTTPError{"items error"})
+			if err == ord.ErrNotFound {
+				c.JSON(http.StatusNotFound, HTTPError{"not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, HTTPError{"recompute total error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"items": items})
+		c.JSON(http.StatusOK, gin.H{"id": id, "old_total": oldTotal, "new_total": newTotal, "changed": oldTotal != newTotal})
+	}
+}
+
+// healthProbeTimeout bounds each dependency probe of /healthz.
+const healthProbeTimeout = time.Second
+
+// pinger is the part of *pgxpool.Pool /healthz probes.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthHandler godoc
+// @Summary      Health with dependencies
+// @Description  Probes the DB, user-service (gRPC health) and product-service (/healthz) with short timeouts. 'ok' when all answer; 'degraded' (still 200) when a service dependency is down but the DB is fine; 'down' (503) when the DB is.
+// @Tags         health
+// @Produce      json
+// @Success      200  {object}  map[string]interface{}
+// @Failure      503  {object}  map[string]interface{}
+// @Router       /healthz [get]
+func healthHandler(db pinger, ext *ord.Ext) gin.HandlerFunc {
+	return httpx.Health(healthProbeTimeout,
+		httpx.HealthCheck{Name: "db", Critical: true, Probe: db.Ping},
+		httpx.HealthCheck{Name: "user_service", Probe: ext.PingUser},
+		httpx.HealthCheck{Name: "product_service", Probe: ext.PingProduct},
+	)
+}
+
+// newHTTPServer is the public server, listening on ORDER_SERVICE_ADDR.
+func newHTTPServer(cfg config.Config, h http.Handler) *http.Server {
+	return &http.Server{
+		Addr:         cfg.OrderSvcAddr,
+		Handler:      h,
+		ReadTimeout:  5 * time.Second,
+		WriteTimeout: 5 * time.Second,
 	}
 }
 
@@ -302,44 +1869,188 @@ func main() {
 	}
 	defer pool.Close()
 
-	ext, err := ord.NewExt(cfg.UserSvcAddr, cfg.ProductSvcBaseURL)
+	userAgent := cfg.OutboundUserAgent
+	if userAgent == "" {
+		userAgent = ord.DefaultUserAgent + "/" + httpx.Version
+	}
+	ext, err := ord.NewExt(cfg.UserSvcAddr, cfg.ProductSvcBaseURL,
+		ord.WithAllowedHosts(strings.Split(cfg.ProductSvcAllowedHosts, ",")...),
+		ord.WithUserAgent(userAgent),
+		ord.WithHTTPTimeout(cfg.ExtHTTPTimeout),
+		ord.WithStrictDecode(cfg.ProductStrictDecode))
 	if err != nil {
 		log.Fatalf("ext clients: %v", err)
 	}
 
-	repo := ord.NewPGRepo(pool)
+	repo := ord.NewPGRepo(pool, ord.WithMaxListRows(cfg.MaxListRows))
+
+	opts := defaultOrderOptions()
+	opts.DraftTTL = cfg.OrderDraftTTL
+	opts.PaymentSecret = cfg.PaymentSecret
+	opts.RestockNotFound = ord.ParseRestockPolicy(cfg.RestockNotFoundPolicy)
+	opts.SagaTimeout = cfg.OrderSagaTimeout
+	opts.Auth = cfg.AuthEnabled
+	opts.RejectZeroTotal = cfg.RejectZeroTotal
+	opts.StatusNoop = ord.ParseNoopPolicy(cfg.StatusNoop)
+	opts.Audit = audit.NewPGRecorder(pool)
+	if t, err := ord.ParseTransitions(cfg.OrderStatusTransitions); err != nil {
+		log.Printf("[config] ORDER_STATUS_TRANSITIONS: %v, using the defaults", err)
+	} else {
+		opts.Transitions = t
+	}
+	if l, err := ord.ParseLockedFields(cfg.OrderLockedFields); err != nil {
+		log.Printf("[config] ORDER_LOCKED_FIELDS: %v, using the defaults", err)
+	} else {
+		opts.LockedFields = l
+	}
+	if cfg.PriceDecimals > ord.MaxPriceDecimals {
+		log.Printf("[config] PRICE_DECIMALS=%d above %d, using %d", cfg.PriceDecimals, ord.MaxPriceDecimals, ord.DefaultPriceDecimals)
+	} else {
+		opts.PriceDecimals = int32(cfg.PriceDecimals)
+	}
+
+	// Release the stock held by expired drafts and by interrupted order creations
+	sweepCtx, stopSweep := context.WithCancel(context.Background())
+	defer stopSweep()
+	go func() {
+		t := time.NewTicker(time.Minute)
+		defer t.Stop()
+		for {
+			select {
+			case <-sweepCtx.Done():
+				return
+			case <-t.C:
+				sweepTenants(sweepCtx, repo, ext, opts)
+			}
+		}
+	}()
+
+	// Nightly rollup of the previous day's sales; also on start, in case the last run was missed
+	go func() {
+		rollupSales(sweepCtx, repo, time.Now().AddDate(0, 0, -1))
+		for {
+			t := time.NewTimer(time.Until(nextRollup(time.Now())))
+			select {
+			case <-sweepCtx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+				rollupSales(sweepCtx, repo, time.Now().AddDate(0, 0, -1))
+			}
+		}
+	}()
+
+	idemBackend := idempotency.ParseBackend(cfg.IdempotencyBackend)
+	idem := idempotency.New(idemBackend, pool, cfg.IdempotencyTTL)
+	log.Printf("[idempotency] %s backend, keys kept %s", idemBackend, cfg.IdempotencyTTL)
 
 	// Gin
 	r := gin.New()
+	r.Use(httpx.CORS(cfg.OrderCORSOrigins))
+	r.Use(httpx.SecurityHeaders(
+		httpx.WithFrameOptions(cfg.FrameOptions),
+		httpx.WithContentSecurityPolicy(cfg.ContentSecurityPolicy),
+		httpx.WithReferrerPolicy(cfg.ReferrerPolicy),
+	))
 	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	r.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery())
+	r.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery(), httpx.JSONCase())
+	if cfg.RateLimit > 0 {
+		backend := ratelimit.ParseBackend(cfg.RateLimiterBackend)
+		r.Use(httpx.RateLimit(ratelimit.New(backend, pool, cfg.RateLimit, cfg.RateLimitWindow)))
+		log.Printf("[ratelimit] %s backend: %d req / %s per client", backend, cfg.RateLimit, cfg.RateLimitWindow)
+	}
+
+	// Caps on in-flight requests of the expensive route groups
+	limits := httpx.ParseConcurrencyLimits(cfg.ConcurrencyLimits)
+	reportsLimit := httpx.ConcurrencyLimit(limits["reports"])
 
 	// Health
-	r.GET("/healthz", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	r.GET("/healthz", healthHandler(pool, ext))
+
+	// API contract: version and registered routes
+	r.GET("/api-info", httpx.APIInfo(r, "order-service"))
+
+	// Everything below is scoped to the caller's tenant
+	r.Use(httpx.Tenant(cfg.MultiTenant), httpx.AuditActor())
 
 	// POST /orders  — create an order by verifying user and stock
-	// Create
-	r.POST("/orders", createOrderHandler(repo, ext))
+	// Create; a retry with the same Idempotency-Key replays the first answer
+	createOrder := []gin.HandlerFunc{httpx.Idempotency(idem, orderCaller(ext, opts)), createOrderHandler(repo, ext, opts)}
+	if opts.Auth && cfg.JWTSecret != "" {
+		// the login JWT is checked locally, with no user-service round trip per order
+		createOrder = append([]gin.HandlerFunc{httpx.RequireAuth(cfg.JWTSecret, cfg.JWTLeeway)}, createOrder...)
+	}
+	r.POST("/orders", createOrder...)
+
+	// Pre-checkout cart validation (mutates nothing)
+	r.POST("/orders/validate", validateCartHandler(ext, opts))
 
 	// Get order by ID
 	r.GET("/orders/:id", getOrderHandler(repo))
 
 	// List orders by user
 	r.GET("/orders/user/:user_id", listOrdersByUserHandler(repo))
+	r.GET("/orders/user/:user_id/exists", userHasOrdersHandler(repo))
+	r.GET("/orders/user/:user_id/latest", latestOrderHandler(repo))
+	r.GET("/orders/user/:user_id/status-counts", statusCountsHandler(repo))
+	r.GET("/orders/user/:user_id/product-totals", reportsLimit, productTotalsHandler(repo, ext))
+	r.GET("/orders/products/:product_id/active-quantity", activeQuantityHandler(repo))
+	r.GET("/orders/product/:product_id/related", reportsLimit, relatedProductsHandler(repo))
+	r.GET("/orders/products/:product_id/related", reportsLimit, relatedProductsHandler(repo))
 
 	// Update order status
-	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext))
+	r.PUT("/orders/:id/status", updateOrderStatusHandler(repo, ext, opts))
 
 	//Get order items
-	r.GET("/orders/:id/items", getOrderItemsHandler(repo))
+	r.GET("/orders/:id/items", getOrderItemsHandler(repo, ext))
+	r.POST("/orders/:id/items", addOrderItemHandler(repo, ext, opts))
+
+	// Stock movements the order caused (from product-service)
+	r.GET("/orders/:id/stock-movements", getOrderStockMovementsHandler(repo, ext))
+
+	// Payment provider callback (HMAC-signed, idempotent on provider_ref)
+	r.POST("/orders/webhook/payment", paymentWebhookHandler(repo, opts))
+
+	// Refunds of paid orders
+	r.POST("/orders/:id/refunds", createRefundHandler(repo, ext, opts))
+	r.GET("/orders/:id/refunds", listRefundsHandler(repo, opts))
+
+	// Mark paid (idempotent)
+	r.POST("/orders/:id/pay", payOrderHandler(repo, opts))
+
+	// Commit a draft (draft -> pending)
+	r.POST("/orders/:id/commit", commitDraftHandler(repo, opts))
+
+	// Reports (from the nightly daily_sales rollup)
+	r.GET("/reports/daily", reportsLimit, dailySalesHandler(repo))
+	r.GET("/reports/daily/export", reportsLimit, dailySalesExportHandler(repo))
+
+	// Admin endpoints, served only on the admin listener (ORDER_ADMIN_ADDR)
+	admin := gin.New()
+	admin.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery(), httpx.JSONCase(), httpx.Tenant(cfg.MultiTenant), httpx.AuditActor())
+
+	// Admin: move a duplicate account's orders to the surviving user
+	admin.POST("/orders/merge-users", mergeUsersHandler(repo))
+
+	// Admin: cancel (and restock) everything a user has pending
+	admin.POST("/orders/user/:user_id/cancel-pending", cancelPendingHandler(repo, ext, opts))
+
+	// Admin: recompute stored total from items
+	admin.POST("/orders/:id/recompute-total", recomputeTotalHandler(repo, opts))
+
+	srv := newHTTPServer(cfg, r)
+	// Bind up front so a bad ORDER_SERVICE_ADDR fails at startup and the log shows the real port
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		log.Fatalf("[http] listen %s: %v", srv.Addr, err)
+	}
 
-	srv := &http.Server{Addr: cfg.ProductSvcBaseURL /* placeholder to reuse config? set your ORDER_SERVICE_ADDR */, Handler: r, ReadTimeout: 5 * time.Second, WriteTimeout: 5 * time.Second}
+	// Admin endpoints, config and profiling on their own listener, never on the public router
+	adminSrv := httpx.StartAdmin(cfg.OrderAdminAddr, "order-service", httpx.Admin(cfg.Redacted(), cfg.EnablePprof, admin))
 
 	go func() {
-		addr := ":8082" // or cfg.OrderSvcAddr
-		srv.Addr = addr
-		log.Printf("[http] order-service listening on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("[http] order-service listening on %s", ln.Addr())
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("[http] error: %v", err)
 		}
 	}()
@@ -350,4 +2061,5 @@ func main() {
 	ctxSh, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel2()
 	_ = srv.Shutdown(ctxSh)
+	_ = adminSrv.Shutdown(ctxSh)
 }
@@ -0,0 +1,2071 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/shopspring/decimal"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
+	"github.com/MikeMC777/ordenes-ecom/internal/httpx"
+	"github.com/MikeMC777/ordenes-ecom/internal/product"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+//
+// ---------- STUBS & FAKES ----------
+//
+
+// stubRepo implements the product.Repository interface in memory.
+type stubRepo struct {
+	products  map[string]*product.Product
+	subs      map[string][]product.RestockSubscription
+	movements []product.StockMovement
+	// restocks already applied, keyed by order_id + product_id
+	ledger map[string]bool
+	// order-line decrements already applied, keyed by order_id + item_id
+	taken map[string]bool
+	// owning tenant by product id; seeded products belong to tenant.Default
+	tenants map[string]string
+	// price_history rows, oldest first
+	prices []product.PriceChange
+	// when set, GetByID reads this snapshot instead: a replica lagging behind the writes
+	replica map[string]*product.Product
+	// when set, the lookups by id or barcode fail with it (database down)
+	lookupErr error
+}
+
+// visible mirrors the tenant_id filter of PGRepo.
+func (s *stubRepo) visible(ctx context.Context, id string) bool {
+	owner, ok := s.tenants[id]
+	if !ok {
+		owner = tenant.Default
+	}
+	return owner == tenant.From(ctx)
+}
+
+func (s *stubRepo) record(id string, delta, resulting int, ch product.StockChange) {
+	if ch.Reason == "" {
+		ch.Reason = product.ReasonAdjustment
+	}
+	s.movements = append(s.movements, product.StockMovement{
+		ID: int64(len(s.movements) + 1), ProductID: id, Delta: delta, Reason: ch.Reason,
+		ResultingStock: resulting, OrderID: ch.OrderID, At: time.Now(),
+	})
+}
+
+func newStubRepo(ps ...product.Product) *stubRepo {
+	s := &stubRepo{
+		products: map[string]*product.Product{},
+		subs:     map[string][]product.RestockSubscription{},
+		tenants:  map[string]string{},
+	}
+	for i := range ps {
+		p := ps[i]
+		s.products[p.ID] = &p
+	}
+	return s
+}
+
+func (s *stubRepo) Create(ctx context.Context, p *product.Product) error {
+	cp := *p
+	s.products[p.ID] = &cp
+	s.tenants[p.ID] = tenant.From(ctx)
+	return nil
+}
+
+func (s *stubRepo) GetByID(ctx context.Context, id string) (*product.Product, error) {
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+	if s.replica != nil {
+		p, ok := s.replica[id]
+		if !ok || p.DeletedAt != nil || !s.visible(ctx, id) {
+			return nil, product.ErrNotFound
+		}
+		cp := *p
+		return &cp, nil
+	}
+	return s.GetByIDPrimary(ctx, id)
+}
+
+func (s *stubRepo) GetByIDPrimary(ctx context.Context, id string) (*product.Product, error) {
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+	p, ok := s.products[id]
+	if !ok || p.DeletedAt != nil || !s.visible(ctx, id) {
+		return nil, product.ErrNotFound
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (s *stubRepo) GetByIDIncludeDeleted(ctx context.Context, id string) (*product.Product, error) {
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+	p, ok := s.products[id]
+	if !ok || !s.visible(ctx, id) {
+		return nil, product.ErrNotFound
+	}
+	cp := *p
+	return &cp, nil
+}
+
+func (s *stubRepo) GetByBarcode(ctx context.Context, code string) (*product.Product, error) {
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+	for _, p := range s.products {
+		if p.Barcode == code && s.visible(ctx, p.ID) {
+			cp := *p
+			return &cp, nil
+		}
+	}
+	return nil, product.ErrNotFound
+}
+
+func (s *stubRepo) List(ctx context.Context, q product.Query) ([]product.Product, error) {
+	out := s.created(s.search(ctx, q.Q), q)
+	if q.Limit <= 0 || q.Limit > 100 {
+		q.Limit = 20
+	}
+	if q.Offset > len(out) {
+		q.Offset = len(out)
+	}
+	out = out[q.Offset:]
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+	return out, nil
+}
+
+func (s *stubRepo) CountSearch(ctx context.Context, q product.Query) (int, error) {
+	return len(s.created(s.search(ctx, q.Q), q)), nil
+}
+
+// created keeps the products within q's inclusive created_at bounds.
+func (s *stubRepo) created(in []product.Product, q product.Query) []product.Product {
+	out := []product.Product{}
+	for _, p := range in {
+		if (q.CreatedFrom == nil || !p.CreatedAt.Before(*q.CreatedFrom)) && (q.CreatedTo == nil || !p.CreatedAt.After(*q.CreatedTo)) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// search mirrors the ILIKE match on name/description, ordered by ID so pages are stable.
+func (s *stubRepo) search(ctx context.Context, q string) []product.Product {
+	q = strings.ToLower(strings.TrimSpace(q))
+	out := []product.Product{}
+	for _, p := range s.products {
+		if !s.visible(ctx, p.ID) {
+			continue
+		}
+		if q == "" || strings.Contains(strings.ToLower(p.Name), q) || strings.Contains(strings.ToLower(p.Description), q) {
+			out = append(out, *p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+func (s *stubRepo) LowStock(ctx context.Context, threshold, limit, offset int) ([]product.Product, error) {
+	out := []product.Product{}
+	for _, p := range s.products {
+		t := threshold
+		if t < 0 {
+			t = p.LowStockThreshold
+		}
+		if p.Stock <= t {
+			out = append(out, *p)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Stock < out[j].Stock })
+	return out, nil
+}
+
+func (s *stubRepo) Categories(ctx context.Context) ([]product.CategoryCount, error) {
+	counts := map[string]int{}
+	for _, p := range s.products {
+		if p.Category != "" && p.DeletedAt == nil {
+			counts[p.Category]++
+		}
+	}
+	out := []product.CategoryCount{}
+	for c, n := range counts {
+		out = append(out, product.CategoryCount{Category: c, Products: n})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Category < out[j].Category })
+	return out, nil
+}
+
+func (s *stubRepo) Update(ctx context.Context, p *product.Product, updatePrice bool, backorder *bool, ch product.StockChange) (bool, error) {
+	cur, ok := s.products[p.ID]
+	if !ok || cur.DeletedAt != nil || !s.visible(ctx, p.ID) {
+		return false, nil
+	}
+	if !p.UpdatedAt.IsZero() && !cur.UpdatedAt.Equal(p.UpdatedAt) {
+		return true, product.ErrPreconditionFailed
+	}
+	allow := cur.AllowBackorder
+	if backorder != nil {
+		allow = *backorder
+	}
+	if p.Stock < 0 && !allow {
+		return true, product.ErrNegativeStock
+	}
+	cur.AllowBackorder = allow
+	cur.UpdatedAt = cur.UpdatedAt.Add(time.Second)
+	if p.Name != "" {
+		cur.Name = p.Name
+	}
+	if p.Barcode != "" {
+		cur.Barcode = p.Barcode
+	}
+	if p.Category != "" {
+		cur.Category = p.Category
+	}
+	if p.Status != "" {
+		cur.Status = p.Status
+	}
+	if updatePrice {
+		cur.Price = p.Price
+	}
+	if delta := p.Stock - cur.Stock; delta != 0 {
+		s.record(p.ID, delta, p.Stock, ch)
+	}
+	cur.Stock = p.Stock
+	if p.LowStockThreshold >= 0 {
+		cur.LowStockThreshold = p.LowStockThreshold
+	}
+	return true, nil
+}
+
+func (s *stubRepo) Delete(ctx context.Context, id string) (bool, error) {
+	p, ok := s.products[id]
+	if !ok || p.DeletedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	p.DeletedAt = &now
+	return true, nil
+}
+
+func (s *stubRepo) BulkAdjustPrice(ctx context.Context, category string, percent decimal.Decimal) ([]product.PriceChange, error) {
+	var changes []product.PriceChange
+	for _, p := range s.products {
+		if p.Category != category || p.DeletedAt != nil || !s.visible(ctx, p.ID) {
+			continue
+		}
+		np, err := product.AdjustPrice(p.Price, percent)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, product.PriceChange{ProductID: p.ID, OldPrice: p.Price, NewPrice: np})
+		p.Price = np
+	}
+	s.prices = append(s.prices, changes...)
+	return changes, nil
+}
+
+func (s *stubRepo) DecrementStock(ctx context.Context, id string, qty int, ch product.StockChange) (int, error) {
+	p, ok := s.products[id]
+	if !ok {
+		return 0, product.ErrNotFound
+	}
+	if p.Stock < qty && !p.AllowBackorder {
+		return 0, product.ErrInsufficientStock
+	}
+	p.Stock -= qty
+	s.record(id, -qty, p.Stock, ch)
+	return p.Stock, nil
+}
+
+func (s *stubRepo) DecrementStockOnce(ctx context.Context, id string, qty int, ch product.StockChange) (int, bool, error) {
+	p, ok := s.products[id]
+	if !ok {
+		return 0, false, product.ErrNotFound
+	}
+	key := ch.OrderID + "/" + ch.ItemID
+	if s.taken[key] {
+		return p.Stock, false, nil
+	}
+	stock, err := s.DecrementStock(ctx, id, qty, ch)
+	if err != nil {
+		return 0, false, err
+	}
+	if s.taken == nil {
+		s.taken = map[string]bool{}
+	}
+	s.taken[key] = true
+	return stock, true, nil
+}
+
+func (s *stubRepo) IncrementStock(ctx context.Context, id string, qty int, ch product.StockChange) (int, error) {
+	p, ok := s.products[id]
+	if !ok {
+		return 0, product.ErrNotFound
+	}
+	p.Stock += qty
+	s.record(id, qty, p.Stock, ch)
+	return p.Stock, nil
+}
+
+func (s *stubRepo) SetStock(ctx context.Context, id string, stock int, ch product.StockChange) (int, error) {
+	p, ok := s.products[id]
+	if !ok || p.DeletedAt != nil || !s.visible(ctx, id) {
+		return 0, product.ErrNotFound
+	}
+	before := p.Stock
+	if stock != before {
+		p.Stock = stock
+		s.record(id, stock-before, stock, ch)
+	}
+	return before, nil
+}
+
+func (s *stubRepo) RestockOnce(ctx context.Context, id string, qty int, ch product.StockChange) (int, bool, error) {
+	p, ok := s.products[id]
+	if !ok || p.DeletedAt != nil {
+		return 0, false, product.ErrNotFound
+	}
+	if s.ledger == nil {
+		s.ledger = map[string]bool{}
+	}
+	key := ch.OrderID + "/" + id
+	if s.ledger[key] {
+		return p.Stock, false, nil
+	}
+	s.ledger[key] = true
+	p.Stock += qty
+	s.record(id, qty, p.Stock, ch)
+	return p.Stock, true, nil
+}
+
+func (s *stubRepo) TransferStock(ctx context.Context, fromID, toID string, qty int) (int, int, error) {
+	from, ok1 := s.products[fromID]
+	to, ok2 := s.products[toID]
+	if !ok1 || !ok2 {
+		return 0, 0, product.ErrNotFound
+	}
+	if from.Stock < qty {
+		return 0, 0, product.ErrInsufficientStock
+	}
+	from.Stock -= qty
+	to.Stock += qty
+	s.record(fromID, -qty, from.Stock, product.StockChange{Reason: product.ReasonTransferOut})
+	s.record(toID, qty, to.Stock, product.StockChange{Reason: product.ReasonTransferIn})
+	return from.Stock, to.Stock, nil
+}
+
+func (s *stubRepo) StockMovements(ctx context.Context, productID string, limit, offset int) ([]product.StockMovement, error) {
+	out := []product.StockMovement{}
+	for i := len(s.movements) - 1; i >= 0; i-- {
+		if s.movements[i].ProductID == productID {
+			out = append(out, s.movements[i])
+		}
+	}
+	if offset >= len(out) {
+		return []product.StockMovement{}, nil
+	}
+	out = out[offset:]
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (s *stubRepo) OrderStockMovements(ctx context.Context, orderID string) ([]product.StockMovement, error) {
+	out := []product.StockMovement{}
+	for _, m := range s.movements {
+		if m.OrderID == orderID && s.visible(ctx, m.ProductID) {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (s *stubRepo) Subscribe(ctx context.Context, sub *product.RestockSubscription) error {
+	s.subs[sub.ProductID] = append(s.subs[sub.ProductID], *sub)
+	return nil
+}
+
+func (s *stubRepo) RestockSubscriptions(ctx context.Context, productID string) ([]product.RestockSubscription, error) {
+	return s.subs[productID], nil
+}
+
+func (s *stubRepo) ClearRestockSubscriptions(ctx context.Context, productID string, emails []string) error {
+	var left []product.RestockSubscription
+	for _, sub := range s.subs[productID] {
+		if !slices.Contains(emails, sub.Email) {
+			left = append(left, sub)
+		}
+	}
+	s.subs[productID] = left
+	return nil
+}
+
+// fakeNotifier records the notifications it receives.
+type fakeNotifier struct {
+	calls int
+	sent  []product.RestockSubscription
+	// availability events: product id -> new availability, in order
+	availability []availabilityEvent
+	// runs inside NotifyRestock, e.g. to subscribe someone mid-delivery
+	duringRestock func()
+}
+
+type availabilityEvent struct {
+	id        string
+	available bool
+}
+
+func (f *fakeNotifier) NotifyRestock(ctx context.Context, p *product.Product, subs []product.RestockSubscription) error {
+	f.calls++
+	f.sent = append(f.sent, subs...)
+	if f.duringRestock != nil {
+		f.duringRestock()
+	}
+	return nil
+}
+
+func (f *fakeNotifier) NotifyAvailability(ctx context.Context, p *product.Product, available bool) error {
+	f.availability = append(f.availability, availabilityEvent{p.ID, available})
+	return nil
+}
+
+// fakeAudit keeps the recorded audit entries.
+type fakeAudit struct{ entries []audit.Entry }
+
+func (f *fakeAudit) Record(ctx context.Context, e audit.Entry) error {
+	f.entries = append(f.entries, e)
+	return nil
+}
+
+func doJSON(r http.Handler, method, url, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	return w
+}
+
+//
+// ---------- TESTS ----------
+//
+
+func TestNotifyMe_Subscribes(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 0})
+
+	r := gin.New()
+	r.POST("/products/:id/notify-me", notifyMeHandler(repo))
+
+	w := doJSON(r, http.MethodPost, "/products/"+id+"/notify-me", `{"email":"a@test.com"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(repo.subs[id]) != 1 {
+		t.Fatalf("suscripciones=%d, esperaba 1", len(repo.subs[id]))
+	}
+
+	w = doJSON(r, http.MethodPost, "/products/"+uuid.NewString()+"/notify-me", `{"email":"a@test.com"}`)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status=%d body=%s (esperaba 404)", w.Code, w.Body.String())
+	}
+}
+
+func TestUpdateProduct_RestockFiresOnlyFromZero(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name    string
+		before  int
+		after   int
+		fires   bool
+		remains int
+	}{
+		{"zero to positive", 0, 5, true, 0},
+		{"positive to positive", 2, 5, false, 1},
+		{"zero to zero", 0, 0, false, 1},
+		{"positive to zero", 3, 0, false, 1},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			id := uuid.NewString()
+			repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: tc.before})
+			repo.subs[id] = []product.RestockSubscription{{ProductID: id, Email: "a@test.com"}}
+			n := &fakeNotifier{}
+
+			r := gin.New()
+			r.PUT("/products/:id", updateProductHandler(repo, n, defaultProductOptions()))
+
+			w := doJSON(r, http.MethodPut, "/products/"+id, `{"stock":`+strconv.Itoa(tc.after)+`}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+			}
+			if fired := n.calls > 0; fired != tc.fires {
+				t.Fatalf("disparó=%v, esperaba %v", fired, tc.fires)
+			}
+			if len(repo.subs[id]) != tc.remains {
+				t.Fatalf("suscripciones restantes=%d, esperaba %d", len(repo.subs[id]), tc.remains)
+			}
+		})
+	}
+}
+
+func TestUpdateProduct_RestockKeepsLateSubscriptions(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 0})
+	repo.subs[id] = []product.RestockSubscription{{ProductID: id, Email: "a@test.com"}}
+	// b se suscribe después de leer las suscripciones y antes de borrarlas
+	n := &fakeNotifier{duringRestock: func() {
+		repo.subs[id] = append(repo.subs[id], product.RestockSubscription{ProductID: id, Email: "b@test.com"})
+	}}
+
+	r := gin.New()
+	r.PUT("/products/:id", updateProductHandler(repo, n, defaultProductOptions()))
+	if w := doJSON(r, http.MethodPut, "/products/"+id, `{"stock":5}`); w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(n.sent) != 1 || n.sent[0].Email != "a@test.com" {
+		t.Fatalf("enviadas=%+v, esperaba solo a@test.com", n.sent)
+	}
+	if subs := repo.subs[id]; len(subs) != 1 || subs[0].Email != "b@test.com" {
+		t.Fatalf("restantes=%+v, esperaba que b@test.com espere la próxima reposición", subs)
+	}
+}
+
+func TestUpdateProduct_AvailabilityFiresOnCrossing(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		name      string
+		before    int
+		after     int
+		fires     bool
+		available bool
+	}{
+		{"zero to positive", 0, 5, true, true},
+		{"positive to zero", 3, 0, true, false},
+		{"positive to positive", 2, 5, false, false},
+		{"zero to zero", 0, 0, false, false},
+		{"backordered to positive", -2, 1, true, true},
+		{"positive to backordered", 1, -1, true, false},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			id := uuid.NewString()
+			repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: tc.before, AllowBackorder: true})
+			n := &fakeNotifier{}
+
+			r := gin.New()
+			r.PUT("/products/:id", updateProductHandler(repo, n, defaultProductOptions()))
+
+			w := doJSON(r, http.MethodPut, "/products/"+id, `{"stock":`+strconv.Itoa(tc.after)+`}`)
+			if w.Code != http.StatusOK {
+				t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+			}
+			if fired := len(n.availability) > 0; fired != tc.fires {
+				t.Fatalf("disparó=%v, esperaba %v", fired, tc.fires)
+			}
+			if tc.fires && (len(n.availability) != 1 || n.availability[0] != (availabilityEvent{id, tc.available})) {
+				t.Fatalf("eventos=%+v, esperaba uno con available=%v", n.availability, tc.available)
+			}
+		})
+	}
+}
+
+func TestTransferStock_Availability(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "A", Stock: 3}
+	b := product.Product{ID: uuid.NewString(), Name: "B", Stock: 0}
+	repo := newStubRepo(a, b)
+	n := &fakeNotifier{}
+
+	r := gin.New()
+	r.POST("/products/transfer-stock", transferStockHandler(repo, n))
+
+	body := func(from, to string, qty int) string {
+		return `{"from_id":"` + from + `","to_id":"` + to + `","qty":` + strconv.Itoa(qty) + `}`
+	}
+
+	// A se vacía y B recibe sus primeras unidades: los dos cruzan el cero
+	if w := doJSON(r, http.MethodPost, "/products/transfer-stock", body(a.ID, b.ID, 3)); w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	want := []availabilityEvent{{a.ID, false}, {b.ID, true}}
+	if len(n.availability) != 2 || n.availability[0] != want[0] || n.availability[1] != want[1] {
+		t.Fatalf("eventos=%+v, esperaba %+v", n.availability, want)
+	}
+
+	// B conserva unidades y A estaba vacío: solo A cruza
+	if w := doJSON(r, http.MethodPost, "/products/transfer-stock", body(b.ID, a.ID, 1)); w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(n.availability) != 3 || n.availability[2] != (availabilityEvent{a.ID, true}) {
+		t.Fatalf("eventos=%+v, esperaba solo A pasando a disponible", n.availability)
+	}
+}
+
+func TestListProducts_Envelope(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo(product.Product{ID: uuid.NewString(), Name: "Mouse", Price: "10.00", Stock: 1})
+
+	r := gin.New()
+	r.GET("/products", listOnlyHandler(repo))
+	r.GET("/products/search", searchHandler(repo))
+
+	for _, url := range []string{"/products", "/products/search?q=mo"} {
+		w := doJSON(r, http.MethodGet, url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", url, w.Code, w.Body.String())
+		}
+		var body product.ListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s json inválido: %v", url, err)
+		}
+		if _, err := time.Parse(time.RFC3339, body.GeneratedAt); err != nil {
+			t.Fatalf("%s generated_at=%q no es RFC3339", url, body.GeneratedAt)
+		}
+		if body.Version == "" || body.Limit != 20 || len(body.Items) != 1 {
+			t.Fatalf("%s body inesperado: %s", url, w.Body.String())
+		}
+	}
+}
+
+func TestLowStock_Thresholds(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "A", Stock: 2, LowStockThreshold: 1}
+	b := product.Product{ID: uuid.NewString(), Name: "B", Stock: 4, LowStockThreshold: 10}
+	c := product.Product{ID: uuid.NewString(), Name: "C", Stock: 8, LowStockThreshold: 5}
+	repo := newStubRepo(a, b, c)
+
+	r := gin.New()
+	r.GET("/products/low-stock", lowStockHandler(repo))
+
+	names := func(url string) []string {
+		w := doJSON(r, http.MethodGet, url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", url, w.Code, w.Body.String())
+		}
+		var body product.ListResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("json inválido: %v", err)
+		}
+		var out []string
+		for _, p := range body.Items {
+			out = append(out, p.Name)
+		}
+		return out
+	}
+
+	// umbral explícito: stock <= 5, ascendente
+	if got := names("/products/low-stock?threshold=5"); len(got) != 2 || got[0] != "A" || got[1] != "B" {
+		t.Fatalf("umbral explícito: %v, esperaba [A B]", got)
+	}
+	// umbral por producto: solo B (4 <= 10); A (2 > 1) y C (8 > 5) están bien
+	if got := names("/products/low-stock"); len(got) != 1 || got[0] != "B" {
+		t.Fatalf("umbral por producto: %v, esperaba [B]", got)
+	}
+
+	if w := doJSON(r, http.MethodGet, "/products/low-stock?threshold=-1", ""); w.Code != http.StatusBadRequest {
+		t.Fatalf("status=%d (esperaba 400)", w.Code)
+	}
+}
+
+func TestTransferStock(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "A", Stock: 5}
+	b := product.Product{ID: uuid.NewString(), Name: "B", Stock: 1}
+	repo := newStubRepo(a, b)
+
+	r := gin.New()
+	r.POST("/products/transfer-stock", transferStockHandler(repo, &fakeNotifier{}))
+
+	body := func(qty int) string {
+		return `{"from_id":"` + a.ID + `","to_id":"` + b.ID + `","qty":` + strconv.Itoa(qty) + `}`
+	}
+
+	w := doJSON(r, http.MethodPost, "/products/transfer-stock", body(3))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if repo.products[a.ID].Stock != 2 || repo.products[b.ID].Stock != 4 {
+		t.Fatalf("stock tras la transferencia: A=%d B=%d, esperaba 2 y 4", repo.products[a.ID].Stock, repo.products[b.ID].Stock)
+	}
+
+	// stock de origen insuficiente: 409 y no se mueve nada
+	w = doJSON(r, http.MethodPost, "/products/transfer-stock", body(10))
+	if w.Code != http.StatusConflict {
+		t.Fatalf("status=%d body=%s (esperaba 409)", w.Code, w.Body.String())
+	}
+	if repo.products[a.ID].Stock != 2 || repo.products[b.ID].Stock != 4 {
+		t.Fatalf("el stock se movió pese al error: A=%d B=%d", repo.products[a.ID].Stock, repo.products[b.ID].Stock)
+	}
+}
+
+func TestGetProduct_CamelCase(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 1, LowStockThreshold: 5})
+
+	r := gin.New()
+	r.Use(httpx.JSONCase())
+	r.GET("/products/:id", getProductHandler(repo))
+
+	w := doJSON(r, http.MethodGet, "/products/"+id+"?case=camel", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	for _, k := range []string{"createdAt", "lowStockThreshold", "updatedAt"} {
+		if _, ok := got[k]; !ok {
+			t.Fatalf("falta la clave %q: %s", k, w.Body.String())
+		}
+	}
+	if _, ok := got["created_at"]; ok {
+		t.Fatalf("sigue habiendo claves snake_case: %s", w.Body.String())
+	}
+
+	// por defecto sigue en snake_case
+	w = doJSON(r, http.MethodGet, "/products/"+id, "")
+	if !strings.Contains(w.Body.String(), `"created_at"`) {
+		t.Fatalf("la respuesta por defecto no está en snake_case: %s", w.Body.String())
+	}
+}
+
+func TestGetProduct_EmptyDescriptionKeyPresent(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 1})
+
+	r := gin.New()
+	r.GET("/products/:id", getProductHandler(repo))
+
+	w := doJSON(r, http.MethodGet, "/products/"+id, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var got map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	d, ok := got["description"]
+	if !ok || d != "" {
+		t.Fatalf("description=%v presente=%v, esperaba string vacío: %s", d, ok, w.Body.String())
+	}
+}
+
+func TestStockMovements_RecordedPerPath(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "A", Price: "10.00", Stock: 10}
+	b := product.Product{ID: uuid.NewString(), Name: "B", Price: "10.00", Stock: 0}
+	repo := newStubRepo(a, b)
+	oid := uuid.NewString()
+
+	r := gin.New()
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+	r.POST("/products/transfer-stock", transferStockHandler(repo, &fakeNotifier{}))
+	r.GET("/products/:id/stock-movements", stockMovementsHandler(repo))
+
+	steps := []struct {
+		method, url, body string
+	}{
+		{http.MethodPut, "/products/" + a.ID, `{"stock":8,"stock_reason":"order","order_id":"` + oid + `"}`},
+		{http.MethodPut, "/products/" + a.ID, `{"stock":10,"stock_reason":"cancel","order_id":"` + oid + `"}`},
+		{http.MethodPut, "/products/" + a.ID, `{"stock":7}`},
+		{http.MethodPost, "/products/transfer-stock", `{"from_id":"` + a.ID + `","to_id":"` + b.ID + `","qty":2}`},
+	}
+	for _, st := range steps {
+		if w := doJSON(r, st.method, st.url, st.body); w.Code != http.StatusOK {
+			t.Fatalf("%s %s: status=%d body=%s", st.method, st.url, w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(r, http.MethodGet, "/products/"+a.ID+"/stock-movements", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Items []product.StockMovement `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	// más nuevos primero
+	want := []struct {
+		reason    product.MovementReason
+		delta     int
+		resulting int
+		orderID   string
+	}{
+		{product.ReasonTransferOut, -2, 5, ""},
+		{product.ReasonAdjustment, -3, 7, ""},
+		{product.ReasonCancel, 2, 10, oid},
+		{product.ReasonOrder, -2, 8, oid},
+	}
+	if len(resp.Items) != len(want) {
+		t.Fatalf("movimientos=%d, esperaba %d: %s", len(resp.Items), len(want), w.Body.String())
+	}
+	for i, m := range resp.Items {
+		if m.Reason != want[i].reason || m.Delta != want[i].delta || m.ResultingStock != want[i].resulting || m.OrderID != want[i].orderID {
+			t.Fatalf("movimiento %d = %+v, esperaba %+v", i, m, want[i])
+		}
+	}
+
+	// el destino de la transferencia registra su parte
+	w = doJSON(r, http.MethodGet, "/products/"+b.ID+"/stock-movements?limit=1", "")
+	if !strings.Contains(w.Body.String(), `"reason":"transfer_in"`) {
+		t.Fatalf("falta transfer_in: %s", w.Body.String())
+	}
+
+	// paginación
+	w = doJSON(r, http.MethodGet, "/products/"+a.ID+"/stock-movements?limit=2&offset=3", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Items) != 1 || resp.Items[0].Reason != product.ReasonOrder {
+		t.Fatalf("página: %s", w.Body.String())
+	}
+
+	// motivo desconocido y producto desconocido
+	if w := doJSON(r, http.MethodPut, "/products/"+a.ID, `{"stock":1,"stock_reason":"theft"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("motivo inválido status=%d", w.Code)
+	}
+	if w := doJSON(r, http.MethodGet, "/products/"+uuid.NewString()+"/stock-movements", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("producto desconocido status=%d", w.Code)
+	}
+}
+
+func TestOrderStockMovements(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "A", Price: "10.00", Stock: 10}
+	b := product.Product{ID: uuid.NewString(), Name: "B", Price: "10.00", Stock: 5}
+	repo := newStubRepo(a, b)
+	oid, other := uuid.NewString(), uuid.NewString()
+
+	r := gin.New()
+	r.POST("/products/:id/decrement", decrementStockHandler(repo, &fakeNotifier{}))
+	r.GET("/products/:id", getProductHandler(repo))
+	r.GET("/products/stock-movements", orderStockMovementsHandler(repo))
+
+	for _, st := range []struct{ id, body string }{
+		{a.ID, `{"qty":2,"reason":"order","order_id":"` + oid + `"}`},
+		{b.ID, `{"qty":1,"reason":"order","order_id":"` + other + `"}`},
+		{b.ID, `{"qty":3,"reason":"order","order_id":"` + oid + `"}`},
+	} {
+		if w := doJSON(r, http.MethodPost, "/products/"+st.id+"/decrement", st.body); w.Code != http.StatusOK {
+			t.Fatalf("decrement: status=%d body=%s", w.Code, w.Body.String())
+		}
+	}
+
+	w := doJSON(r, http.MethodGet, "/products/stock-movements?order_id="+oid, "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var resp struct {
+		Items []product.StockMovement `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	// solo los movimientos de la orden, más viejos primero, de los dos productos
+	if len(resp.Items) != 2 || resp.Items[0].ProductID != a.ID || resp.Items[0].Delta != -2 || resp.Items[1].ProductID != b.ID || resp.Items[1].Delta != -3 {
+		t.Fatalf("movimientos: %s", w.Body.String())
+	}
+	for _, m := range resp.Items {
+		if m.OrderID != oid || m.Reason != product.ReasonOrder {
+			t.Fatalf("movimiento %+v, esperaba un movimiento de la orden %s", m, oid)
+		}
+	}
+
+	// una orden que no movió nada da una lista vacía, no 404
+	w = doJSON(r, http.MethodGet, "/products/stock-movements?order_id="+uuid.NewString(), "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"items":[]`) {
+		t.Fatalf("vacío: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodGet, "/products/stock-movements?order_id=nope", ""); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("order_id inválido status=%d", w.Code)
+	}
+}
+
+func TestGetProductByBarcode(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	r := gin.New()
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+	r.GET("/products/barcode/:code", getProductByBarcodeHandler(repo))
+
+	w := doJSON(r, http.MethodPost, "/products", `{"name":"Scanner","price":"50.00","stock":1,"barcode":"4006381333931"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodPost, "/products", `{"name":"Bad","price":"1.00","barcode":"4006381333932"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("create con barcode inválido status=%d", w.Code)
+	}
+
+	cases := []struct {
+		code string
+		want int
+	}{
+		{"4006381333931", http.StatusOK},         // válido y existente
+		{"4006381333932", http.StatusBadRequest}, // dígito verificador incorrecto
+		{"5901234123457", http.StatusNotFound},   // válido pero desconocido
+	}
+	for _, tc := range cases {
+		w := doJSON(r, http.MethodGet, "/products/barcode/"+tc.code, "")
+		if w.Code != tc.want {
+			t.Fatalf("GET %s: status=%d, esperaba %d: %s", tc.code, w.Code, tc.want, w.Body.String())
+		}
+		if tc.want == http.StatusOK && !strings.Contains(w.Body.String(), `"name":"Scanner"`) {
+			t.Fatalf("producto equivocado: %s", w.Body.String())
+		}
+	}
+}
+
+func TestProductLookups_DatabaseError(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Scanner", Price: "50.00", Stock: 1, Barcode: "4006381333931"})
+	repo.lookupErr = errors.New("connection refused")
+	r := gin.New()
+	r.GET("/products/:id", getProductHandler(repo))
+	r.GET("/products/barcode/:code", getProductByBarcodeHandler(repo))
+	r.GET("/products/:id/stock-movements", stockMovementsHandler(repo))
+	r.POST("/products/:id/notify-me", notifyMeHandler(repo))
+
+	// una base caída es un 500, no un 404 que el cliente tomaría como "no existe el producto"
+	reqs := []struct{ method, path, body string }{
+		{http.MethodGet, "/products/" + id, ""},
+		{http.MethodGet, "/products/" + id + "?include_deleted=true", ""},
+		{http.MethodGet, "/products/barcode/4006381333931", ""},
+		{http.MethodGet, "/products/" + id + "/stock-movements", ""},
+		{http.MethodPost, "/products/" + id + "/notify-me", `{"email":"a@example.com"}`},
+	}
+	for _, tc := range reqs {
+		if w := doJSON(r, tc.method, tc.path, tc.body); w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s %s: status=%d, esperaba 500: %s", tc.method, tc.path, w.Code, w.Body.String())
+		}
+	}
+
+	repo.lookupErr = nil
+	if w := doJSON(r, http.MethodGet, "/products/barcode/5901234123457", ""); w.Code != http.StatusNotFound {
+		t.Fatalf("barcode desconocido: status=%d, esperaba 404", w.Code)
+	}
+}
+
+func TestDeleteProduct_Soft(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Legacy", Price: "9.90", Stock: 1})
+
+	r := gin.New()
+	r.GET("/products/:id", getProductHandler(repo))
+	r.DELETE("/products/:id", deleteProductHandler(repo, defaultProductOptions()))
+
+	if w := doJSON(r, http.MethodDelete, "/products/"+id, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d", w.Code)
+	}
+	if w := doJSON(r, http.MethodDelete, "/products/"+id, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("segundo delete status=%d, esperaba 404", w.Code)
+	}
+	if w := doJSON(r, http.MethodGet, "/products/"+id, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get del borrado status=%d, esperaba 404", w.Code)
+	}
+	w := doJSON(r, http.MethodGet, "/products/"+id+"?include_deleted=true", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"name":"Legacy"`) || !strings.Contains(w.Body.String(), `"deleted_at"`) {
+		t.Fatalf("include_deleted status=%d body=%s", w.Code, w.Body.String())
+	}
+}
+
+func TestListProducts_CreatedRange(t *testing.T) {
+	t.Parallel()
+
+	day := func(d int) time.Time { return time.Date(2025, 3, d, 12, 0, 0, 0, time.UTC) }
+	jan, feb, mar := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	repo := newStubRepo(
+		product.Product{ID: jan, Name: "A", Price: "1.00", CreatedAt: day(1)},
+		product.Product{ID: feb, Name: "B", Price: "1.00", CreatedAt: day(10)},
+		product.Product{ID: mar, Name: "C", Price: "1.00", CreatedAt: day(20)},
+	)
+	r := gin.New()
+	r.GET("/products", listOnlyHandler(repo))
+
+	cases := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"solo from", "?created_from=2025-03-10T12:00:00Z", []string{feb, mar}}, // inclusivo
+		{"to only", "?created_to=2025-03-10T12:00:00Z", []string{jan, feb}},
+		{"both", "?created_from=2025-03-05T00:00:00Z&created_to=2025-03-15T00:00:00Z", []string{feb}},
+		{"offset en el límite", "?created_from=2025-03-10T09:00:00-03:00", []string{feb, mar}}, // 12:00 UTC
+		{"empty range", "?created_from=2025-03-11T00:00:00Z&created_to=2025-03-12T00:00:00Z", nil},
+	}
+	for _, tc := range cases {
+		w := doJSON(r, http.MethodGet, "/products"+tc.query, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", tc.name, w.Code, w.Body.String())
+		}
+		var body struct {
+			Items []product.Product `json:"items"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: json inválido: %v", tc.name, err)
+		}
+		got := map[string]bool{}
+		for _, p := range body.Items {
+			got[p.ID] = true
+		}
+		if len(got) != len(tc.want) {
+			t.Fatalf("%s: %d productos, esperaba %d", tc.name, len(got), len(tc.want))
+		}
+		for _, id := range tc.want {
+			if !got[id] {
+				t.Fatalf("%s: falta %s", tc.name, id)
+			}
+		}
+	}
+
+	for _, q := range []string{
+		"?created_from=2025-03-10", // fecha sin hora
+		"?created_to=yesterday",    // no es una fecha
+		"?created_from=2025-03-15T00:00:00Z&created_to=2025-03-05T00:00:00Z", // invertido
+	} {
+		if w := doJSON(r, http.MethodGet, "/products"+q, ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("%s: status=%d, esperaba 400", q, w.Code)
+		}
+	}
+}
+
+func TestSparseFieldsets(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Description: "Wireless", Price: "10.00", Stock: 1})
+
+	r := gin.New()
+	r.GET("/products", listOnlyHandler(repo))
+	r.GET("/products/search", searchHandler(repo))
+	r.GET("/products/:id", getProductHandler(repo))
+
+	want := map[string]bool{"id": true, "name": true, "price": true}
+	check := func(url string, obj map[string]any) {
+		t.Helper()
+		if len(obj) != len(want) {
+			t.Fatalf("%s devolvió las claves %v, esperaba solo id,name,price", url, obj)
+		}
+		for k := range obj {
+			if !want[k] {
+				t.Fatalf("%s devolvió el campo no pedido %q", url, k)
+			}
+		}
+	}
+
+	w := doJSON(r, http.MethodGet, "/products/"+id+"?fields=id,name,price", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("get status=%d body=%s", w.Code, w.Body.String())
+	}
+	var one map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &one); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	check("get", one)
+
+	for _, url := range []string{"/products?fields=id,name,price", "/products/search?q=mo&fields=id,%20name,price"} {
+		w := doJSON(r, http.MethodGet, url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", url, w.Code, w.Body.String())
+		}
+		var body struct {
+			Version string           `json:"version"`
+			Limit   int              `json:"limit"`
+			Items   []map[string]any `json:"items"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s json inválido: %v", url, err)
+		}
+		// el envelope queda intacto, solo se proyectan los items
+		if body.Version == "" || body.Limit != 20 || len(body.Items) != 1 {
+			t.Fatalf("%s body inesperado: %s", url, w.Body.String())
+		}
+		check(url, body.Items[0])
+	}
+
+	// sin ?fields vuelve todo
+	if w := doJSON(r, http.MethodGet, "/products/"+id, ""); !strings.Contains(w.Body.String(), `"description":"Wireless"`) {
+		t.Fatalf("esperaba el producto completo: %s", w.Body.String())
+	}
+
+	for _, url := range []string{"/products/" + id + "?fields=id,secret", "/products?fields=cost", "/products/search?q=mo&fields=name,Price"} {
+		w := doJSON(r, http.MethodGet, url, "")
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "unknown field") {
+			t.Fatalf("%s status=%d body=%s, esperaba 400 por campo desconocido", url, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestListEndpoints_EmptyIsArray(t *testing.T) {
+	t.Parallel()
+
+	// un producto con mucho stock, sin categoría y sin movimientos
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 50})
+
+	r := gin.New()
+	r.GET("/products", listOnlyHandler(repo))
+	r.GET("/products/search", searchHandler(repo))
+	r.GET("/products/low-stock", lowStockHandler(repo))
+	r.GET("/products/categories", categoriesHandler(repo))
+	r.GET("/products/:id/stock-movements", stockMovementsHandler(repo))
+
+	empty := newStubRepo()
+	re := gin.New()
+	re.GET("/products", listOnlyHandler(empty))
+	re.GET("/products/search", searchHandler(empty))
+
+	cases := []struct {
+		r   http.Handler
+		url string
+	}{
+		{re, "/products"},
+		{re, "/products?fields=id,name"},
+		{re, "/products/search?q=zz"},
+		{r, "/products/low-stock?threshold=5"},
+		{r, "/products/categories"},
+		{r, "/products/" + id + "/stock-movements"},
+		{r, "/products/" + id + "/stock-movements?offset=10"},
+	}
+	for _, tc := range cases {
+		w := doJSON(tc.r, http.MethodGet, tc.url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", tc.url, w.Code, w.Body.String())
+		}
+		var body map[string]json.RawMessage
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s json inválido: %v", tc.url, err)
+		}
+		if string(body["items"]) != "[]" {
+			t.Fatalf("%s items=%s, esperaba []", tc.url, body["items"])
+		}
+	}
+}
+
+func TestCategories(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	r := gin.New()
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+	r.DELETE("/products/:id", deleteProductHandler(repo, defaultProductOptions()))
+	r.GET("/products/categories", categoriesHandler(repo))
+
+	create := func(body string) string {
+		t.Helper()
+		w := doJSON(r, http.MethodPost, "/products", body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create status=%d body=%s", w.Code, w.Body.String())
+		}
+		var p product.Product
+		_ = json.Unmarshal(w.Body.Bytes(), &p)
+		return p.ID
+	}
+	create(`{"name":"Mouse","price":"10.00","category":"Peripherals"}`)
+	create(`{"name":"Keyboard","price":"20.00","category":" Peripherals "}`)
+	create(`{"name":"Cable","price":"2.00","category":"Accessories"}`)
+	create(`{"name":"Loose item","price":"1.00"}`) // sin categoría: no se lista
+	gone := create(`{"name":"Old","price":"1.00","category":"Legacy"}`)
+	moved := create(`{"name":"Hub","price":"5.00"}`)
+
+	if w := doJSON(r, http.MethodPut, "/products/"+moved, `{"stock":0,"category":"Accessories"}`); w.Code != http.StatusOK {
+		t.Fatalf("update status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodDelete, "/products/"+gone, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete status=%d", w.Code)
+	}
+
+	w := doJSON(r, http.MethodGet, "/products/categories", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var body struct {
+		Items []product.CategoryCount `json:"items"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("json inválido: %v", err)
+	}
+	want := []product.CategoryCount{{Category: "Accessories", Products: 2}, {Category: "Peripherals", Products: 2}}
+	if len(body.Items) != len(want) {
+		t.Fatalf("categorías=%+v, esperaba %+v", body.Items, want)
+	}
+	for i := range want {
+		if body.Items[i] != want[i] {
+			t.Fatalf("categorías=%+v, esperaba %+v", body.Items, want)
+		}
+	}
+}
+
+func TestCreateProduct_MalformedVsInvalid(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	r := gin.New()
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+	r.POST("/products/transfer-stock", transferStockHandler(repo, &fakeNotifier{}))
+
+	cases := []struct {
+		url, body string
+		want      int
+	}{
+		{"/products", `{"name":"Mouse",`, http.StatusBadRequest},
+		{"/products", `{"name":"Mouse","price":"10.00","stock":"many"}`, http.StatusBadRequest},
+		{"/products", `{"name":"Mouse","price":"10.00","stock":-1}`, http.StatusUnprocessableEntity},
+		{"/products", `{"price":"10.00"}`, http.StatusUnprocessableEntity},
+		{"/products", `{"name":"Mouse","price":"10.001"}`, http.StatusUnprocessableEntity},
+		{"/products", `{"name":"Mouse","price":"-1"}`, http.StatusUnprocessableEntity},
+		{"/products", `{"name":"Mouse","price":"ten"}`, http.StatusUnprocessableEntity},
+		{"/products/transfer-stock", `{"from_id":"a","to_id":"b","qty":"1"}`, http.StatusBadRequest},
+		{"/products/transfer-stock", `{"from_id":"a","to_id":"a","qty":1}`, http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		w := doJSON(r, http.MethodPost, tc.url, tc.body)
+		if w.Code != tc.want {
+			t.Fatalf("POST %s %s: status=%d, esperaba %d: %s", tc.url, tc.body, w.Code, tc.want, w.Body.String())
+		}
+	}
+	if len(repo.products) != 0 {
+		t.Fatalf("no debía crearse nada, hay %d productos", len(repo.products))
+	}
+}
+
+func TestValidatePrice(t *testing.T) {
+	t.Parallel()
+
+	r := gin.New()
+	r.POST("/products/validate-price", validatePriceHandler(defaultProductOptions()))
+	fine := defaultProductOptions()
+	fine.PriceDecimals = 4
+	r.POST("/fine/validate-price", validatePriceHandler(fine))
+
+	cases := []struct {
+		url, price string
+		want       product.PriceValidation
+	}{
+		{"/products/validate-price", `"19.9"`, product.PriceValidation{Valid: true, Normalized: "19.90"}},
+		{"/products/validate-price", `"  7 "`, product.PriceValidation{Valid: true, Normalized: "7.00"}},
+		{"/products/validate-price", `"1.230"`, product.PriceValidation{Valid: true, Normalized: "1.23"}},
+		{"/products/validate-price", `"1.234"`, product.PriceValidation{Error: "price has too many decimals (at most 2)"}},
+		{"/fine/validate-price", `"1.234"`, product.PriceValidation{Valid: true, Normalized: "1.234"}},
+		{"/products/validate-price", `"-5.00"`, product.PriceValidation{Error: "price must be >= 0"}},
+		{"/products/validate-price", `"abc"`, product.PriceValidation{Error: "price must be a number"}},
+		{"/products/validate-price", `""`, product.PriceValidation{Error: "price is required"}},
+	}
+	for _, tc := range cases {
+		w := doJSON(r, http.MethodPost, tc.url, `{"price":`+tc.price+`}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d body=%s", tc.price, w.Code, w.Body.String())
+		}
+		var got product.PriceValidation
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got != tc.want {
+			t.Fatalf("%s: %+v (%v), esperaba %+v", tc.price, got, err, tc.want)
+		}
+	}
+
+	// un precio que no es string es un body mal formado
+	if w := doJSON(r, http.MethodPost, "/products/validate-price", `{"price":19.9}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("precio numérico: status=%d, esperaba 400", w.Code)
+	}
+}
+
+func TestRestock_ReplayIsNoop(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "Mouse", Price: "10.00", Stock: 0}
+	repo := newStubRepo(a)
+	repo.subs[a.ID] = []product.RestockSubscription{{ProductID: a.ID, Email: "x@test.com"}}
+	notifier := &fakeNotifier{}
+	r := gin.New()
+	r.POST("/products/:id/restock", restockHandler(repo, notifier))
+
+	orderID := uuid.NewString()
+	body := fmt.Sprintf(`{"order_id":%q,"qty":3}`, orderID)
+	for i, want := range []string{`"applied":true`, `"applied":false`, `"applied":false`} {
+		w := doJSON(r, http.MethodPost, "/products/"+a.ID+"/restock", body)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) || !strings.Contains(w.Body.String(), `"stock":3`) {
+			t.Fatalf("llamada %d: status=%d body=%s, esperaba %s y stock 3", i+1, w.Code, w.Body.String(), want)
+		}
+	}
+	if len(repo.movements) != 1 || repo.movements[0].Reason != product.ReasonCancel || repo.movements[0].OrderID != orderID {
+		t.Fatalf("movimientos=%+v, esperaba un único cancel de la orden", repo.movements)
+	}
+	if notifier.calls != 1 {
+		t.Fatalf("notificaciones de reposición=%d, esperaba 1", notifier.calls)
+	}
+	if len(notifier.availability) != 1 || !notifier.availability[0].available {
+		t.Fatalf("eventos de disponibilidad=%+v, esperaba uno (0 -> 3) y ninguno en los reintentos", notifier.availability)
+	}
+
+	// otra orden repone el mismo producto por separado
+	if w := doJSON(r, http.MethodPost, "/products/"+a.ID+"/restock", fmt.Sprintf(`{"order_id":%q,"qty":2}`, uuid.NewString())); !strings.Contains(w.Body.String(), `"stock":5`) {
+		t.Fatalf("segunda orden: %s", w.Body.String())
+	}
+
+	cases := []struct {
+		url, body string
+		want      int
+	}{
+		{"/products/" + a.ID + "/restock", `{"order_id":"nope","qty":1}`, http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/restock", fmt.Sprintf(`{"order_id":%q,"qty":0}`, orderID), http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/restock", fmt.Sprintf(`{"order_id":%q,"qty":1,"reason":"order"}`, orderID), http.StatusUnprocessableEntity},
+		{"/products/" + uuid.NewString() + "/restock", fmt.Sprintf(`{"order_id":%q,"qty":1}`, orderID), http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if w := doJSON(r, http.MethodPost, tc.url, tc.body); w.Code != tc.want {
+			t.Fatalf("%s %s: status=%d, esperaba %d", tc.url, tc.body, w.Code, tc.want)
+		}
+	}
+}
+
+func TestStockDelta_DecrementIncrement(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "Mouse", Price: "10.00", Stock: 2}
+	repo := newStubRepo(a)
+	repo.subs[a.ID] = []product.RestockSubscription{{ProductID: a.ID, Email: "x@test.com"}}
+	notifier := &fakeNotifier{}
+	r := gin.New()
+	r.POST("/products/:id/decrement", decrementStockHandler(repo, notifier))
+	r.POST("/products/:id/increment", incrementStockHandler(repo, notifier))
+
+	orderID := uuid.NewString()
+	w := doJSON(r, http.MethodPost, "/products/"+a.ID+"/decrement", fmt.Sprintf(`{"qty":2,"order_id":%q}`, orderID))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"stock":0`) {
+		t.Fatalf("decrement: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(repo.movements) != 1 || repo.movements[0].Delta != -2 || repo.movements[0].Reason != product.ReasonOrder || repo.movements[0].OrderID != orderID {
+		t.Fatalf("movimientos=%+v, esperaba -2 de la orden", repo.movements)
+	}
+
+	// no queda nada: 409 con lo disponible y el stock intacto
+	w = doJSON(r, http.MethodPost, "/products/"+a.ID+"/decrement", `{"qty":1}`)
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), `"insufficient stock"`) || !strings.Contains(w.Body.String(), `"available":0`) {
+		t.Fatalf("sobreventa: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if repo.products[a.ID].Stock != 0 {
+		t.Fatalf("stock=%d tras un descuento rechazado", repo.products[a.ID].Stock)
+	}
+
+	// devolver unidades desde cero avisa a los suscriptos
+	w = doJSON(r, http.MethodPost, "/products/"+a.ID+"/increment", fmt.Sprintf(`{"qty":1,"reason":"cancel","order_id":%q}`, orderID))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"stock":1`) {
+		t.Fatalf("increment: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if notifier.calls != 1 {
+		t.Fatalf("notificaciones de reposición=%d, esperaba 1", notifier.calls)
+	}
+	if len(notifier.availability) != 2 || notifier.availability[0].available || !notifier.availability[1].available {
+		t.Fatalf("eventos de disponibilidad=%+v, esperaba 2 -> 0 y 0 -> 1", notifier.availability)
+	}
+
+	// por línea de orden: repetir un descuento ya aplicado es un 200 sin efecto
+	line := fmt.Sprintf(`{"qty":1,"order_id":%q,"item_id":%q}`, orderID, uuid.NewString())
+	for i, want := range []string{`"applied":true`, `"applied":false`} {
+		w = doJSON(r, http.MethodPost, "/products/"+a.ID+"/decrement", line)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), want) || !strings.Contains(w.Body.String(), `"stock":0`) {
+			t.Fatalf("descuento de línea %d: status=%d body=%s, esperaba %s", i, w.Code, w.Body.String(), want)
+		}
+	}
+	if len(repo.movements) != 3 {
+		t.Fatalf("movimientos=%+v, esperaba que el reintento no registre nada", repo.movements)
+	}
+
+	cases := []struct {
+		url, body string
+		want      int
+	}{
+		{"/products/" + uuid.NewString() + "/decrement", `{"qty":1}`, http.StatusNotFound},
+		{"/products/" + uuid.NewString() + "/increment", `{"qty":1}`, http.StatusNotFound},
+		{"/products/" + a.ID + "/decrement", `{"qty":0}`, http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/increment", `{"qty":-1}`, http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/decrement", `{"qty":1,"reason":"recalc"}`, http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/decrement", `{"qty":1,"order_id":"nope"}`, http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/decrement", fmt.Sprintf(`{"qty":1,"order_id":%q,"item_id":"nope"}`, orderID), http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/decrement", fmt.Sprintf(`{"qty":1,"item_id":%q}`, uuid.NewString()), http.StatusUnprocessableEntity},
+		{"/products/" + a.ID + "/increment", fmt.Sprintf(`{"qty":1,"order_id":%q,"item_id":%q}`, orderID, uuid.NewString()), http.StatusUnprocessableEntity},
+	}
+	for _, tc := range cases {
+		if w := doJSON(r, http.MethodPost, tc.url, tc.body); w.Code != tc.want {
+			t.Fatalf("POST %s %s: status=%d, esperaba %d", tc.url, tc.body, w.Code, tc.want)
+		}
+	}
+}
+
+func TestRecalcStock(t *testing.T) {
+	t.Parallel()
+
+	a := product.Product{ID: uuid.NewString(), Name: "Mouse", Price: "10.00", Stock: 0}
+	repo := newStubRepo(a)
+	repo.subs[a.ID] = []product.RestockSubscription{{ProductID: a.ID, Email: "x@test.com"}}
+	notifier := &fakeNotifier{}
+
+	// order-service: 10 unidades de a retenidas por órdenes activas (las canceladas ya no cuentan)
+	var gotTenant string
+	osrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotTenant = r.Header.Get(tenant.Header)
+		if r.URL.Path != "/orders/products/"+a.ID+"/active-quantity" {
+			http.Error(w, "boom", http.StatusInternalServerError)
+			return
+		}
+		_, _ = io.WriteString(w, fmt.Sprintf(`{"product_id":%q,"quantity":10}`, a.ID))
+	}))
+	defer osrv.Close()
+
+	r := gin.New()
+	r.Use(httpx.Tenant(false))
+	r.POST("/products/:id/recalc-stock", recalcStockHandler(repo, product.NewOrderClient(osrv.URL), notifier))
+
+	w := doJSON(r, http.MethodPost, "/products/"+a.ID+"/recalc-stock?initial=25", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"stock":15`) || !strings.Contains(w.Body.String(), `"previous_stock":0`) {
+		t.Fatalf("status=%d body=%s, esperaba stock 15 (25 - 10)", w.Code, w.Body.String())
+	}
+	if repo.products[a.ID].Stock != 15 {
+		t.Fatalf("stock guardado=%d, esperaba 15", repo.products[a.ID].Stock)
+	}
+	if len(repo.movements) != 1 || repo.movements[0].Reason != product.ReasonRecalc || repo.movements[0].Delta != 15 {
+		t.Fatalf("movimientos=%+v, esperaba un único recalc de +15", repo.movements)
+	}
+	if gotTenant != tenant.Default {
+		t.Fatalf("tenant reenviado=%q, esperaba %q", gotTenant, tenant.Default)
+	}
+	if notifier.calls != 1 {
+		t.Fatalf("notificaciones de reposición=%d, esperaba 1 (0 -> 15)", notifier.calls)
+	}
+
+	// mismo resultado otra vez: ningún movimiento nuevo
+	if w := doJSON(r, http.MethodPost, "/products/"+a.ID+"/recalc-stock?initial=25", ""); w.Code != http.StatusOK || len(repo.movements) != 1 {
+		t.Fatalf("re-corrida: status=%d movimientos=%d", w.Code, len(repo.movements))
+	}
+
+	cases := []struct {
+		url  string
+		want int
+	}{
+		{"/products/" + a.ID + "/recalc-stock?initial=9", http.StatusConflict}, // menos que las 10 retenidas
+		{"/products/" + a.ID + "/recalc-stock?initial=-1", http.StatusBadRequest},
+		{"/products/" + a.ID + "/recalc-stock", http.StatusBadRequest},
+		{"/products/" + uuid.NewString() + "/recalc-stock?initial=5", http.StatusNotFound},
+	}
+	for _, tc := range cases {
+		if w := doJSON(r, http.MethodPost, tc.url, ""); w.Code != tc.want {
+			t.Fatalf("%s: status=%d body=%s, esperaba %d", tc.url, w.Code, w.Body.String(), tc.want)
+		}
+	}
+	if repo.products[a.ID].Stock != 15 {
+		t.Fatalf("una llamada rechazada cambió el stock: %d", repo.products[a.ID].Stock)
+	}
+
+	// order-service falla -> 502 y el stock intacto
+	b := product.Product{ID: uuid.NewString(), Name: "Pad", Price: "5.00", Stock: 4}
+	repo.products[b.ID] = &b
+	if w := doJSON(r, http.MethodPost, "/products/"+b.ID+"/recalc-stock?initial=5", ""); w.Code != http.StatusBadGateway || repo.products[b.ID].Stock != 4 {
+		t.Fatalf("order-service caído: status=%d stock=%d", w.Code, repo.products[b.ID].Stock)
+	}
+}
+
+func TestBulkPriceAdjust(t *testing.T) {
+	t.Parallel()
+
+	kb1 := product.Product{ID: uuid.NewString(), Name: "Keyboard 60%", Price: "199.90", Category: "Keyboards"}
+	kb2 := product.Product{ID: uuid.NewString(), Name: "Keyboard TKL", Price: "50.00", Category: "Keyboards"}
+	gone := product.Product{ID: uuid.NewString(), Name: "Old keyboard", Price: "30.00", Category: "Keyboards", DeletedAt: &time.Time{}}
+	mouse := product.Product{ID: uuid.NewString(), Name: "Mouse", Price: "20.00", Category: "Mice"}
+	repo := newStubRepo(kb1, kb2, gone, mouse)
+
+	r := gin.New()
+	r.Use(httpx.Tenant(false))
+	r.POST("/products/bulk-price-adjust", bulkPriceAdjustHandler(repo))
+
+	w := doJSON(r, http.MethodPost, "/products/bulk-price-adjust", `{"category":" Keyboards ","percent":"-10"}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"updated":2`) {
+		t.Fatalf("status=%d body=%s, esperaba 2 actualizados", w.Code, w.Body.String())
+	}
+	want := map[string]string{kb1.ID: "179.91", kb2.ID: "45.00", gone.ID: "30.00", mouse.ID: "20.00"}
+	for id, price := range want {
+		if got := repo.products[id].Price; got != price {
+			t.Fatalf("%s price=%s, esperaba %s", repo.products[id].Name, got, price)
+		}
+	}
+	if len(repo.prices) != 2 {
+		t.Fatalf("historial de precios=%+v, esperaba 2 filas", repo.prices)
+	}
+	for _, h := range repo.prices {
+		if h.ProductID == kb1.ID && (h.OldPrice != "199.90" || h.NewPrice != "179.91") {
+			t.Fatalf("fila del historial=%+v, esperaba 199.90 -> 179.91", h)
+		}
+	}
+
+	// ningún producto en la categoría: no cambia nada
+	if w := doJSON(r, http.MethodPost, "/products/bulk-price-adjust", `{"category":"Monitors","percent":"5"}`); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"updated":0`) {
+		t.Fatalf("categoría vacía: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	for _, body := range []string{
+		`{"category":"Keyboards","percent":"0"}`,
+		`{"category":"Keyboards","percent":"-100"}`,
+		`{"category":"Keyboards","percent":"101"}`,
+		`{"category":"Keyboards","percent":"ten"}`,
+		`{"category":"","percent":"10"}`,
+	} {
+		if w := doJSON(r, http.MethodPost, "/products/bulk-price-adjust", body); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: status=%d, esperaba 422", body, w.Code)
+		}
+	}
+	if len(repo.prices) != 2 || repo.products[kb1.ID].Price != "179.91" {
+		t.Fatalf("llamadas rechazadas cambiaron precios: historial=%d price=%s", len(repo.prices), repo.products[kb1.ID].Price)
+	}
+}
+
+func TestProductStatus(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	r := gin.New()
+	r.Use(httpx.Tenant(false))
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+
+	var created product.Product
+	w := doJSON(r, http.MethodPost, "/products", `{"name":"Mouse","price":"10.00","stock":3}`)
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.Status != product.StatusActive {
+		t.Fatalf("status=%d body=%s, esperaba active por defecto", w.Code, w.Body.String())
+	}
+	w = doJSON(r, http.MethodPost, "/products", `{"name":"Old mouse","price":"10.00","stock":3,"status":" Discontinued "}`)
+	if w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"status":"discontinued"`) {
+		t.Fatalf("status=%d body=%s, esperaba discontinued", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodPost, "/products", `{"name":"Mouse","price":"10.00","status":"gone"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status inválido en create: %d", w.Code)
+	}
+
+	// independiente del stock: 3 unidades pero sin disponibilidad temporal
+	w = doJSON(r, http.MethodPut, "/products/"+created.ID, `{"stock":3,"status":"out_of_stock"}`)
+	if w.Code != http.StatusOK || repo.products[created.ID].Status != product.StatusOutOfStock || repo.products[created.ID].Stock != 3 {
+		t.Fatalf("status=%d body=%s, esperaba out_of_stock con stock 3", w.Code, w.Body.String())
+	}
+	// omitido: sin cambios
+	if w := doJSON(r, http.MethodPut, "/products/"+created.ID, `{"stock":3}`); w.Code != http.StatusOK || repo.products[created.ID].Status != product.StatusOutOfStock {
+		t.Fatalf("el status cambió sin enviarse: %s", repo.products[created.ID].Status)
+	}
+	if w := doJSON(r, http.MethodPut, "/products/"+created.ID, `{"stock":3,"status":"paused"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status inválido en update: %d", w.Code)
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	newRouter := func(required bool) *gin.Engine {
+		r := gin.New()
+		r.Use(httpx.Tenant(required))
+		r.GET("/products", listOnlyHandler(repo))
+		r.GET("/products/:id", getProductHandler(repo))
+		r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+		return r
+	}
+	r := newRouter(true)
+	as := func(tenantID, method, url, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(method, url, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		if tenantID != "" {
+			req.Header.Set(tenant.Header, tenantID)
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := as("store-a", http.MethodPost, "/products", `{"name":"Mouse","price":"10.00","stock":1}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create: status=%d body=%s", w.Code, w.Body.String())
+	}
+	var created product.Product
+	_ = json.Unmarshal(w.Body.Bytes(), &created)
+
+	if w := as("store-a", http.MethodGet, "/products/"+created.ID, ""); w.Code != http.StatusOK {
+		t.Fatalf("get del tenant A: status=%d", w.Code)
+	}
+	if w := as("store-b", http.MethodGet, "/products/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get del tenant B: status=%d, esperaba 404", w.Code)
+	}
+	if w := as("store-b", http.MethodGet, "/products", ""); strings.Contains(w.Body.String(), created.ID) {
+		t.Fatalf("el listado del tenant B filtra el producto del tenant A: %s", w.Body.String())
+	}
+
+	// MULTI_TENANT=true: sin un tenant válido se rechaza
+	for _, id := range []string{"", "Not a tenant!"} {
+		if w := as(id, http.MethodGet, "/products", ""); w.Code != http.StatusBadRequest {
+			t.Fatalf("tenant %q: status=%d, esperaba 400", id, w.Code)
+		}
+	}
+
+	// deshabilitado: sin header se usa el tenant por defecto, que no ve el producto de A
+	r = newRouter(false)
+	if w := as("", http.MethodGet, "/products/"+created.ID, ""); w.Code != http.StatusNotFound {
+		t.Fatalf("get del tenant por defecto: status=%d, esperaba 404", w.Code)
+	}
+}
+
+func TestUpdateProduct_NotFound(t *testing.T) {
+	t.Parallel()
+
+	deleted := time.Now()
+	gone := product.Product{ID: uuid.NewString(), Name: "Old", Price: "1.00", DeletedAt: &deleted}
+	repo := newStubRepo(gone)
+	r := gin.New()
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+
+	for _, id := range []string{uuid.NewString(), gone.ID} {
+		w := doJSON(r, http.MethodPut, "/products/"+id, `{"name":"New","stock":3}`)
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("PUT %s: status=%d body=%s, esperaba 404", id, w.Code, w.Body.String())
+		}
+	}
+	if len(repo.movements) != 0 {
+		t.Fatalf("movimientos=%+v, esperaba ninguno", repo.movements)
+	}
+}
+
+func TestUpdateProduct_IfMatch(t *testing.T) {
+	t.Parallel()
+
+	p := product.Product{ID: uuid.NewString(), Name: "Lamp", Price: "10.00", Stock: 2, UpdatedAt: time.Now().Truncate(time.Microsecond)}
+	repo := newStubRepo(p)
+	r := gin.New()
+	r.GET("/products/:id", getProductHandler(repo))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+
+	w := doJSON(r, http.MethodGet, "/products/"+p.ID, "")
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || etag == "" {
+		t.Fatalf("GET: status=%d etag=%q, esperaba 200 con ETag", w.Code, etag)
+	}
+
+	put := func(ifMatch, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/products/"+p.ID, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", ifMatch)
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// versión coincidente: se aplica y la respuesta trae el ETag nuevo
+	w = put(etag, `{"name":"Desk lamp","stock":2}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("PUT coincidente: status=%d body=%s, esperaba 200", w.Code, w.Body.String())
+	}
+	next := w.Header().Get("ETag")
+	if next == "" || next == etag {
+		t.Fatalf("ETag tras el update=%q, esperaba uno nuevo (era %q)", next, etag)
+	}
+
+	// versión vieja (y una mal formada): se rechaza y no cambia nada
+	for _, tag := range []string{etag, "garbage"} {
+		w = put(tag, `{"name":"Floor lamp","stock":9}`)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("PUT If-Match=%s: status=%d body=%s, esperaba 412", tag, w.Code, w.Body.String())
+		}
+	}
+	if got := repo.products[p.ID]; got.Name != "Desk lamp" || got.Stock != 2 {
+		t.Fatalf("producto=%+v, esperaba solo el primer update", got)
+	}
+	if len(repo.movements) != 0 {
+		t.Fatalf("movimientos=%+v, esperaba ninguno", repo.movements)
+	}
+
+	// "*" coincide con cualquier versión actual
+	if w = put("*", `{"name":"Floor lamp","stock":2}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT If-Match=*: status=%d body=%s, esperaba 200", w.Code, w.Body.String())
+	}
+}
+
+func TestSearch_Total(t *testing.T) {
+	t.Parallel()
+
+	var ps []product.Product
+	for i := 0; i < 5; i++ {
+		ps = append(ps, product.Product{ID: uuid.NewString(), Name: fmt.Sprintf("Cable %d", i), Price: "1.00"})
+	}
+	ps = append(ps, product.Product{ID: uuid.NewString(), Name: "Mouse", Description: "USB cable included", Price: "9.00"})
+	ps = append(ps, product.Product{ID: uuid.NewString(), Name: "Monitor", Price: "99.00"})
+	r := gin.New()
+	r.GET("/products/search", searchHandler(newStubRepo(ps...)))
+
+	cases := []struct {
+		url   string
+		items int
+		total int
+	}{
+		{"/products/search?q=cable&limit=2", 2, 6},
+		{"/products/search?q=cable&limit=4&offset=4", 2, 6},
+		{"/products/search?q=cable&offset=10", 0, 6},
+		{"/products/search?q=keyboard", 0, 0},
+	}
+	for _, tc := range cases {
+		w := doJSON(r, http.MethodGet, tc.url, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("GET %s: status=%d body=%s", tc.url, w.Code, w.Body.String())
+		}
+		var body struct {
+			Items []product.Product `json:"items"`
+			Total *int              `json:"total"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("GET %s: json inválido: %v", tc.url, err)
+		}
+		if len(body.Items) != tc.items || body.Total == nil || *body.Total != tc.total {
+			t.Fatalf("GET %s: items=%d total=%v, esperaba items=%d total=%d", tc.url, len(body.Items), body.Total, tc.items, tc.total)
+		}
+	}
+}
+
+func TestUpdateProduct_Backorder(t *testing.T) {
+	t.Parallel()
+
+	back := product.Product{ID: uuid.NewString(), Name: "Preorder", Price: "5.00", Stock: 1, AllowBackorder: true}
+	strict := product.Product{ID: uuid.NewString(), Name: "Regular", Price: "5.00", Stock: 1}
+	repo := newStubRepo(back, strict)
+	r := gin.New()
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+
+	// pedidos por encima del stock: negativo solo con backorder
+	if w := doJSON(r, http.MethodPut, "/products/"+back.ID, `{"stock":-2,"stock_reason":"order"}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT con backorder: status=%d body=%s, esperaba 200", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodPut, "/products/"+strict.ID, `{"stock":-2,"stock_reason":"order"}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PUT estricto: status=%d body=%s, esperaba 422", w.Code, w.Body.String())
+	}
+	if repo.products[back.ID].Stock != -2 || repo.products[strict.ID].Stock != 1 {
+		t.Fatalf("stock back=%d strict=%d, esperaba -2 y 1", repo.products[back.ID].Stock, repo.products[strict.ID].Stock)
+	}
+	// omitir allow_backorder lo conserva; el mismo update puede activarlo
+	if !repo.products[back.ID].AllowBackorder {
+		t.Fatalf("un update que no mandó allow_backorder lo borró")
+	}
+	if w := doJSON(r, http.MethodPut, "/products/"+strict.ID, `{"stock":-1,"allow_backorder":true}`); w.Code != http.StatusOK {
+		t.Fatalf("PUT que lo activa: status=%d body=%s, esperaba 200", w.Code, w.Body.String())
+	}
+	// desactivarlo se valida contra el stock del mismo update; con 422 no cambia nada
+	if w := doJSON(r, http.MethodPut, "/products/"+back.ID, `{"stock":-3,"allow_backorder":false}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("PUT que lo desactiva: status=%d body=%s, esperaba 422", w.Code, w.Body.String())
+	}
+	if p := repo.products[back.ID]; !p.AllowBackorder || p.Stock != -2 {
+		t.Fatalf("tras un PUT rechazado: allow_backorder=%v stock=%d, esperaba true y -2", p.AllowBackorder, p.Stock)
+	}
+}
+
+func TestProductWrites_ReadFromPrimary(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 0})
+	repo.subs[id] = []product.RestockSubscription{{ProductID: id, Email: "a@test.com"}}
+	// la réplica se quedó con el producto tal como estaba antes de estas escrituras
+	repo.replica = map[string]*product.Product{id: {ID: id, Name: "Mouse", Price: "10.00", Stock: 0}}
+	n := &fakeNotifier{}
+	r := gin.New()
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+	r.PUT("/products/:id", updateProductHandler(repo, n, defaultProductOptions()))
+
+	w := doJSON(r, http.MethodPost, "/products", `{"name":"Teclado","price":"20.00","stock":3}`)
+	var created product.Product
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil || created.ID == "" || created.Name != "Teclado" {
+		t.Fatalf("create: status=%d body=%s, esperaba 201 con el producto creado", w.Code, w.Body.String())
+	}
+
+	w = doJSON(r, http.MethodPut, "/products/"+id, `{"name":"Mouse Pro","stock":5}`)
+	var updated product.Product
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &updated) != nil || updated.Name != "Mouse Pro" || updated.Stock != 5 {
+		t.Fatalf("update: status=%d body=%s, esperaba 200 con los datos nuevos", w.Code, w.Body.String())
+	}
+	// el 0 -> 5 se detecta aunque la réplica siga con stock 0
+	if n.calls != 1 || len(n.sent) != 1 {
+		t.Fatalf("notificaciones de reposición=%d (%d suscripciones), esperaba 1", n.calls, len(n.sent))
+	}
+}
+
+func TestAudit_ProductWrites(t *testing.T) {
+	t.Parallel()
+
+	rec := &fakeAudit{}
+	opts := defaultProductOptions()
+	opts.Audit = rec
+	repo := newStubRepo()
+	r := gin.New()
+	r.Use(httpx.AuditActor())
+	r.POST("/products", createProductHandler(repo, opts))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, opts))
+	r.DELETE("/products/:id", deleteProductHandler(repo, opts))
+
+	w := doJSON(r, http.MethodPost, "/products", `{"name":"Lamp","price":"10.00","stock":2}`)
+	var created product.Product
+	if w.Code != http.StatusCreated || json.Unmarshal(w.Body.Bytes(), &created) != nil {
+		t.Fatalf("create: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(rec.entries) != 1 {
+		t.Fatalf("entradas=%d tras create, esperaba 1", len(rec.entries))
+	}
+	e := rec.entries[0]
+	detail, _ := e.Detail.(map[string]audit.Change)
+	// las solicitudes de httptest vienen de 192.0.2.1
+	if e.Action != audit.ActionCreate || e.ResourceType != audit.ResourceProduct || e.ResourceID != created.ID ||
+		e.Actor != "ip:192.0.2.1" || detail["name"].To != "Lamp" || detail["price"].To != "10.00" {
+		t.Fatalf("entrada de create=%+v", e)
+	}
+
+	// un update registra solo lo que cambió
+	if w := doJSON(r, http.MethodPut, "/products/"+created.ID, `{"price":"12.50","stock":2}`); w.Code != http.StatusOK {
+		t.Fatalf("update: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if w := doJSON(r, http.MethodDelete, "/products/"+created.ID, ""); w.Code != http.StatusNoContent {
+		t.Fatalf("delete: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if len(rec.entries) != 3 {
+		t.Fatalf("entradas=%+v, esperaba create, update y delete", rec.entries)
+	}
+	upd, _ := rec.entries[1].Detail.(map[string]audit.Change)
+	if rec.entries[1].Action != audit.ActionUpdate || len(upd) != 1 || upd["price"] != (audit.Change{From: "10.00", To: "12.50"}) {
+		t.Fatalf("entrada de update=%+v", rec.entries[1])
+	}
+	if rec.entries[2].Action != audit.ActionDelete || rec.entries[2].ResourceID != created.ID {
+		t.Fatalf("entrada de delete=%+v", rec.entries[2])
+	}
+}
+
+func TestAPIInfo(t *testing.T) {
+	t.Parallel()
+
+	repo := newStubRepo()
+	r := gin.New()
+	r.GET("/api-info", httpx.APIInfo(r, "product-service"))
+	r.GET("/products", listOnlyHandler(repo))
+	r.GET("/products/:id", getProductHandler(repo))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+
+	w := doJSON(r, http.MethodGet, "/api-info", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	var info struct {
+		Service    string           `json:"service"`
+		APIVersion string           `json:"api_version"`
+		Endpoints  []httpx.Endpoint `json:"endpoints"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Service != "product-service" || info.APIVersion != httpx.Version {
+		t.Fatalf("service=%q api_version=%q", info.Service, info.APIVersion)
+	}
+	seen := map[string]bool{}
+	for _, e := range info.Endpoints {
+		seen[e.Method+" "+e.Path] = true
+	}
+	// las rutas registradas después de /api-info también se listan
+	for _, want := range []string{"GET /api-info", "GET /products", "GET /products/:id", "PUT /products/:id"} {
+		if !seen[want] {
+			t.Fatalf("falta %s en %+v", want, info.Endpoints)
+		}
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 1})
+
+	// mismo orden de registro que main: headers, después swagger, después la API
+	r := gin.New()
+	r.Use(httpx.SecurityHeaders(httpx.WithFrameOptions("SAMEORIGIN")))
+	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/products/:id", getProductHandler(repo))
+
+	for _, path := range []string{"/products/" + id, "/docs/index.html", "/docs/doc.json"} {
+		w := doJSON(r, http.MethodGet, path, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status=%d", path, w.Code)
+		}
+		h := w.Header()
+		if h.Get("X-Content-Type-Options") != "nosniff" {
+			t.Fatalf("%s: X-Content-Type-Options=%q", path, h.Get("X-Content-Type-Options"))
+		}
+		if h.Get("X-Frame-Options") != "SAMEORIGIN" {
+			t.Fatalf("%s: X-Frame-Options=%q", path, h.Get("X-Frame-Options"))
+		}
+		if h.Get("Content-Security-Policy") != httpx.DefaultContentSecurityPolicy {
+			t.Fatalf("%s: Content-Security-Policy=%q", path, h.Get("Content-Security-Policy"))
+		}
+	}
+}
+
+func TestProductDescription_MaxLen(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 1})
+	opts := defaultProductOptions()
+	opts.MaxDescriptionLen = 10
+	r := gin.New()
+	r.POST("/products", createProductHandler(repo, opts))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, opts))
+
+	body := func(desc string) string {
+		b, _ := json.Marshal(map[string]any{"name": "Pad", "price": "5.00", "description": desc})
+		return string(b)
+	}
+	cases := []struct {
+		name, desc string
+		want       int
+	}{
+		{"ascii at limit", strings.Repeat("a", 10), http.StatusCreated},
+		{"ascii above", strings.Repeat("a", 11), http.StatusUnprocessableEntity},
+		// 10 runas pero 20 bytes: se cuentan caracteres, no bytes
+		{"multibyte at limit", strings.Repeat("ñ", 10), http.StatusCreated},
+		{"multibyte above", strings.Repeat("ñ", 11), http.StatusUnprocessableEntity},
+		{"emoji at limit", strings.Repeat("🛒", 10), http.StatusCreated},
+	}
+	for _, tc := range cases {
+		if w := doJSON(r, http.MethodPost, "/products", body(tc.desc)); w.Code != tc.want {
+			t.Fatalf("create %s: status=%d body=%s, esperaba %d", tc.name, w.Code, w.Body.String(), tc.want)
+		}
+		want := tc.want
+		if want == http.StatusCreated {
+			want = http.StatusOK
+		}
+		if w := doJSON(r, http.MethodPut, "/products/"+id, body(tc.desc)); w.Code != want {
+			t.Fatalf("update %s: status=%d body=%s, esperaba %d", tc.name, w.Code, w.Body.String(), want)
+		}
+	}
+}
+
+func TestCacheControl(t *testing.T) {
+	t.Parallel()
+
+	id := uuid.NewString()
+	repo := newStubRepo(product.Product{ID: id, Name: "Mouse", Price: "10.00", Stock: 1})
+
+	// mismo registro que main
+	r := gin.New()
+	r.Use(httpx.JSONCase(), httpx.NoStore())
+	r.GET("/products", httpx.PublicCache(120), listOnlyHandler(repo))
+	r.GET("/products/:id", httpx.PublicCache(120), getProductHandler(repo))
+	r.PUT("/products/:id", updateProductHandler(repo, &fakeNotifier{}, defaultProductOptions()))
+	r.POST("/products", createProductHandler(repo, defaultProductOptions()))
+
+	for _, path := range []string{"/products", "/products/" + id, "/products/" + id + "?case=camel"} {
+		w := doJSON(r, http.MethodGet, path, "")
+		if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "public, max-age=120" {
+			t.Fatalf("GET %s: status=%d Cache-Control=%q", path, w.Code, w.Header().Get("Cache-Control"))
+		}
+		if w.Header().Get("Vary") != tenant.Header+", Accept" {
+			t.Fatalf("GET %s: Vary=%q, esperaba %s, Accept", path, w.Header().Get("Vary"), tenant.Header)
+		}
+	}
+
+	// misma URL, claves camelCase por Accept: un cache que solo mire la URL mezclaría las dos
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/products/"+id, nil)
+	req.Header.Set("Accept", "application/json; case=camel")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"allowBackorder"`) {
+		t.Fatalf("GET camel: status=%d body=%s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(w.Header().Get("Vary"), "Accept") {
+		t.Fatalf("GET camel: Vary=%q, esperaba que incluya Accept", w.Header().Get("Vary"))
+	}
+
+	// los errores no se cachean
+	if w := doJSON(r, http.MethodGet, "/products/"+uuid.NewString(), ""); w.Code != http.StatusNotFound || w.Header().Get("Cache-Control") != "" {
+		t.Fatalf("404: status=%d Cache-Control=%q", w.Code, w.Header().Get("Cache-Control"))
+	}
+
+	// las lecturas autenticadas nunca son públicas
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/products/"+id, nil)
+	req.Header.Set("Authorization", "Bearer tok")
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK || w.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("GET autenticado: Cache-Control=%q, esperaba no-store", w.Header().Get("Cache-Control"))
+	}
+
+	// escrituras
+	writes := []struct{ method, path, body string }{
+		{http.MethodPut, "/products/" + id, `{"name":"Mouse 2"}`},
+		{http.MethodPost, "/products", `{"name":"Pad","price":"5.00","stock":1}`},
+	}
+	for _, tc := range writes {
+		w := doJSON(r, tc.method, tc.path, tc.body)
+		if w.Code >= 300 || w.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("%s %s: status=%d Cache-Control=%q, esperaba no-store", tc.method, tc.path, w.Code, w.Header().Get("Cache-Control"))
+		}
+	}
+
+	// deshabilitado (PRODUCT_CACHE_MAX_AGE=0): sin header en las lecturas
+	r0 := gin.New()
+	r0.Use(httpx.NoStore())
+	r0.GET("/products/:id", httpx.PublicCache(0), getProductHandler(repo))
+	if w := doJSON(r0, http.MethodGet, "/products/"+id, ""); w.Header().Get("Cache-Control") != "" {
+		t.Fatalf("deshabilitado: Cache-Control=%q", w.Header().Get("Cache-Control"))
+	}
+}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+	log.SetOutput(io.Discard)
+}
@@ -7,22 +7,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	_ "github.com/MikeMC777/ordenes-ecom/docs"
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
 	"github.com/MikeMC777/ordenes-ecom/internal/config"
 	"github.com/MikeMC777/ordenes-ecom/internal/httpx"
 	"github.com/MikeMC777/ordenes-ecom/internal/product"
+	"github.com/MikeMC777/ordenes-ecom/internal/ratelimit"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
@@ -33,11 +39,19 @@ import (
 // @Tags         products
 // @Param        limit   query     int     false  "Limit (1-100)"  minimum(1) maximum(100) default(20)
 // @Param        offset  query     int     false  "Offset (>=0)"   minimum(0) default(0)
+// @Param        fields  query     string  false  "Only return these fields (e.g. id,name,price)"
+// @Param        created_from  query  string  false  "Only products created at or after this RFC3339 time"
+// @Param        created_to    query  string  false  "Only products created at or before this RFC3339 time"
 // @Success      200     {object}  product.ListResponse
+// @Failure      400     {object}  product.HTTPError
 // @Failure      500     {object}  product.HTTPError
 // @Router       /products [get]
 func listOnlyHandler(repo product.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		fields, ok := productFieldset(c)
+		if !ok {
+			return
+		}
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 		if limit <= 0 || limit > 100 {
 			limit = 20
@@ -47,23 +61,35 @@ func listOnlyHandler(repo product.Repository) gin.HandlerFunc {
 			offset = 0
 		}
 
-		// Empty search force: pagination only
-		items, err := repo.List(c.Request.Context(), product.Query{Q: "", Limit: limit, Offset: offset})
+		from, to, err := product.ParseCreatedRange(c.Query("created_from"), c.Query("created_to"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Empty search force: pagination only (optionally within a created_at range)
+		items, err := repo.List(c.Request.Context(), product.Query{Q: "", Limit: limit, Offset: offset, CreatedFrom: from, CreatedTo: to})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "list error"})
+			return
+		}
+		out, err := httpx.Project(items, fields)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "list error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"limit": limit, "offset": offset, "items": items})
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"limit": limit, "offset": offset, "items": out}))
 	}
 }
 
 // searchHandler godoc
 // @Summary      Search products (pagination + query)
-// @Description  Returns a paginated list filtered by 'q' on name/description (ILIKE).
+// @Description  Returns a paginated list filtered by 'q' on name/description (ILIKE; accent-insensitive when PRODUCT_SEARCH_MODE=unaccent). 'total' is the full match count, regardless of limit/offset.
 // @Tags         products
 // @Param        q       query     string  true   "Search text (min 2 chars)"
 // @Param        limit   query     int     false  "Limit (1-100)"  minimum(1) maximum(100) default(20)
 // @Param        offset  query     int     false  "Offset (>=0)"   minimum(0) default(0)
+// @Param        fields  query     string  false  "Only return these fields (e.g. id,name,price)"
 // @Success      200     {object}  product.ListResponse
 // @Failure      400     {object}  product.HTTPError
 // @Failure      500     {object}  product.HTTPError
@@ -75,6 +101,10 @@ func searchHandler(repo product.Repository) gin.HandlerFunc {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "q is required (min 2 chars)"})
 			return
 		}
+		fields, ok := productFieldset(c)
+		if !ok {
+			return
+		}
 		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
 		if limit <= 0 || limit > 100 {
 			limit = 20
@@ -84,32 +114,257 @@ func searchHandler(repo product.Repository) gin.HandlerFunc {
 			offset = 0
 		}
 
-		items, err := repo.List(c.Request.Context(), product.Query{Q: q, Limit: limit, Offset: offset})
+		query := product.Query{Q: q, Limit: limit, Offset: offset}
+		items, err := repo.List(c.Request.Context(), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "search error"})
+			return
+		}
+		total, err := repo.CountSearch(c.Request.Context(), query)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "search error"})
+			return
+		}
+		out, err := httpx.Project(items, fields)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "search error"})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"q": q, "limit": limit, "offset": offset, "items": items})
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"q": q, "limit": limit, "offset": offset, "total": total, "items": out}))
+	}
+}
+
+// lowStockHandler godoc
+// @Summary      Low-stock report
+// @Description  Products with stock <= threshold, lowest stock first. Without 'threshold', each product is compared against its own low_stock_threshold.
+// @Tags         products
+// @Param        threshold  query     int     false  "Stock threshold (>=0)"  minimum(0)
+// @Param        limit      query     int     false  "Limit (1-100)"  minimum(1) maximum(100) default(20)
+// @Param        offset     query     int     false  "Offset (>=0)"   minimum(0) default(0)
+// @Success      200        {object}  product.ListResponse
+// @Failure      400        {object}  product.HTTPError
+// @Failure      500        {object}  product.HTTPError
+// @Router       /products/low-stock [get]
+func lowStockHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		threshold := -1 // per-product threshold
+		if v, ok := c.GetQuery("threshold"); ok {
+			t, err := strconv.Atoi(v)
+			if err != nil || t < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "threshold must be an integer >= 0"})
+				return
+			}
+			threshold = t
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if limit <= 0 || limit > 100 {
+			limit = 20
+		}
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		items, err := repo.LowStock(c.Request.Context(), threshold, limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "low stock error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"limit": limit, "offset": offset, "items": items}))
+	}
+}
+
+// categoriesHandler godoc
+// @Summary      List categories
+// @Description  Distinct categories of (non-deleted) products with their product counts, alphabetically. Uncategorized products are left out.
+// @Tags         products
+// @Success      200  {object}  map[string]interface{}
+// @Failure      500  {object}  product.HTTPError
+// @Router       /products/categories [get]
+func categoriesHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		items, err := repo.Categories(c.Request.Context())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "categories error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"items": items}))
+	}
+}
+
+// stockMovementsHandler godoc
+// @Summary      Stock movement history
+// @Description  Audit of a product's stock changes (orders, cancels, adjustments, transfers), newest first.
+// @Tags         products
+// @Param        id      path      string  true   "Product ID (UUID)"
+// @Param        limit   query     int     false  "Limit (1-100)"  minimum(1) maximum(100) default(20)
+// @Param        offset  query     int     false  "Offset (>=0)"   minimum(0) default(0)
+// @Success      200     {object}  map[string]interface{}
+// @Failure      404     {object}  product.HTTPError
+// @Failure      500     {object}  product.HTTPError
+// @Router       /products/{id}/stock-movements [get]
+func stockMovementsHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		if _, err := repo.GetByID(c.Request.Context(), id); err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock movements error"})
+			return
+		}
+		limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if limit <= 0 || limit > 100 {
+			limit = 20
+		}
+		offset, _ := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if offset < 0 {
+			offset = 0
+		}
+
+		items, err := repo.StockMovements(c.Request.Context(), id, limit, offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock movements error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"limit": limit, "offset": offset, "items": items}))
+	}
+}
+
+// orderStockMovementsHandler godoc
+// @Summary      Stock movements of an order
+// @Description  Every stock movement recorded with the given order_id, across products, oldest first (its creation decrements, then cancel or refund restocks). An order that moved no stock gives an empty list.
+// @Tags         products
+// @Param        order_id  query     string  true  "Order ID (UUID)"
+// @Success      200       {object}  map[string]interface{}
+// @Failure      422       {object}  product.HTTPError
+// @Failure      500       {object}  product.HTTPError
+// @Router       /products/stock-movements [get]
+func orderStockMovementsHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		orderID := c.Query("order_id")
+		if _, err := uuid.Parse(orderID); err != nil {
+			httpx.Unprocessable(c, "order_id must be a UUID")
+			return
+		}
+		items, err := repo.OrderStockMovements(c.Request.Context(), orderID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "stock movements error"})
+			return
+		}
+		c.JSON(http.StatusOK, httpx.ListEnvelope(gin.H{"order_id": orderID, "items": items}))
+	}
+}
+
+// getProductByBarcode godoc
+// @Summary      Get product by EAN-13 barcode
+// @Tags         products
+// @Param        code  path      string  true  "EAN-13 barcode"
+// @Success      200   {object}  product.Product
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/barcode/{code} [get]
+func getProductByBarcodeHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		code, err := product.NormalizeBarcode(c.Param("code"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "barcode must be a valid EAN-13"})
+			return
+		}
+		p, err := repo.GetByBarcode(c.Request.Context(), code)
+		if err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "get product error"})
+			return
+		}
+		c.JSON(http.StatusOK, p)
 	}
 }
 
 // getProduct godoc
 // @Summary      Get product by ID
 // @Tags         products
-// @Param        id   path      string  true  "Product ID (UUID)"
+// @Param        id               path      string  true   "Product ID (UUID)"
+// @Param        include_deleted  query     bool    false  "Also return a soft-deleted product (deleted_at set)"
+// @Param        fields           query     string  false  "Only return these fields (e.g. id,name,price)"
 // @Success      200  {object}  product.Product
+// @Header       200  {string}  ETag  "Version tag for If-Match on PUT"
+// @Failure      400  {object}  product.HTTPError
 // @Failure      404  {object}  product.HTTPError
+// @Failure      500  {object}  product.HTTPError
 // @Router       /products/{id} [get]
 func getProductHandler(repo product.Repository) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
-		p, err := repo.GetByID(c.Request.Context(), id)
+		fields, ok := productFieldset(c)
+		if !ok {
+			return
+		}
+		get := repo.GetByID
+		if inc, _ := strconv.ParseBool(c.Query("include_deleted")); inc {
+			get = repo.GetByIDIncludeDeleted
+		}
+		p, err := get(c.Request.Context(), id)
 		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "get product error"})
 			return
 		}
-		c.JSON(http.StatusOK, p)
+		out, err := httpx.Project(p, fields)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "encode error"})
+			return
+		}
+		c.Header("ETag", product.ETag(p))
+		c.JSON(http.StatusOK, out)
+	}
+}
+
+// maxCategoryLen matches products.category VARCHAR(100).
+const maxCategoryLen = 100
+
+// productOptions are the product-service settings the write handlers need.
+type productOptions struct {
+	// MaxDescriptionLen bounds a product description, in characters (runes).
+	MaxDescriptionLen int
+	// Audit records creations, updates and deletions; nil records nothing.
+	Audit audit.Recorder
+	// PriceDecimals is how many decimals a price may have (PRICE_DECIMALS).
+	PriceDecimals int32
+}
+
+func defaultProductOptions() productOptions {
+	return productOptions{MaxDescriptionLen: 4096, PriceDecimals: product.DefaultPriceDecimals}
+}
+
+// descriptionTooLong answers 422 when the description is over the limit and reports whether it did.
+func descriptionTooLong(c *gin.Context, description string, opts productOptions) bool {
+	if utf8.RuneCountInString(description) <= opts.MaxDescriptionLen {
+		return false
+	}
+	httpx.Unprocessable(c, fmt.Sprintf("description must be at most %d characters", opts.MaxDescriptionLen))
+	return true
+}
+
+// productFields are the keys a sparse fieldset (?fields=) may ask for.
+var productFields = httpx.JSONFields(product.Product{})
+
+// productFieldset parses ?fields=; on an unknown field it answers 400 and returns ok=false.
+func productFieldset(c *gin.Context) (fields []string, ok bool) {
+	fields, err := httpx.ParseFields(c.Query("fields"), productFields)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
 	}
+	return fields, true
 }
 
 // createProduct godoc
@@ -121,97 +376,678 @@ func getProductHandler(repo product.Repository) gin.HandlerFunc {
 // @Success      201   {object}  product.Product
 // @Failure      400   {object}  product.HTTPError
 // @Failure      500   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
 // @Router       /products [post]
-func createProductHandler(repo product.Repository) gin.HandlerFunc {
+func createProductHandler(repo product.Repository, opts productOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var in product.CreateProductRequest
 		// Bind JSON and validate
-		if err := c.BindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		if !httpx.BindJSON(c, &in) {
 			return
 		}
 		if in.Name == "" || in.Price == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "name and price are required"})
+			httpx.Unprocessable(c, "name and price are required")
+			return
+		}
+		price, err := product.ParsePrice(in.Price, opts.PriceDecimals)
+		if err != nil {
+			httpx.Unprocessable(c, err.Error())
+			return
+		}
+		if descriptionTooLong(c, in.Description, opts) {
 			return
 		}
 		if in.Stock < 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "stock must be >= 0"})
+			httpx.Unprocessable(c, "stock must be >= 0")
 			return
 		}
+		threshold := product.DefaultLowStockThreshold
+		if in.LowStockThreshold != nil {
+			if *in.LowStockThreshold < 0 {
+				httpx.Unprocessable(c, "low_stock_threshold must be >= 0")
+				return
+			}
+			threshold = *in.LowStockThreshold
+		}
+		var barcode string
+		if in.Barcode != "" {
+			code, err := product.NormalizeBarcode(in.Barcode)
+			if err != nil {
+				httpx.Unprocessable(c, "barcode must be a valid EAN-13")
+				return
+			}
+			barcode = code
+		}
+		category := strings.TrimSpace(in.Category)
+		if len(category) > maxCategoryLen {
+			httpx.Unprocessable(c, "category must be at most 100 characters")
+			return
+		}
+		status := product.StatusActive
+		if in.Status != "" {
+			st, err := product.ParseStatus(in.Status)
+			if err != nil {
+				httpx.Unprocessable(c, "status must be active, discontinued or out_of_stock")
+				return
+			}
+			status = st
+		}
 		p := &product.Product{
-			ID:          uuid.NewString(),
-			Name:        in.Name,
-			Description: in.Description,
-			Price:       in.Price,
-			Stock:       in.Stock,
+			ID:                uuid.NewString(),
+			Name:              in.Name,
+			Description:       in.Description,
+			Price:             price,
+			Stock:             in.Stock,
+			LowStockThreshold: threshold,
+			Barcode:           barcode,
+			Category:          category,
+			Status:            status,
+			AllowBackorder:    in.AllowBackorder,
 		}
 		if err := repo.Create(c.Request.Context(), p); err != nil {
+			if errors.Is(err, product.ErrDuplicateBarcode) {
+				c.JSON(http.StatusConflict, gin.H{"error": "barcode already in use"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "create error"})
 			return
 		}
 		// return the created one
-		out, _ := repo.GetByID(c.Request.Context(), p.ID)
+		out, _ := repo.GetByIDPrimary(c.Request.Context(), p.ID)
+		audit.Log(c.Request.Context(), opts.Audit, audit.Entry{
+			Action: audit.ActionCreate, ResourceType: audit.ResourceProduct, ResourceID: p.ID,
+			Detail: audit.Diff(nil, out, "created_at", "updated_at"),
+		})
 		c.JSON(http.StatusCreated, out)
 	}
 }
 
+// validatePriceHandler godoc
+// @Summary      Validate a price
+// @Description  Checks 'price' with the rules of product create and update (a non-negative decimal with at most PRICE_DECIMALS decimals) without storing anything. Always 200 for a well-formed body: {valid, normalized} or {valid: false, error}.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        body  body      product.ValidatePriceRequest  true  "price"
+// @Success      200   {object}  product.PriceValidation
+// @Failure      400   {object}  product.HTTPError
+// @Router       /products/validate-price [post]
+func validatePriceHandler(opts productOptions) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in product.ValidatePriceRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		if in.Price == "" {
+			c.JSON(http.StatusOK, product.PriceValidation{Error: "price is required"})
+			return
+		}
+		price, err := product.ParsePrice(in.Price, opts.PriceDecimals)
+		if err != nil {
+			c.JSON(http.StatusOK, product.PriceValidation{Error: err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, product.PriceValidation{Valid: true, Normalized: price})
+	}
+}
+
 // updateProduct godoc
 // @Summary      Update product (partial)
-// @Description  If 'price' is not provided, it is not modified. Empty fields do not change.
+// @Description  If 'price' is not provided, it is not modified. Empty fields do not change. 'status' (active, discontinued, out_of_stock) is independent of stock; only active products can be ordered. A stock change is recorded as a stock movement with 'stock_reason' (order, cancel, refund, adjustment; default adjustment) and optional 'order_id'. Stock may only be negative when 'allow_backorder' is set. With If-Match (the ETag from GET /products/{id}) the update only applies if the product has not changed since; otherwise 412.
 // @Tags         products
 // @Accept       json
 // @Produce      json
-// @Param        id    path      string                         true  "Product ID (UUID)"
-// @Param        body  body      product.UpdateProductRequest   true  "name, description, price, stock"
-// @Success      200   {object}  product.Product
-// @Failure      400   {object}  product.HTTPError
-// @Failure      404   {object}  product.HTTPError
-// @Failure      500   {object}  product.HTTPError
+// @Param        id        path      string                         true   "Product ID (UUID)"
+// @Param        If-Match  header    string                         false  "ETag of the version being edited"
+// @Param        body      body      product.UpdateProductRequest   true   "name, description, price, stock"
+// @Success      200       {object}  product.Product
+// @Failure      400       {object}  product.HTTPError
+// @Failure      404       {object}  product.HTTPError
+// @Failure      412       {object}  product.HTTPError
+// @Failure      422       {object}  product.HTTPError
+// @Failure      500       {object}  product.HTTPError
 // @Router       /products/{id} [put]
-func updateProductHandler(repo product.Repository) gin.HandlerFunc {
+func updateProductHandler(repo product.Repository, notifier product.Notifier, opts productOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		var in product.UpdateProductRequest
-		if err := c.BindJSON(&in); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid json"})
+		if !httpx.BindJSON(c, &in) {
 			return
 		}
+		if descriptionTooLong(c, in.Description, opts) {
+			return
+		}
+		// stock before the update, to detect the 0 -> positive transition
+		before := -1
+		prev, err := repo.GetByIDPrimary(c.Request.Context(), id)
+		if err == nil {
+			before = prev.Stock
+		}
 		updatePrice := in.Price != ""
+		if updatePrice {
+			price, err := product.ParsePrice(in.Price, opts.PriceDecimals)
+			if err != nil {
+				httpx.Unprocessable(c, err.Error())
+				return
+			}
+			in.Price = price
+		}
 		p := &product.Product{
-			ID:          id,
-			Name:        in.Name,
-			Description: in.Description,
-			Price:       in.Price,
-			Stock:       in.Stock,
+			ID:                id,
+			Name:              in.Name,
+			Description:       in.Description,
+			Price:             in.Price,
+			Stock:             in.Stock,
+			LowStockThreshold: -1, // -1 => no change
+		}
+		if in.LowStockThreshold != nil {
+			if *in.LowStockThreshold < 0 {
+				httpx.Unprocessable(c, "low_stock_threshold must be >= 0")
+				return
+			}
+			p.LowStockThreshold = *in.LowStockThreshold
 		}
 
-		if in.Stock < 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "stock must be >= 0"})
+		reason, err := product.ParseMovementReason(in.StockReason)
+		if err != nil {
+			httpx.Unprocessable(c, "stock_reason must be order, cancel, refund or adjustment")
 			return
 		}
-		if err := repo.Update(c.Request.Context(), p, updatePrice); err != nil {
+		if in.OrderID != "" {
+			if _, err := uuid.Parse(in.OrderID); err != nil {
+				httpx.Unprocessable(c, "order_id must be a UUID")
+				return
+			}
+		}
+		if in.Barcode != "" {
+			code, err := product.NormalizeBarcode(in.Barcode)
+			if err != nil {
+				httpx.Unprocessable(c, "barcode must be a valid EAN-13")
+				return
+			}
+			p.Barcode = code
+		}
+		if category := strings.TrimSpace(in.Category); category != "" {
+			if len(category) > maxCategoryLen {
+				httpx.Unprocessable(c, "category must be at most 100 characters")
+				return
+			}
+			p.Category = category
+		}
+		if in.Status != "" {
+			st, err := product.ParseStatus(in.Status)
+			if err != nil {
+				httpx.Unprocessable(c, "status must be active, discontinued or out_of_stock")
+				return
+			}
+			p.Status = st
+		}
+		if ifMatch := c.GetHeader("If-Match"); ifMatch != "" && strings.TrimSpace(ifMatch) != "*" {
+			at, ok := product.ParseETag(ifMatch)
+			if !ok {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match the current version"})
+				return
+			}
+			p.UpdatedAt = at
+		}
+		ch := product.StockChange{Reason: reason, OrderID: in.OrderID}
+		found, err := repo.Update(c.Request.Context(), p, updatePrice, in.AllowBackorder, ch)
+		if err != nil {
+			if errors.Is(err, product.ErrNegativeStock) {
+				httpx.Unprocessable(c, err.Error())
+				return
+			}
+			if errors.Is(err, product.ErrPreconditionFailed) {
+				c.JSON(http.StatusPreconditionFailed, gin.H{"error": "If-Match does not match the current version"})
+				return
+			}
+			if errors.Is(err, product.ErrDuplicateBarcode) {
+				c.JSON(http.StatusConflict, gin.H{"error": "barcode already in use"})
+				return
+			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "update error"})
 			return
 		}
-		out, err := repo.GetByID(c.Request.Context(), id)
-		if err != nil {
+		if !found {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
+		out, err := repo.GetByIDPrimary(c.Request.Context(), id)
+		if err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "update error"})
+			return
+		}
+		if before >= 0 && product.Restocked(before, out.Stock) {
+			// best-effort: a failed notification keeps the subscriptions for the next restock
+			if err := product.NotifyRestock(c.Request.Context(), repo, notifier, out); err != nil {
+				log.Printf("[restock] notify %s error: %v", id, err)
+			}
+		}
+		if prev != nil {
+			notifyAvailability(c.Request.Context(), repo, notifier, id, prev.Stock, out.Stock)
+		}
+		audit.Log(c.Request.Context(), opts.Audit, audit.Entry{
+			Action: audit.ActionUpdate, ResourceType: audit.ResourceProduct, ResourceID: id,
+			Detail: audit.Diff(prev, out, "updated_at"),
+		})
+		c.Header("ETag", product.ETag(out))
 		c.JSON(http.StatusOK, out)
 	}
 }
 
+// notifyMeHandler godoc
+// @Summary      Subscribe to restock notification
+// @Description  Registers an email to be notified when the product goes from 0 to positive stock.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string                    true  "Product ID (UUID)"
+// @Param        body  body      product.NotifyMeRequest   true  "email (req), user_id"
+// @Success      201   {object}  product.RestockSubscription
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/{id}/notify-me [post]
+func notifyMeHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var in product.NotifyMeRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		email := strings.TrimSpace(in.Email)
+		if email == "" || !strings.Contains(email, "@") {
+			httpx.Unprocessable(c, "valid email is required")
+			return
+		}
+		if in.UserID != "" {
+			if _, err := uuid.Parse(in.UserID); err != nil {
+				httpx.Unprocessable(c, "invalid user_id")
+				return
+			}
+		}
+		if _, err := repo.GetByID(c.Request.Context(), id); err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "subscribe error"})
+			return
+		}
+		sub := &product.RestockSubscription{ProductID: id, UserID: in.UserID, Email: email}
+		if err := repo.Subscribe(c.Request.Context(), sub); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "subscribe error"})
+			return
+		}
+		c.JSON(http.StatusCreated, sub)
+	}
+}
+
+// transferStockHandler godoc
+// @Summary      Transfer stock between products
+// @Description  Atomically decrements 'from_id' and increments 'to_id' by 'qty'. Nothing moves if the source lacks stock. Served only on the admin listener (PRODUCT_ADMIN_ADDR).
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        body  body      product.TransferStockRequest  true  "from_id, to_id, qty (>0)"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      409   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/transfer-stock [post]
+func transferStockHandler(repo product.Repository, notifier product.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in product.TransferStockRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		if in.FromID == "" || in.ToID == "" || in.Qty <= 0 {
+			httpx.Unprocessable(c, "from_id, to_id and qty > 0 are required")
+			return
+		}
+		if in.FromID == in.ToID {
+			httpx.Unprocessable(c, "from_id and to_id must differ")
+			return
+		}
+
+		fromStock, toStock, err := repo.TransferStock(c.Request.Context(), in.FromID, in.ToID, in.Qty)
+		if err != nil {
+			switch err {
+			case product.ErrInsufficientStock:
+				c.JSON(http.StatusConflict, gin.H{"error": "insufficient stock"})
+			case product.ErrNotFound:
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "transfer error"})
+			}
+			return
+		}
+		if product.Restocked(toStock-in.Qty, toStock) {
+			if to, err := repo.GetByIDPrimary(c.Request.Context(), in.ToID); err == nil {
+				if err := product.NotifyRestock(c.Request.Context(), repo, notifier, to); err != nil {
+					log.Printf("[restock] notify %s error: %v", in.ToID, err)
+				}
+			}
+		}
+		notifyAvailability(c.Request.Context(), repo, notifier, in.FromID, fromStock+in.Qty, fromStock)
+		notifyAvailability(c.Request.Context(), repo, notifier, in.ToID, toStock-in.Qty, toStock)
+		c.JSON(http.StatusOK, gin.H{
+			"from": gin.H{"id": in.FromID, "stock": fromStock},
+			"to":   gin.H{"id": in.ToID, "stock": toStock},
+			"qty":  in.Qty,
+		})
+	}
+}
+
+// bulkPriceAdjustHandler godoc
+// @Summary      Bulk-adjust prices of a category
+// @Description  Changes by 'percent' (decimal in (-100, 100], e.g. -10 for 10% off) the price of every product in 'category', in a single transaction. Prices keep their scale (at least cents) and each change is recorded in price history. Served only on the admin listener (PRODUCT_ADMIN_ADDR).
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        body  body      product.BulkPriceAdjustRequest  true  "category, percent"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/bulk-price-adjust [post]
+func bulkPriceAdjustHandler(repo product.Repository) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var in product.BulkPriceAdjustRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		category := strings.TrimSpace(in.Category)
+		if category == "" || len(category) > maxCategoryLen {
+			httpx.Unprocessable(c, "category is required (at most 100 characters)")
+			return
+		}
+		percent, err := product.ParseAdjustPercent(in.Percent)
+		if err != nil {
+			httpx.Unprocessable(c, err.Error())
+			return
+		}
+
+		changes, err := repo.BulkAdjustPrice(c.Request.Context(), category, percent)
+		if err != nil {
+			log.Printf("[price] bulk adjust %q error: %v", category, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "bulk price adjust error"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"category": category, "percent": percent.String(), "updated": len(changes)})
+	}
+}
+
+// restockHandler godoc
+// @Summary      Restock for an order (idempotent)
+// @Description  Gives back 'qty' units released by order 'order_id'. Applied at most once per (order, product): a replay returns applied=false and changes nothing.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string                  true  "Product ID (UUID)"
+// @Param        body  body      product.RestockRequest  true  "order_id, qty (>0), reason"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/{id}/restock [post]
+func restockHandler(repo product.Repository, notifier product.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var in product.RestockRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		if _, err := uuid.Parse(in.OrderID); err != nil || in.Qty <= 0 {
+			httpx.Unprocessable(c, "order_id (UUID) and qty > 0 are required")
+			return
+		}
+		reason := product.ReasonCancel
+		if in.Reason != "" {
+			r, err := product.ParseMovementReason(in.Reason)
+			if err != nil || (r != product.ReasonCancel && r != product.ReasonRefund) {
+				httpx.Unprocessable(c, "reason must be cancel or refund")
+				return
+			}
+			reason = r
+		}
+
+		stock, applied, err := repo.RestockOnce(c.Request.Context(), id, in.Qty, product.StockChange{Reason: reason, OrderID: in.OrderID})
+		if err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "restock error"})
+			return
+		}
+		if applied && product.Restocked(stock-in.Qty, stock) {
+			if p, err := repo.GetByIDPrimary(c.Request.Context(), id); err == nil {
+				if err := product.NotifyRestock(c.Request.Context(), repo, notifier, p); err != nil {
+					log.Printf("[restock] notify %s error: %v", id, err)
+				}
+			}
+		}
+		if applied {
+			notifyAvailability(c.Request.Context(), repo, notifier, id, stock-in.Qty, stock)
+		}
+		c.JSON(http.StatusOK, gin.H{"id": id, "stock": stock, "applied": applied})
+	}
+}
+
+// decrementStockHandler godoc
+// @Summary      Atomically take stock
+// @Description  Takes 'qty' units in a single conditional UPDATE, so concurrent orders cannot oversell: 409 when fewer are left (unless the product allows backorders). Recorded as a stock movement with 'reason' (default order) and 'order_id'. With 'item_id' (the order line) it is applied at most once per (order_id, item_id): a replay returns applied=false and changes nothing, so it is safe to retry.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string                     true  "Product ID (UUID)"
+// @Param        body  body      product.StockDeltaRequest  true  "qty (>0), reason, order_id, item_id"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      409   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/{id}/decrement [post]
+func decrementStockHandler(repo product.Repository, notifier product.Notifier) gin.HandlerFunc {
+	return stockDeltaHandler(repo, notifier, -1)
+}
+
+// incrementStockHandler godoc
+// @Summary      Atomically give stock back
+// @Description  Adds 'qty' units in a single UPDATE. Recorded as a stock movement with 'reason' (default adjustment) and 'order_id'; going from 0 to positive notifies restock subscribers.
+// @Tags         products
+// @Accept       json
+// @Produce      json
+// @Param        id    path      string                     true  "Product ID (UUID)"
+// @Param        body  body      product.StockDeltaRequest  true  "qty (>0), reason, order_id"
+// @Success      200   {object}  map[string]interface{}
+// @Failure      400   {object}  product.HTTPError
+// @Failure      404   {object}  product.HTTPError
+// @Failure      422   {object}  product.HTTPError
+// @Failure      500   {object}  product.HTTPError
+// @Router       /products/{id}/increment [post]
+func incrementStockHandler(repo product.Repository, notifier product.Notifier) gin.HandlerFunc {
+	return stockDeltaHandler(repo, notifier, +1)
+}
+
+// stockDeltaHandler serves decrement (sign -1) and increment (+1) through the repo's atomic
+// DecrementStock/IncrementStock, never a read-modify-write of the stock.
+func stockDeltaHandler(repo product.Repository, notifier product.Notifier, sign int) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		var in product.StockDeltaRequest
+		if !httpx.BindJSON(c, &in) {
+			return
+		}
+		if in.Qty <= 0 {
+			httpx.Unprocessable(c, "qty must be > 0")
+			return
+		}
+		if in.Reason == "" && sign < 0 {
+			in.Reason = string(product.ReasonOrder)
+		}
+		reason, err := product.ParseMovementReason(in.Reason)
+		if err != nil {
+			httpx.Unprocessable(c, "reason must be order, cancel, refund or adjustment")
+			return
+		}
+		if in.OrderID != "" {
+			if _, err := uuid.Parse(in.OrderID); err != nil {
+				httpx.Unprocessable(c, "order_id must be a UUID")
+				return
+			}
+		}
+		if in.ItemID != "" {
+			if _, err := uuid.Parse(in.ItemID); err != nil || in.OrderID == "" || sign > 0 {
+				httpx.Unprocessable(c, "item_id must be a UUID, sent with order_id on a decrement")
+				return
+			}
+		}
+
+		ch := product.StockChange{Reason: reason, OrderID: in.OrderID, ItemID: in.ItemID}
+		var stock int
+		applied := true
+		switch {
+		case in.ItemID != "":
+			stock, applied, err = repo.DecrementStockOnce(c.Request.Context(), id, in.Qty, ch)
+		case sign < 0:
+			stock, err = repo.DecrementStock(c.Request.Context(), id, in.Qty, ch)
+		default:
+			stock, err = repo.IncrementStock(c.Request.Context(), id, in.Qty, ch)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, product.ErrInsufficientStock):
+				body := gin.H{"error": "insufficient stock"}
+				if p, err := repo.GetByIDPrimary(c.Request.Context(), id); err == nil {
+					body["available"] = p.Stock
+				}
+				c.JSON(http.StatusConflict, body)
+			case errors.Is(err, product.ErrNotFound):
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+			default:
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "stock update error"})
+			}
+			return
+		}
+
+		if !applied {
+			// a replay of a line that already took its units: nothing moved
+			c.JSON(http.StatusOK, gin.H{"id": id, "stock": stock, "applied": false})
+			return
+		}
+
+		before := stock - sign*in.Qty
+		if product.Restocked(before, stock) {
+			if p, err := repo.GetByIDPrimary(c.Request.Context(), id); err == nil {
+				if err := product.NotifyRestock(c.Request.Context(), repo, notifier, p); err != nil {
+					log.Printf("[restock] notify %s error: %v", id, err)
+				}
+			}
+		}
+		notifyAvailability(c.Request.Context(), repo, notifier, id, before, stock)
+		c.JSON(http.StatusOK, gin.H{"id": id, "stock": stock, "applied": true})
+	}
+}
+
+// recalcStockHandler godoc
+// @Summary      Recalculate stock from orders (admin)
+// @Description  Sets stock to 'initial' minus the units held by orders that are not canceled (asked to order-service), for fixing stock after data migrations. The correction is recorded as a 'recalc' stock movement. Served only on the admin listener (PRODUCT_ADMIN_ADDR).
+// @Tags         products
+// @Produce      json
+// @Param        id       path      string  true  "Product ID (UUID)"
+// @Param        initial  query     int     true  "Stock before any order (>= 0)"
+// @Success      200      {object}  map[string]interface{}
+// @Failure      400      {object}  product.HTTPError
+// @Failure      404      {object}  product.HTTPError
+// @Failure      409      {object}  product.HTTPError
+// @Failure      500      {object}  product.HTTPError
+// @Failure      502      {object}  product.HTTPError
+// @Router       /products/{id}/recalc-stock [post]
+func recalcStockHandler(repo product.Repository, orders product.OrderQuantities, notifier product.Notifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.Param("id")
+		initial, err := strconv.Atoi(c.Query("initial"))
+		if err != nil || initial < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "initial must be an integer >= 0"})
+			return
+		}
+		if _, err := repo.GetByID(c.Request.Context(), id); err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "recalc stock error"})
+			return
+		}
+		held, err := orders.ActiveQuantity(c.Request.Context(), id)
+		if err != nil {
+			log.Printf("[recalc] %s order service error: %v", id, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "order service error"})
+			return
+		}
+		stock := initial - held
+		if stock < 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "initial is below the units held by orders", "held_by_orders": held})
+			return
+		}
+		before, err := repo.SetStock(c.Request.Context(), id, stock, product.StockChange{Reason: product.ReasonRecalc})
+		if err != nil {
+			if errors.Is(err, product.ErrNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "recalc stock error"})
+			return
+		}
+		if product.Restocked(before, stock) {
+			if p, err := repo.GetByIDPrimary(c.Request.Context(), id); err == nil {
+				if err := product.NotifyRestock(c.Request.Context(), repo, notifier, p); err != nil {
+					log.Printf("[recalc] notify %s error: %v", id, err)
+				}
+			}
+		}
+		notifyAvailability(c.Request.Context(), repo, notifier, id, before, stock)
+		c.JSON(http.StatusOK, gin.H{"id": id, "stock": stock, "previous_stock": before, "held_by_orders": held})
+	}
+}
+
+// notifyAvailability emits product.availability_changed when a stock change crossed zero,
+// best-effort like the restock notifications: a failure is only logged.
+func notifyAvailability(ctx context.Context, repo product.Repository, notifier product.Notifier, id string, before, after int) {
+	if !product.AvailabilityChanged(before, after) {
+		return
+	}
+	p, err := repo.GetByIDPrimary(ctx, id)
+	if err != nil {
+		log.Printf("[availability] %s fetch error: %v", id, err)
+		return
+	}
+	if err := notifier.NotifyAvailability(ctx, p, after > 0); err != nil {
+		log.Printf("[availability] notify %s error: %v", id, err)
+	}
+}
+
 // deleteProduct godoc
 // @Summary      Delete product by ID
-// @Description  Deletes a product by its ID (UUID).
+// @Description  Soft-deletes a product by its ID (UUID): it disappears from listings and stock changes, but GET with include_deleted=true still returns it.
 // @Tags         products
 // @Param        id   path      string  true  "Product ID (UUID)"
 // @Success      204  "No Content"
 // @Failure      404  {object}  product.HTTPError
 // @Failure      500  {object}  product.HTTPError
 // @Router       /products/{id} [delete]
-func deleteProductHandler(repo product.Repository) gin.HandlerFunc {
+func deleteProductHandler(repo product.Repository, opts productOptions) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		id := c.Param("id")
 		ok, err := repo.Delete(c.Request.Context(), id)
@@ -223,6 +1059,7 @@ func deleteProductHandler(repo product.Repository) gin.HandlerFunc {
 			c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
 			return
 		}
+		audit.Log(c.Request.Context(), opts.Audit, audit.Entry{Action: audit.ActionDelete, ResourceType: audit.ResourceProduct, ResourceID: id})
 		c.Status(http.StatusNoContent)
 	}
 }
@@ -243,35 +1080,125 @@ func main() {
 	defer pool.Close()
 	log.Println("[db] connected")
 
-	repo := product.NewPGRepo(pool)
+	repoOpts := []product.Option{product.WithSearchMode(product.ParseSearchMode(cfg.ProductSearchMode))}
+	if cfg.PostgresReadDSN != "" {
+		readPool, err := pgxpool.New(ctx, cfg.PostgresReadDSN)
+		if err != nil {
+			log.Fatalf("db read pool error: %v", err)
+		}
+		if err := readPool.Ping(ctx); err != nil {
+			log.Fatalf("db read ping error: %v", err)
+		}
+		defer readPool.Close()
+		repoOpts = append(repoOpts, product.WithReadDB(readPool))
+		log.Println("[db] reads go to POSTGRES_READ_DSN")
+	}
+	repo := product.NewPGRepo(pool, repoOpts...)
+
+	opts := defaultProductOptions()
+	if cfg.MaxDescriptionLen > 0 {
+		opts.MaxDescriptionLen = cfg.MaxDescriptionLen
+	}
+	opts.Audit = audit.NewPGRecorder(pool)
+	if cfg.PriceDecimals > product.MaxPriceDecimals {
+		log.Printf("[config] PRICE_DECIMALS=%d above %d, using %d", cfg.PriceDecimals, product.MaxPriceDecimals, product.DefaultPriceDecimals)
+	} else {
+		opts.PriceDecimals = int32(cfg.PriceDecimals)
+	}
+
+	var notifier product.Notifier = product.LogNotifier{}
+	if cfg.RestockWebhookURL != "" {
+		notifier = product.NewWebhookNotifier(cfg.RestockWebhookURL)
+	}
 
 	// Gin
 	r := gin.New()
+	r.Use(httpx.CORS(cfg.ProductCORSOrigins))
+	r.Use(httpx.SecurityHeaders(
+		httpx.WithFrameOptions(cfg.FrameOptions),
+		httpx.WithContentSecurityPolicy(cfg.ContentSecurityPolicy),
+		httpx.WithReferrerPolicy(cfg.ReferrerPolicy),
+	))
 	r.GET("/docs/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
-	r.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery())
+	r.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery(), httpx.JSONCase(), httpx.NoStore())
+	if cfg.RateLimit > 0 {
+		backend := ratelimit.ParseBackend(cfg.RateLimiterBackend)
+		r.Use(httpx.RateLimit(ratelimit.New(backend, pool, cfg.RateLimit, cfg.RateLimitWindow)))
+		log.Printf("[ratelimit] %s backend: %d req / %s per client", backend, cfg.RateLimit, cfg.RateLimitWindow)
+	}
+
+	// Caps on in-flight requests of the expensive route groups
+	limits := httpx.ParseConcurrencyLimits(cfg.ConcurrencyLimits)
+	searchLimit := httpx.ConcurrencyLimit(limits["search"])
+	bulkLimit := httpx.ConcurrencyLimit(limits["bulk"])
 
 	// Health
 	r.GET("/healthz", func(c *gin.Context) {
 		c.String(http.StatusOK, "ok")
 	})
 
+	// API contract: version and registered routes
+	r.GET("/api-info", httpx.APIInfo(r, "product-service"))
+
+	// Everything below is scoped to the caller's tenant
+	r.Use(httpx.Tenant(cfg.MultiTenant), httpx.AuditActor())
+
 	// List
-	r.GET("/products", listOnlyHandler(repo))
+	r.GET("/products", httpx.PublicCache(cfg.ProductCacheMaxAge), listOnlyHandler(repo))
 
 	// Search
-	r.GET("/products/search", searchHandler(repo))
+	r.GET("/products/search", searchLimit, searchHandler(repo))
+
+	// Low-stock report
+	r.GET("/products/low-stock", searchLimit, lowStockHandler(repo))
+
+	// Categories for navigation
+	r.GET("/products/categories", categoriesHandler(repo))
+
+	// Lookup by EAN-13 (POS scanners)
+	r.GET("/products/barcode/:code", getProductByBarcodeHandler(repo))
 
 	// Get product by ID
-	r.GET("/products/:id", getProductHandler(repo))
+	r.GET("/products/:id", httpx.PublicCache(cfg.ProductCacheMaxAge), getProductHandler(repo))
 
 	// Create
-	r.POST("/products", createProductHandler(repo))
+	r.POST("/products", createProductHandler(repo, opts))
 
 	// Update
-	r.PUT("/products/:id", updateProductHandler(repo))
+	r.PUT("/products/:id", updateProductHandler(repo, notifier, opts))
+
+	// Integration tooling: check a price before importing it
+	r.POST("/products/validate-price", validatePriceHandler(opts))
+
+	// Idempotent restock of an order's units (cancel, saga recovery)
+	r.POST("/products/:id/restock", restockHandler(repo, notifier))
+
+	// Atomic stock changes (order-service takes and gives back units through these)
+	r.POST("/products/:id/decrement", decrementStockHandler(repo, notifier))
+	r.POST("/products/:id/increment", incrementStockHandler(repo, notifier))
+
+	// Restock notification subscription
+	r.POST("/products/:id/notify-me", notifyMeHandler(repo))
+
+	// Stock movement history
+	r.GET("/products/:id/stock-movements", stockMovementsHandler(repo))
+	r.GET("/products/stock-movements", orderStockMovementsHandler(repo))
 
 	// Delete
-	r.DELETE("/products/:id", deleteProductHandler(repo))
+	r.DELETE("/products/:id", deleteProductHandler(repo, opts))
+
+	// Admin endpoints, served only on the admin listener (PRODUCT_ADMIN_ADDR)
+	admin := gin.New()
+	admin.Use(httpx.RequestID(), httpx.Logger(), gin.Recovery(), httpx.JSONCase(), httpx.NoStore(), httpx.Tenant(cfg.MultiTenant), httpx.AuditActor())
+
+	// Transfer stock between two products
+	admin.POST("/products/transfer-stock", transferStockHandler(repo, notifier))
+
+	// Merchandising: change the prices of a whole category by a percentage
+	admin.POST("/products/bulk-price-adjust", bulkLimit, bulkPriceAdjustHandler(repo))
+
+	// Admin: recalculate stock from the orders in order-service
+	admin.POST("/products/:id/recalc-stock", bulkLimit, recalcStockHandler(repo, product.NewOrderClient(cfg.OrderSvcBaseURL), notifier))
 
 	// Server + Graceful shutdown
 	srv := &http.Server{
@@ -281,6 +1208,9 @@ func main() {
 		WriteTimeout: 5 * time.Second,
 	}
 
+	// Admin endpoints, config and profiling on their own listener, never on the public router
+	adminSrv := httpx.StartAdmin(cfg.ProductAdminAddr, "product-service", httpx.Admin(cfg.Redacted(), cfg.EnablePprof, admin))
+
 	go func() {
 		log.Printf("[http] product-service listening on %s", cfg.ProductSvcAddr)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -296,4 +1226,5 @@ func main() {
 	ctxShutdown, cancel2 := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel2()
 	_ = srv.Shutdown(ctxShutdown)
+	_ = adminSrv.Shutdown(ctxShutdown)
 }
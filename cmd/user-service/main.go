@@ -6,6 +6,7 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
 	"github.com/MikeMC777/ordenes-ecom/internal/config"
 	userSvc "github.com/MikeMC777/ordenes-ecom/internal/user"
 	pb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
@@ -43,9 +45,21 @@ func main() {
 		log.Fatalf("listen error: %v", err)
 	}
 
-	server := grpc.NewServer()
+	interceptors := []grpc.UnaryServerInterceptor{
+		userSvc.RequestIDInterceptor(),
+		userSvc.TenantInterceptor(cfg.MultiTenant),
+		userSvc.ActorInterceptor(),
+	}
+	if strings.EqualFold(cfg.LogLevel, "debug") {
+		interceptors = append(interceptors, userSvc.PayloadLogInterceptor(log.Default()))
+		log.Println("[grpc] LOG_LEVEL=debug: logging request payloads (secrets masked)")
+	}
+	server := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
 	repo := userSvc.NewRepoFromPool(pool)
-	service := userSvc.NewService(repo)
+	service := userSvc.NewService(repo,
+		userSvc.WithSessionTTL(cfg.SessionTTL),
+		userSvc.WithJWT(cfg.JWTSecret, cfg.JWTTTL),
+		userSvc.WithAudit(audit.NewPGRecorder(pool)))
 
 	pb.RegisterUserServiceServer(server, service)
 
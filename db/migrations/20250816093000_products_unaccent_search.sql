@@ -0,0 +1,20 @@
+-- +goose Up
+CREATE EXTENSION IF NOT EXISTS unaccent;
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent() is only STABLE; an IMMUTABLE wrapper is required to index it.
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION f_unaccent(text) RETURNS text
+  LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+AS $$ SELECT public.unaccent('public.unaccent', $1) $$;
+-- +goose StatementEnd
+
+CREATE INDEX IF NOT EXISTS idx_products_name_unaccent
+  ON products USING gin (f_unaccent(name) gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_products_description_unaccent
+  ON products USING gin (f_unaccent(description) gin_trgm_ops);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_products_description_unaccent;
+DROP INDEX IF EXISTS idx_products_name_unaccent;
+DROP FUNCTION IF EXISTS f_unaccent(text);
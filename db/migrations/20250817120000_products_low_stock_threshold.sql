@@ -0,0 +1,10 @@
+-- +goose Up
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS low_stock_threshold INTEGER NOT NULL DEFAULT 5
+    CONSTRAINT products_low_stock_threshold_nonneg CHECK (low_stock_threshold >= 0);
+
+CREATE INDEX IF NOT EXISTS idx_products_stock ON products (stock);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_products_stock;
+ALTER TABLE products DROP COLUMN IF EXISTS low_stock_threshold;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- status adds 'draft': an order that holds stock until expires_at, committed via /commit
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_orders_draft_expires_at ON orders (expires_at) WHERE status = 'draft';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_draft_expires_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS expires_at;
@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS sessions (
+  id UUID PRIMARY KEY,  -- token id
+  user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  expires_at TIMESTAMP NOT NULL,
+  revoked BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_user_id ON sessions(user_id, issued_at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS sessions;
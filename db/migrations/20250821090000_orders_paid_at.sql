@@ -0,0 +1,5 @@
+-- +goose Up
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS paid_at TIMESTAMP;
+
+-- +goose Down
+ALTER TABLE orders DROP COLUMN IF EXISTS paid_at;
@@ -0,0 +1,15 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS stock_movements (
+  id BIGSERIAL PRIMARY KEY,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  delta INT NOT NULL,
+  reason VARCHAR(20) NOT NULL,
+  resulting_stock INT NOT NULL,
+  order_id UUID,
+  at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_stock_movements_product_at ON stock_movements(product_id, at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS stock_movements;
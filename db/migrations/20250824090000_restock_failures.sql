@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS restock_failures (
+  id BIGSERIAL PRIMARY KEY,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL,
+  quantity INT NOT NULL,
+  reason TEXT NOT NULL,
+  at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_restock_failures_order_id ON restock_failures(order_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS restock_failures;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE products ADD COLUMN IF NOT EXISTS barcode VARCHAR(13);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_barcode ON products(barcode) WHERE barcode IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_products_barcode;
+ALTER TABLE products DROP COLUMN IF EXISTS barcode;
@@ -0,0 +1,22 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS refunds (
+  id UUID PRIMARY KEY,
+  order_id UUID NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+  amount NUMERIC(10,2) NOT NULL CHECK (amount > 0),
+  reason TEXT NOT NULL DEFAULT '',
+  restock BOOLEAN NOT NULL DEFAULT FALSE,
+  at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS refund_items (
+  refund_id UUID NOT NULL REFERENCES refunds(id) ON DELETE CASCADE,
+  product_id UUID NOT NULL,
+  quantity INTEGER NOT NULL CHECK (quantity > 0),
+  PRIMARY KEY (refund_id, product_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds(order_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS refund_items;
+DROP TABLE IF EXISTS refunds;
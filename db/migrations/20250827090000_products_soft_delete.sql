@@ -0,0 +1,12 @@
+-- +goose Up
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
+
+-- a deleted product keeps its barcode for history but frees it for new products
+DROP INDEX IF EXISTS idx_products_barcode;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_barcode ON products(barcode) WHERE barcode IS NOT NULL AND deleted_at IS NULL;
+
+-- +goose Down
+DELETE FROM products WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_products_barcode;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_products_barcode ON products(barcode) WHERE barcode IS NOT NULL;
+ALTER TABLE products DROP COLUMN IF EXISTS deleted_at;
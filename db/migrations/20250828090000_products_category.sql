@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE products ADD COLUMN IF NOT EXISTS category VARCHAR(100);
+CREATE INDEX IF NOT EXISTS idx_products_category ON products(category) WHERE deleted_at IS NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_products_category;
+ALTER TABLE products DROP COLUMN IF EXISTS category;
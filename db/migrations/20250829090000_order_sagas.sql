@@ -0,0 +1,24 @@
+-- +goose Up
+-- No FK to orders: a saga exists before (and possibly without) its order.
+CREATE TABLE IF NOT EXISTS order_sagas (
+  order_id UUID PRIMARY KEY,
+  state TEXT NOT NULL DEFAULT 'started',
+  created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_sagas_started ON order_sagas(updated_at) WHERE state = 'started';
+
+CREATE TABLE IF NOT EXISTS order_saga_steps (
+  id BIGSERIAL PRIMARY KEY,
+  order_id UUID NOT NULL REFERENCES order_sagas(order_id) ON DELETE CASCADE,
+  product_id UUID NOT NULL,
+  quantity INT NOT NULL,
+  compensated BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS idx_order_saga_steps_order_id ON order_saga_steps(order_id);
+
+-- +goose Down
+DROP TABLE IF EXISTS order_saga_steps;
+DROP TABLE IF EXISTS order_sagas;
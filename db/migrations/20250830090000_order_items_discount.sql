@@ -0,0 +1,9 @@
+-- +goose Up
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount NUMERIC(10,2) NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS line_total NUMERIC(10,2);
+UPDATE order_items SET line_total = quantity * price WHERE line_total IS NULL;
+ALTER TABLE order_items ALTER COLUMN line_total SET NOT NULL;
+
+-- +goose Down
+ALTER TABLE order_items DROP COLUMN IF EXISTS line_total;
+ALTER TABLE order_items DROP COLUMN IF EXISTS discount;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- One cancel restock per (order, product): replays are no-ops.
+CREATE TABLE IF NOT EXISTS restock_ledger (
+  order_id UUID NOT NULL,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  quantity INT NOT NULL,
+  at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (order_id, product_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS restock_ledger;
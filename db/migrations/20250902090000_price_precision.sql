@@ -0,0 +1,15 @@
+-- +goose Up
+-- Prices keep the scale they are written with, so PRICE_DECIMALS can go beyond cents
+-- without rewriting existing 2-decimal values.
+ALTER TABLE products ALTER COLUMN price TYPE NUMERIC;
+ALTER TABLE orders ALTER COLUMN total TYPE NUMERIC;
+ALTER TABLE order_items ALTER COLUMN price TYPE NUMERIC;
+ALTER TABLE order_items ALTER COLUMN discount TYPE NUMERIC;
+ALTER TABLE order_items ALTER COLUMN line_total TYPE NUMERIC;
+
+-- +goose Down
+ALTER TABLE order_items ALTER COLUMN line_total TYPE NUMERIC(10,2);
+ALTER TABLE order_items ALTER COLUMN discount TYPE NUMERIC(10,2);
+ALTER TABLE order_items ALTER COLUMN price TYPE NUMERIC(10,2);
+ALTER TABLE orders ALTER COLUMN total TYPE NUMERIC(10,2);
+ALTER TABLE products ALTER COLUMN price TYPE NUMERIC(10,2);
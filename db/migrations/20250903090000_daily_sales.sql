@@ -0,0 +1,16 @@
+-- +goose Up
+-- Paid order lines rolled up per day and product, rebuilt nightly for reports.
+CREATE TABLE IF NOT EXISTS daily_sales (
+  tenant_id TEXT NOT NULL DEFAULT 'default',
+  date DATE NOT NULL,
+  product_id UUID NOT NULL,
+  units INT NOT NULL,
+  revenue NUMERIC NOT NULL,
+  updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (tenant_id, date, product_id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_paid_at ON orders(tenant_id, paid_at) WHERE status = 'paid';
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_paid_at;
+DROP TABLE IF EXISTS daily_sales;
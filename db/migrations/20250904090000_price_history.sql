@@ -0,0 +1,14 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS price_history (
+  id BIGSERIAL PRIMARY KEY,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  old_price NUMERIC NOT NULL,
+  new_price NUMERIC NOT NULL,
+  reason VARCHAR(20) NOT NULL,
+  at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_price_history_product_at ON price_history(product_id, at DESC);
+
+-- +goose Down
+DROP TABLE IF EXISTS price_history;
@@ -0,0 +1,7 @@
+-- +goose Up
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'active'
+    CONSTRAINT products_status_valid CHECK (status IN ('active', 'discontinued', 'out_of_stock'));
+
+-- +goose Down
+ALTER TABLE products DROP COLUMN IF EXISTS status;
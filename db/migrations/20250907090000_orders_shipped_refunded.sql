@@ -0,0 +1,8 @@
+-- +goose Up
+-- status adds 'shipped' and 'refunded'; shipped orders still count as sales
+DROP INDEX IF EXISTS idx_orders_paid_at;
+CREATE INDEX IF NOT EXISTS idx_orders_paid_at ON orders(tenant_id, paid_at) WHERE status IN ('paid', 'shipped');
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_paid_at;
+CREATE INDEX IF NOT EXISTS idx_orders_paid_at ON orders(tenant_id, paid_at) WHERE status = 'paid';
@@ -0,0 +1,14 @@
+-- +goose Up
+ALTER TABLE products
+  ADD COLUMN IF NOT EXISTS allow_backorder BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- stock may only go below zero on products that take backorders
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_stock_nonneg;
+ALTER TABLE products
+  ADD CONSTRAINT products_stock_nonneg CHECK (stock >= 0 OR allow_backorder);
+
+-- +goose Down
+ALTER TABLE products DROP CONSTRAINT IF EXISTS products_stock_nonneg;
+ALTER TABLE products
+  ADD CONSTRAINT products_stock_nonneg CHECK (stock >= 0);
+ALTER TABLE products DROP COLUMN IF EXISTS allow_backorder;
@@ -0,0 +1,34 @@
+-- +goose Up
+CREATE TABLE IF NOT EXISTS audit_log (
+  id BIGSERIAL PRIMARY KEY,
+  tenant_id TEXT NOT NULL DEFAULT 'default',
+  actor VARCHAR(255) NOT NULL,
+  action VARCHAR(50) NOT NULL,
+  resource_type VARCHAR(50) NOT NULL,
+  resource_id VARCHAR(255) NOT NULL,
+  at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+  detail_json JSONB
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_resource ON audit_log(tenant_id, resource_type, resource_id, at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_actor ON audit_log(tenant_id, actor, at);
+
+-- append-only: rows can be inserted, never changed or removed
+-- +goose StatementBegin
+CREATE OR REPLACE FUNCTION audit_log_append_only() RETURNS trigger
+  LANGUAGE plpgsql
+AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append-only';
+END;
+$$;
+-- +goose StatementEnd
+
+CREATE TRIGGER audit_log_append_only
+  BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+-- +goose Down
+DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only();
+DROP TABLE IF EXISTS audit_log;
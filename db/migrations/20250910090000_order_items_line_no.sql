@@ -0,0 +1,18 @@
+-- +goose Up
+ALTER TABLE order_items ADD COLUMN IF NOT EXISTS line_no INT;
+
+-- existing orders get their lines numbered in id order
+UPDATE order_items i SET line_no = n.line_no
+FROM (
+  SELECT id, ROW_NUMBER() OVER (PARTITION BY order_id ORDER BY id) AS line_no
+  FROM order_items
+) n
+WHERE n.id = i.id AND i.line_no IS NULL;
+
+ALTER TABLE order_items ALTER COLUMN line_no SET NOT NULL;
+ALTER TABLE order_items
+  ADD CONSTRAINT order_items_line_no_uniq UNIQUE (order_id, line_no);
+
+-- +goose Down
+ALTER TABLE order_items DROP CONSTRAINT IF EXISTS order_items_line_no_uniq;
+ALTER TABLE order_items DROP COLUMN IF EXISTS line_no;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- status adds 'delivered'; shipped_at / delivered_at are set once, on entering each status.
+-- Orders shipped before this have no known ship time and keep it NULL.
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipped_at TIMESTAMP;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP;
+-- delivered orders still count as sales
+DROP INDEX IF EXISTS idx_orders_paid_at;
+CREATE INDEX IF NOT EXISTS idx_orders_paid_at ON orders(tenant_id, paid_at) WHERE status IN ('paid', 'shipped', 'delivered');
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_orders_paid_at;
+CREATE INDEX IF NOT EXISTS idx_orders_paid_at ON orders(tenant_id, paid_at) WHERE status IN ('paid', 'shipped');
+ALTER TABLE orders DROP COLUMN IF EXISTS delivered_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS shipped_at;
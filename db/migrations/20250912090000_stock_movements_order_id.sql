@@ -0,0 +1,6 @@
+-- +goose Up
+-- which movements did an order cause (GET /orders/{id}/stock-movements)
+CREATE INDEX IF NOT EXISTS idx_stock_movements_order_id ON stock_movements(order_id) WHERE order_id IS NOT NULL;
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_stock_movements_order_id;
@@ -0,0 +1,7 @@
+-- +goose Up
+-- which orders hold a product (active quantity, GET /orders/products/{product_id}/related);
+-- the other side of the self-join rides on order_items_line_no_uniq (order_id, line_no)
+CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id);
+
+-- +goose Down
+DROP INDEX IF EXISTS idx_order_items_product_id;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- One order-line decrement per (order, item): a retried POST /products/{id}/decrement is a no-op.
+-- Keyed by line rather than product because an order may carry the same product on several lines.
+CREATE TABLE IF NOT EXISTS decrement_ledger (
+  order_id UUID NOT NULL,
+  item_id UUID NOT NULL,
+  product_id UUID NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+  quantity INT NOT NULL,
+  at TIMESTAMP NOT NULL DEFAULT NOW(),
+  PRIMARY KEY (order_id, item_id)
+);
+
+-- +goose Down
+DROP TABLE IF EXISTS decrement_ledger;
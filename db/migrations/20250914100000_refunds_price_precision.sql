@@ -0,0 +1,6 @@
+-- +goose Up
+-- Refunds follow PRICE_DECIMALS like the totals they are taken from (see price_precision).
+ALTER TABLE refunds ALTER COLUMN amount TYPE NUMERIC;
+
+-- +goose Down
+ALTER TABLE refunds ALTER COLUMN amount TYPE NUMERIC(10,2);
@@ -15,9 +15,37 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/healthz": {
+            "get": {
+                "description": "Probes the DB, user-service (gRPC health) and product-service (/healthz) with short timeouts. 'ok' when all answer; 'degraded' (still 200) when a service dependency is down but the DB is fine; 'down' (503) when the DB is.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Health with dependencies",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
         "/orders": {
             "post": {
-                "description": "Validates user, checks stock, decrements inventory, and stores order \u0026 items.",
+                "description": "Validates user, checks stock, decrements inventory, and stores order \u0026 items. A product whose status is not active is 409.\nWith draft=true the order is stored as 'draft': stock is held until it expires or is committed.\nWith dry_run=true it validates and freezes prices but neither moves stock nor persists: 200 with the would-be order, its items and each product's would_remaining stock.\nWith AUTH_ENABLED=true a session is required and the order belongs to its user: a different body user_id is 403. With JWT_SECRET set as well, the bearer is the JWT from AuthenticateUser instead; a missing, tampered or expired one is 401.\nA body without user_id or items is 422 {\"error\":{\"code\":\"missing_fields\",\"fields\":[\"user_id\",\"items\"]}}, listing exactly the missing ones.",
                 "consumes": [
                     "application/json"
                 ],
@@ -29,6 +57,30 @@ const docTemplate = `{
                 ],
                 "summary": "Create order",
                 "parameters": [
+                    {
+                        "type": "boolean",
+                        "description": "Create as draft (holds stock, expires)",
+                        "name": "draft",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Preview only: nothing is stored and stock is not touched",
+                        "name": "dry_run",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer \u003csession_id\u003e, or Bearer \u003cjwt\u003e with JWT_SECRET (required with AUTH_ENABLED=true)",
+                        "name": "Authorization",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Retries by the same user with the same key replay the first response (IDEMPOTENCY_TTL); 409 while the first is still running",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    },
                     {
                         "description": "user_id \u0026 items",
                         "name": "body",
@@ -40,6 +92,13 @@ const docTemplate = `{
                     }
                 ],
                 "responses": {
+                    "200": {
+                        "description": "dry_run=true",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
                     "201": {
                         "description": "Created",
                         "schema": {
@@ -53,8 +112,26 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.HTTPError"
                         }
                     },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "403": {
+                        "description": "Forbidden",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
                     "409": {
-                        "description": "Conflict",
+                        "description": "insufficient stock (product_id, requested, available) or product unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/main.StockConflict"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
                         "schema": {
                             "$ref": "#/definitions/main.HTTPError"
                         }
@@ -64,40 +141,38 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/main.HTTPError"
                         }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
                     }
                 }
             }
         },
-        "/orders/user/{user_id}": {
-            "get": {
+        "/orders/merge-users": {
+            "post": {
+                "description": "Moves every order of 'from_user_id' to 'to_user_id' in one transaction, when duplicate accounts are merged. Merging a user into itself is rejected. Served only on the admin listener (ORDER_ADMIN_ADDR).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "orders"
                 ],
-                "summary": "List orders by user",
+                "summary": "Merge two users' order history (admin)",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "User ID (UUID)",
-                        "name": "user_id",
-                        "in": "path",
-                        "required": true
-                    },
-                    {
-                        "maximum": 100,
-                        "minimum": 1,
-                        "type": "integer",
-                        "default": 20,
-                        "description": "Limit (1-100)",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "minimum": 0,
-                        "type": "integer",
-                        "default": 0,
-                        "description": "Offset (\u003e=0)",
-                        "name": "offset",
-                        "in": "query"
+                        "description": "from_user_id, to_user_id (UUIDs)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/order.MergeUsersRequest"
+                        }
                     }
                 ],
                 "responses": {
@@ -108,6 +183,18 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -117,19 +204,29 @@ const docTemplate = `{
                 }
             }
         },
-        "/orders/{id}": {
+        "/orders/product/{product_id}/related": {
             "get": {
+                "description": "The products most often in the same orders as this one (drafts and canceled orders aside), with how many orders they share, most shared first. Also served at /orders/products/{product_id}/related.",
                 "tags": [
                     "orders"
                 ],
-                "summary": "Get order by ID",
+                "summary": "Products bought together with a product",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Order ID (UUID)",
-                        "name": "id",
+                        "description": "Product ID (UUID)",
+                        "name": "product_id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "maximum": 50,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 10,
+                        "description": "How many products (1-50)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -140,8 +237,14 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/main.HTTPError"
                         }
@@ -149,17 +252,18 @@ const docTemplate = `{
                 }
             }
         },
-        "/orders/{id}/items": {
+        "/orders/products/{product_id}/active-quantity": {
             "get": {
+                "description": "Sum of the product's quantities over orders that are not canceled. Used by product-service to recalculate stock.",
                 "tags": [
                     "orders"
                 ],
-                "summary": "Order items",
+                "summary": "Units of a product held by orders",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Order ID (UUID)",
-                        "name": "id",
+                        "description": "Product ID (UUID)",
+                        "name": "product_id",
                         "in": "path",
                         "required": true
                     }
@@ -172,8 +276,14 @@ const docTemplate = `{
                             "additionalProperties": true
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
                         "schema": {
                             "$ref": "#/definitions/main.HTTPError"
                         }
@@ -181,37 +291,29 @@ const docTemplate = `{
                 }
             }
         },
-        "/orders/{id}/status": {
-            "put": {
-                "consumes": [
-                    "application/json"
-                ],
-                "produces": [
-                    "application/json"
-                ],
+        "/orders/products/{product_id}/related": {
+            "get": {
+                "description": "The products most often in the same orders as this one (drafts and canceled orders aside), with how many orders they share, most shared first. Also served at /orders/products/{product_id}/related.",
                 "tags": [
                     "orders"
                 ],
-                "summary": "Update order status",
+                "summary": "Products bought together with a product",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Order ID (UUID)",
-                        "name": "id",
+                        "description": "Product ID (UUID)",
+                        "name": "product_id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "description": "status: pending|paid|canceled",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
-                        }
+                        "maximum": 50,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 10,
+                        "description": "How many products (1-50)",
+                        "name": "limit",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -228,12 +330,6 @@ const docTemplate = `{
                             "$ref": "#/definitions/main.HTTPError"
                         }
                     },
-                    "404": {
-                        "description": "Not Found",
-                        "schema": {
-                            "$ref": "#/definitions/main.HTTPError"
-                        }
-                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -243,20 +339,26 @@ const docTemplate = `{
                 }
             }
         },
-        "/products": {
+        "/orders/user/{user_id}": {
             "get": {
-                "description": "Returns a paginated list ordered by creation date. No search filter applied.",
+                "description": "One page of the user's orders, newest first, plus 'total': how many orders match the filters across all pages.\nA limit above MAX_LIST_ROWS (default 100) is cut down to it; 'truncated' is true when that cut left orders out, and 'limit' then reports the ceiling.",
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "List products (pagination only)",
+                "summary": "List orders by user",
                 "parameters": [
                     {
-                        "maximum": 100,
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
                         "minimum": 1,
                         "type": "integer",
                         "default": 20,
-                        "description": "Limit (1-100)",
+                        "description": "Limit (\u003e=1, capped at MAX_LIST_ROWS)",
                         "name": "limit",
                         "in": "query"
                     },
@@ -267,56 +369,1745 @@ const docTemplate = `{
                         "description": "Offset (\u003e=0)",
                         "name": "offset",
                         "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only orders with total \u003e= this decimal",
+                        "name": "min_total",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only orders with total \u003c= this decimal",
+                        "name": "max_total",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "draft",
+                            "pending",
+                            "paid",
+                            "shipped",
+                            "delivered",
+                            "refunded",
+                            "canceled"
+                        ],
+                        "type": "string",
+                        "description": "Only orders in this status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only orders created at or after this RFC3339 time or YYYY-MM-DD date",
+                        "name": "from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only orders created before this RFC3339 time or YYYY-MM-DD date",
+                        "name": "to",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.ListResponse"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/product.HTTPError"
+                            "$ref": "#/definitions/main.HTTPError"
                         }
                     }
                 }
-            },
+            }
+        },
+        "/orders/user/{user_id}/cancel-pending": {
             "post": {
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "For account closure or a fraud response: cancels every pending order of the user one by one, restocking each like PUT /orders/{id}/status does; orders in any other status are left alone.\n'failures' lists the orders that could not be canceled and why; the others still are. 409 when ORDER_STATUS_TRANSITIONS does not allow pending-\u003ecanceled. Served only on the admin listener (ORDER_ADMIN_ADDR).",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "products"
+                    "orders"
                 ],
-                "summary": "Create product",
+                "summary": "Cancel a user's pending orders (admin)",
                 "parameters": [
                     {
-                        "description": "name (req), price (req), description, stock",
-                        "name": "body",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/product.CreateProductRequest"
-                        }
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
                     }
                 ],
                 "responses": {
-                    "201": {
-                        "description": "Created",
+                    "200": {
+                        "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
-                    "400": {
-                        "description": "Bad Request",
+                    "409": {
+                        "description": "Conflict",
                         "schema": {
-                            "$ref": "#/definitions/product.HTTPError"
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/user/{user_id}/exists": {
+            "get": {
+                "description": "Cheap yes/no (EXISTS) for showing an \"orders\" tab, without listing or counting.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Whether a user has orders",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "boolean"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/user/{user_id}/latest": {
+            "get": {
+                "description": "The user's newest order with its items, for \"reorder last purchase\".",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Most recent order of a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/user/{user_id}/product-totals": {
+            "get": {
+                "description": "One GROUP BY over the user's paid, shipped and delivered orders: quantity, number of orders and amount spent per product, most bought first.\nWith expand=product each entry also carries product_name (soft-deleted products still resolve).",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Units bought per product by a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "product",
+                        "name": "expand",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/user/{user_id}/status-counts": {
+            "get": {
+                "description": "One GROUP BY query for profile badges; every status is present, 0 when the user has none.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Order counts per status for a user",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID (UUID)",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/validate": {
+            "post": {
+                "description": "Runs the same checks as order creation (user, items, products and their status, stock, expected_price) without mutating anything, reporting every problem at once.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Validate cart",
+                "parameters": [
+                    {
+                        "description": "user_id \u0026 items",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/order.CreateOrderRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/order.CartReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/webhook/payment": {
+            "post": {
+                "description": "Verifies the HMAC-SHA256 of the raw body (hex, header X-Signature) and marks the order paid.\nIdempotent on provider_ref: a replayed callback is a 200 no-op.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Payment provider callback",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "hex HMAC-SHA256 of the body",
+                        "name": "X-Signature",
+                        "in": "header",
+                        "required": true
+                    },
+                    {
+                        "description": "order_id, status, provider_ref",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/order.PaymentEvent"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "401": {
+                        "description": "Unauthorized",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}": {
+            "get": {
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Get order by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/commit": {
+            "post": {
+                "description": "Turns a non-expired draft into a pending order, keeping its stock hold.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Commit draft order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "410": {
+                        "description": "Gone",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/items": {
+            "get": {
+                "description": "With expand=product each item also carries the product's product_name and current_price (soft-deleted products still resolve, flagged product_deleted; null if the product is gone) and price_changed.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Order items",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "product",
+                        "name": "expand",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Prices the line like order creation (current price, optional discount, PRICE_DECIMALS), takes its stock and appends it after the last line; the total is recomputed. A canceled order takes no new lines, and a paid order's items are locked (409) while ORDER_LOCKED_FIELDS includes items.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Add an item to an order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "product_id, quantity, optional discount",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/order.CreateOrderItem"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.StockConflict"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/pay": {
+            "post": {
+                "description": "Idempotent: paying an already paid order is a 200 no-op. Stamps paid_at; a live draft is committed and paid.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Mark order paid",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/recompute-total": {
+            "post": {
+                "description": "Recalculates the total from the persisted items (same rounding as order creation, at PRICE_DECIMALS) and fixes the stored value. A paid order's total is locked (409) while ORDER_LOCKED_FIELDS includes total. Served only on the admin listener (ORDER_ADMIN_ADDR).",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Recompute order total (admin)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/refunds": {
+            "get": {
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Order refunds",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Records a refund; the order total minus prior refunds must cover 'amount', which may have up to PRICE_DECIMALS decimals like the order's prices. With restock=true the listed items' stock is given back.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Refund a paid, shipped or delivered order (partial)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "amount, reason, items, restock",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/order.CreateRefundRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/status": {
+            "put": {
+                "description": "Moves the order along ORDER_STATUS_TRANSITIONS (default pending-\u003epaid|canceled, paid-\u003eshipped|refunded|canceled, shipped-\u003edelivered|refunded, delivered-\u003erefunded); any other change is 409 and a status outside them is 422. Entering shipped / delivered stamps shipped_at / delivered_at once.\nCanceling an order that still holds stock (draft, pending, or paid and not shipped) restocks its items; for a paid order, minus what its refunds already restocked. An illegal move is 409 {\"error\":\"illegal transition paid-\u003epending\"}.\nAsking for the status the order already has follows STATUS_NOOP: ignore (200, unchanged; default), conflict (409) or touch (200, updated_at bumped).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Update order status",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "status: pending|paid|shipped|delivered|refunded|canceled (drafts: canceled only)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/orders/{id}/stock-movements": {
+            "get": {
+                "description": "The stock movements product-service recorded with this order_id, oldest first: the decrements of its creation and added items, then any cancel or refund restocks.",
+                "tags": [
+                    "orders"
+                ],
+                "summary": "Stock movements caused by an order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products": {
+            "get": {
+                "description": "Returns a paginated list ordered by creation date. No search filter applied.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "List products (pagination only)",
+                "parameters": [
+                    {
+                        "maximum": 100,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Limit (1-100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset (\u003e=0)",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only return these fields (e.g. id,name,price)",
+                        "name": "fields",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only products created at or after this RFC3339 time",
+                        "name": "created_from",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only products created at or before this RFC3339 time",
+                        "name": "created_to",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.ListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Create product",
+                "parameters": [
+                    {
+                        "description": "name (req), price (req), description, stock",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.CreateProductRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/barcode/{code}": {
+            "get": {
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get product by EAN-13 barcode",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "EAN-13 barcode",
+                        "name": "code",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/bulk-price-adjust": {
+            "post": {
+                "description": "Changes by 'percent' (decimal in (-100, 100], e.g. -10 for 10% off) the price of every product in 'category', in a single transaction. Prices keep their scale (at least cents) and each change is recorded in price history. Served only on the admin listener (PRODUCT_ADMIN_ADDR).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Bulk-adjust prices of a category",
+                "parameters": [
+                    {
+                        "description": "category, percent",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.BulkPriceAdjustRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/categories": {
+            "get": {
+                "description": "Distinct categories of (non-deleted) products with their product counts, alphabetically. Uncategorized products are left out.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "List categories",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/low-stock": {
+            "get": {
+                "description": "Products with stock \u003c= threshold, lowest stock first. Without 'threshold', each product is compared against its own low_stock_threshold.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "Low-stock report",
+                "parameters": [
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "description": "Stock threshold (\u003e=0)",
+                        "name": "threshold",
+                        "in": "query"
+                    },
+                    {
+                        "maximum": 100,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Limit (1-100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset (\u003e=0)",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.ListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/search": {
+            "get": {
+                "description": "Returns a paginated list filtered by 'q' on name/description (ILIKE; accent-insensitive when PRODUCT_SEARCH_MODE=unaccent). 'total' is the full match count, regardless of limit/offset.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "Search products (pagination + query)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search text (min 2 chars)",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "maximum": 100,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Limit (1-100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset (\u003e=0)",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only return these fields (e.g. id,name,price)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.ListResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/stock-movements": {
+            "get": {
+                "description": "Every stock movement recorded with the given order_id, across products, oldest first (its creation decrements, then cancel or refund restocks). An order that moved no stock gives an empty list.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "Stock movements of an order",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Order ID (UUID)",
+                        "name": "order_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/transfer-stock": {
+            "post": {
+                "description": "Atomically decrements 'from_id' and increments 'to_id' by 'qty'. Nothing moves if the source lacks stock. Served only on the admin listener (PRODUCT_ADMIN_ADDR).",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Transfer stock between products",
+                "parameters": [
+                    {
+                        "description": "from_id, to_id, qty (\u003e0)",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.TransferStockRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/validate-price": {
+            "post": {
+                "description": "Checks 'price' with the rules of product create and update (a non-negative decimal with at most PRICE_DECIMALS decimals) without storing anything. Always 200 for a well-formed body: {valid, normalized} or {valid: false, error}.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Validate a price",
+                "parameters": [
+                    {
+                        "description": "price",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.ValidatePriceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.PriceValidation"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}": {
+            "get": {
+                "tags": [
+                    "products"
+                ],
+                "summary": "Get product by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Also return a soft-deleted product (deleted_at set)",
+                        "name": "include_deleted",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only return these fields (e.g. id,name,price)",
+                        "name": "fields",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Version tag for If-Match on PUT"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "If 'price' is not provided, it is not modified. Empty fields do not change. 'status' (active, discontinued, out_of_stock) is independent of stock; only active products can be ordered. A stock change is recorded as a stock movement with 'stock_reason' (order, cancel, refund, adjustment; default adjustment) and optional 'order_id'. Stock may only be negative when 'allow_backorder' is set. With If-Match (the ETag from GET /products/{id}) the update only applies if the product has not changed since; otherwise 412.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Update product (partial)",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of the version being edited",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "description": "name, description, price, stock",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.UpdateProductRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/product.Product"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "412": {
+                        "description": "Precondition Failed",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Soft-deletes a product by its ID (UUID): it disappears from listings and stock changes, but GET with include_deleted=true still returns it.",
+                "tags": [
+                    "products"
+                ],
+                "summary": "Delete product by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/decrement": {
+            "post": {
+                "description": "Takes 'qty' units in a single conditional UPDATE, so concurrent orders cannot oversell: 409 when fewer are left (unless the product allows backorders). Recorded as a stock movement with 'reason' (default order) and 'order_id'. With 'item_id' (the order line) it is applied at most once per (order_id, item_id): a replay returns applied=false and changes nothing, so it is safe to retry.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Atomically take stock",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "qty (\u003e0), reason, order_id, item_id",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.StockDeltaRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/products/{id}/increment": {
+            "post": {
+                "description": "Adds 'qty' units in a single UPDATE. Recorded as a stock movement with 'reason' (default adjustment) and 'order_id'; going from 0 to positive notifies restock subscribers.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "products"
+                ],
+                "summary": "Atomically give stock back",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "qty (\u003e0), reason, order_id",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.StockDeltaRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
                         }
                     },
                     "500": {
@@ -328,44 +2119,42 @@ const docTemplate = `{
                 }
             }
         },
-        "/products/search": {
-            "get": {
-                "description": "Returns a paginated list filtered by 'q' on name/description (ILIKE).",
+        "/products/{id}/notify-me": {
+            "post": {
+                "description": "Registers an email to be notified when the product goes from 0 to positive stock.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "products"
                 ],
-                "summary": "Search products (pagination + query)",
+                "summary": "Subscribe to restock notification",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Search text (min 2 chars)",
-                        "name": "q",
-                        "in": "query",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
                         "required": true
                     },
                     {
-                        "maximum": 100,
-                        "minimum": 1,
-                        "type": "integer",
-                        "default": 20,
-                        "description": "Limit (1-100)",
-                        "name": "limit",
-                        "in": "query"
-                    },
-                    {
-                        "minimum": 0,
-                        "type": "integer",
-                        "default": 0,
-                        "description": "Offset (\u003e=0)",
-                        "name": "offset",
-                        "in": "query"
+                        "description": "email (req), user_id",
+                        "name": "body",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/product.NotifyMeRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "200": {
-                        "description": "OK",
+                    "201": {
+                        "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/product.ListResponse"
+                            "$ref": "#/definitions/product.RestockSubscription"
                         }
                     },
                     "400": {
@@ -374,6 +2163,18 @@ const docTemplate = `{
                             "$ref": "#/definitions/product.HTTPError"
                         }
                     },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -383,12 +2184,16 @@ const docTemplate = `{
                 }
             }
         },
-        "/products/{id}": {
-            "get": {
+        "/products/{id}/recalc-stock": {
+            "post": {
+                "description": "Sets stock to 'initial' minus the units held by orders that are not canceled (asked to order-service), for fixing stock after data migrations. The correction is recorded as a 'recalc' stock movement. Served only on the admin listener (PRODUCT_ADMIN_ADDR).",
+                "produces": [
+                    "application/json"
+                ],
                 "tags": [
                     "products"
                 ],
-                "summary": "Get product by ID",
+                "summary": "Recalculate stock from orders (admin)",
                 "parameters": [
                     {
                         "type": "string",
@@ -396,13 +2201,27 @@ const docTemplate = `{
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Stock before any order (\u003e= 0)",
+                        "name": "initial",
+                        "in": "query",
+                        "required": true
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
                         }
                     },
                     "404": {
@@ -410,11 +2229,31 @@ const docTemplate = `{
                         "schema": {
                             "$ref": "#/definitions/product.HTTPError"
                         }
+                    },
+                    "409": {
+                        "description": "Conflict",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "502": {
+                        "description": "Bad Gateway",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
                     }
                 }
-            },
-            "put": {
-                "description": "If 'price' is not provided, it is not modified. Empty fields do not change.",
+            }
+        },
+        "/products/{id}/restock": {
+            "post": {
+                "description": "Gives back 'qty' units released by order 'order_id'. Applied at most once per (order, product): a replay returns applied=false and changes nothing.",
                 "consumes": [
                     "application/json"
                 ],
@@ -424,7 +2263,7 @@ const docTemplate = `{
                 "tags": [
                     "products"
                 ],
-                "summary": "Update product (partial)",
+                "summary": "Restock for an order (idempotent)",
                 "parameters": [
                     {
                         "type": "string",
@@ -434,12 +2273,12 @@ const docTemplate = `{
                         "required": true
                     },
                     {
-                        "description": "name, description, price, stock",
+                        "description": "order_id, qty (\u003e0), reason",
                         "name": "body",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/product.UpdateProductRequest"
+                            "$ref": "#/definitions/product.RestockRequest"
                         }
                     }
                 ],
@@ -447,7 +2286,8 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/product.Product"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
@@ -462,6 +2302,12 @@ const docTemplate = `{
                             "$ref": "#/definitions/product.HTTPError"
                         }
                     },
+                    "422": {
+                        "description": "Unprocessable Entity",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
@@ -469,36 +2315,181 @@ const docTemplate = `{
                         }
                     }
                 }
-            },
-            "delete": {
-                "description": "Deletes a product by its ID (UUID).",
+            }
+        },
+        "/products/{id}/stock-movements": {
+            "get": {
+                "description": "Audit of a product's stock changes (orders, cancels, adjustments, transfers), newest first.",
                 "tags": [
                     "products"
                 ],
-                "summary": "Delete product by ID",
+                "summary": "Stock movement history",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Product ID (UUID)",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "maximum": 100,
+                        "minimum": 1,
+                        "type": "integer",
+                        "default": 20,
+                        "description": "Limit (1-100)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "minimum": 0,
+                        "type": "integer",
+                        "default": 0,
+                        "description": "Offset (\u003e=0)",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/product.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/daily": {
+            "get": {
+                "description": "Units and revenue per product and day (UTC), from paid orders. Read from the nightly rollup, so the current day is not included.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "reports"
+                ],
+                "summary": "Daily sales report",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "First day (YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day, inclusive (YYYY-MM-DD)",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    }
+                }
+            }
+        },
+        "/reports/daily/export": {
+            "get": {
+                "description": "The daily sales report of [from, to] as a CSV (default) or JSON download. Honors Range and If-Range against the ETag (206 Partial Content), so an interrupted download can be resumed.",
+                "produces": [
+                    "text/csv",
+                    "application/json"
+                ],
+                "tags": [
+                    "reports"
+                ],
+                "summary": "Export the daily sales report",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Product ID (UUID)",
-                        "name": "id",
-                        "in": "path",
-                        "required": true
+                        "description": "First day (YYYY-MM-DD)",
+                        "name": "from",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Last day, inclusive (YYYY-MM-DD)",
+                        "name": "to",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "csv (default) or json",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Byte range, e.g. bytes=1024-",
+                        "name": "Range",
+                        "in": "header"
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
                     },
-                    "404": {
-                        "description": "Not Found",
+                    "206": {
+                        "description": "Partial Content",
                         "schema": {
-                            "$ref": "#/definitions/product.HTTPError"
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.HTTPError"
+                        }
+                    },
+                    "416": {
+                        "description": "Requested Range Not Satisfiable",
+                        "schema": {
+                            "type": "string"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "$ref": "#/definitions/product.HTTPError"
+                            "$ref": "#/definitions/main.HTTPError"
                         }
                     }
                 }
@@ -514,9 +2505,87 @@ const docTemplate = `{
                 }
             }
         },
+        "main.StockConflict": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "integer"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "product_id": {
+                    "type": "string"
+                },
+                "requested": {
+                    "type": "integer"
+                }
+            }
+        },
+        "order.CartProblem": {
+            "type": "object",
+            "properties": {
+                "available": {
+                    "type": "integer"
+                },
+                "code": {
+                    "type": "string"
+                },
+                "current_price": {
+                    "type": "string"
+                },
+                "expected_price": {
+                    "type": "string"
+                },
+                "product_id": {
+                    "type": "string"
+                },
+                "requested": {
+                    "type": "integer"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "order.CartReport": {
+            "type": "object",
+            "properties": {
+                "problems": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.CartProblem"
+                    }
+                },
+                "total": {
+                    "type": "string"
+                },
+                "valid": {
+                    "type": "boolean"
+                }
+            }
+        },
         "order.CreateOrderItem": {
             "type": "object",
             "properties": {
+                "discount": {
+                    "description": "optional per-line promotion: percent or amount, never more than the line value",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/order.ItemDiscount"
+                        }
+                    ]
+                },
+                "expected_price": {
+                    "description": "optional: unit price the client showed; /orders/validate reports it if it changed",
+                    "type": "string",
+                    "example": "15.00"
+                },
+                "id": {
+                    "description": "optional: client-supplied line id (UUID); generated when empty",
+                    "type": "string",
+                    "example": "0b6f1f8e-8a55-4c1e-a0a4-1c2f0d1f6b7e"
+                },
                 "product_id": {
                     "type": "string",
                     "example": "4e7d4e5c-5cb9-4a3f-9f21-7e1a4f9f2b2a"
@@ -542,13 +2611,122 @@ const docTemplate = `{
                 }
             }
         },
+        "order.CreateRefundRequest": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "5.00"
+                },
+                "items": {
+                    "description": "optional: products refunded; with restock=true their stock is given back",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/order.RefundItem"
+                    }
+                },
+                "reason": {
+                    "type": "string",
+                    "example": "damaged item"
+                },
+                "restock": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "order.ItemDiscount": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "string",
+                    "example": "2.50"
+                },
+                "percent": {
+                    "type": "string",
+                    "example": "10"
+                }
+            }
+        },
+        "order.MergeUsersRequest": {
+            "type": "object",
+            "properties": {
+                "from_user_id": {
+                    "type": "string",
+                    "example": "b2f5ff47-2b1e-4f22-8a96-5f3c1f2f2e7b"
+                },
+                "to_user_id": {
+                    "type": "string",
+                    "example": "6a0c7a0e-3f7b-4d0e-9a1c-2d5e8f4b1c3a"
+                }
+            }
+        },
+        "order.PaymentEvent": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "string"
+                },
+                "provider_ref": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "provider status, \"paid\" moves the order to paid",
+                    "type": "string"
+                }
+            }
+        },
+        "order.RefundItem": {
+            "type": "object",
+            "properties": {
+                "product_id": {
+                    "type": "string"
+                },
+                "quantity": {
+                    "type": "integer"
+                }
+            }
+        },
+        "product.BulkPriceAdjustRequest": {
+            "type": "object",
+            "properties": {
+                "category": {
+                    "type": "string",
+                    "example": "Peripherals"
+                },
+                "percent": {
+                    "description": "decimal percentage in (-100, 100]; negative lowers prices",
+                    "type": "string",
+                    "example": "-10"
+                }
+            }
+        },
         "product.CreateProductRequest": {
             "type": "object",
             "properties": {
+                "allow_backorder": {
+                    "description": "optional: accept orders beyond the stock (it may go negative); default false",
+                    "type": "boolean",
+                    "example": false
+                },
+                "barcode": {
+                    "description": "optional EAN-13 (validated check digit)",
+                    "type": "string",
+                    "example": "4006381333931"
+                },
+                "category": {
+                    "description": "optional",
+                    "type": "string",
+                    "example": "Peripherals"
+                },
                 "description": {
                     "type": "string",
                     "example": "RGB 60%"
                 },
+                "low_stock_threshold": {
+                    "description": "optional, defaults to 5",
+                    "type": "integer",
+                    "example": 3
+                },
                 "name": {
                     "type": "string",
                     "example": "Mecanical Keyboard"
@@ -557,6 +2735,11 @@ const docTemplate = `{
                     "type": "string",
                     "example": "199.90"
                 },
+                "status": {
+                    "description": "optional: active (default), discontinued or out_of_stock",
+                    "type": "string",
+                    "example": "active"
+                },
                 "stock": {
                     "type": "integer",
                     "example": 10
@@ -575,8 +2758,12 @@ const docTemplate = `{
         "product.ListResponse": {
             "type": "object",
             "properties": {
+                "generated_at": {
+                    "description": "server time the list was generated (RFC3339, UTC)",
+                    "type": "string"
+                },
                 "items": {
-                    "description": "total items found",
+                    "description": "page of products",
                     "type": "array",
                     "items": {
                         "$ref": "#/definitions/product.Product"
@@ -593,21 +2780,78 @@ const docTemplate = `{
                 "q": {
                     "description": "search query applied",
                     "type": "string"
+                },
+                "total": {
+                    "description": "total products matching q, regardless of limit/offset (search only)",
+                    "type": "integer"
+                },
+                "version": {
+                    "description": "API version",
+                    "type": "string"
+                }
+            }
+        },
+        "product.NotifyMeRequest": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string",
+                    "example": "buyer@test.com"
+                },
+                "user_id": {
+                    "type": "string",
+                    "example": "b2f5ff47-2b1e-4f22-8a96-5f3c1f2f2e7b"
+                }
+            }
+        },
+        "product.PriceValidation": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "normalized": {
+                    "type": "string",
+                    "example": "19.90"
+                },
+                "valid": {
+                    "type": "boolean"
                 }
             }
         },
         "product.Product": {
             "type": "object",
             "properties": {
+                "allow_backorder": {
+                    "description": "Backorders: orders may take the stock below zero",
+                    "type": "boolean"
+                },
+                "barcode": {
+                    "description": "EAN-13, empty when the product has none",
+                    "type": "string"
+                },
+                "category": {
+                    "description": "Navigation category, empty when uncategorized",
+                    "type": "string"
+                },
                 "created_at": {
                     "type": "string"
                 },
+                "deleted_at": {
+                    "description": "Set once the product is (soft) deleted; only visible with include_deleted",
+                    "type": "string"
+                },
                 "description": {
+                    "description": "Always present: \"\" when the product has no description (NULL in the DB)",
                     "type": "string"
                 },
                 "id": {
                     "type": "string"
                 },
+                "low_stock_threshold": {
+                    "description": "Stock at or below which the product shows up in the low-stock report",
+                    "type": "integer"
+                },
                 "name": {
                     "type": "string"
                 },
@@ -615,6 +2859,14 @@ const docTemplate = `{
                     "description": "We store price as a string to avoid rounding errors (NUMERIC in Postgres)",
                     "type": "string"
                 },
+                "status": {
+                    "description": "Availability: active, discontinued or out_of_stock; only active products can be ordered",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/product.Status"
+                        }
+                    ]
+                },
                 "stock": {
                     "type": "integer"
                 },
@@ -623,20 +2875,153 @@ const docTemplate = `{
                 }
             }
         },
+        "product.RestockRequest": {
+            "type": "object",
+            "properties": {
+                "order_id": {
+                    "type": "string",
+                    "example": "0b9f3c1e-6a55-4d8e-9a0e-3f1c2d4e5f60"
+                },
+                "qty": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "reason": {
+                    "description": "optional: cancel (default) or refund",
+                    "type": "string",
+                    "example": "cancel"
+                }
+            }
+        },
+        "product.RestockSubscription": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "product_id": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "product.Status": {
+            "type": "string",
+            "enum": [
+                "active",
+                "discontinued",
+                "out_of_stock"
+            ],
+            "x-enum-comments": {
+                "StatusOutOfStock": "StatusOutOfStock marks a product temporarily unavailable, whatever its stock says"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "StatusOutOfStock marks a product temporarily unavailable, whatever its stock says"
+            ],
+            "x-enum-varnames": [
+                "StatusActive",
+                "StatusDiscontinued",
+                "StatusOutOfStock"
+            ]
+        },
+        "product.StockDeltaRequest": {
+            "type": "object",
+            "properties": {
+                "item_id": {
+                    "description": "optional, decrement only, with order_id: the order line taking the units; the decrement\nis then applied at most once per (order_id, item_id), so a client may retry it",
+                    "type": "string",
+                    "example": "0b6f1f8e-8a55-4c1e-a0a4-1c2f0d1f6b7e"
+                },
+                "order_id": {
+                    "type": "string",
+                    "example": "0b9f3c1e-6a55-4d8e-9a0e-3f1c2d4e5f60"
+                },
+                "qty": {
+                    "type": "integer",
+                    "example": 2
+                },
+                "reason": {
+                    "description": "optional: movement reason (order, cancel, refund, adjustment) and the order behind it",
+                    "type": "string",
+                    "example": "order"
+                }
+            }
+        },
+        "product.TransferStockRequest": {
+            "type": "object",
+            "properties": {
+                "from_id": {
+                    "type": "string",
+                    "example": "11111111-1111-1111-1111-111111111111"
+                },
+                "qty": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "to_id": {
+                    "type": "string",
+                    "example": "22222222-2222-2222-2222-222222222222"
+                }
+            }
+        },
         "product.UpdateProductRequest": {
             "type": "object",
             "properties": {
+                "allow_backorder": {
+                    "description": "optional: if omitted, it is not modified; with backorders stock may be negative",
+                    "type": "boolean"
+                },
+                "barcode": {
+                    "description": "optional EAN-13; empty keeps the current one",
+                    "type": "string"
+                },
+                "category": {
+                    "description": "optional; empty keeps the current one",
+                    "type": "string"
+                },
                 "description": {
                     "type": "string"
                 },
+                "low_stock_threshold": {
+                    "description": "optional: if omitted, it is not modified",
+                    "type": "integer"
+                },
                 "name": {
                     "type": "string"
                 },
+                "order_id": {
+                    "description": "optional: order that caused the stock change",
+                    "type": "string"
+                },
                 "price": {
                     "type": "string"
                 },
+                "status": {
+                    "description": "optional: active, discontinued or out_of_stock; empty keeps the current one",
+                    "type": "string"
+                },
                 "stock": {
                     "type": "integer"
+                },
+                "stock_reason": {
+                    "description": "optional: why the stock changed (order, cancel, adjustment); default adjustment",
+                    "type": "string"
+                }
+            }
+        },
+        "product.ValidatePriceRequest": {
+            "type": "object",
+            "properties": {
+                "price": {
+                    "type": "string",
+                    "example": "19.9"
                 }
             }
         }
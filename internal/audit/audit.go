@@ -0,0 +1,117 @@
+// Package audit records who changed what (products, orders, users) in an append-only log.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+)
+
+// Actions recorded.
+const (
+	ActionCreate       = "create"
+	ActionUpdate       = "update"
+	ActionDelete       = "delete"
+	ActionStatusChange = "status_change"
+)
+
+// Resource types recorded.
+const (
+	ResourceProduct = "product"
+	ResourceOrder   = "order"
+	ResourceUser    = "user"
+)
+
+// Anonymous is the actor of a change made without any identity in the context.
+const Anonymous = "anonymous"
+
+// Entry is one audited change. Detail is stored as JSON, usually the Diff of the change.
+type Entry struct {
+	Actor        string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	At           time.Time
+	Detail       any
+}
+
+// Recorder appends entries to the audit log.
+type Recorder interface {
+	Record(ctx context.Context, e Entry) error
+}
+
+type ctxKey struct{}
+
+// WithActor returns a copy of ctx carrying who is acting (e.g. "user:<id>", "ip:<addr>").
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, actor)
+}
+
+// ActorFrom returns the actor stored in ctx, or Anonymous if there is none.
+func ActorFrom(ctx context.Context) string {
+	if a, _ := ctx.Value(ctxKey{}).(string); a != "" {
+		return a
+	}
+	return Anonymous
+}
+
+// Log records e with r, best-effort: the change already happened, so a failure is only
+// logged. Actor and At default to the context's actor and now. A nil r records nothing.
+func Log(ctx context.Context, r Recorder, e Entry) {
+	if r == nil {
+		return
+	}
+	if e.Actor == "" {
+		e.Actor = ActorFrom(ctx)
+	}
+	if e.At.IsZero() {
+		e.At = time.Now()
+	}
+	if err := r.Record(ctx, e); err != nil {
+		log.Printf("[audit] %s %s %s by %s error: %v", e.Action, e.ResourceType, e.ResourceID, e.Actor, err)
+	}
+}
+
+// Change is a field's value before and after; From is omitted on creation.
+type Change struct {
+	From any `json:"from,omitempty"`
+	To   any `json:"to,omitempty"`
+}
+
+// Diff compares the JSON forms of before and after and returns the top-level fields that
+// differ. A nil before lists every field of after (a creation). Fields in ignore (e.g.
+// updated_at) are left out.
+func Diff(before, after any, ignore ...string) map[string]Change {
+	b, a := jsonFields(before), jsonFields(after)
+	skip := make(map[string]bool, len(ignore))
+	for _, k := range ignore {
+		skip[k] = true
+	}
+	out := map[string]Change{}
+	for k, v := range a {
+		if !skip[k] && !reflect.DeepEqual(b[k], v) {
+			out[k] = Change{From: b[k], To: v}
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok && !skip[k] {
+			out[k] = Change{From: v}
+		}
+	}
+	return out
+}
+
+func jsonFields(v any) map[string]any {
+	out := map[string]any{}
+	if v == nil || reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil() {
+		return out
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return out
+	}
+	_ = json.Unmarshal(raw, &out)
+	return out
+}
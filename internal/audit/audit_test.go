@@ -0,0 +1,103 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type product struct {
+	Name      string    `json:"name"`
+	Price     string    `json:"price"`
+	Stock     int       `json:"stock"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func TestDiff(t *testing.T) {
+	before := &product{Name: "Lamp", Price: "10.00", Stock: 2, UpdatedAt: time.Unix(1, 0)}
+	after := &product{Name: "Lamp", Price: "12.50", Stock: 2, UpdatedAt: time.Unix(2, 0)}
+
+	got, _ := json.Marshal(Diff(before, after, "updated_at"))
+	if string(got) != `{"price":{"from":"10.00","to":"12.50"}}` {
+		t.Fatalf("update diff=%s, want only the price change", got)
+	}
+
+	var none *product
+	created := Diff(none, after, "updated_at")
+	if len(created) != 3 || created["name"].From != nil || created["name"].To != "Lamp" {
+		t.Fatalf("create diff=%+v, want every field with only 'to'", created)
+	}
+
+	if d := Diff(after, after); len(d) != 0 {
+		t.Fatalf("no-op diff=%+v, want empty", d)
+	}
+}
+
+type memRecorder struct {
+	entries []Entry
+	err     error
+}
+
+func (m *memRecorder) Record(_ context.Context, e Entry) error {
+	m.entries = append(m.entries, e)
+	return m.err
+}
+
+func TestLog(t *testing.T) {
+	rec := &memRecorder{}
+	Log(context.Background(), rec, Entry{Action: ActionDelete, ResourceType: ResourceProduct, ResourceID: "p1"})
+	Log(WithActor(context.Background(), "user:u1"), rec, Entry{Action: ActionCreate, ResourceType: ResourceOrder, ResourceID: "o1"})
+	if len(rec.entries) != 2 {
+		t.Fatalf("entries=%d, want 2", len(rec.entries))
+	}
+	if rec.entries[0].Actor != Anonymous || rec.entries[1].Actor != "user:u1" {
+		t.Fatalf("actors=%q,%q", rec.entries[0].Actor, rec.entries[1].Actor)
+	}
+	if rec.entries[0].At.IsZero() {
+		t.Fatal("At not set")
+	}
+
+	// best-effort: a failing or missing recorder never panics nor fails the caller
+	Log(context.Background(), &memRecorder{err: errors.New("down")}, Entry{Action: ActionUpdate})
+	Log(context.Background(), nil, Entry{Action: ActionUpdate})
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/audit
+func TestPGRecorder_AppendOnly(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+
+	id := uuid.NewString()
+	e := Entry{Actor: "user:u1", Action: ActionUpdate, ResourceType: ResourceProduct, ResourceID: id, At: time.Now(),
+		Detail: map[string]Change{"price": {From: "10.00", To: "12.50"}}}
+	if err := NewPGRecorder(pool).Record(ctx, e); err != nil {
+		t.Fatalf("record: %v", err)
+	}
+	var actor, detail string
+	if err := pool.QueryRow(ctx, `SELECT actor, detail_json::text FROM audit_log WHERE resource_id=$1`, id).Scan(&actor, &detail); err != nil {
+		t.Fatalf("select: %v", err)
+	}
+	if actor != "user:u1" || detail != `{"price": {"to": "12.50", "from": "10.00"}}` {
+		t.Fatalf("row actor=%q detail=%s", actor, detail)
+	}
+	if _, err := pool.Exec(ctx, `UPDATE audit_log SET actor='x' WHERE resource_id=$1`, id); err == nil {
+		t.Fatal("update succeeded, want the append-only trigger to reject it")
+	}
+	if _, err := pool.Exec(ctx, `DELETE FROM audit_log WHERE resource_id=$1`, id); err == nil {
+		t.Fatal("delete succeeded, want the append-only trigger to reject it")
+	}
+}
@@ -0,0 +1,43 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+// DB is the subset of *pgxpool.Pool the recorder uses.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+// PGRecorder appends entries to the audit_log table (append-only: a trigger rejects
+// updates and deletes), scoped by the context's tenant.
+type PGRecorder struct {
+	db DB
+}
+
+func NewPGRecorder(db DB) *PGRecorder { return &PGRecorder{db: db} }
+
+func (r *PGRecorder) Record(ctx context.Context, e Entry) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var detail []byte
+	if e.Detail != nil {
+		b, err := json.Marshal(e.Detail)
+		if err != nil {
+			return err
+		}
+		detail = b
+	}
+	_, err := r.db.Exec(ctx, `
+		INSERT INTO audit_log (tenant_id, actor, action, resource_type, resource_id, at, detail_json)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+	`, tenant.From(ctx), e.Actor, e.Action, e.ResourceType, e.ResourceID, e.At, detail)
+	return err
+}
@@ -0,0 +1,14 @@
+// Package buildinfo holds the build metadata, stamped at link time:
+//
+//	go build -ldflags "-X github.com/MikeMC777/ordenes-ecom/internal/buildinfo.Version=1.4.0 \
+//	  -X github.com/MikeMC777/ordenes-ecom/internal/buildinfo.Commit=$(git rev-parse --short HEAD) \
+//	  -X github.com/MikeMC777/ordenes-ecom/internal/buildinfo.BuildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)" ./cmd/user-service
+//
+// Unstamped builds report the dev defaults below.
+package buildinfo
+
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildTime = "unknown"
+)
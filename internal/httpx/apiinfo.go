@@ -0,0 +1,33 @@
+package httpx
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Endpoint is a route registered on the router.
+type Endpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// APIInfo answers GET /api-info with the service name, the API version and the routes
+// registered on r. The routes are read per request, so ones added after it are listed too.
+func APIInfo(r *gin.Engine, service string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		routes := r.Routes()
+		eps := make([]Endpoint, 0, len(routes))
+		for _, rt := range routes {
+			eps = append(eps, Endpoint{Method: rt.Method, Path: rt.Path})
+		}
+		sort.Slice(eps, func(i, j int) bool {
+			if eps[i].Path != eps[j].Path {
+				return eps[i].Path < eps[j].Path
+			}
+			return eps[i].Method < eps[j].Method
+		})
+		c.JSON(http.StatusOK, gin.H{"service": service, "api_version": Version, "endpoints": eps})
+	}
+}
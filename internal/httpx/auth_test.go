@@ -0,0 +1,87 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/jwt"
+)
+
+func TestRequireAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	r := gin.New()
+	r.GET("/me", RequireAuth(secret, 0), func(c *gin.Context) {
+		id, _ := AuthUser(c)
+		c.String(http.StatusOK, id)
+	})
+	get := func(auth string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	sign := func(key string, c jwt.Claims) string {
+		tok, err := jwt.Sign([]byte(key), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tok
+	}
+
+	valid := sign(secret, jwt.New("user-1", time.Now(), time.Minute))
+	if w := get("Bearer " + valid); w.Code != http.StatusOK || w.Body.String() != "user-1" {
+		t.Fatalf("valid token: status=%d body=%s", w.Code, w.Body.String())
+	}
+
+	// the signature of a token for user-1 over a payload naming someone else
+	other := sign("guess", jwt.New("admin", time.Now(), time.Minute))
+	tampered := strings.Join([]string{strings.Split(valid, ".")[0], strings.Split(other, ".")[1], strings.Split(valid, ".")[2]}, ".")
+
+	cases := map[string]struct {
+		auth, msg string
+	}{
+		"missing":      {"", "authentication required"},
+		"not bearer":   {"Basic dXNlcjpwYXNz", "authentication required"},
+		"expired":      {"Bearer " + sign(secret, jwt.New("user-1", time.Now().Add(-time.Hour), time.Minute)), "token expired"},
+		"tampered":     {"Bearer " + tampered, "invalid token"},
+		"other secret": {"Bearer " + other, "invalid token"},
+		"garbage":      {"Bearer abc", "invalid token"},
+	}
+	for name, tc := range cases {
+		w := get(tc.auth)
+		if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), tc.msg) {
+			t.Fatalf("%s: status=%d body=%s, want 401 %q", name, w.Code, w.Body.String(), tc.msg)
+		}
+	}
+
+	// JWT_LEEWAY: a token a few seconds past exp still passes, one past the window doesn't
+	lenient := gin.New()
+	lenient.GET("/me", RequireAuth(secret, 30*time.Second), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	for _, tc := range []struct {
+		expiredAgo time.Duration
+		want       int
+	}{
+		{10 * time.Second, http.StatusOK},
+		{time.Minute, http.StatusUnauthorized},
+	} {
+		tok := sign(secret, jwt.New("user-1", time.Now().Add(-time.Minute-tc.expiredAgo), time.Minute))
+		req := httptest.NewRequest(http.MethodGet, "/me", nil)
+		req.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		lenient.ServeHTTP(w, req)
+		if w.Code != tc.want {
+			t.Fatalf("expired %s ago, 30s leeway: status=%d, want %d", tc.expiredAgo, w.Code, tc.want)
+		}
+	}
+}
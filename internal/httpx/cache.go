@@ -0,0 +1,57 @@
+package httpx
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+// NoStore marks mutating (non GET/HEAD) and authenticated responses as not cacheable,
+// so no shared cache keeps a write result or someone else's data.
+func NoStore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !readOnly(c.Request) || c.GetHeader("Authorization") != "" {
+			c.Header("Cache-Control", "no-store")
+		}
+		c.Next()
+	}
+}
+
+// PublicCache lets shared caches (CDNs) keep a route's successful responses for
+// maxAgeSeconds. Errors and authenticated requests are not marked, and the response varies
+// by tenant and by Accept (JSONCase switches the key casing on it). A maxAgeSeconds <= 0
+// disables it.
+func PublicCache(maxAgeSeconds int) gin.HandlerFunc {
+	value := "public, max-age=" + strconv.Itoa(maxAgeSeconds)
+	return func(c *gin.Context) {
+		if maxAgeSeconds <= 0 || !readOnly(c.Request) || c.GetHeader("Authorization") != "" {
+			c.Next()
+			return
+		}
+		cw := &cacheWriter{ResponseWriter: c.Writer, value: value}
+		c.Writer = cw
+		c.Next()
+		c.Writer = cw.ResponseWriter
+	}
+}
+
+func readOnly(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
+
+// cacheWriter sets Cache-Control once the status is known: only a 200 is cacheable.
+type cacheWriter struct {
+	gin.ResponseWriter
+	value string
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if code == http.StatusOK && !w.Written() {
+		w.Header().Set("Cache-Control", w.value)
+		w.Header().Add("Vary", tenant.Header+", Accept")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,51 @@
+package httpx
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConcurrencyLimit caps the requests in flight through it at max, answering 503 with
+// Retry-After to the overflow instead of queueing it. Share one instance between the
+// routes of a group so they draw from the same cap. max <= 0 disables it.
+func ConcurrencyLimit(max int) gin.HandlerFunc {
+	if max <= 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	sem := make(chan struct{}, max)
+	return func(c *gin.Context) {
+		select {
+		case sem <- struct{}{}:
+		default:
+			c.Header("Retry-After", "1")
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "too many concurrent requests, retry later"})
+			return
+		}
+		defer func() { <-sem }()
+		c.Next()
+	}
+}
+
+// ParseConcurrencyLimits reads "group=max,group=max" (e.g. "search=20,reports=4").
+// Malformed entries are logged and skipped; a group left out has no cap.
+func ParseConcurrencyLimits(s string) map[string]int {
+	out := map[string]int{}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		group, v, ok := strings.Cut(entry, "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || strings.TrimSpace(group) == "" || err != nil || n < 0 {
+			log.Printf("[concurrency] invalid limit %q, ignored", entry)
+			continue
+		}
+		out[strings.TrimSpace(group)] = n
+	}
+	return out
+}
@@ -0,0 +1,88 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestConcurrencyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	const max = 2
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	r := gin.New()
+	limit := ConcurrencyLimit(max)
+	r.GET("/slow", limit, func(c *gin.Context) {
+		entered <- struct{}{}
+		<-release
+		c.Status(http.StatusOK)
+	})
+	r.GET("/fast", limit, func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	// saturate the cap with requests blocked in the handler
+	var wg sync.WaitGroup
+	codes := make([]int, max)
+	for i := 0; i < max; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			codes[i] = w.Code
+		}(i)
+		<-entered
+	}
+
+	// overflow, on any route of the group: 503 right away
+	for _, path := range []string{"/slow", "/fast"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") == "" {
+			t.Fatalf("%s over the cap: status=%d retry-after=%q, want 503", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d under the cap: status=%d", i, code)
+		}
+	}
+
+	// slots are given back
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("after release: status=%d", w.Code)
+	}
+}
+
+func TestConcurrencyLimit_Disabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	r := gin.New()
+	r.GET("/", ConcurrencyLimit(0), func(c *gin.Context) { c.Status(http.StatusOK) })
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status=%d", w.Code)
+	}
+}
+
+func TestParseConcurrencyLimits(t *testing.T) {
+	got := ParseConcurrencyLimits(" search=20, reports = 4,bad,=3,neg=-1,x=y,")
+	want := map[string]int{"search": 20, "reports": 4}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	if got := ParseConcurrencyLimits(""); len(got) != 0 {
+		t.Fatalf("empty: got %v", got)
+	}
+}
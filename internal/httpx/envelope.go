@@ -0,0 +1,18 @@
+package httpx
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Version is the API version reported in list responses (override with -ldflags "-X ...").
+var Version = "1.0"
+
+// ListEnvelope adds the server timestamp and API version to a list response body,
+// so clients can detect stale cached lists. Existing keys are left untouched.
+func ListEnvelope(body gin.H) gin.H {
+	body["generated_at"] = time.Now().UTC().Format(time.RFC3339)
+	body["version"] = Version
+	return body
+}
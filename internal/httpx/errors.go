@@ -0,0 +1,31 @@
+package httpx
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Status convention for request errors:
+//   - 400 Bad Request: the request cannot be parsed (malformed JSON, wrong types, bad query/path values).
+//   - 422 Unprocessable Entity: the body is well-formed but breaks a rule (missing field, invalid quantity, amount...).
+//   - 409 Conflict: the request is valid but clashes with current state (insufficient stock, duplicates).
+
+// BindJSON decodes the body into v; when it cannot be parsed it answers 400 and returns false.
+func BindJSON(c *gin.Context, v any) bool {
+	if err := c.ShouldBindJSON(v); err != nil {
+		BadRequest(c, "invalid json")
+		return false
+	}
+	return true
+}
+
+// BadRequest answers 400 for input that cannot be parsed.
+func BadRequest(c *gin.Context, msg string) {
+	c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": msg})
+}
+
+// Unprocessable answers 422 for a well-formed request that fails validation.
+func Unprocessable(c *gin.Context, msg string) {
+	c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": msg})
+}
@@ -0,0 +1,24 @@
+package httpx
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServeExport sends a generated export as a download named filename. It answers Range
+// requests (Accept-Ranges: bytes, 206 Partial Content, 416 outside the body) so an
+// interrupted download can be resumed, and sets a strong ETag from the content so a client
+// resuming with If-Range gets the whole file again if the export changed meanwhile. body must
+// be the same bytes for the same request: an export carries no generated_at.
+func ServeExport(c *gin.Context, filename, contentType string, body []byte) {
+	sum := sha256.Sum256(body)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Header("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(c.Writer, c.Request, filename, time.Time{}, bytes.NewReader(body))
+}
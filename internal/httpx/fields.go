@@ -0,0 +1,101 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ParseFields parses a sparse fieldset (?fields=id,name,price) against the allowed keys.
+// An empty value returns nil, meaning "all fields"; an unknown field is an error.
+func ParseFields(raw string, allowed []string) ([]string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return nil, nil
+	}
+	known := make(map[string]bool, len(allowed))
+	for _, f := range allowed {
+		known[f] = true
+	}
+	var fields []string
+	seen := map[string]bool{}
+	for _, f := range strings.Split(raw, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		if !known[f] {
+			return nil, fmt.Errorf("unknown field %q", f)
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// Project keeps only the given top-level JSON keys of v (or of each element when v is a slice).
+// nil fields returns v unchanged.
+func Project(v any, fields []string) (any, error) {
+	if fields == nil {
+		return v, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	switch t := doc.(type) {
+	case []any:
+		for i := range t {
+			t[i] = pick(t[i], fields)
+		}
+		return t, nil
+	default:
+		return pick(t, fields), nil
+	}
+}
+
+func pick(v any, fields []string) any {
+	m, ok := v.(map[string]any)
+	if !ok {
+		return v
+	}
+	out := make(map[string]any, len(fields))
+	for _, f := range fields {
+		if val, ok := m[f]; ok {
+			out[f] = val
+		}
+	}
+	return out
+}
+
+// JSONFields lists the JSON keys of a struct value, in declaration order.
+func JSONFields(v any) []string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var out []string
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(sf.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		out = append(out, name)
+	}
+	return out
+}
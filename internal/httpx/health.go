@@ -0,0 +1,67 @@
+package httpx
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Overall and per-check states reported by Health.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+// HealthCheck is one dependency probed by Health.
+type HealthCheck struct {
+	Name string
+	// Critical means the service can't work without it: failing it is down, not degraded
+	Critical bool
+	Probe    func(ctx context.Context) error
+}
+
+// Health answers {status, checks} after probing every check concurrently, each bounded by
+// timeout. status is ok when all pass, degraded when only non-critical ones fail (200 in
+// both cases, the service still answers) and down with 503 when a critical one fails.
+func Health(timeout time.Duration, checks ...HealthCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		results := make([]error, len(checks))
+		var wg sync.WaitGroup
+		for i, hc := range checks {
+			wg.Add(1)
+			go func(i int, hc HealthCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+				defer cancel()
+				results[i] = hc.Probe(ctx)
+			}(i, hc)
+		}
+		wg.Wait()
+
+		status := HealthOK
+		out := make(map[string]string, len(checks))
+		for i, hc := range checks {
+			if results[i] == nil {
+				out[hc.Name] = HealthOK
+				continue
+			}
+			log.Printf("[health] %s: %v", hc.Name, results[i])
+			out[hc.Name] = HealthDown
+			if hc.Critical {
+				status = HealthDown
+			} else if status == HealthOK {
+				status = HealthDegraded
+			}
+		}
+		code := http.StatusOK
+		if status == HealthDown {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, gin.H{"status": status, "checks": out})
+	}
+}
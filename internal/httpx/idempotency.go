@@ -0,0 +1,110 @@
+package httpx
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/idempotency"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+const (
+	// IdempotencyHeader carries the client's key for a retryable write.
+	IdempotencyHeader = "Idempotency-Key"
+	// IdempotencyReplayedHeader is set on a response served from the store.
+	IdempotencyReplayedHeader = "Idempotency-Replayed"
+
+	maxIdempotencyKeyLen = 255
+)
+
+// Idempotency replays the stored response when a request repeats an Idempotency-Key
+// (scoped by tenant, caller, method, route and query string), so a client retrying after a
+// timeout doesn't apply the write twice and a ?dry_run=true preview never answers for the
+// real write. caller, when not nil, names who sends the request (e.g. its user) so two callers
+// reusing a key never get each other's response. While the first request with a key is still
+// running a repeat is 409. Requests without the header go through untouched. 5xx responses
+// are not stored, so those stay retryable; store errors fail open and are logged.
+func Idempotency(store idempotency.Store, caller func(*gin.Context) string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLen {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+		scope := tenant.From(c.Request.Context())
+		if caller != nil {
+			scope += "|" + caller(c)
+		}
+		scoped := scope + "|" + c.Request.Method + " " + c.FullPath() + "?" + c.Request.URL.RawQuery + "|" + key
+
+		if replay(c, store, scoped) {
+			return
+		}
+		reserved, err := store.Reserve(c.Request.Context(), scoped)
+		if err != nil {
+			log.Printf("[idempotency] reserve error: %v", err)
+		} else if !reserved {
+			// the first request finished meanwhile, or is still running
+			if replay(c, store, scoped) {
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "idempotent request in progress"})
+			return
+		}
+
+		rw := &recordWriter{ResponseWriter: c.Writer}
+		c.Writer = rw
+		c.Next()
+		c.Writer = rw.ResponseWriter
+
+		if status := rw.Status(); status < http.StatusInternalServerError {
+			resp := idempotency.Response{Status: status, ContentType: rw.Header().Get("Content-Type"), Body: rw.body.Bytes()}
+			if err := store.Save(c.Request.Context(), scoped, resp); err != nil {
+				log.Printf("[idempotency] save error: %v", err)
+			}
+		} else if reserved {
+			if err := store.Release(c.Request.Context(), scoped); err != nil {
+				log.Printf("[idempotency] release error: %v", err)
+			}
+		}
+	}
+}
+
+// replay answers with the response stored for key, if any.
+func replay(c *gin.Context, store idempotency.Store, key string) bool {
+	prev, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		log.Printf("[idempotency] get error: %v", err)
+		return false
+	}
+	if prev == nil {
+		return false
+	}
+	c.Header(IdempotencyReplayedHeader, "true")
+	c.Data(prev.Status, prev.ContentType, prev.Body)
+	c.Abort()
+	return true
+}
+
+// recordWriter keeps a copy of the body written through it.
+type recordWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *recordWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,107 @@
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JSONCase re-serializes JSON responses with camelCase keys when the client asks
+// for it with ?case=camel or an Accept parameter (Accept: application/json; case=camel).
+// Models keep their snake_case tags; the default output is unchanged.
+func JSONCase() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !wantsCamel(c) {
+			c.Next()
+			return
+		}
+		bw := &bufferedWriter{ResponseWriter: c.Writer}
+		c.Writer = bw
+		c.Next()
+		c.Writer = bw.ResponseWriter
+
+		body := bw.buf.Bytes()
+		// exports (ServeExport) stay byte-for-byte as generated, so their ranges line up
+		attachment := strings.HasPrefix(bw.Header().Get("Content-Disposition"), "attachment")
+		if len(body) > 0 && !attachment && strings.HasPrefix(bw.Header().Get("Content-Type"), "application/json") {
+			if out, err := camelizeJSON(body); err == nil {
+				body = out
+			}
+		}
+		bw.Header().Del("Content-Length")
+		if len(body) == 0 {
+			bw.ResponseWriter.WriteHeaderNow()
+			return
+		}
+		_, _ = bw.ResponseWriter.Write(body)
+	}
+}
+
+func wantsCamel(c *gin.Context) bool {
+	if strings.EqualFold(c.Query("case"), "camel") {
+		return true
+	}
+	for _, part := range strings.Split(c.GetHeader("Accept"), ",") {
+		if _, params, err := mime.ParseMediaType(strings.TrimSpace(part)); err == nil && strings.EqualFold(params["case"], "camel") {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedWriter holds the body until the handler finishes so it can be reshaped.
+type bufferedWriter struct {
+	gin.ResponseWriter
+	buf bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error)       { return w.buf.Write(b) }
+func (w *bufferedWriter) WriteString(s string) (int, error) { return w.buf.WriteString(s) }
+
+func camelizeJSON(b []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return json.Marshal(camelizeKeys(v))
+}
+
+func camelizeKeys(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, val := range t {
+			out[ToCamel(k)] = camelizeKeys(val)
+		}
+		return out
+	case []any:
+		for i := range t {
+			t[i] = camelizeKeys(t[i])
+		}
+		return t
+	default:
+		return v
+	}
+}
+
+// ToCamel converts a snake_case key to camelCase ("created_at" -> "createdAt").
+func ToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	parts := strings.Split(s, "_")
+	var b strings.Builder
+	b.WriteString(parts[0])
+	for _, p := range parts[1:] {
+		if p == "" {
+			continue
+		}
+		b.WriteString(strings.ToUpper(p[:1]) + p[1:])
+	}
+	return b.String()
+}
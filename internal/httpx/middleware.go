@@ -1,21 +1,39 @@
 package httpx
 
 import (
+	"context"
 	"log"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 )
 
+// ContextWithRequestID returns a copy of ctx carrying the request ID id. It is what RequestID
+// stores, and what Ext forwards to product-service (X-Request-ID) and user-service (metadata).
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return reqid.With(ctx, id)
+}
+
+// RequestIDFromContext returns the request ID stored in ctx, or "" if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	return reqid.From(ctx)
+}
+
 func RequestID() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		rid := c.GetHeader("X-Request-ID")
+		rid := c.GetHeader(reqid.Header)
 		if rid == "" {
 			rid = uuid.NewString()
 		}
 		c.Set("rid", rid)
-		c.Writer.Header().Set("X-Request-ID", rid)
+		// also on the request context, so outbound calls (Ext) can propagate it
+		c.Request = c.Request.WithContext(ContextWithRequestID(c.Request.Context(), rid))
+		c.Writer.Header().Set(reqid.Header, rid)
 		c.Next()
 	}
 }
@@ -25,7 +43,30 @@ func Logger() gin.HandlerFunc {
 		start := time.Now()
 		c.Next()
 		rid, _ := c.Get("rid")
-		log.Printf("[http] rid=%v %s %s status=%d dur=%s",
-			rid, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(start))
+		log.Printf("[http] rid=%v %s %s status=%d dur=%s ua=%q",
+			rid, c.Request.Method, c.Request.URL.Path, c.Writer.Status(), time.Since(start), c.Request.UserAgent())
+	}
+}
+
+// Tenant resolves the caller's tenant from the X-Tenant-ID header and stores it on the
+// request context. With required set (MULTI_TENANT=true) a request without one gets 400.
+func Tenant(required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, err := tenant.Resolve(c.GetHeader(tenant.Header), required)
+		if err != nil {
+			BadRequest(c, err.Error())
+			return
+		}
+		c.Request = c.Request.WithContext(tenant.With(c.Request.Context(), id))
+		c.Next()
+	}
+}
+
+// AuditActor attributes the request's changes to the client address ("ip:<addr>") in the
+// audit log, until a handler that authenticates the caller replaces it with the user.
+func AuditActor() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(audit.WithActor(c.Request.Context(), "ip:"+c.ClientIP()))
+		c.Next()
 	}
 }
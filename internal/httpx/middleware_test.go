@@ -0,0 +1,47 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+)
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var inCtx string
+	r := gin.New()
+	r.Use(RequestID(), Logger(), gin.Recovery())
+	r.GET("/", func(c *gin.Context) {
+		inCtx = reqid.From(c.Request.Context())
+		c.Status(http.StatusNoContent)
+	})
+
+	get := func(rid string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if rid != "" {
+			req.Header.Set(reqid.Header, rid)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	// without one, every request gets a fresh id, in the response and on the context
+	first := get("").Header().Get(reqid.Header)
+	if _, err := uuid.Parse(first); err != nil || inCtx != first {
+		t.Fatalf("generated %s=%q (context %q), want a UUID on both", reqid.Header, first, inCtx)
+	}
+	if second := get("").Header().Get(reqid.Header); second == first {
+		t.Fatalf("two requests share the id %q", first)
+	}
+
+	// an inbound id is echoed back and carried on to outbound calls
+	if got := get("edge-rid-42").Header().Get(reqid.Header); got != "edge-rid-42" || inCtx != "edge-rid-42" {
+		t.Fatalf("echoed %s=%q (context %q), want edge-rid-42", reqid.Header, got, inCtx)
+	}
+}
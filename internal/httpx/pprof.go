@@ -0,0 +1,63 @@
+package httpx
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// Pprof returns the net/http/pprof handlers under /debug/pprof/ when enabled, and a handler
+// that answers 404 to everything otherwise. It is meant for the admin listener started by
+// StartAdmin, never the public router.
+func Pprof(enabled bool) http.Handler {
+	mux := http.NewServeMux()
+	if !enabled {
+		return mux
+	}
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
+// Admin is the admin listener's handler: GET /admin/config answering config as JSON, Pprof
+// when withPprof, and routes, when not nil, for every other path (the service's admin
+// endpoints, which must never be on the public router). config must already be redacted
+// (config.Config.Redacted): it is shown as given.
+func Admin(config any, withPprof bool, routes http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/debug/pprof/", Pprof(withPprof))
+	mux.HandleFunc("/admin/config", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(config)
+	})
+	if routes != nil {
+		mux.Handle("/", routes)
+	}
+	return mux
+}
+
+// StartAdmin serves h (see Admin) on its own listener at addr and returns the server to shut
+// down. It has no write timeout: CPU profiles and traces stream for as long as ?seconds= asks.
+func StartAdmin(addr, service string, h http.Handler) *http.Server {
+	srv := &http.Server{Addr: addr, Handler: h, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		log.Printf("[admin] %s admin listener on http://%s", service, addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[admin] error: %v", err)
+		}
+	}()
+	return srv
+}
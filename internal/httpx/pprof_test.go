@@ -0,0 +1,85 @@
+package httpx
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPprof(t *testing.T) {
+	paths := []string{"/debug/pprof/", "/debug/pprof/cmdline", "/debug/pprof/symbol", "/debug/pprof/heap", "/debug/pprof/goroutine?debug=1"}
+
+	on := Pprof(true)
+	for _, p := range paths {
+		w := httptest.NewRecorder()
+		on.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("enabled: GET %s status=%d", p, w.Code)
+		}
+	}
+
+	off := Pprof(false)
+	for _, p := range paths {
+		w := httptest.NewRecorder()
+		off.ServeHTTP(w, httptest.NewRequest(http.MethodGet, p, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("disabled: GET %s status=%d, want 404", p, w.Code)
+		}
+	}
+}
+
+func TestAdmin_Config(t *testing.T) {
+	cfg := struct {
+		OrderSvcAddr string
+		PostgresDSN  string
+		JWTSecret    string
+	}{":8082", "postgres://app:xxxxx@db:5432/ordenesdb", "[REDACTED]"}
+	h := Admin(cfg, true, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/admin/config", nil))
+	var got map[string]string
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &got) != nil {
+		t.Fatalf("status=%d body=%s", w.Code, w.Body.String())
+	}
+	if got["OrderSvcAddr"] != ":8082" || got["PostgresDSN"] != cfg.PostgresDSN || got["JWTSecret"] != "[REDACTED]" {
+		t.Fatalf("config=%v", got)
+	}
+	if cc := w.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Fatalf("Cache-Control=%q", cc)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/config", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST status=%d, want 405", w.Code)
+	}
+	// pprof still answers next to it
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("pprof status=%d", w.Code)
+	}
+}
+
+func TestAdmin_Routes(t *testing.T) {
+	routes := http.NewServeMux()
+	routes.HandleFunc("/orders/merge-users", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	h := Admin(nil, false, routes)
+
+	for path, want := range map[string]int{
+		"/orders/merge-users":  http.StatusAccepted,
+		"/admin/config":        http.StatusOK,
+		"/debug/pprof/cmdline": http.StatusNotFound, // pprof off
+		"/orders/unknown":      http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Fatalf("%s: status=%d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package httpx
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/ratelimit"
+)
+
+// RateLimit rejects clients (by IP) over the limiter's quota with 429 and Retry-After.
+// Limiter errors fail open: the request goes through and the error is logged.
+func RateLimit(l ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, retryAfter, err := l.Allow(c.Request.Context(), c.ClientIP())
+		if err != nil {
+			log.Printf("[ratelimit] error: %v", err)
+			c.Next()
+			return
+		}
+		if !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+		c.Next()
+	}
+}
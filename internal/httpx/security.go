@@ -0,0 +1,126 @@
+package httpx
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+// DefaultContentSecurityPolicy keeps everything same-origin but allows the inline
+// script/style and data: images the swagger UI under /docs relies on.
+const DefaultContentSecurityPolicy = "default-src 'self'; script-src 'self' 'unsafe-inline'; " +
+	"style-src 'self' 'unsafe-inline'; img-src 'self' data:; frame-ancestors 'none'"
+
+type securityHeaders struct {
+	frameOptions   string
+	csp            string
+	referrerPolicy string
+}
+
+// SecurityOption overrides one of the SecurityHeaders defaults. Empty values keep the default.
+type SecurityOption func(*securityHeaders)
+
+// WithFrameOptions sets X-Frame-Options (X_FRAME_OPTIONS; default DENY).
+func WithFrameOptions(v string) SecurityOption {
+	return func(s *securityHeaders) {
+		if v != "" {
+			s.frameOptions = v
+		}
+	}
+}
+
+// WithContentSecurityPolicy sets Content-Security-Policy (CONTENT_SECURITY_POLICY; default
+// DefaultContentSecurityPolicy).
+func WithContentSecurityPolicy(v string) SecurityOption {
+	return func(s *securityHeaders) {
+		if v != "" {
+			s.csp = v
+		}
+	}
+}
+
+// WithReferrerPolicy sets Referrer-Policy (REFERRER_POLICY; default no-referrer).
+func WithReferrerPolicy(v string) SecurityOption {
+	return func(s *securityHeaders) {
+		if v != "" {
+			s.referrerPolicy = v
+		}
+	}
+}
+
+// SecurityHeaders sets X-Content-Type-Options, X-Frame-Options, Referrer-Policy and
+// Content-Security-Policy on every response. Register it before the /docs route so
+// the swagger UI gets them too.
+func SecurityHeaders(opts ...SecurityOption) gin.HandlerFunc {
+	s := securityHeaders{
+		frameOptions:   "DENY",
+		csp:            DefaultContentSecurityPolicy,
+		referrerPolicy: "no-referrer",
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return func(c *gin.Context) {
+		h := c.Writer.Header()
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", s.frameOptions)
+		h.Set("Referrer-Policy", s.referrerPolicy)
+		h.Set("Content-Security-Policy", s.csp)
+		c.Next()
+	}
+}
+
+// corsAllowHeaders are the request headers browsers may send cross-origin: what the
+// services read besides the CORS-safelisted ones.
+var corsAllowHeaders = strings.Join([]string{
+	"Authorization", "Content-Type", IdempotencyHeader, reqid.Header, tenant.Header,
+}, ", ")
+
+// CORS answers cross-origin requests from the comma-separated origins ("*" allows any):
+// matching requests get Access-Control-Allow-Origin and preflights (OPTIONS with
+// Access-Control-Request-Method) are answered 204 here; a preflight from any other origin
+// is 403. With no origins it does nothing, so same-origin deployments are unchanged.
+// Register it first: preflights must not reach rate limiting or auth.
+func CORS(origins string) gin.HandlerFunc {
+	allowed := map[string]bool{}
+	for _, o := range strings.Split(origins, ",") {
+		if o = strings.TrimRight(strings.TrimSpace(o), "/"); o != "" {
+			allowed[o] = true
+		}
+	}
+	if len(allowed) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Add("Vary", "Origin")
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowed["*"] && !allowed[origin] {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", reqid.Header)
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE")
+			h.Set("Access-Control-Allow-Headers", corsAllowHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
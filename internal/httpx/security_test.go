@@ -0,0 +1,77 @@
+package httpx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSecurityHeaders_Overrides(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(SecurityHeaders(WithReferrerPolicy("strict-origin"), WithFrameOptions("")))
+	r.GET("/x", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if got := w.Header().Get("Referrer-Policy"); got != "strict-origin" {
+		t.Fatalf("Referrer-Policy=%q, want strict-origin", got)
+	}
+	if got := w.Header().Get("X-Frame-Options"); got != "DENY" {
+		t.Fatalf("X-Frame-Options=%q, want the DENY default for an empty override", got)
+	}
+}
+
+func TestCORS(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	serve := func(origins, method, origin string, preflight bool) *httptest.ResponseRecorder {
+		r := gin.New()
+		r.Use(CORS(origins))
+		r.GET("/x", func(c *gin.Context) { c.Status(http.StatusOK) })
+		req := httptest.NewRequest(method, "/x", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		if preflight {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+	const shop = "https://shop.example.com"
+
+	// preflight from an allowed origin: answered here, the route never runs
+	w := serve("https://admin.example.com, "+shop+"/", http.MethodOptions, shop, true)
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != shop ||
+		w.Header().Get("Access-Control-Allow-Methods") == "" || w.Header().Get("Access-Control-Allow-Headers") != corsAllowHeaders {
+		t.Fatalf("preflight: status=%d headers=%v", w.Code, w.Header())
+	}
+	// the actual request
+	w = serve(shop, http.MethodGet, shop, false)
+	if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != shop || w.Header().Get("Vary") != "Origin" {
+		t.Fatalf("allowed GET: status=%d headers=%v", w.Code, w.Header())
+	}
+	// "*" allows any origin
+	if w = serve("*", http.MethodGet, "https://x.test", false); w.Header().Get("Access-Control-Allow-Origin") != "https://x.test" {
+		t.Fatalf("wildcard: headers=%v", w.Header())
+	}
+
+	// another origin: its preflight is refused, a plain request gets no CORS headers
+	if w = serve(shop, http.MethodOptions, "https://evil.test", true); w.Code != http.StatusForbidden {
+		t.Fatalf("foreign preflight: status=%d, want 403", w.Code)
+	}
+	if w = serve(shop, http.MethodGet, "https://evil.test", false); w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Fatalf("foreign GET: status=%d headers=%v", w.Code, w.Header())
+	}
+
+	// unset: no CORS at all, same-origin requests untouched
+	for _, origins := range []string{"", " , "} {
+		w = serve(origins, http.MethodGet, shop, false)
+		if w.Code != http.StatusOK || w.Header().Get("Access-Control-Allow-Origin") != "" || w.Header().Get("Vary") != "" {
+			t.Fatalf("CORS(%q): status=%d headers=%v", origins, w.Code, w.Header())
+		}
+	}
+}
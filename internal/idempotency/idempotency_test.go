@@ -0,0 +1,185 @@
+package idempotency
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestMemory_Expiry(t *testing.T) {
+	now := time.Date(2025, 9, 6, 10, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Hour)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if r, err := m.Get(ctx, "k"); r != nil || err != nil {
+		t.Fatalf("unknown key: %+v, %v", r, err)
+	}
+	_ = m.Save(ctx, "k", Response{Status: 201, ContentType: "application/json", Body: []byte(`{"id":1}`)})
+
+	now = now.Add(59 * time.Minute)
+	r, err := m.Get(ctx, "k")
+	if err != nil || r == nil || r.Status != 201 || string(r.Body) != `{"id":1}` {
+		t.Fatalf("before expiry: %+v, %v", r, err)
+	}
+
+	now = now.Add(time.Minute)
+	if r, _ := m.Get(ctx, "k"); r != nil {
+		t.Fatalf("expired key still returned: %+v", r)
+	}
+
+	// a later save prunes it
+	_ = m.Save(ctx, "other", Response{Status: 200})
+	if _, ok := m.entries["k"]; ok {
+		t.Fatal("expired key not pruned")
+	}
+}
+
+func TestMemory_Reserve(t *testing.T) {
+	now := time.Date(2025, 9, 6, 10, 0, 0, 0, time.UTC)
+	m := NewMemory(time.Hour)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if ok, err := m.Reserve(ctx, "k"); !ok || err != nil {
+		t.Fatalf("first reserve: %v, %v", ok, err)
+	}
+	// in flight: nothing to replay and nobody else gets it
+	if r, _ := m.Get(ctx, "k"); r != nil {
+		t.Fatalf("reserved key replayed: %+v", r)
+	}
+	if ok, _ := m.Reserve(ctx, "k"); ok {
+		t.Fatal("second reserve of a key in flight succeeded")
+	}
+
+	// finished: replayed, still not reservable
+	_ = m.Save(ctx, "k", Response{Status: 201, Body: []byte(`{}`)})
+	if ok, _ := m.Reserve(ctx, "k"); ok {
+		t.Fatal("reserve of a finished key succeeded")
+	}
+	_ = m.Release(ctx, "k")
+	if r, _ := m.Get(ctx, "k"); r == nil || r.Status != 201 {
+		t.Fatalf("release dropped a stored response: %+v", r)
+	}
+
+	// a released reservation frees the key at once
+	_, _ = m.Reserve(ctx, "failed")
+	_ = m.Release(ctx, "failed")
+	if ok, _ := m.Reserve(ctx, "failed"); !ok {
+		t.Fatal("released key not reservable")
+	}
+
+	// an abandoned one lapses after InFlightTTL
+	now = now.Add(InFlightTTL)
+	if ok, _ := m.Reserve(ctx, "failed"); !ok {
+		t.Fatal("abandoned reservation still blocks the key")
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	cases := map[string]Backend{"postgres": BackendPostgres, " Postgres ": BackendPostgres, "memory": BackendMemory, "": BackendMemory, "redis": BackendMemory}
+	for in, want := range cases {
+		if got := ParseBackend(in); got != want {
+			t.Fatalf("ParseBackend(%q)=%s, want %s", in, got, want)
+		}
+	}
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/idempotency
+func TestPGStore_SharedAndExpiry(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+
+	// two pools = two service instances pointing at the same DB
+	newPool := func() *pgxpool.Pool {
+		p, err := pgxpool.New(ctx, dsn)
+		if err != nil {
+			t.Fatalf("pool: %v", err)
+		}
+		t.Cleanup(p.Close)
+		return p
+	}
+	a := NewPGStore(newPool(), time.Hour)
+	b := NewPGStore(newPool(), time.Hour)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	b.now = func() time.Time { return now }
+
+	key := "test-" + uuid.NewString()
+	if err := a.Save(ctx, key, Response{Status: 201, ContentType: "application/json", Body: []byte(`{"ok":true}`)}); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	r, err := b.Get(ctx, key)
+	if err != nil || r == nil || r.Status != 201 || r.ContentType != "application/json" || string(r.Body) != `{"ok":true}` {
+		t.Fatalf("other instance: %+v, %v", r, err)
+	}
+	// a live key is not overwritten
+	if err := b.Save(ctx, key, Response{Status: 500}); err != nil {
+		t.Fatalf("second save: %v", err)
+	}
+	if r, _ := a.Get(ctx, key); r == nil || r.Status != 201 {
+		t.Fatalf("live key overwritten: %+v", r)
+	}
+
+	// a finished key can't be reserved; a fresh one only once across instances
+	if ok, err := b.Reserve(ctx, key); ok || err != nil {
+		t.Fatalf("reserve of a finished key: %v, %v", ok, err)
+	}
+	inflight := "test-" + uuid.NewString()
+	if ok, err := a.Reserve(ctx, inflight); !ok || err != nil {
+		t.Fatalf("reserve: %v, %v", ok, err)
+	}
+	if ok, _ := b.Reserve(ctx, inflight); ok {
+		t.Fatal("key reserved twice")
+	}
+	if r, _ := b.Get(ctx, inflight); r != nil {
+		t.Fatalf("reservation replayed: %+v", r)
+	}
+	if err := a.Release(ctx, inflight); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if ok, _ := b.Reserve(ctx, inflight); !ok {
+		t.Fatal("released key not reservable")
+	}
+
+	now = now.Add(time.Hour)
+	if r, err := a.Get(ctx, key); err != nil || r != nil {
+		t.Fatalf("expired key: %+v, %v", r, err)
+	}
+	// once expired the key can be reused
+	if err := b.Save(ctx, key, Response{Status: 200, Body: []byte(`{}`)}); err != nil {
+		t.Fatalf("save after expiry: %v", err)
+	}
+	if r, _ := a.Get(ctx, key); r == nil || r.Status != 200 {
+		t.Fatalf("after expiry: %+v", r)
+	}
+
+	// a scoped key longer than the key column (tenant, user, route and query plus a
+	// 255-character client key) is still stored, and only its exact value matches
+	long := "acme|" + uuid.NewString() + "|POST /orders?draft=true|" + strings.Repeat("k", 254)
+	for _, k := range []string{long + "1", long + "2"} {
+		if ok, err := a.Reserve(ctx, k); !ok || err != nil {
+			t.Fatalf("reserve long key: %v, %v", ok, err)
+		}
+	}
+	if err := a.Save(ctx, long+"1", Response{Status: 201, Body: []byte(`{"n":1}`)}); err != nil {
+		t.Fatalf("save long key: %v", err)
+	}
+	if r, err := b.Get(ctx, long+"1"); err != nil || r == nil || string(r.Body) != `{"n":1}` {
+		t.Fatalf("long key: %+v, %v", r, err)
+	}
+	if r, err := b.Get(ctx, long+"2"); err != nil || r != nil {
+		t.Fatalf("other long key replayed: %+v, %v", r, err)
+	}
+	if err := b.Release(ctx, long+"2"); err != nil {
+		t.Fatalf("release long key: %v", err)
+	}
+}
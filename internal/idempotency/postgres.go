@@ -0,0 +1,120 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PGStore keeps responses in the idempotency_keys table, so a retry reaching another
+// instance still replays them. Rows are keyed by the hex SHA-256 of the key: a scoped key
+// (tenant, caller, route, query and the client's up to 255 characters) can outgrow the
+// column, and a fixed-length digest never does.
+type PGStore struct {
+	db  *pgxpool.Pool
+	ttl time.Duration
+	now func() time.Time
+
+	mu     sync.Mutex
+	pruned time.Time
+}
+
+func NewPGStore(db *pgxpool.Pool, ttl time.Duration) *PGStore {
+	return &PGStore{db: db, ttl: ttl, now: time.Now}
+}
+
+func (s *PGStore) Get(ctx context.Context, key string) (*Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var r Response
+	err := s.db.QueryRow(ctx, `
+		SELECT status, content_type, body FROM idempotency_keys
+		WHERE key=$1 AND expires_at > $2 AND status > 0
+	`, rowKey(key), s.now()).Scan(&r.Status, &r.ContentType, &r.Body)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &r, nil
+}
+
+// Reserve inserts a placeholder row (status 0) for key, or takes over an expired one. The
+// primary key makes it atomic across instances.
+func (s *PGStore) Reserve(ctx context.Context, key string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := s.now()
+	s.prune(ctx, now)
+
+	tag, err := s.db.Exec(ctx, `
+		INSERT INTO idempotency_keys (key, status, content_type, body, created_at, expires_at)
+		VALUES ($1, 0, '', ''::bytea, $2, $3)
+		ON CONFLICT (key) DO UPDATE
+		SET status = 0, content_type = '', body = ''::bytea,
+		    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+	`, rowKey(key), now, now.Add(reservationTTL(s.ttl)))
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+// Save stores r for key, completing its reservation; an expired row for the same key is
+// replaced.
+func (s *PGStore) Save(ctx context.Context, key string, r Response) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := s.now()
+	s.prune(ctx, now)
+
+	_, err := s.db.Exec(ctx, `
+		INSERT INTO idempotency_keys (key, status, content_type, body, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (key) DO UPDATE
+		SET status = EXCLUDED.status, content_type = EXCLUDED.content_type, body = EXCLUDED.body,
+		    created_at = EXCLUDED.created_at, expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.status = 0 OR idempotency_keys.expires_at <= EXCLUDED.created_at
+	`, rowKey(key), r.Status, r.ContentType, r.Body, now, now.Add(s.ttl))
+	return err
+}
+
+// Release deletes key's placeholder row; a stored response is kept.
+func (s *PGStore) Release(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := s.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE key=$1 AND status = 0`, rowKey(key))
+	return err
+}
+
+// rowKey is the idempotency_keys primary key for key.
+func rowKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// prune drops expired keys once per TTL and per instance; failures are ignored since Get
+// never returns an expired row.
+func (s *PGStore) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.pruned) < s.ttl {
+		s.mu.Unlock()
+		return
+	}
+	s.pruned = now
+	s.mu.Unlock()
+
+	_, _ = s.db.Exec(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, now)
+}
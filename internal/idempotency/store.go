@@ -0,0 +1,149 @@
+// Package idempotency keeps the responses of requests sent with an Idempotency-Key so a
+// retry gets the same answer instead of repeating the write: in process (single instance)
+// or in Postgres, shared by every instance using the same DB.
+package idempotency
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Response is what gets replayed for a key.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps responses by key until their TTL runs out. Get returns nil for an unknown or
+// expired key, and for one still reserved by a request in flight.
+type Store interface {
+	Get(ctx context.Context, key string) (*Response, error)
+	// Reserve claims key for a request about to run; false when another request holds it
+	// or already stored its response. A reservation lapses after InFlightTTL.
+	Reserve(ctx context.Context, key string) (bool, error)
+	Save(ctx context.Context, key string, r Response) error
+	// Release drops a reservation whose request left nothing to replay.
+	Release(ctx context.Context, key string) error
+}
+
+// InFlightTTL bounds how long a reservation blocks its key, so one left behind by a crashed
+// instance does not lock the key for the whole TTL.
+const InFlightTTL = time.Minute
+
+// reservationTTL is how long a reservation made now lasts under ttl.
+func reservationTTL(ttl time.Duration) time.Duration {
+	if ttl < InFlightTTL {
+		return ttl
+	}
+	return InFlightTTL
+}
+
+type Backend string
+
+const (
+	BackendMemory   Backend = "memory"
+	BackendPostgres Backend = "postgres"
+)
+
+// ParseBackend maps IDEMPOTENCY_BACKEND to a Backend; unknown values fall back to memory.
+func ParseBackend(s string) Backend {
+	if Backend(strings.ToLower(strings.TrimSpace(s))) == BackendPostgres {
+		return BackendPostgres
+	}
+	return BackendMemory
+}
+
+// New builds the store for backend. db is only used by the postgres backend.
+func New(backend Backend, db *pgxpool.Pool, ttl time.Duration) Store {
+	if backend == BackendPostgres {
+		return NewPGStore(db, ttl)
+	}
+	return NewMemory(ttl)
+}
+
+type memEntry struct {
+	resp    Response
+	expires time.Time
+	// reserved by a request in flight, no response yet
+	pending bool
+}
+
+// Memory is a per-process store. Keys are not shared across instances.
+type Memory struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]memEntry
+	pruned  time.Time
+}
+
+func NewMemory(ttl time.Duration) *Memory {
+	return &Memory{ttl: ttl, now: time.Now, entries: map[string]memEntry{}}
+}
+
+func (m *Memory) Get(_ context.Context, key string) (*Response, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok || e.pending || !m.now().Before(e.expires) {
+		return nil, nil
+	}
+	r := e.resp
+	return &r, nil
+}
+
+func (m *Memory) Reserve(_ context.Context, key string) (bool, error) {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && now.Before(e.expires) {
+		return false, nil
+	}
+	m.prune(now)
+	m.entries[key] = memEntry{expires: now.Add(reservationTTL(m.ttl)), pending: true}
+	return true, nil
+}
+
+func (m *Memory) Save(_ context.Context, key string, r Response) error {
+	now := m.now()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.prune(now)
+	m.entries[key] = memEntry{resp: r, expires: now.Add(m.ttl)}
+	return nil
+}
+
+func (m *Memory) Release(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if e, ok := m.entries[key]; ok && e.pending {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// prune drops expired keys at most once per TTL so the map doesn't grow forever. m.mu must
+// be held.
+func (m *Memory) prune(now time.Time) {
+	if now.Sub(m.pruned) < m.ttl {
+		return
+	}
+	m.pruned = now
+	for k, e := range m.entries {
+		if !now.Before(e.expires) {
+			delete(m.entries, k)
+		}
+	}
+}
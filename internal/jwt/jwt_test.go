@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignVerify(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	token, err := Sign(secret, New("user-1", now, 15*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(token, ".") != 2 {
+		t.Fatalf("token=%q is not header.payload.signature", token)
+	}
+
+	c, err := Verify(secret, token, now.Add(14*time.Minute), 0)
+	if err != nil || c.Subject != "user-1" || c.IssuedAt != now.Unix() || c.ExpiresAt != now.Add(15*time.Minute).Unix() {
+		t.Fatalf("claims=%+v err=%v", c, err)
+	}
+
+	if _, err := Verify(secret, token, now.Add(15*time.Minute), 0); !errors.Is(err, ErrExpired) {
+		t.Fatalf("at expiry: err=%v, want ErrExpired", err)
+	}
+	if _, err := Verify([]byte("other"), token, now, 0); !errors.Is(err, ErrSignature) {
+		t.Fatalf("wrong secret: err=%v, want ErrSignature", err)
+	}
+}
+
+func TestVerify_Leeway(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	token, _ := Sign(secret, New("user-1", now, 15*time.Minute))
+	exp := now.Add(15 * time.Minute)
+
+	cases := []struct {
+		at     time.Time
+		leeway time.Duration
+		ok     bool
+	}{
+		{exp.Add(-time.Second), 0, true},
+		{exp, 0, false},
+		{exp.Add(29 * time.Second), 30 * time.Second, true},  // just inside the window
+		{exp.Add(30 * time.Second), 30 * time.Second, false}, // just beyond it
+		{exp.Add(time.Second), -time.Minute, false},          // negative counts as none
+	}
+	for _, tc := range cases {
+		_, err := Verify(secret, token, tc.at, tc.leeway)
+		if tc.ok && err != nil {
+			t.Fatalf("%s past exp, leeway %s: err=%v, want accepted", tc.at.Sub(exp), tc.leeway, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrExpired) {
+			t.Fatalf("%s past exp, leeway %s: err=%v, want ErrExpired", tc.at.Sub(exp), tc.leeway, err)
+		}
+	}
+}
+
+func TestVerify_Tampered(t *testing.T) {
+	secret := []byte("s3cret")
+	now := time.Unix(1_700_000_000, 0)
+	token, _ := Sign(secret, New("user-1", now, time.Hour))
+	parts := strings.Split(token, ".")
+
+	// another subject under the original signature
+	forged, _ := Sign([]byte("attacker"), New("admin", now, time.Hour))
+	swapped := parts[0] + "." + strings.Split(forged, ".")[1] + "." + parts[2]
+
+	// a flipped signature byte
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sig[0] ^= 0xff
+	flipped := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString(sig)
+
+	// "alg":"none" with no signature
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	cases := map[string]struct {
+		token string
+		want  error
+	}{
+		"payload swapped":  {swapped, ErrSignature},
+		"signature edited": {flipped, ErrSignature},
+		"alg none":         {none, ErrMalformed},
+		"two parts":        {parts[0] + "." + parts[1], ErrMalformed},
+		"not base64":       {parts[0] + "." + parts[1] + ".!!", ErrMalformed},
+		"empty":            {"", ErrMalformed},
+	}
+	for name, tc := range cases {
+		if _, err := Verify(secret, tc.token, now, 0); !errors.Is(err, tc.want) {
+			t.Fatalf("%s: err=%v, want %v", name, err, tc.want)
+		}
+	}
+}
@@ -4,117 +4,545 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"strings"
 
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 	userpb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
 )
 
+// ErrProductNotFound is returned by FetchProduct when product-service answers 404.
+var ErrProductNotFound = errors.New("product not found")
+
+// ErrUserService wraps a user-service failure (unreachable, timed out, internal error), as
+// opposed to an answer that the user does not exist.
+var ErrUserService = errors.New("user service error")
+
+// StockError is returned by AdjustStock when the product has fewer units than the decrement.
+type StockError struct {
+	ProductID string
+	Requested int
+	Available int
+}
+
+func (e *StockError) Error() string { return "insufficient stock" }
+
+// Stock reasons sent to product-service, recorded in its stock movement history.
+const (
+	StockReasonOrder  = "order"
+	StockReasonCancel = "cancel"
+	StockReasonRefund = "refund"
+)
+
 type ProductDTO struct {
 	ID          string `json:"id"`
 	Name        string `json:"name"`
 	Description string `json:"description"`
 	Price       string `json:"price"`
 	Stock       int    `json:"stock"`
+	// active, discontinued or out_of_stock
+	Status string `json:"status,omitempty"`
+	// the product takes orders beyond its stock (which may go negative)
+	AllowBackorder bool `json:"allow_backorder,omitempty"`
+	// set when the product was soft-deleted (only returned with include_deleted)
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// productWire is everything product-service answers for a product: ProductDTO plus the
+// fields order-service has no use for, so strict decoding only trips on fields it has
+// never heard of.
+type productWire struct {
+	ProductDTO
+	LowStockThreshold int       `json:"low_stock_threshold"`
+	Barcode           string    `json:"barcode"`
+	Category          string    `json:"category"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// Orderable reports whether the product's status lets it be ordered, whatever its stock.
+// An empty status comes from a product-service that predates the field.
+func (p *ProductDTO) Orderable() bool {
+	return p.Status == "" || p.Status == "active"
 }
 
 type Ext struct {
 	HTTP           *http.Client
 	User           userpb.UserServiceClient
 	ProductBaseURL string
+	// Max concurrent calls in FetchProducts/PreCheck; 0 uses DefaultFetchConcurrency
+	FetchConcurrency int
+	// gRPC health of user-service, on the same connection as User
+	UserHealth healthpb.HealthClient
+	// User-Agent of the calls to product-service; empty uses DefaultUserAgent
+	UserAgent string
+	// StrictDecode rejects product fields order-service doesn't know instead of ignoring
+	// them, to catch contract drift in CI
+	StrictDecode bool
 }
 
-func NewExt(userAddr, productBaseURL string) (*Ext, error) {
+// DefaultHTTPTimeout bounds each call to product-service when EXT_HTTP_TIMEOUT is unset.
+const DefaultHTTPTimeout = 5 * time.Second
+
+// DefaultUserAgent identifies order-service in product-service's logs when Ext.UserAgent is unset.
+const DefaultUserAgent = "order-service"
+
+// ErrHostNotAllowed is returned by NewExt when the product base URL is outside the allow-list.
+var ErrHostNotAllowed = errors.New("product service host not allowed")
+
+// ExtOption configures NewExt.
+type ExtOption func(*extOptions)
+
+type extOptions struct {
+	allowedHosts []string
+	userAgent    string
+	strictDecode bool
+	httpTimeout  time.Duration
+}
+
+// WithAllowedHosts restricts ProductBaseURL to these hosts ("product" or "product:8081");
+// empty entries are ignored and an empty list allows any host.
+func WithAllowedHosts(hosts ...string) ExtOption {
+	return func(o *extOptions) {
+		for _, h := range hosts {
+			if h = strings.TrimSpace(h); h != "" {
+				o.allowedHosts = append(o.allowedHosts, strings.ToLower(h))
+			}
+		}
+	}
+}
+
+// WithUserAgent sets the User-Agent sent to product-service (e.g. "order-service/1.0").
+func WithUserAgent(ua string) ExtOption {
+	return func(o *extOptions) { o.userAgent = strings.TrimSpace(ua) }
+}
+
+// WithStrictDecode makes product responses with unknown fields an error (PRODUCT_STRICT_DECODE).
+func WithStrictDecode(on bool) ExtOption {
+	return func(o *extOptions) { o.strictDecode = on }
+}
+
+// WithHTTPTimeout bounds each attempt of a call to product-service (EXT_HTTP_TIMEOUT); 0 or
+// less uses DefaultHTTPTimeout. A shorter deadline on the caller's context still wins.
+func WithHTTPTimeout(d time.Duration) ExtOption {
+	return func(o *extOptions) { o.httpTimeout = d }
+}
+
+func NewExt(userAddr, productBaseURL string, opts ...ExtOption) (*Ext, error) {
+	o := extOptions{httpTimeout: DefaultHTTPTimeout}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.httpTimeout <= 0 {
+		o.httpTimeout = DefaultHTTPTimeout
+	}
+	if err := checkProductURL(productBaseURL, o.allowedHosts); err != nil {
+		return nil, err
+	}
+
 	// Non-blocking gRPC connection (RPC will use WaitForReady)
 	conn, err := grpc.Dial(userAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
 	if err != nil {
 		return nil, err
 	}
 	return &Ext{
-		HTTP:           &http.Client{Timeout: 5 * time.Second},
+		HTTP:           &http.Client{Timeout: o.httpTimeout},
 		User:           userpb.NewUserServiceClient(conn),
+		UserHealth:     healthpb.NewHealthClient(conn),
 		ProductBaseURL: strings.TrimRight(productBaseURL, "/"),
+		UserAgent:      o.userAgent,
+		StrictDecode:   o.strictDecode,
 	}, nil
 }
 
+// checkProductURL matches the URL's host against allowed, either by hostname or host:port.
+func checkProductURL(raw string, allowed []string) error {
+	if len(allowed) == 0 {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return fmt.Errorf("%w: invalid URL %q", ErrHostNotAllowed, raw)
+	}
+	host, hostport := strings.ToLower(u.Hostname()), strings.ToLower(u.Host)
+	for _, a := range allowed {
+		if a == host || a == hostport {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", ErrHostNotAllowed, u.Host)
+}
+
 func (e *Ext) FetchProduct(ctx context.Context, id string) (*ProductDTO, error) {
+	return e.fetchProduct(ctx, id, false)
+}
+
+// fetchProduct with includeDeleted also resolves soft-deleted products.
+func (e *Ext) fetchProduct(ctx context.Context, id string, includeDeleted bool) (*ProductDTO, error) {
 	url := e.ProductBaseURL + "/products/" + id
+	if includeDeleted {
+		url += "?include_deleted=true"
+	}
 	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	res, err := e.doWithRetry(req)
 	if err != nil {
 		return nil, fmt.Errorf("fetch %s: http error: %w", url, err)
 	}
 	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("fetch %s: %w", url, ErrProductNotFound)
+	}
 	if res.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
 		return nil, fmt.Errorf("fetch %s: status=%d body=%q", url, res.StatusCode, string(b))
 	}
-	var p ProductDTO
-	if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+	var p productWire
+	dec := json.NewDecoder(res.Body)
+	if e.StrictDecode {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(&p); err != nil {
 		return nil, fmt.Errorf("decode %s: %w", url, err)
 	}
-	return &p, nil
+	return &p.ProductDTO, nil
 }
 
-func (e *Ext) ValidateUser(ctx context.Context, userID string) (bool, error) {
+// DefaultFetchConcurrency bounds the in-flight calls of a batched fetch when Ext.FetchConcurrency is unset.
+const DefaultFetchConcurrency = 8
+
+func (e *Ext) fetchLimit() int {
+	if e.FetchConcurrency > 0 {
+		return e.FetchConcurrency
+	}
+	return DefaultFetchConcurrency
+}
+
+// FetchProducts fetches each distinct id once, concurrently, keyed by product id.
+// Products that no longer exist are left out of the map; any other error fails the batch.
+// With includeDeleted, soft-deleted products are returned too (DeletedAt set).
+func (e *Ext) FetchProducts(ctx context.Context, ids []string, includeDeleted bool) (map[string]*ProductDTO, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(e.fetchLimit())
+	out := e.fetchInto(g, gctx, ids, includeDeleted)
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PreCheck validates the user and fetches every product in one bounded batch, so an order
+// waits for the slowest call instead of their sum. Nothing is mutated. An unknown user is
+// userOK=false; a user-service failure (ErrUserService) or a product error fails the whole
+// batch, so outages are not reported as client mistakes.
+func (e *Ext) PreCheck(ctx context.Context, userID string, ids []string) (userOK bool, products map[string]*ProductDTO, err error) {
+	g, gctx := errgroup.WithContext(ctx)
+	g.SetLimit(e.fetchLimit())
+	g.Go(func() error {
+		ok, err := e.ValidateUser(gctx, userID)
+		switch status.Code(err) {
+		case codes.OK:
+			userOK = ok
+		case codes.InvalidArgument, codes.NotFound:
+			// the id itself is the problem
+			userOK = false
+		default:
+			return fmt.Errorf("%w: %v", ErrUserService, err)
+		}
+		return nil
+	})
+	products = e.fetchInto(g, gctx, ids, false)
+	if err := g.Wait(); err != nil {
+		return false, nil, err
+	}
+	return userOK, products, nil
+}
+
+// fetchInto schedules one fetch per distinct id on g; the map is complete once g.Wait returns.
+func (e *Ext) fetchInto(g *errgroup.Group, ctx context.Context, ids []string, includeDeleted bool) map[string]*ProductDTO {
+	var mu sync.Mutex
+	out := make(map[string]*ProductDTO, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		id := id
+		g.Go(func() error {
+			p, err := e.fetchProduct(ctx, id, includeDeleted)
+			switch {
+			case errors.Is(err, ErrProductNotFound):
+				return nil
+			case err != nil:
+				return err
+			}
+			mu.Lock()
+			out[id] = p
+			mu.Unlock()
+			return nil
+		})
+	}
+	return out
+}
+
+// userCtx bounds a user-service call and forwards the request ID and tenant as metadata.
+func userCtx(ctx context.Context) (context.Context, context.CancelFunc) {
 	ctx2, cancel := context.WithTimeout(ctx, 2*time.Second)
+	if rid := reqid.From(ctx); rid != "" {
+		ctx2 = metadata.AppendToOutgoingContext(ctx2, reqid.MetadataKey, rid)
+	}
+	ctx2 = metadata.AppendToOutgoingContext(ctx2, tenant.MetadataKey, tenant.From(ctx))
+	return ctx2, cancel
+}
+
+func (e *Ext) ValidateUser(ctx context.Context, userID string) (bool, error) {
+	ctx2, cancel := userCtx(ctx)
 	defer cancel()
-	_, err := e.User.ValidateUser(ctx2, &userpb.ValidateUserRequest{Id: userID}, grpc.WaitForReady(true))
+	resp, err := e.User.ValidateUser(ctx2, &userpb.ValidateUserRequest{Id: userID}, grpc.WaitForReady(true))
 	if err != nil {
 		return false, err
 	}
-	return true, nil
+	return resp.GetOk(), nil
 }
 
-// Adjust stock by adding delta (delta can be negative)
-// Use PUT /products/{id} with { “stock”: newValue }
-func (e *Ext) AdjustStock(ctx context.Context, productID string, delta int) error {
-	p, err := e.FetchProduct(ctx, productID)
+// VerifySession resolves a session token to its user; ok is false for an unknown,
+// revoked or expired session.
+func (e *Ext) VerifySession(ctx context.Context, sessionID string) (userID string, ok bool, err error) {
+	ctx2, cancel := userCtx(ctx)
+	defer cancel()
+	resp, err := e.User.VerifySession(ctx2, &userpb.VerifySessionRequest{SessionId: sessionID}, grpc.WaitForReady(true))
+	if err != nil {
+		return "", false, err
+	}
+	return resp.GetUserId(), resp.GetOk(), nil
+}
+
+// PingUser asks user-service's gRPC health service whether it is serving. Unlike the other
+// calls it doesn't wait for the connection to be ready: a probe must fail fast.
+func (e *Ext) PingUser(ctx context.Context) error {
+	if e.UserHealth == nil {
+		return errors.New("user-service health client not configured")
+	}
+	resp, err := e.UserHealth.Check(ctx, &healthpb.HealthCheckRequest{})
 	if err != nil {
-		return fmt.Errorf("adjust fetch: %w", err)
+		return err
 	}
-	newStock := p.Stock + delta
-	if newStock < 0 {
-		return fmt.Errorf("insufficient stock")
+	if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("user-service %s", resp.GetStatus())
 	}
-	body, _ := json.Marshal(map[string]int{"stock": newStock})
-	url := e.ProductBaseURL + "/products/" + productID
-	req, _ := http.NewRequestWithContext(ctx, http.MethodPut, url, bytes.NewReader(body))
+	return nil
+}
+
+// PingProduct calls product-service's /healthz once, without retries.
+func (e *Ext) PingProduct(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, e.ProductBaseURL+"/healthz", nil)
+	if err != nil {
+		return err
+	}
+	e.setHeaders(req)
+	client := e.HTTP
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("product-service healthz status=%d", res.StatusCode)
+	}
+	return nil
+}
+
+// AdjustStock adds delta (negative takes units) through product-service's atomic
+// POST /products/{id}/decrement or /increment, so concurrent orders cannot oversell.
+// Running short is a *StockError carrying what is left. It is sent once: a bare delta is
+// not idempotent. Order lines take their units with TakeStock instead.
+func (e *Ext) AdjustStock(ctx context.Context, productID string, delta int, reason, orderID string) error {
+	if delta == 0 {
+		return nil
+	}
+	op, qty := "increment", delta
+	if delta < 0 {
+		op, qty = "decrement", -delta
+	}
+	body, _ := json.Marshal(map[string]any{"qty": qty, "reason": reason, "order_id": orderID})
+	url := e.ProductBaseURL + "/products/" + productID + "/" + op
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	// retrying a delta the server may have applied could apply it twice
+	res, err := e.doOnce(req)
+	if err != nil {
+		return fmt.Errorf("adjust %s: http error: %w", url, err)
+	}
+	defer res.Body.Close()
+	return stockResult(res, url, productID, qty)
+}
+
+// TakeStock takes qty units for line itemID of order orderID through product-service's
+// POST /products/{id}/decrement keyed by (order_id, item_id): a replay of a decrement the
+// server already applied changes nothing, so unlike AdjustStock it is retried, and a
+// timeout whose response was lost does not leave units taken without the caller knowing.
+func (e *Ext) TakeStock(ctx context.Context, productID string, qty int, orderID, itemID string) error {
+	body, _ := json.Marshal(map[string]any{"qty": qty, "reason": StockReasonOrder, "order_id": orderID, "item_id": itemID})
+	url := e.ProductBaseURL + "/products/" + productID + "/decrement"
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	res, err := e.doWithRetry(req)
 	if err != nil {
 		return fmt.Errorf("adjust %s: http error: %w", url, err)
 	}
 	defer res.Body.Close()
+	return stockResult(res, url, productID, qty)
+}
+
+// stockResult maps product-service's answer to a decrement or increment of qty units.
+func stockResult(res *http.Response, url, productID string, qty int) error {
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		return fmt.Errorf("adjust %s: %w", url, ErrProductNotFound)
+	case http.StatusConflict:
+		var out struct {
+			Available int `json:"available"`
+		}
+		_ = json.Unmarshal(b, &out)
+		return &StockError{ProductID: productID, Requested: qty, Available: out.Available}
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return fmt.Errorf("invalid stock body=%q (%s)", string(b), url)
+	default:
+		return fmt.Errorf("update stock error: status=%d body=%q url=%s", res.StatusCode, string(b), url)
+	}
+}
+
+// RestockOnce gives qty units back to a product for a canceled order through product-service's
+// idempotent restock: replaying it for the same (order, product) is a no-op (applied=false),
+// so cancels and saga recovery can retry safely.
+func (e *Ext) RestockOnce(ctx context.Context, productID string, qty int, orderID string) (bool, error) {
+	body, _ := json.Marshal(map[string]any{"order_id": orderID, "qty": qty, "reason": StockReasonCancel})
+	url := e.ProductBaseURL + "/products/" + productID + "/restock"
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := e.doWithRetry(req)
+	if err != nil {
+		return false, fmt.Errorf("restock %s: http error: %w", url, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return false, fmt.Errorf("restock %s: %w", url, ErrProductNotFound)
+	}
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return false, fmt.Errorf("restock %s: status=%d body=%q", url, res.StatusCode, string(b))
+	}
+	var out struct {
+		Applied bool `json:"applied"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return false, fmt.Errorf("decode %s: %w", url, err)
+	}
+	return out.Applied, nil
+}
+
+// StockMovement is one stock change product-service recorded for an order.
+type StockMovement struct {
+	ID             int64     `json:"id"`
+	ProductID      string    `json:"product_id"`
+	Delta          int       `json:"delta"`
+	Reason         string    `json:"reason"`
+	ResultingStock int       `json:"resulting_stock"`
+	OrderID        string    `json:"order_id,omitempty"`
+	At             time.Time `json:"at"`
+}
+
+// OrderStockMovements asks product-service for the stock movements recorded with orderID,
+// oldest first.
+func (e *Ext) OrderStockMovements(ctx context.Context, orderID string) ([]StockMovement, error) {
+	q := url.Values{"order_id": {orderID}}.Encode()
+	url := e.ProductBaseURL + "/products/stock-movements?" + q
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	res, err := e.doWithRetry(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch %s: http error: %w", url, err)
+	}
+	defer res.Body.Close()
 	if res.StatusCode != http.StatusOK {
 		b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
-		switch res.StatusCode {
-		case http.StatusNotFound:
-			return fmt.Errorf("product not found (status=404 %s)", url)
-		case http.StatusBadRequest:
-			return fmt.Errorf("invalid stock body=%q (%s)", string(b), url)
-		default:
-			return fmt.Errorf("update stock error: status=%d body=%q url=%s", res.StatusCode, string(b), url)
-		}
+		return nil, fmt.Errorf("fetch %s: status=%d body=%q", url, res.StatusCode, string(b))
 	}
-	return nil
+	var out struct {
+		Items []StockMovement `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("decode %s: %w", url, err)
+	}
+	if out.Items == nil {
+		out.Items = []StockMovement{}
+	}
+	return out.Items, nil
+}
+
+// setHeaders marks a call to product-service with who makes it (User-Agent), the request
+// it belongs to (X-Request-ID, when the context has one) and the tenant.
+func (e *Ext) setHeaders(req *http.Request) {
+	ua := e.UserAgent
+	if ua == "" {
+		ua = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", ua)
+	if rid := reqid.From(req.Context()); rid != "" {
+		req.Header.Set(reqid.Header, rid)
+	}
+	req.Header.Set(tenant.Header, tenant.From(req.Context()))
+}
+
+// doOnce sends req a single time, for calls that must not be repeated.
+func (e *Ext) doOnce(req *http.Request) (*http.Response, error) {
+	if e.HTTP == nil {
+		e.HTTP = &http.Client{Timeout: 5 * time.Second}
+	}
+	e.setHeaders(req)
+	return e.HTTP.Do(req)
 }
 
 // Helper to retry http requests
 func (e *Ext) doWithRetry(req *http.Request) (*http.Response, error) {
 	if e.HTTP == nil {
-		e.HTTP = &http.Client{Timeout: 5 * time.Second}
+		e.HTTP = &http.Client{Timeout: DefaultHTTPTimeout}
 	}
+	e.setHeaders(req)
 
+	ctx := req.Context()
 	var lastErr error
 	for i := 0; i < 3; i++ {
+		if i > 0 && req.GetBody != nil {
+			// the previous attempt consumed the body
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
 		res, err := e.HTTP.Do(req)
 		if err == nil {
 			if res.StatusCode >= 500 {
@@ -126,7 +554,12 @@ func (e *Ext) doWithRetry(req *http.Request) (*http.Response, error) {
 		} else {
 			lastErr = err
 		}
-		time.Sleep(time.Duration(100*(1<<i)) * time.Millisecond)
+		// the caller's deadline outranks the retries: once it is gone, stop
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("after %d attempts: %w", i+1, lastErr)
+		case <-time.After(time.Duration(100*(1<<i)) * time.Millisecond):
+		}
 	}
 	return nil, fmt.Errorf("after retries: %w", lastErr)
 }
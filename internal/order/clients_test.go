@@ -0,0 +1,425 @@
+package order
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/httpx"
+	"github.com/MikeMC777/ordenes-ecom/internal/product"
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	userpb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
+)
+
+// slowUser answers ValidateUser after a fixed delay, or fails with err.
+type slowUser struct {
+	userpb.UserServiceClient
+	ok    bool
+	delay time.Duration
+	err   error
+}
+
+func (u slowUser) ValidateUser(ctx context.Context, in *userpb.ValidateUserRequest, opts ...grpc.CallOption) (*userpb.ValidateUserResponse, error) {
+	time.Sleep(u.delay)
+	if u.err != nil {
+		return nil, u.err
+	}
+	return &userpb.ValidateUserResponse{Ok: u.ok}, nil
+}
+
+// slowProducts serves /products/{id} after a delay and tracks the peak of in-flight requests.
+// ids starting with "missing" answer 404, "broken" answer 400.
+func slowProducts(delay time.Duration, peak *int64) *httptest.Server {
+	var inflight int64
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&inflight, 1)
+		defer atomic.AddInt64(&inflight, -1)
+		for {
+			p := atomic.LoadInt64(peak)
+			if n <= p || atomic.CompareAndSwapInt64(peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(delay)
+		id := strings.TrimPrefix(r.URL.Path, "/products/")
+		switch {
+		case strings.HasPrefix(id, "missing"):
+			w.WriteHeader(http.StatusNotFound)
+		case strings.HasPrefix(id, "broken"):
+			w.WriteHeader(http.StatusBadRequest)
+		default:
+			_ = json.NewEncoder(w).Encode(ProductDTO{ID: id, Price: "1.00", Stock: 5})
+		}
+	}))
+}
+
+func cartIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = "p" + string(rune('a'+i))
+	}
+	return ids
+}
+
+func TestPreCheck(t *testing.T) {
+	var peak int64
+	srv := slowProducts(5*time.Millisecond, &peak)
+	defer srv.Close()
+	ext := &Ext{HTTP: srv.Client(), User: slowUser{ok: true}, ProductBaseURL: srv.URL, FetchConcurrency: 3}
+
+	ids := append(cartIDs(10), "missing-1", "pa") // duplicate id is fetched once
+	ok, products, err := ext.PreCheck(context.Background(), "u1", ids)
+	if err != nil || !ok {
+		t.Fatalf("PreCheck ok=%v err=%v", ok, err)
+	}
+	if len(products) != 10 {
+		t.Fatalf("got %d products, expected 10 (404s left out)", len(products))
+	}
+	if _, found := products["missing-1"]; found {
+		t.Fatal("missing product should not be in the map")
+	}
+	if peak > 3 {
+		t.Fatalf("peak in-flight=%d, expected <= 3", peak)
+	}
+
+	// an invalid user is a result, not an error
+	ext.User = slowUser{ok: false}
+	if ok, _, err := ext.PreCheck(context.Background(), "u1", cartIDs(2)); err != nil || ok {
+		t.Fatalf("invalid user: ok=%v err=%v", ok, err)
+	}
+
+	// a rejected id is an invalid user too; user-service failing is an error, not a bad user
+	ext.User = slowUser{err: status.Error(codes.InvalidArgument, "id is required")}
+	if ok, _, err := ext.PreCheck(context.Background(), "", cartIDs(2)); err != nil || ok {
+		t.Fatalf("rejected id: ok=%v err=%v", ok, err)
+	}
+	for _, c := range []codes.Code{codes.Unavailable, codes.DeadlineExceeded, codes.Internal} {
+		ext.User = slowUser{err: status.Error(c, "boom")}
+		if _, _, err := ext.PreCheck(context.Background(), "u1", cartIDs(2)); !errors.Is(err, ErrUserService) {
+			t.Fatalf("user-service %s: err=%v, expected ErrUserService", c, err)
+		}
+	}
+	ext.User = slowUser{ok: true}
+
+	// any other product failure fails the whole batch
+	if _, products, err := ext.PreCheck(context.Background(), "u1", append(cartIDs(3), "broken-1")); err == nil || products != nil {
+		t.Fatalf("expected the batch to fail, got products=%v err=%v", products, err)
+	}
+}
+
+// BenchmarkPreCheck compares the old serial pre-check (user, then each product)
+// against the batched one for a 20-item cart with 2ms per call.
+func BenchmarkPreCheck(b *testing.B) {
+	var peak int64
+	srv := slowProducts(2*time.Millisecond, &peak)
+	defer srv.Close()
+	ext := &Ext{HTTP: srv.Client(), User: slowUser{ok: true, delay: 2 * time.Millisecond}, ProductBaseURL: srv.URL}
+	ids := cartIDs(20)
+	ctx := context.Background()
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ext.ValidateUser(ctx, "u1"); err != nil {
+				b.Fatal(err)
+			}
+			for _, id := range ids {
+				if _, err := ext.FetchProduct(ctx, id); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, err := ext.PreCheck(ctx, "u1", ids); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestNewExt_AllowedHosts(t *testing.T) {
+	allow := WithAllowedHosts("product", "localhost:8081", " ")
+	cases := []struct {
+		url  string
+		opts []ExtOption
+		ok   bool
+	}{
+		{"http://product:8081", []ExtOption{allow}, true},     // hostname match, any port
+		{"http://LOCALHOST:8081/", []ExtOption{allow}, true},  // host:port match, case-insensitive
+		{"http://localhost:9999", []ExtOption{allow}, false},  // right host, wrong port
+		{"http://169.254.169.254", []ExtOption{allow}, false}, // metadata endpoint
+		{"http://product.evil.com", []ExtOption{allow}, false},
+		{"file:///etc/passwd", []ExtOption{allow}, false},
+		{"http://anything:1234", nil, true}, // no allow-list: unchanged behavior
+		{"http://anything:1234", []ExtOption{WithAllowedHosts("")}, true},
+	}
+	for _, tc := range cases {
+		ext, err := NewExt("localhost:0", tc.url, tc.opts...)
+		if tc.ok && (err != nil || ext == nil) {
+			t.Fatalf("NewExt(%q) err=%v, expected it to be allowed", tc.url, err)
+		}
+		if !tc.ok && !errors.Is(err, ErrHostNotAllowed) {
+			t.Fatalf("NewExt(%q) err=%v, expected ErrHostNotAllowed", tc.url, err)
+		}
+	}
+}
+
+func TestExt_OutboundHeaders(t *testing.T) {
+	type seen struct{ method, path, ua, rid string }
+	var (
+		mu  sync.Mutex
+		got []seen
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, seen{r.Method, r.URL.Path, r.UserAgent(), r.Header.Get(reqid.Header)})
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(ProductDTO{ID: "p1", Price: "1.00", Stock: 5})
+	}))
+	defer srv.Close()
+
+	ext, err := NewExt("localhost:0", srv.URL, WithUserAgent("order-service/9.9"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := reqid.With(context.Background(), "rid-123")
+	if _, err := ext.FetchProduct(ctx, "p1"); err != nil {
+		t.Fatalf("FetchProduct: %v", err)
+	}
+	// AdjustStock = a single POST /decrement
+	if err := ext.AdjustStock(ctx, "p1", -1, StockReasonOrder, "o1"); err != nil {
+		t.Fatalf("AdjustStock: %v", err)
+	}
+	if err := ext.PingProduct(ctx); err != nil {
+		t.Fatalf("PingProduct: %v", err)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("requests=%+v, want 3", got)
+	}
+	for _, s := range got {
+		if s.ua != "order-service/9.9" || s.rid != "rid-123" {
+			t.Fatalf("%s %s: User-Agent=%q X-Request-ID=%q", s.method, s.path, s.ua, s.rid)
+		}
+	}
+
+	// built by hand and without a request id: default agent, no X-Request-ID
+	got = nil
+	bare := &Ext{HTTP: srv.Client(), ProductBaseURL: srv.URL}
+	if _, err := bare.FetchProduct(context.Background(), "p1"); err != nil {
+		t.Fatalf("FetchProduct: %v", err)
+	}
+	if len(got) != 1 || got[0].ua != DefaultUserAgent || got[0].rid != "" {
+		t.Fatalf("bare Ext sent %+v", got)
+	}
+}
+
+// The id the RequestID middleware puts on the context is the one product-service sees.
+func TestExt_ForwardsMiddlewareRequestID(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got []string
+	)
+	products := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = append(got, r.Header.Get(reqid.Header))
+		mu.Unlock()
+		_ = json.NewEncoder(w).Encode(ProductDTO{ID: "p1", Price: "1.00", Stock: 5})
+	}))
+	defer products.Close()
+
+	ext, err := NewExt("localhost:0", products.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(httpx.RequestID())
+	var inCtx string
+	r.POST("/orders", func(c *gin.Context) {
+		inCtx = httpx.RequestIDFromContext(c.Request.Context())
+		if _, err := ext.FetchProduct(c.Request.Context(), "p1"); err != nil {
+			t.Errorf("FetchProduct: %v", err)
+		}
+		if err := ext.AdjustStock(c.Request.Context(), "p1", -1, StockReasonOrder, "o1"); err != nil {
+			t.Errorf("AdjustStock: %v", err)
+		}
+		c.Status(http.StatusCreated)
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", nil)
+	req.Header.Set(reqid.Header, "rid-from-client")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if inCtx != "rid-from-client" {
+		t.Fatalf("RequestIDFromContext=%q, want rid-from-client", inCtx)
+	}
+	if len(got) != 2 || got[0] != "rid-from-client" || got[1] != "rid-from-client" {
+		t.Fatalf("product-service saw %s=%q, want rid-from-client on both calls", reqid.Header, got)
+	}
+
+	// a context built with the helper (e.g. a background job) is forwarded the same way
+	got = nil
+	if _, err := ext.FetchProduct(httpx.ContextWithRequestID(context.Background(), "rid-job"), "p1"); err != nil {
+		t.Fatalf("FetchProduct: %v", err)
+	}
+	if len(got) != 1 || got[0] != "rid-job" {
+		t.Fatalf("product-service saw %s=%q, want rid-job", reqid.Header, got)
+	}
+}
+
+func TestExt_StrictDecode(t *testing.T) {
+	// what product-service answers today, every field included
+	full, err := json.Marshal(product.Product{ID: "p1", Name: "Mate", Price: "9.90", Stock: 3, Status: product.StatusActive, Barcode: "7790001000012", Category: "yerba"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same product after product-service grew a field order-service never heard of
+	var m map[string]any
+	_ = json.Unmarshal(full, &m)
+	m["warehouse"] = "north"
+	extra, _ := json.Marshal(m)
+
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(body)
+	}))
+	defer srv.Close()
+
+	for _, strict := range []bool{false, true} {
+		ext := &Ext{HTTP: srv.Client(), ProductBaseURL: srv.URL, StrictDecode: strict}
+
+		body = full
+		p, err := ext.FetchProduct(context.Background(), "p1")
+		if err != nil || p.ID != "p1" || p.Price != "9.90" || p.Stock != 3 {
+			t.Fatalf("strict=%v, known fields: product=%+v err=%v", strict, p, err)
+		}
+
+		body = extra
+		p, err = ext.FetchProduct(context.Background(), "p1")
+		if strict {
+			if err == nil || !strings.Contains(err.Error(), `unknown field "warehouse"`) {
+				t.Fatalf("strict, extra field: product=%+v err=%v, want an unknown field error", p, err)
+			}
+			continue
+		}
+		if err != nil || p.ID != "p1" {
+			t.Fatalf("lenient, extra field: product=%+v err=%v", p, err)
+		}
+	}
+}
+
+func TestExt_RequestDeadlineBeatsClientTimeout(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		select { // hang until the client gives up
+		case <-r.Context().Done():
+		case <-release:
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	ext, err := NewExt("localhost:0", srv.URL, WithHTTPTimeout(10*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ext.HTTP.Timeout != 10*time.Second {
+		t.Fatalf("client timeout=%s, expected 10s", ext.HTTP.Timeout)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = ext.FetchProduct(ctx, "p1")
+	// neither the client timeout nor the retry backoff outlast the request's deadline
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("FetchProduct took %s, expected it to stop at the 50ms deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err=%v, expected context.DeadlineExceeded", err)
+	}
+	if n := atomic.LoadInt64(&calls); n != 1 {
+		t.Fatalf("%d attempts, expected no retry after the deadline", n)
+	}
+
+	// unset or non-positive falls back to the default
+	for _, opts := range [][]ExtOption{nil, {WithHTTPTimeout(0)}, {WithHTTPTimeout(-time.Second)}} {
+		ext, _ := NewExt("localhost:0", srv.URL, opts...)
+		if ext.HTTP.Timeout != DefaultHTTPTimeout {
+			t.Fatalf("client timeout=%s, expected %s", ext.HTTP.Timeout, DefaultHTTPTimeout)
+		}
+	}
+}
+
+func TestExt_TakeStockRetriesIdempotently(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		stock = 5
+		calls int
+		taken = map[string]bool{}
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var in struct {
+			Qty     int    `json:"qty"`
+			OrderID string `json:"order_id"`
+			ItemID  string `json:"item_id"`
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if err := json.NewDecoder(r.Body).Decode(&in); err != nil || in.ItemID == "" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		if in.Qty > stock {
+			w.WriteHeader(http.StatusConflict)
+			_ = json.NewEncoder(w).Encode(map[string]any{"available": stock})
+			return
+		}
+		key := in.OrderID + "/" + in.ItemID
+		if !taken[key] {
+			taken[key] = true
+			stock -= in.Qty
+		}
+		if calls == 1 {
+			// applied, but the answer is lost
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"stock": stock})
+	}))
+	defer srv.Close()
+	ext := &Ext{HTTP: srv.Client(), ProductBaseURL: srv.URL}
+
+	if err := ext.TakeStock(context.Background(), "p1", 2, "o1", "i1"); err != nil {
+		t.Fatalf("TakeStock: %v", err)
+	}
+	if calls != 2 || stock != 3 {
+		t.Fatalf("calls=%d stock=%d, expected the retry to take the units once (2 and 3)", calls, stock)
+	}
+
+	// running short is an answer, not a failure to retry
+	calls = 0
+	var se *StockError
+	if err := ext.TakeStock(context.Background(), "p1", 4, "o1", "i2"); !errors.As(err, &se) || se.Available != 3 {
+		t.Fatalf("err=%v, expected a *StockError with 3 available", err)
+	}
+	if calls != 1 {
+		t.Fatalf("calls=%d, expected no retry after a 409", calls)
+	}
+}
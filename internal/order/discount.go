@@ -0,0 +1,57 @@
+package order
+
+import (
+	"errors"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInvalidDiscount     = errors.New("discount needs exactly one of percent (0-100] or a positive amount")
+	ErrDiscountExceedsLine = errors.New("discount exceeds the line value")
+)
+
+var hundred = decimal.NewFromInt(100)
+
+const (
+	// DefaultPriceDecimals is the precision prices and totals are frozen with (cents).
+	DefaultPriceDecimals = 2
+	// MaxPriceDecimals bounds PRICE_DECIMALS.
+	MaxPriceDecimals = 6
+)
+
+// ItemDiscount is a promotion on one order line: a percent of the line or a fixed amount off it.
+// swagger:model ItemDiscount
+type ItemDiscount struct {
+	Percent string `json:"percent,omitempty" example:"10"`
+	Amount  string `json:"amount,omitempty"  example:"2.50"`
+}
+
+// ApplyDiscount prices a line of qty units at unit, minus d (nil for none). It returns the
+// discount and the resulting line total, both rounded to places decimals.
+func ApplyDiscount(unit decimal.Decimal, qty int, d *ItemDiscount, places int32) (discount, line decimal.Decimal, err error) {
+	gross := unit.Mul(decimal.NewFromInt(int64(qty))).Round(places)
+	if d == nil || (d.Percent == "" && d.Amount == "") {
+		return decimal.Zero, gross, nil
+	}
+	if d.Percent != "" && d.Amount != "" {
+		return decimal.Zero, gross, ErrInvalidDiscount
+	}
+	if d.Percent != "" {
+		p, err := decimal.NewFromString(d.Percent)
+		if err != nil || !p.IsPositive() || p.GreaterThan(hundred) {
+			return decimal.Zero, gross, ErrInvalidDiscount
+		}
+		discount = gross.Mul(p).Div(hundred).Round(places)
+	} else {
+		a, err := decimal.NewFromString(d.Amount)
+		if err != nil || !a.IsPositive() || !a.Equal(a.Round(places)) {
+			return decimal.Zero, gross, ErrInvalidDiscount
+		}
+		if a.GreaterThan(gross) {
+			return decimal.Zero, gross, ErrDiscountExceedsLine
+		}
+		discount = a
+	}
+	return discount, gross.Sub(discount), nil
+}
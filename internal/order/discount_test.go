@@ -0,0 +1,54 @@
+package order
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestApplyDiscount(t *testing.T) {
+	d := decimal.RequireFromString
+	cases := []struct {
+		unit     string
+		qty      int
+		discount *ItemDiscount
+		wantOff  string
+		wantLine string
+		err      error
+	}{
+		{"10.00", 3, nil, "0", "30", nil},
+		{"10.00", 3, &ItemDiscount{Percent: "10"}, "3", "27", nil},
+		{"9.99", 1, &ItemDiscount{Percent: "33.333"}, "3.33", "6.66", nil}, // rounded to cents
+		{"10.00", 2, &ItemDiscount{Amount: "2.50"}, "2.5", "17.5", nil},
+		{"10.00", 2, &ItemDiscount{Amount: "20"}, "20", "0", nil},   // whole line
+		{"10.00", 2, &ItemDiscount{Percent: "100"}, "20", "0", nil}, // whole line
+		{"10.00", 2, &ItemDiscount{Amount: "20.01"}, "0", "20", ErrDiscountExceedsLine},
+		{"10.00", 2, &ItemDiscount{Percent: "101"}, "0", "20", ErrInvalidDiscount},
+		{"10.00", 2, &ItemDiscount{Percent: "0"}, "0", "20", ErrInvalidDiscount},
+		{"10.00", 2, &ItemDiscount{Amount: "-1"}, "0", "20", ErrInvalidDiscount},
+		{"10.00", 2, &ItemDiscount{Amount: "0.001"}, "0", "20", ErrInvalidDiscount},
+		{"10.00", 2, &ItemDiscount{Percent: "5", Amount: "1"}, "0", "20", ErrInvalidDiscount},
+	}
+	for _, tc := range cases {
+		off, line, err := ApplyDiscount(d(tc.unit), tc.qty, tc.discount, DefaultPriceDecimals)
+		if err != tc.err || !off.Equal(d(tc.wantOff)) || !line.Equal(d(tc.wantLine)) {
+			t.Fatalf("ApplyDiscount(%s, %d, %+v) = %s, %s, %v; want %s, %s, %v",
+				tc.unit, tc.qty, tc.discount, off, line, err, tc.wantOff, tc.wantLine, tc.err)
+		}
+	}
+}
+
+func TestApplyDiscount_Places(t *testing.T) {
+	d := decimal.RequireFromString
+	// 0.0125 per gram: at cents the line would round away the fraction
+	off, line, err := ApplyDiscount(d("0.0125"), 3, &ItemDiscount{Percent: "10"}, 4)
+	if err != nil || !off.Equal(d("0.0038")) || !line.Equal(d("0.0337")) {
+		t.Fatalf("4 places: %s, %s, %v; want 0.0038, 0.0337", off, line, err)
+	}
+	if _, _, err := ApplyDiscount(d("1"), 1, &ItemDiscount{Amount: "0.0001"}, 4); err != nil {
+		t.Fatalf("amount within 4 places rejected: %v", err)
+	}
+	if _, _, err := ApplyDiscount(d("1"), 1, &ItemDiscount{Amount: "0.0001"}, 2); err != ErrInvalidDiscount {
+		t.Fatalf("amount beyond 2 places: err=%v, want ErrInvalidDiscount", err)
+	}
+}
@@ -1,10 +1,34 @@
 package order
 
+import (
+	"errors"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrInvalidItemID   = errors.New("item id must be a UUID")
+	ErrDuplicateItemID = errors.New("duplicate item id")
+)
+
 // CreateOrderItem payload de ítem.
 // swagger:model CreateOrderItem
 type CreateOrderItem struct {
+	// optional: client-supplied line id (UUID); generated when empty
+	ID        string `json:"id,omitempty" example:"0b6f1f8e-8a55-4c1e-a0a4-1c2f0d1f6b7e"`
 	ProductID string `json:"product_id" example:"4e7d4e5c-5cb9-4a3f-9f21-7e1a4f9f2b2a"`
 	Quantity  int    `json:"quantity"  example:"2"`
+	// optional: unit price the client showed; /orders/validate reports it if it changed
+	ExpectedPrice string `json:"expected_price,omitempty" example:"15.00"`
+	// optional per-line promotion: percent or amount, never more than the line value
+	Discount *ItemDiscount `json:"discount,omitempty"`
+}
+
+// MergeUsersRequest payload of POST /orders/merge-users: from_user_id's orders move to to_user_id.
+// swagger:model MergeUsersRequest
+type MergeUsersRequest struct {
+	FromUserID string `json:"from_user_id" example:"b2f5ff47-2b1e-4f22-8a96-5f3c1f2f2e7b"`
+	ToUserID   string `json:"to_user_id" example:"6a0c7a0e-3f7b-4d0e-9a1c-2d5e8f4b1c3a"`
 }
 
 // CreateOrderRequest payload de creación de orden.
@@ -13,3 +37,78 @@ type CreateOrderRequest struct {
 	UserID string            `json:"user_id" example:"b2f5ff47-2b1e-4f22-8a96-5f3c1f2f2e7b"`
 	Items  []CreateOrderItem `json:"items"`
 }
+
+// MissingFields lists the required fields r lacks: user_id, and items when it has none.
+func (r CreateOrderRequest) MissingFields() []string {
+	var missing []string
+	if r.UserID == "" {
+		missing = append(missing, "user_id")
+	}
+	if len(r.Items) == 0 {
+		missing = append(missing, "items")
+	}
+	return missing
+}
+
+// CheckDuplicateItems rejects, before anything is written, lines repeating a client-supplied
+// item id (it would otherwise violate the order_items key mid-transaction). The same product
+// on several lines stays allowed: each line can carry its own discount.
+func CheckDuplicateItems(items []CreateOrderItem) error {
+	ids := make(map[string]bool, len(items))
+	for _, it := range items {
+		if it.ID == "" {
+			continue
+		}
+		if _, err := uuid.Parse(it.ID); err != nil {
+			return ErrInvalidItemID
+		}
+		if ids[it.ID] {
+			return ErrDuplicateItemID
+		}
+		ids[it.ID] = true
+	}
+	return nil
+}
+
+// Problem codes reported by the cart pre-flight.
+const (
+	ProblemInvalidUser       = "invalid_user"
+	ProblemInvalidItem       = "invalid_item"
+	ProblemProductNotFound   = "product_not_found"
+	ProblemInsufficientStock = "insufficient_stock"
+	ProblemPriceChanged      = "price_changed"
+	ProblemInvalidDiscount   = "invalid_discount"
+	// only with REJECT_ZERO_TOTAL: nothing in the cart costs anything and no discount explains it
+	ProblemZeroTotal = "zero_total"
+	// the product exists but its status (discontinued, out_of_stock) isn't active
+	ProblemProductUnavailable = "product_unavailable"
+)
+
+// CartProblem is one thing wrong with a cart.
+// swagger:model CartProblem
+type CartProblem struct {
+	Code          string `json:"code"`
+	ProductID     string `json:"product_id,omitempty"`
+	Requested     int    `json:"requested,omitempty"`
+	Available     *int   `json:"available,omitempty"`
+	ExpectedPrice string `json:"expected_price,omitempty"`
+	CurrentPrice  string `json:"current_price,omitempty"`
+	Status        string `json:"status,omitempty"`
+}
+
+// CartReport is the result of validating a cart without creating the order.
+// swagger:model CartReport
+type CartReport struct {
+	Valid    bool          `json:"valid"`
+	Total    string        `json:"total"`
+	Problems []CartProblem `json:"problems"`
+}
+
+// StockImpact is what creating an order would do to one product's stock (?dry_run=true).
+// swagger:model StockImpact
+type StockImpact struct {
+	ProductID      string `json:"product_id"`
+	Stock          int    `json:"stock"`
+	Requested      int    `json:"requested"`
+	WouldRemaining int    `json:"would_remaining"`
+}
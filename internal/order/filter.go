@@ -0,0 +1,122 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var ErrInvalidTotalFilter = errors.New("min_total/max_total must be non-negative decimals with min_total <= max_total")
+
+// TotalFilter bounds an order's total; a nil end is open. Totals are compared as
+// decimals (NUMERIC in SQL), never as text: "100.00" is above "99.50".
+type TotalFilter struct {
+	Min, Max *decimal.Decimal
+}
+
+// ParseTotalFilter parses the min_total / max_total query values; empty means unbounded.
+func ParseTotalFilter(minRaw, maxRaw string) (TotalFilter, error) {
+	var f TotalFilter
+	for _, b := range []struct {
+		raw string
+		dst **decimal.Decimal
+	}{{minRaw, &f.Min}, {maxRaw, &f.Max}} {
+		if b.raw == "" {
+			continue
+		}
+		v, err := decimal.NewFromString(b.raw)
+		if err != nil || v.IsNegative() {
+			return TotalFilter{}, ErrInvalidTotalFilter
+		}
+		*b.dst = &v
+	}
+	if f.Min != nil && f.Max != nil && f.Min.GreaterThan(*f.Max) {
+		return TotalFilter{}, ErrInvalidTotalFilter
+	}
+	return f, nil
+}
+
+// Match reports whether total is within the bounds.
+func (f TotalFilter) Match(total string) bool {
+	t, err := decimal.NewFromString(total)
+	if err != nil {
+		return false
+	}
+	return (f.Min == nil || t.GreaterThanOrEqual(*f.Min)) && (f.Max == nil || t.LessThanOrEqual(*f.Max))
+}
+
+// args returns the bounds as SQL parameters (NULL when open), to be cast with ::numeric.
+func (f TotalFilter) args() (minArg, maxArg *string) {
+	if f.Min != nil {
+		s := f.Min.String()
+		minArg = &s
+	}
+	if f.Max != nil {
+		s := f.Max.String()
+		maxArg = &s
+	}
+	return minArg, maxArg
+}
+
+// ErrInvalidDateRange is returned by ParseDateRange for a malformed or inverted range.
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+// ParseDateRange reads the from / to query values: RFC3339 or YYYY-MM-DD (midnight UTC), both
+// optional. from is inclusive and to exclusive, so from=2024-01-01&to=2024-02-01 is January.
+// from must be before to.
+func ParseDateRange(from, to string) (*time.Time, *time.Time, error) {
+	parse := func(name, v string) (*time.Time, error) {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return nil, nil
+		}
+		for _, layout := range []string{time.RFC3339, time.DateOnly} {
+			if t, err := time.Parse(layout, v); err == nil {
+				return &t, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: %s must be RFC3339 or YYYY-MM-DD", ErrInvalidDateRange, name)
+	}
+	f, err := parse("from", from)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := parse("to", to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f != nil && t != nil && !f.Before(*t) {
+		return nil, nil, fmt.Errorf("%w: from must be before to", ErrInvalidDateRange)
+	}
+	return f, t, nil
+}
+
+// ListFilter narrows the orders ListByUser / CountByUser go through; a zero field doesn't
+// filter.
+type ListFilter struct {
+	Total TotalFilter
+	// Status keeps only the orders in it; empty is any status
+	Status Status
+	// created_at bounds: From inclusive, To exclusive
+	From, To *time.Time
+}
+
+// Match reports whether o passes every filter.
+func (f ListFilter) Match(o Order) bool {
+	return f.Total.Match(o.Total) &&
+		(f.Status == "" || o.Status == f.Status) &&
+		(f.From == nil || !o.CreatedAt.Before(*f.From)) &&
+		(f.To == nil || o.CreatedAt.Before(*f.To))
+}
+
+// statusArg returns Status as a SQL parameter, NULL when it doesn't filter.
+func (f ListFilter) statusArg() *string {
+	if f.Status == "" {
+		return nil
+	}
+	s := string(f.Status)
+	return &s
+}
@@ -0,0 +1,73 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTotalFilter(t *testing.T) {
+	f, err := ParseTotalFilter("99.50", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as text "100.00" < "99.50"; as numbers it is above the minimum
+	if "100.00" > "99.50" || !f.Match("100.00") {
+		t.Fatal("100.00 should match min_total=99.50")
+	}
+	if f.Match("99.49") || !f.Match("99.5") {
+		t.Fatal("the minimum is inclusive and compared as a number")
+	}
+
+	f, _ = ParseTotalFilter("", "100")
+	if !f.Match("100.00") || f.Match("100.01") || !f.Match("9.99") {
+		t.Fatal("max_total=100 should keep 100.00 and 9.99 and drop 100.01")
+	}
+
+	for _, bad := range [][2]string{{"abc", ""}, {"", "-1"}, {"10", "9.99"}} {
+		if _, err := ParseTotalFilter(bad[0], bad[1]); err != ErrInvalidTotalFilter {
+			t.Fatalf("ParseTotalFilter(%q, %q) err=%v", bad[0], bad[1], err)
+		}
+	}
+}
+
+func TestParseDateRange(t *testing.T) {
+	from, to, err := ParseDateRange("2024-01-01", "2024-02-01T12:00:00+02:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !from.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !to.Equal(time.Date(2024, 2, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("from=%v to=%v", from, to)
+	}
+	if from, to, err := ParseDateRange("", " "); from != nil || to != nil || err != nil {
+		t.Fatalf("empty range: from=%v to=%v err=%v", from, to, err)
+	}
+	for _, bad := range [][2]string{{"2024-1-1", ""}, {"", "yesterday"}, {"2024-02-30", ""}, {"2024-02-01", "2024-01-01"}, {"2024-01-01", "2024-01-01"}} {
+		if _, _, err := ParseDateRange(bad[0], bad[1]); !errors.Is(err, ErrInvalidDateRange) {
+			t.Fatalf("ParseDateRange(%q, %q) err=%v", bad[0], bad[1], err)
+		}
+	}
+}
+
+func TestListFilter(t *testing.T) {
+	from, to, _ := ParseDateRange("2024-01-01", "2024-02-01")
+	f := ListFilter{Status: StatusPaid, From: from, To: to}
+	cases := []struct {
+		o    Order
+		want bool
+	}{
+		{Order{Status: StatusPaid, Total: "1.00", CreatedAt: *from}, true}, // from is inclusive
+		{Order{Status: StatusPaid, Total: "1.00", CreatedAt: *to}, false},  // to is exclusive
+		{Order{Status: StatusPaid, Total: "1.00", CreatedAt: to.Add(-time.Nanosecond)}, true},
+		{Order{Status: StatusPaid, Total: "1.00", CreatedAt: from.Add(-time.Nanosecond)}, false},
+		{Order{Status: StatusPending, Total: "1.00", CreatedAt: from.AddDate(0, 0, 9)}, false},
+	}
+	for i, tc := range cases {
+		if got := f.Match(tc.o); got != tc.want {
+			t.Fatalf("case %d: Match=%v, expected %v", i, got, tc.want)
+		}
+	}
+	if !(ListFilter{}).Match(Order{Status: StatusCanceled, Total: "0.00"}) {
+		t.Fatal("the zero filter should match every order")
+	}
+}
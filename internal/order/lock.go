@@ -0,0 +1,61 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Fields of an order that mutating endpoints change and ORDER_LOCKED_FIELDS can lock.
+const (
+	// FieldItems: its lines (POST /orders/{id}/items).
+	FieldItems = "items"
+	// FieldTotal: the stored total (POST /orders/{id}/recompute-total).
+	FieldTotal = "total"
+)
+
+// ErrInvalidLockedFields is returned by ParseLockedFields for an unknown field.
+var ErrInvalidLockedFields = errors.New("invalid locked fields")
+
+// LockedFields are the fields that can no longer change once an order has been paid.
+type LockedFields map[string]bool
+
+// DefaultLockedFields is used when ORDER_LOCKED_FIELDS is unset: a paid order keeps its
+// lines and its total.
+var DefaultLockedFields = LockedFields{FieldItems: true, FieldTotal: true}
+
+// ParseLockedFields reads ORDER_LOCKED_FIELDS, e.g. "items,total". Empty means
+// DefaultLockedFields and "none" locks nothing.
+func ParseLockedFields(s string) (LockedFields, error) {
+	s = strings.TrimSpace(s)
+	switch strings.ToLower(s) {
+	case "":
+		return DefaultLockedFields, nil
+	case "none":
+		return LockedFields{}, nil
+	}
+	l := LockedFields{}
+	for _, f := range strings.Split(s, ",") {
+		switch f = strings.ToLower(strings.TrimSpace(f)); f {
+		case FieldItems, FieldTotal:
+			l[f] = true
+		case "":
+		default:
+			return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidLockedFields, f)
+		}
+	}
+	return l, nil
+}
+
+// Mutable reports whether field of o may still change: always before payment, and
+// afterwards (paid, shipped, delivered, refunded, or canceled after paying) only when not locked.
+func (l LockedFields) Mutable(o *Order, field string) bool {
+	if !l[field] {
+		return true
+	}
+	switch o.Status {
+	case StatusPaid, StatusShipped, StatusDelivered, StatusRefunded:
+		return false
+	}
+	return o.PaidAt == nil
+}
@@ -0,0 +1,56 @@
+package order
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParseLockedFields(t *testing.T) {
+	cases := []struct {
+		in   string
+		want LockedFields
+		err  error
+	}{
+		{"", DefaultLockedFields, nil},
+		{"none", LockedFields{}, nil},
+		{" Items , total ", LockedFields{FieldItems: true, FieldTotal: true}, nil},
+		{"total,", LockedFields{FieldTotal: true}, nil},
+		{"items,note", nil, ErrInvalidLockedFields},
+	}
+	for _, tc := range cases {
+		got, err := ParseLockedFields(tc.in)
+		if !errors.Is(err, tc.err) || len(got) != len(tc.want) {
+			t.Fatalf("ParseLockedFields(%q) = %v, %v; want %v, %v", tc.in, got, err, tc.want, tc.err)
+		}
+		for f := range tc.want {
+			if !got[f] {
+				t.Fatalf("ParseLockedFields(%q) = %v, missing %s", tc.in, got, f)
+			}
+		}
+	}
+}
+
+func TestLockedFields_Mutable(t *testing.T) {
+	paidAt := time.Now()
+	l := LockedFields{FieldItems: true}
+	cases := []struct {
+		order Order
+		field string
+		want  bool
+	}{
+		{Order{Status: StatusDraft}, FieldItems, true},
+		{Order{Status: StatusPending}, FieldItems, true},
+		{Order{Status: StatusPaid}, FieldItems, false},
+		{Order{Status: StatusShipped}, FieldItems, false},
+		{Order{Status: StatusRefunded}, FieldItems, false},
+		{Order{Status: StatusCanceled}, FieldItems, true},                   // canceled before paying
+		{Order{Status: StatusCanceled, PaidAt: &paidAt}, FieldItems, false}, // canceled after paying
+		{Order{Status: StatusPaid}, FieldTotal, true},                       // not locked
+	}
+	for _, tc := range cases {
+		if got := l.Mutable(&tc.order, tc.field); got != tc.want {
+			t.Fatalf("Mutable(%s, paid_at=%v, %s) = %v; want %v", tc.order.Status, tc.order.PaidAt != nil, tc.field, got, tc.want)
+		}
+	}
+}
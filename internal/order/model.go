@@ -5,16 +5,60 @@ import "time"
 type Order struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
-	Status    string    `json:"status"`
+	Status    Status    `json:"status"`
 	Total     string    `json:"total"` // NUMERIC -> string
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Only set for drafts: when the stock hold is released
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Set when the order moves to paid
+	PaidAt *time.Time `json:"paid_at,omitempty"`
+	// Set once, when the order first moves to shipped / delivered; never cleared
+	ShippedAt   *time.Time `json:"shipped_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// Number of item lines; only filled in list rows (ListByUser)
+	ItemCount int `json:"item_count,omitempty"`
 }
 
 type Item struct {
-	ID        string `json:"id"`
-	OrderID   string `json:"order_id"`
+	ID      string `json:"id"`
+	OrderID string `json:"order_id"`
+	// 1-based position within the order, fixed at creation (invoice line)
+	LineNo    int    `json:"line_no"`
 	ProductID string `json:"product_id"`
 	Quantity  int    `json:"quantity"`
-	Price     string `json:"price"`
+	// unit price frozen at order time
+	Price string `json:"price"`
+	// amount taken off this line, empty when none
+	Discount string `json:"discount,omitempty"`
+	// what the line costs: quantity * price - discount
+	LineTotal string `json:"line_total"`
+}
+
+// ItemWithProduct is an Item enriched with the product's live data (?expand=product).
+// CurrentPrice and ProductName are nil when the product no longer exists at all;
+// a soft-deleted product still resolves, with ProductDeleted set.
+type ItemWithProduct struct {
+	Item
+	ProductName    *string `json:"product_name"`
+	CurrentPrice   *string `json:"current_price"`
+	PriceChanged   bool    `json:"price_changed"`
+	ProductDeleted bool    `json:"product_deleted,omitempty"`
+}
+
+// ProductTotal is how much of one product a user has bought across their paid orders.
+// ProductName is only filled with ?expand=product, nil when the product no longer exists.
+type ProductTotal struct {
+	ProductID   string  `json:"product_id"`
+	Quantity    int     `json:"quantity"`
+	Orders      int     `json:"orders"`
+	Spent       string  `json:"spent"`
+	ProductName *string `json:"product_name,omitempty"`
+}
+
+// RelatedProduct is a product bought together with another: Orders counts the orders that
+// hold both.
+type RelatedProduct struct {
+	ProductID string `json:"product_id"`
+	Orders    int    `json:"orders"`
 }
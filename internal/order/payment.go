@@ -0,0 +1,35 @@
+package order
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// PaymentEvent is a payment provider callback; ProviderRef makes it idempotent.
+type PaymentEvent struct {
+	OrderID     string `json:"order_id"`
+	Status      string `json:"status"` // provider status, "paid" moves the order to paid
+	ProviderRef string `json:"provider_ref"`
+}
+
+// SignPayload returns the hex HMAC-SHA256 of body with secret.
+func SignPayload(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks sig (hex, optionally prefixed with "sha256=") against body.
+func VerifySignature(secret string, body []byte, sig string) bool {
+	if secret == "" || sig == "" {
+		return false
+	}
+	got, err := hex.DecodeString(strings.TrimPrefix(sig, "sha256="))
+	if err != nil {
+		return false
+	}
+	want, _ := hex.DecodeString(SignPayload(secret, body))
+	return hmac.Equal(got, want)
+}
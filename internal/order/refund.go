@@ -0,0 +1,75 @@
+package order
+
+import (
+	"errors"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrNotPaid            = errors.New("order is not paid")
+	ErrRefundExceedsTotal = errors.New("refund exceeds the refundable amount")
+	ErrRefundItems        = errors.New("refund items exceed the ordered quantities")
+)
+
+// RefundItem is a product quantity given back with a refund.
+type RefundItem struct {
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+}
+
+// Refund is a (possibly partial) refund recorded against a paid order.
+type Refund struct {
+	ID      string       `json:"id"`
+	OrderID string       `json:"order_id"`
+	Amount  string       `json:"amount"` // NUMERIC -> string
+	Reason  string       `json:"reason"`
+	Restock bool         `json:"restock"`
+	Items   []RefundItem `json:"items,omitempty"`
+	At      time.Time    `json:"at"`
+}
+
+// CreateRefundRequest payload of POST /orders/{id}/refunds.
+// swagger:model CreateRefundRequest
+type CreateRefundRequest struct {
+	Amount string `json:"amount" example:"5.00"`
+	Reason string `json:"reason" example:"damaged item"`
+	// optional: products refunded; with restock=true their stock is given back
+	Items   []RefundItem `json:"items,omitempty"`
+	Restock bool         `json:"restock,omitempty"`
+}
+
+// CheckRefundAmount returns the refunded total after adding amount, or
+// ErrRefundExceedsTotal when total minus the prior refunds cannot cover it.
+func CheckRefundAmount(total, refunded, amount decimal.Decimal) (decimal.Decimal, error) {
+	after := refunded.Add(amount)
+	if after.GreaterThan(total) {
+		return refunded, ErrRefundExceedsTotal
+	}
+	return after, nil
+}
+
+// UnrestockedItems is what canceling a paid order still has to give back: items minus the
+// units that refunds with restock already returned, per product. Lines left with nothing
+// are dropped.
+func UnrestockedItems(items []Item, refunds []Refund) []Item {
+	returned := map[string]int{}
+	for _, rf := range refunds {
+		if !rf.Restock {
+			continue
+		}
+		for _, it := range rf.Items {
+			returned[it.ProductID] += it.Quantity
+		}
+	}
+	out := make([]Item, 0, len(items))
+	for _, it := range items {
+		taken := min(returned[it.ProductID], it.Quantity)
+		returned[it.ProductID] -= taken
+		if it.Quantity -= taken; it.Quantity > 0 {
+			out = append(out, it)
+		}
+	}
+	return out
+}
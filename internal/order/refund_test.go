@@ -0,0 +1,51 @@
+package order
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestCheckRefundAmount(t *testing.T) {
+	d := decimal.RequireFromString
+	cases := []struct {
+		total, refunded, amount string
+		want                    string
+		err                     error
+	}{
+		{"30.00", "0", "10.00", "10", nil},
+		{"30.00", "10.00", "20.00", "30", nil}, // exactly the rest
+		{"30.00", "10.00", "20.01", "10", ErrRefundExceedsTotal},
+		{"0.30", "0.10", "0.20", "0.3", nil}, // no float rounding
+	}
+	for _, tc := range cases {
+		got, err := CheckRefundAmount(d(tc.total), d(tc.refunded), d(tc.amount))
+		if err != tc.err || !got.Equal(d(tc.want)) {
+			t.Fatalf("CheckRefundAmount(%s, %s, %s) = %s, %v; want %s, %v",
+				tc.total, tc.refunded, tc.amount, got, err, tc.want, tc.err)
+		}
+	}
+}
+
+func TestUnrestockedItems(t *testing.T) {
+	items := []Item{
+		{ProductID: "a", Quantity: 2},
+		{ProductID: "b", Quantity: 1},
+		{ProductID: "a", Quantity: 3},
+	}
+	refunds := []Refund{
+		{Restock: true, Items: []RefundItem{{ProductID: "a", Quantity: 3}}},
+		{Restock: false, Items: []RefundItem{{ProductID: "b", Quantity: 1}}}, // money only
+		{Restock: true, Items: []RefundItem{{ProductID: "b", Quantity: 1}}},
+	}
+	got := UnrestockedItems(items, refunds)
+	if len(got) != 1 || got[0].ProductID != "a" || got[0].Quantity != 2 {
+		t.Fatalf("UnrestockedItems = %+v, want only 2 units of a", got)
+	}
+	if items[0].Quantity != 2 || items[2].Quantity != 3 {
+		t.Fatalf("input modified: %+v", items)
+	}
+	if got := UnrestockedItems(items, nil); len(got) != len(items) {
+		t.Fatalf("no refunds: %+v", got)
+	}
+}
@@ -7,23 +7,105 @@ import (
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 )
 
 var (
-	ErrNotFound = errors.New("order not found")
+	ErrNotFound     = errors.New("order not found")
+	ErrNotDraft     = errors.New("order is not a draft")
+	ErrDraftExpired = errors.New("draft expired")
+	// ErrInvalidTransition means the order's current status does not allow the change.
+	ErrInvalidTransition = errors.New("invalid status transition")
 )
 
+// Repository methods returning lists give an empty slice, never nil, when nothing matches,
+// so handlers serialize [] instead of null.
 type Repository interface {
 	Create(ctx context.Context, o *Order, items []Item) error
 	GetByID(ctx context.Context, id string) (*Order, []Item, error)
-	ListByUser(ctx context.Context, userID string, limit, offset int) ([]Order, error)
-	UpdateStatus(ctx context.Context, id, status string) error
+	ListByUser(ctx context.Context, userID string, f ListFilter, limit, offset int) (list []Order, truncated bool, err error)
+	CountByUser(ctx context.Context, userID string, f ListFilter) (int, error)
+	HasOrders(ctx context.Context, userID string) (bool, error)
+	StatusCounts(ctx context.Context, userID string) (map[Status]int, error)
+	ProductTotals(ctx context.Context, userID string) ([]ProductTotal, error)
+	LatestByUser(ctx context.Context, userID string) (*Order, []Item, error)
+	ActiveQuantity(ctx context.Context, productID string) (int, error)
+	RelatedProducts(ctx context.Context, productID string, limit int) ([]RelatedProduct, error)
+	UpdateStatus(ctx context.Context, id string, status Status) error
+	ReassignUser(ctx context.Context, fromUserID, toUserID string) (moved int, err error)
 	GetItems(ctx context.Context, orderID string) ([]Item, error)
+	RecomputeTotal(ctx context.Context, id string, places int32) (old, new string, err error)
+	AddItem(ctx context.Context, orderID string, it *Item, places int32) (total string, err error)
+
+	CommitDraft(ctx context.Context, id string) error
+	ExpireDrafts(ctx context.Context) ([]string, error)
+
+	ApplyPayment(ctx context.Context, ev PaymentEvent) (applied bool, err error)
+	MarkPaid(ctx context.Context, id string) (changed bool, err error)
+	RecordRestockFailure(ctx context.Context, f *RestockFailure) error
+	CreateRefund(ctx context.Context, rf *Refund, places int32) (refunded string, err error)
+	ListRefunds(ctx context.Context, orderID string) ([]Refund, error)
+
+	StartSaga(ctx context.Context, orderID string) error
+	RecordSagaStep(ctx context.Context, orderID, productID string, qty int) (stepID int64, err error)
+	CompensateSagaStep(ctx context.Context, stepID int64) error
+	FinishSaga(ctx context.Context, orderID string, state SagaState) error
+	StaleSagas(ctx context.Context, olderThan time.Duration) ([]Saga, error)
+
+	Tenants(ctx context.Context) ([]string, error)
+
+	SalesTenants(ctx context.Context, day time.Time) ([]string, error)
+	PaidLines(ctx context.Context, day time.Time) ([]SaleLine, error)
+	SaveDailySales(ctx context.Context, day time.Time, sales []DailySale) error
+	DailySales(ctx context.Context, from, to time.Time) ([]DailySale, error)
 }
 
-type PGRepo struct{ db *pgxpool.Pool }
+// orderColumns is the SELECT list matching scanOrder.
+const orderColumns = `id,user_id,status,total::text,created_at,updated_at,expires_at,paid_at,shipped_at,delivered_at`
+
+// scanOrder scans orderColumns into o, then any extra columns selected after them.
+func scanOrder(row pgx.Row, o *Order, extra ...any) error {
+	dest := []any{&o.ID, &o.UserID, &o.Status, &o.Total, &o.CreatedAt, &o.UpdatedAt, &o.ExpiresAt, &o.PaidAt, &o.ShippedAt, &o.DeliveredAt}
+	return row.Scan(append(dest, extra...)...)
+}
 
-func NewPGRepo(db *pgxpool.Pool) *PGRepo { return &PGRepo{db: db} }
+// itemColumns is the SELECT list matching scanItem.
+const itemColumns = `id,order_id,line_no,product_id,quantity,price::text,COALESCE(NULLIF(discount,0)::text,''),line_total::text`
+
+func scanItem(row pgx.Row, it *Item) error {
+	return row.Scan(&it.ID, &it.OrderID, &it.LineNo, &it.ProductID, &it.Quantity, &it.Price, &it.Discount, &it.LineTotal)
+}
+
+// DefaultMaxListRows is the most rows one ListByUser call returns when MAX_LIST_ROWS is unset.
+const DefaultMaxListRows = 100
+
+type PGRepo struct {
+	db          *pgxpool.Pool
+	maxListRows int
+}
+
+// RepoOption customizes a PGRepo.
+type RepoOption func(*PGRepo)
+
+// WithMaxListRows caps the rows one ListByUser call returns, whatever limit it is given;
+// n <= 0 keeps DefaultMaxListRows.
+func WithMaxListRows(n int) RepoOption {
+	return func(r *PGRepo) {
+		if n > 0 {
+			r.maxListRows = n
+		}
+	}
+}
+
+func NewPGRepo(db *pgxpool.Pool, opts ...RepoOption) *PGRepo {
+	r := &PGRepo{db: db, maxListRows: DefaultMaxListRows}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
 
 func (r *PGRepo) Create(ctx context.Context, o *Order, items []Item) error {
 	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
@@ -33,86 +115,297 @@ func (r *PGRepo) Create(ctx context.Context, o *Order, items []Item) error {
 	defer func() { _ = tx.Rollback(ctx) }()
 
 	if _, err := tx.Exec(ctx, `
-    INSERT INTO orders (id, user_id, status, total, created_at, updated_at)
-    VALUES ($1,$2,$3,$4,NOW(),NOW())
-  `, o.ID, o.UserID, o.Status, o.Total); err != nil {
+    INSERT INTO orders (id, user_id, status, total, tenant_id, created_at, updated_at, expires_at)
+    VALUES ($1,$2,$3,$4,$6,NOW(),NOW(),$5)
+  `, o.ID, o.UserID, o.Status, o.Total, o.ExpiresAt, tenant.From(ctx)); err != nil {
 		return err
 	}
 
-	for _, it := range items {
+	for i, it := range items {
+		// lines are numbered in request order when the caller did not
+		if it.LineNo == 0 {
+			it.LineNo = i + 1
+		}
 		if _, err := tx.Exec(ctx, `
-      INSERT INTO order_items (id, order_id, product_id, quantity, price)
-      VALUES ($1,$2,$3,$4,$5)
-    `, it.ID, o.ID, it.ProductID, it.Quantity, it.Price); err != nil {
+      INSERT INTO order_items (id, order_id, product_id, quantity, price, discount, line_total, line_no)
+      VALUES ($1,$2,$3,$4,$5::numeric,
+              COALESCE(NULLIF($6::text,'')::numeric, 0),
+              COALESCE(NULLIF($7::text,'')::numeric, $4 * $5::numeric), $8)
+    `, it.ID, o.ID, it.ProductID, it.Quantity, it.Price, it.Discount, it.LineTotal, it.LineNo); err != nil {
 			return err
 		}
 	}
 	return tx.Commit(ctx)
 }
 
+// GetByID returns the order with its items, or ErrNotFound; other errors are the database's.
 func (r *PGRepo) GetByID(ctx context.Context, id string) (*Order, []Item, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
 	var o Order
-	if err := r.db.QueryRow(ctx, `
-    SELECT id,user_id,status,total::text,created_at,updated_at
-    FROM orders WHERE id=$1
-  `, id).Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.CreatedAt, &o.UpdatedAt); err != nil {
+	if err := scanOrder(r.db.QueryRow(ctx, `
+    SELECT `+orderColumns+`
+    FROM orders WHERE id=$1 AND tenant_id=$2
+  `, id, tenant.From(ctx)), &o); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	items, err := r.orderItems(ctx, id)
+	if err != nil {
 		return nil, nil, err
 	}
+	return &o, items, nil
+}
+
+// LatestByUser returns the user's most recent order with its items, or ErrNotFound.
+func (r *PGRepo) LatestByUser(ctx context.Context, userID string) (*Order, []Item, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var o Order
+	if err := scanOrder(r.db.QueryRow(ctx, `
+    SELECT `+orderColumns+`
+    FROM orders WHERE user_id=$1 AND tenant_id=$2
+    ORDER BY created_at DESC, id DESC LIMIT 1
+  `, userID, tenant.From(ctx)), &o); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil, ErrNotFound
+		}
+		return nil, nil, err
+	}
+	items, err := r.orderItems(ctx, o.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &o, items, nil
+}
+
+// orderItems loads the items of an order already resolved within the tenant.
+func (r *PGRepo) orderItems(ctx context.Context, orderID string) ([]Item, error) {
 	rows, err := r.db.Query(ctx, `
-    SELECT id,order_id,product_id,quantity,price::text
+    SELECT `+itemColumns+`
     FROM order_items WHERE order_id=$1
-  `, id)
+    ORDER BY line_no
+  `, orderID)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 	defer rows.Close()
-	var items []Item
+	items := []Item{}
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.ID, &it.OrderID, &it.ProductID, &it.Quantity, &it.Price); err != nil {
-			return nil, nil, err
+		if err := scanItem(rows, &it); err != nil {
+			return nil, err
 		}
 		items = append(items, it)
 	}
-	return &o, items, rows.Err()
+	return items, rows.Err()
 }
 
-func (r *PGRepo) ListByUser(ctx context.Context, userID string, limit, offset int) ([]Order, error) {
-	if limit <= 0 || limit > 100 {
+// HasOrders reports whether the user has at least one order (EXISTS, no list or count).
+func (r *PGRepo) HasOrders(ctx context.Context, userID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var ok bool
+	err := r.db.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE user_id=$1 AND tenant_id=$2)`, userID, tenant.From(ctx)).Scan(&ok)
+	return ok, err
+}
+
+// ActiveQuantity sums the units of productID in orders that still hold stock: every
+// status but canceled (drafts hold it until they expire).
+func (r *PGRepo) ActiveQuantity(ctx context.Context, productID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var n int
+	err := r.db.QueryRow(ctx, `
+    SELECT COALESCE(SUM(i.quantity), 0)
+    FROM order_items i JOIN orders o ON o.id = i.order_id
+    WHERE i.product_id = $1 AND o.tenant_id = $2 AND o.status <> $3
+  `, productID, tenant.From(ctx), StatusCanceled).Scan(&n)
+	return n, err
+}
+
+// RelatedProducts lists the limit products found most often in the same orders as productID
+// (a self-join on order_items), most shared orders first. Drafts and canceled orders were
+// never bought, so they don't count.
+func (r *PGRepo) RelatedProducts(ctx context.Context, productID string, limit int) ([]RelatedProduct, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT b.product_id, COUNT(DISTINCT a.order_id)
+    FROM order_items a
+    JOIN order_items b ON b.order_id = a.order_id AND b.product_id <> a.product_id
+    JOIN orders o ON o.id = a.order_id
+    WHERE a.product_id = $1 AND o.tenant_id = $2 AND o.status NOT IN ($3, $4)
+    GROUP BY b.product_id
+    ORDER BY COUNT(DISTINCT a.order_id) DESC, b.product_id
+    LIMIT $5
+  `, productID, tenant.From(ctx), StatusDraft, StatusCanceled, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []RelatedProduct{}
+	for rows.Next() {
+		var rp RelatedProduct
+		if err := rows.Scan(&rp.ProductID, &rp.Orders); err != nil {
+			return nil, err
+		}
+		out = append(out, rp)
+	}
+	return out, rows.Err()
+}
+
+// StatusCounts counts a user's orders per status in one query; every status is present,
+// with 0 when the user has none in it.
+func (r *PGRepo) StatusCounts(ctx context.Context, userID string) (map[Status]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT status, COUNT(*) FROM orders
+    WHERE user_id=$1 AND tenant_id=$2
+    GROUP BY status
+  `, userID, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := make(map[Status]int, len(Statuses))
+	for _, s := range Statuses {
+		counts[s] = 0
+	}
+	for rows.Next() {
+		var s Status
+		var n int
+		if err := rows.Scan(&s, &n); err != nil {
+			return nil, err
+		}
+		counts[s] = n
+	}
+	return counts, rows.Err()
+}
+
+// ProductTotals sums, per product, what the user bought in their settled (paid, shipped or
+// delivered) orders in one GROUP BY: units, number of orders and line totals. Most bought first.
+func (r *PGRepo) ProductTotals(ctx context.Context, userID string) ([]ProductTotal, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT i.product_id, SUM(i.quantity), COUNT(DISTINCT o.id), SUM(i.line_total)::text
+    FROM order_items i JOIN orders o ON o.id = i.order_id
+    WHERE o.user_id = $1 AND o.tenant_id = $2 AND o.status = ANY($3)
+    GROUP BY i.product_id
+    ORDER BY SUM(i.quantity) DESC, i.product_id
+  `, userID, tenant.From(ctx), settledStatuses())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []ProductTotal{}
+	for rows.Next() {
+		var t ProductTotal
+		if err := rows.Scan(&t.ProductID, &t.Quantity, &t.Orders, &t.Spent); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// ListByUser lists a user's orders, newest first, that pass f (totals compared as NUMERIC).
+// The id breaks created_at ties so pages neither repeat nor skip orders.
+// Each row carries its ItemCount so list views need no per-order item lookup.
+// A limit above the MAX_LIST_ROWS ceiling is cut down to it; truncated reports that the cut
+// left matching orders out.
+func (r *PGRepo) ListByUser(ctx context.Context, userID string, f ListFilter, limit, offset int) ([]Order, bool, error) {
+	if limit <= 0 {
 		limit = 20
 	}
 	if offset < 0 {
 		offset = 0
 	}
+	capped := limit > r.maxListRows
+	fetch := limit
+	if capped {
+		// one row past the ceiling tells whether the cut dropped anything
+		limit, fetch = r.maxListRows, r.maxListRows+1
+	}
+	minTotal, maxTotal := f.Total.args()
 	rows, err := r.db.Query(ctx, `
-    SELECT id,user_id,status,total::text,created_at,updated_at
-    FROM orders WHERE user_id=$1
-    ORDER BY created_at DESC LIMIT $2 OFFSET $3
-  `, userID, limit, offset)
+    SELECT `+orderColumns+`,
+           (SELECT COUNT(*) FROM order_items i WHERE i.order_id = orders.id)
+    FROM orders
+    WHERE user_id=$1 AND tenant_id=$4
+      AND ($5::numeric IS NULL OR total >= $5::numeric)
+      AND ($6::numeric IS NULL OR total <= $6::numeric)
+      AND ($7::text IS NULL OR status = $7::text)
+      AND ($8::timestamptz IS NULL OR created_at >= $8::timestamptz)
+      AND ($9::timestamptz IS NULL OR created_at < $9::timestamptz)
+    ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3
+  `, userID, fetch, offset, tenant.From(ctx), minTotal, maxTotal, f.statusArg(), f.From, f.To)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer rows.Close()
-	var out []Order
+	out := []Order{}
 	for rows.Next() {
 		var o Order
-		if err := rows.Scan(&o.ID, &o.UserID, &o.Status, &o.Total, &o.CreatedAt, &o.UpdatedAt); err != nil {
-			return nil, err
+		if err := scanOrder(rows, &o, &o.ItemCount); err != nil {
+			return nil, false, err
 		}
 		out = append(out, o)
 	}
-	return out, rows.Err()
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+	if len(out) > limit {
+		return out[:limit], true, nil
+	}
+	return out, false, nil
 }
 
-func (r *PGRepo) UpdateStatus(ctx context.Context, id, status string) error {
+// CountByUser counts every order ListByUser would page through for userID and f.
+func (r *PGRepo) CountByUser(ctx context.Context, userID string, f ListFilter) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	minTotal, maxTotal := f.Total.args()
+	var n int
+	err := r.db.QueryRow(ctx, `
+    SELECT COUNT(*) FROM orders
+    WHERE user_id=$1 AND tenant_id=$2
+      AND ($3::numeric IS NULL OR total >= $3::numeric)
+      AND ($4::numeric IS NULL OR total <= $4::numeric)
+      AND ($5::text IS NULL OR status = $5::text)
+      AND ($6::timestamptz IS NULL OR created_at >= $6::timestamptz)
+      AND ($7::timestamptz IS NULL OR created_at < $7::timestamptz)
+  `, userID, tenant.From(ctx), minTotal, maxTotal, f.statusArg(), f.From, f.To).Scan(&n)
+	return n, err
+}
+
+func (r *PGRepo) UpdateStatus(ctx context.Context, id string, status Status) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	tag, err := r.db.Exec(ctx, `
     UPDATE orders
-    SET status = $2, updated_at = NOW()
-    WHERE id = $1
-  `, id, status)
+    SET status = $2,
+        paid_at = CASE WHEN $2 = 'paid' THEN COALESCE(paid_at, NOW()) ELSE paid_at END,
+        shipped_at = CASE WHEN $2 = 'shipped' THEN COALESCE(shipped_at, NOW()) ELSE shipped_at END,
+        delivered_at = CASE WHEN $2 = 'delivered' THEN COALESCE(delivered_at, NOW()) ELSE delivered_at END,
+        updated_at = NOW()
+    WHERE id = $1 AND tenant_id = $3
+  `, id, status, tenant.From(ctx))
 	if err != nil {
 		return err
 	}
@@ -122,27 +415,730 @@ func (r *PGRepo) UpdateStatus(ctx context.Context, id, status string) error {
 	return nil
 }
 
+// ReassignUser moves every order of fromUserID (within the tenant) to toUserID, for merging
+// duplicate accounts. One transaction: either all the orders move or none does.
+func (r *PGRepo) ReassignUser(ctx context.Context, fromUserID, toUserID string) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	tag, err := tx.Exec(ctx, `
+    UPDATE orders SET user_id = $2, updated_at = NOW()
+    WHERE user_id = $1 AND tenant_id = $3
+  `, fromUserID, toUserID, tenant.From(ctx))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return int(tag.RowsAffected()), nil
+}
+
 func (r *PGRepo) GetItems(ctx context.Context, orderID string) ([]Item, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	rows, err := r.db.Query(ctx, `
-    SELECT id, order_id, product_id, quantity, price::text
+    SELECT `+itemColumns+`
     FROM order_items
-    WHERE order_id = $1
-  `, orderID)
+    WHERE order_id = $1 AND order_id IN (SELECT id FROM orders WHERE tenant_id = $2)
+    ORDER BY line_no
+  `, orderID, tenant.From(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []Item
+	items := []Item{}
 	for rows.Next() {
 		var it Item
-		if err := rows.Scan(&it.ID, &it.OrderID, &it.ProductID, &it.Quantity, &it.Price); err != nil {
+		if err := scanItem(rows, &it); err != nil {
 			return nil, err
 		}
 		items = append(items, it)
 	}
 	return items, rows.Err()
 }
+
+// RecomputeTotal recalculates the order total from its persisted items (through
+// ComputeOrderTotal, at places decimals) and stores it. It returns the previous and the
+// recomputed totals.
+func (r *PGRepo) RecomputeTotal(ctx context.Context, id string, places int32) (string, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var oldTotal string
+	if err := tx.QueryRow(ctx, `
+    SELECT total::text FROM orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE
+  `, id, tenant.From(ctx)).Scan(&oldTotal); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", "", ErrNotFound
+		}
+		return "", "", err
+	}
+
+	rows, err := tx.Query(ctx, `
+    SELECT `+itemColumns+`
+    FROM order_items WHERE order_id=$1
+    ORDER BY line_no
+  `, id)
+	if err != nil {
+		return "", "", err
+	}
+	var items []Item
+	for rows.Next() {
+		var it Item
+		if err := scanItem(rows, &it); err != nil {
+			rows.Close()
+			return "", "", err
+		}
+		items = append(items, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", "", err
+	}
+	_, _, newTotal, err := ComputeOrderTotal(items, "", "", places)
+	if err != nil {
+		return "", "", err
+	}
+
+	if _, err := tx.Exec(ctx, `
+    UPDATE orders SET total = $2, updated_at = NOW() WHERE id = $1
+  `, id, newTotal); err != nil {
+		return "", "", err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return "", "", err
+	}
+	return oldTotal, newTotal, nil
+}
+
+// AddItem appends it as the order's next line (line_no after the last one) and stores the
+// total recomputed over every line. It returns the new total, or ErrNotFound.
+func (r *PGRepo) AddItem(ctx context.Context, orderID string, it *Item, places int32) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// the row lock serializes concurrent additions, so line numbers stay unique
+	var found int
+	if err := tx.QueryRow(ctx, `
+    SELECT 1 FROM orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE
+  `, orderID, tenant.From(ctx)).Scan(&found); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+
+	it.OrderID = orderID
+	if err := tx.QueryRow(ctx, `
+    INSERT INTO order_items (id, order_id, product_id, quantity, price, discount, line_total, line_no)
+    VALUES ($1,$2,$3,$4,$5::numeric,
+            COALESCE(NULLIF($6::text,'')::numeric, 0),
+            COALESCE(NULLIF($7::text,'')::numeric, $4 * $5::numeric),
+            (SELECT COALESCE(MAX(line_no), 0) + 1 FROM order_items WHERE order_id = $2))
+    RETURNING line_no
+  `, it.ID, orderID, it.ProductID, it.Quantity, it.Price, it.Discount, it.LineTotal).Scan(&it.LineNo); err != nil {
+		return "", err
+	}
+
+	rows, err := tx.Query(ctx, `SELECT `+itemColumns+` FROM order_items WHERE order_id=$1`, orderID)
+	if err != nil {
+		return "", err
+	}
+	var items []Item
+	for rows.Next() {
+		var x Item
+		if err := scanItem(rows, &x); err != nil {
+			rows.Close()
+			return "", err
+		}
+		items = append(items, x)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	_, _, total, err := ComputeOrderTotal(items, "", "", places)
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(ctx, `
+    UPDATE orders SET total = $2, updated_at = NOW() WHERE id = $1
+  `, orderID, total); err != nil {
+		return "", err
+	}
+	return total, tx.Commit(ctx)
+}
+
+// CommitDraft turns a non-expired draft into a pending order (the stock hold becomes the order's).
+func (r *PGRepo) CommitDraft(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tag, err := r.db.Exec(ctx, `
+    UPDATE orders
+    SET status = $2, expires_at = NULL, updated_at = NOW()
+    WHERE id = $1 AND tenant_id = $4 AND status = $3 AND expires_at > NOW()
+  `, id, StatusPending, StatusDraft, tenant.From(ctx))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+	// why not? missing, not a draft, or expired
+	var st Status
+	if err := r.db.QueryRow(ctx, `SELECT status FROM orders WHERE id=$1 AND tenant_id=$2`, id, tenant.From(ctx)).Scan(&st); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return ErrNotFound
+		}
+		return err
+	}
+	if st != StatusDraft {
+		return ErrNotDraft
+	}
+	return ErrDraftExpired
+}
+
+// ExpireDrafts cancels every draft past its expires_at and returns their IDs, so the
+// caller can release the held stock. Claiming them with one UPDATE keeps concurrent
+// sweepers from releasing the same hold twice.
+func (r *PGRepo) ExpireDrafts(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    UPDATE orders
+    SET status = $1, updated_at = NOW()
+    WHERE tenant_id = $3 AND status = $2 AND expires_at <= NOW()
+    RETURNING id
+  `, StatusCanceled, StatusDraft, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ApplyPayment records a payment callback and, for "paid" events, moves the order to paid,
+// all in one transaction. A ProviderRef seen before is a replay: nothing changes and
+// applied is false. A missing order is ErrNotFound; an order that cannot be paid
+// (canceled, draft) is ErrInvalidTransition and the event is not recorded.
+func (r *PGRepo) ApplyPayment(ctx context.Context, ev PaymentEvent) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var st Status
+	if err := tx.QueryRow(ctx, `SELECT status FROM orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE`, ev.OrderID, tenant.From(ctx)).Scan(&st); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+    INSERT INTO payment_events (provider_ref, order_id, status, received_at)
+    VALUES ($1,$2,$3,NOW())
+    ON CONFLICT (provider_ref) DO NOTHING
+  `, ev.ProviderRef, ev.OrderID, ev.Status)
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return false, nil // replay
+	}
+
+	if ev.Status == string(StatusPaid) && !st.Settled() {
+		if st != StatusPending {
+			return false, ErrInvalidTransition
+		}
+		if _, err := tx.Exec(ctx, `
+    UPDATE orders SET status = $2, paid_at = NOW(), updated_at = NOW() WHERE id = $1
+  `, ev.OrderID, StatusPaid); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkPaid moves a pending order (or a live draft, committing its stock hold) to paid and
+// stamps paid_at. Paying an already paid (or shipped, delivered) order is a no-op (changed=false). Canceled orders
+// and expired drafts are ErrInvalidTransition.
+func (r *PGRepo) MarkPaid(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tag, err := r.db.Exec(ctx, `
+    UPDATE orders
+    SET status = $2, paid_at = NOW(), expires_at = NULL, updated_at = NOW()
+    WHERE id = $1 AND tenant_id = $5 AND (status = $3 OR (status = $4 AND expires_at > NOW()))
+  `, id, StatusPaid, StatusPending, StatusDraft, tenant.From(ctx))
+	if err != nil {
+		return false, err
+	}
+	if tag.RowsAffected() > 0 {
+		return true, nil
+	}
+	var st Status
+	if err := r.db.QueryRow(ctx, `SELECT status FROM orders WHERE id=$1 AND tenant_id=$2`, id, tenant.From(ctx)).Scan(&st); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, ErrNotFound
+		}
+		return false, err
+	}
+	if st.Settled() {
+		return false, nil
+	}
+	return false, ErrInvalidTransition
+}
+
+func (r *PGRepo) RecordRestockFailure(ctx context.Context, f *RestockFailure) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.db.QueryRow(ctx, `
+    INSERT INTO restock_failures (order_id, product_id, quantity, reason, at)
+    VALUES ($1, $2, $3, $4, NOW())
+    RETURNING at
+  `, f.OrderID, f.ProductID, f.Quantity, f.Reason).Scan(&f.At)
+}
+
+// CreateRefund records a refund against a paid order. Under the order's row lock it checks
+// that the order total still covers it (prior refunds included) and that the refunded items
+// do not exceed the ordered quantities. It returns the order's refunded total afterwards,
+// at places decimals like the order's prices.
+func (r *PGRepo) CreateRefund(ctx context.Context, rf *Refund, places int32) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	amount, err := decimal.NewFromString(rf.Amount)
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var st Status
+	var totalText, refundedText string
+	if err := tx.QueryRow(ctx, `
+    SELECT status, total::text FROM orders WHERE id=$1 AND tenant_id=$2 FOR UPDATE
+  `, rf.OrderID, tenant.From(ctx)).Scan(&st, &totalText); err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	if !st.Settled() {
+		return "", ErrNotPaid
+	}
+
+	if err := tx.QueryRow(ctx, `
+    SELECT COALESCE(SUM(amount), 0)::text FROM refunds WHERE order_id=$1
+  `, rf.OrderID).Scan(&refundedText); err != nil {
+		return "", err
+	}
+	after, err := CheckRefundAmount(decimal.RequireFromString(totalText), decimal.RequireFromString(refundedText), amount)
+	if err != nil {
+		return "", err
+	}
+
+	for _, it := range rf.Items {
+		var left int
+		if err := tx.QueryRow(ctx, `
+      SELECT COALESCE((SELECT SUM(quantity) FROM order_items WHERE order_id=$1 AND product_id=$2), 0)
+           - COALESCE((SELECT SUM(ri.quantity) FROM refund_items ri JOIN refunds rf ON rf.id = ri.refund_id
+                       WHERE rf.order_id=$1 AND ri.product_id=$2), 0)
+    `, rf.OrderID, it.ProductID).Scan(&left); err != nil {
+			return "", err
+		}
+		if it.Quantity > left {
+			return "", ErrRefundItems
+		}
+	}
+
+	if err := tx.QueryRow(ctx, `
+    INSERT INTO refunds (id, order_id, amount, reason, restock, at)
+    VALUES ($1, $2, $3, $4, $5, NOW())
+    RETURNING at
+  `, rf.ID, rf.OrderID, amount.StringFixed(places), rf.Reason, rf.Restock).Scan(&rf.At); err != nil {
+		return "", err
+	}
+	for _, it := range rf.Items {
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO refund_items (refund_id, product_id, quantity) VALUES ($1, $2, $3)
+    `, rf.ID, it.ProductID, it.Quantity); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return "", err
+	}
+	return after.StringFixed(places), nil
+}
+
+// ListRefunds returns an order's refunds, oldest first, with their items.
+func (r *PGRepo) ListRefunds(ctx context.Context, orderID string) ([]Refund, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT id, order_id, amount::text, reason, restock, at
+    FROM refunds WHERE order_id=$1 AND order_id IN (SELECT id FROM orders WHERE tenant_id=$2)
+    ORDER BY at, id
+  `, orderID, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	out := []Refund{}
+	index := map[string]int{}
+	for rows.Next() {
+		var rf Refund
+		if err := rows.Scan(&rf.ID, &rf.OrderID, &rf.Amount, &rf.Reason, &rf.Restock, &rf.At); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[rf.ID] = len(out)
+		out = append(out, rf)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.db.Query(ctx, `
+    SELECT ri.refund_id, ri.product_id, ri.quantity
+    FROM refund_items ri JOIN refunds rf ON rf.id = ri.refund_id
+    WHERE rf.order_id=$1 AND rf.order_id IN (SELECT id FROM orders WHERE tenant_id=$2)
+  `, orderID, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			refundID string
+			it       RefundItem
+		)
+		if err := rows.Scan(&refundID, &it.ProductID, &it.Quantity); err != nil {
+			return nil, err
+		}
+		if i, ok := index[refundID]; ok {
+			out[i].Items = append(out[i].Items, it)
+		}
+	}
+	return out, rows.Err()
+}
+
+// StartSaga opens the saga of an order about to be created, before any stock is touched.
+func (r *PGRepo) StartSaga(ctx context.Context, orderID string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.Exec(ctx, `
+    INSERT INTO order_sagas (order_id, state, tenant_id, created_at, updated_at)
+    VALUES ($1, $2, $3, NOW(), NOW())
+  `, orderID, SagaStarted, tenant.From(ctx))
+	return err
+}
+
+// RecordSagaStep stores a stock decrement that product-service already applied.
+func (r *PGRepo) RecordSagaStep(ctx context.Context, orderID, productID string, qty int) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var id int64
+	if err := tx.QueryRow(ctx, `
+    INSERT INTO order_saga_steps (order_id, product_id, quantity)
+    VALUES ($1, $2, $3)
+    RETURNING id
+  `, orderID, productID, qty).Scan(&id); err != nil {
+		return 0, err
+	}
+	// progress keeps the saga from looking stale
+	if _, err := tx.Exec(ctx, `UPDATE order_sagas SET updated_at = NOW() WHERE order_id=$1`, orderID); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit(ctx)
+}
+
+// CompensateSagaStep marks a decrement as given back, so a retry does not restock it twice.
+func (r *PGRepo) CompensateSagaStep(ctx context.Context, stepID int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.Exec(ctx, `UPDATE order_saga_steps SET compensated = TRUE WHERE id=$1`, stepID)
+	return err
+}
+
+// FinishSaga closes a saga as completed (order persisted) or compensated (stock given back).
+func (r *PGRepo) FinishSaga(ctx context.Context, orderID string, state SagaState) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.Exec(ctx, `
+    UPDATE order_sagas SET state = $2, updated_at = NOW()
+    WHERE order_id = $1 AND tenant_id = $4 AND state = $3
+  `, orderID, state, SagaStarted, tenant.From(ctx))
+	return err
+}
+
+// StaleSagas lists sagas still started with no progress for olderThan, with their steps
+// and whether their order was persisted.
+func (r *PGRepo) StaleSagas(ctx context.Context, olderThan time.Duration) ([]Saga, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT s.order_id, s.state, s.updated_at, EXISTS (SELECT 1 FROM orders o WHERE o.id = s.order_id)
+    FROM order_sagas s
+    WHERE s.tenant_id = $3 AND s.state = $1 AND s.updated_at <= NOW() - make_interval(secs => $2)
+    ORDER BY s.updated_at
+    LIMIT 100
+  `, SagaStarted, olderThan.Seconds(), tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	var sagas []Saga
+	idx := map[string]int{}
+	for rows.Next() {
+		var s Saga
+		if err := rows.Scan(&s.OrderID, &s.State, &s.UpdatedAt, &s.OrderExists); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx[s.OrderID] = len(sagas)
+		sagas = append(sagas, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil || len(sagas) == 0 {
+		return sagas, err
+	}
+
+	ids := make([]string, 0, len(sagas))
+	for _, s := range sagas {
+		ids = append(ids, s.OrderID)
+	}
+	steps, err := r.db.Query(ctx, `
+    SELECT id, order_id, product_id, quantity, compensated
+    FROM order_saga_steps
+    WHERE order_id = ANY($1::uuid[])
+    ORDER BY id
+  `, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer steps.Close()
+	for steps.Next() {
+		var st SagaStep
+		var orderID string
+		if err := steps.Scan(&st.ID, &orderID, &st.ProductID, &st.Quantity, &st.Compensated); err != nil {
+			return nil, err
+		}
+		i := idx[orderID]
+		sagas[i].Steps = append(sagas[i].Steps, st)
+	}
+	return sagas, steps.Err()
+}
+
+// Tenants lists the tenants with drafts or started sagas, so the background sweeps can
+// run once per tenant.
+func (r *PGRepo) Tenants(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT tenant_id FROM orders WHERE status = $1
+    UNION
+    SELECT tenant_id FROM order_sagas WHERE state = $2
+  `, StatusDraft, SagaStarted)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// settledStatuses is SettledStatuses as a text[] parameter.
+func settledStatuses() []string {
+	out := make([]string, len(SettledStatuses))
+	for i, s := range SettledStatuses {
+		out[i] = string(s)
+	}
+	return out
+}
+
+// SalesTenants returns the tenants with paid orders on day or an existing rollup for it.
+// Unlike the other methods it is not scoped: it drives the nightly rollup.
+func (r *PGRepo) SalesTenants(ctx context.Context, day time.Time) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	day = Day(day)
+	rows, err := r.db.Query(ctx, `
+    SELECT tenant_id FROM orders WHERE status = ANY($1) AND paid_at >= $2 AND paid_at < $3
+    UNION
+    SELECT tenant_id FROM daily_sales WHERE date = $2::date
+  `, settledStatuses(), day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var t string
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+// PaidLines returns the items of the orders that were paid on day (UTC) and are still
+// settled (paid, shipped or delivered; refunded and canceled ones are not sales).
+func (r *PGRepo) PaidLines(ctx context.Context, day time.Time) ([]SaleLine, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	day = Day(day)
+	rows, err := r.db.Query(ctx, `
+    SELECT i.product_id, i.quantity, i.line_total::text
+    FROM order_items i JOIN orders o ON o.id = i.order_id
+    WHERE o.tenant_id = $1 AND o.status = ANY($2) AND o.paid_at >= $3 AND o.paid_at < $4
+  `, tenant.From(ctx), settledStatuses(), day, day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []SaleLine
+	for rows.Next() {
+		var l SaleLine
+		if err := rows.Scan(&l.ProductID, &l.Quantity, &l.LineTotal); err != nil {
+			return nil, err
+		}
+		out = append(out, l)
+	}
+	return out, rows.Err()
+}
+
+// SaveDailySales makes sales the rollup of day: rows are upserted per (date, product) and
+// products no longer sold that day are dropped, so re-running a day is idempotent.
+func (r *PGRepo) SaveDailySales(ctx context.Context, day time.Time, sales []DailySale) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	t := tenant.From(ctx)
+	date := Day(day).Format(DateLayout)
+	ids := make([]string, len(sales))
+	for i, s := range sales {
+		ids[i] = s.ProductID
+		if _, err := tx.Exec(ctx, `
+      INSERT INTO daily_sales (tenant_id, date, product_id, units, revenue)
+      VALUES ($1, $2::date, $3, $4, $5::numeric)
+      ON CONFLICT (tenant_id, date, product_id)
+      DO UPDATE SET units = EXCLUDED.units, revenue = EXCLUDED.revenue, updated_at = NOW()
+    `, t, date, s.ProductID, s.Units, s.Revenue); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `
+    DELETE FROM daily_sales
+    WHERE tenant_id = $1 AND date = $2::date AND NOT (product_id = ANY($3::uuid[]))
+  `, t, date, ids); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DailySales returns the rollup rows between from and to (inclusive days), by date and product.
+func (r *PGRepo) DailySales(ctx context.Context, from, to time.Time) ([]DailySale, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+    SELECT to_char(date, 'YYYY-MM-DD'), product_id, units, revenue::text
+    FROM daily_sales
+    WHERE tenant_id = $1 AND date BETWEEN $2::date AND $3::date
+    ORDER BY date, product_id
+  `, tenant.From(ctx), Day(from).Format(DateLayout), Day(to).Format(DateLayout))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []DailySale{}
+	for rows.Next() {
+		var s DailySale
+		if err := rows.Scan(&s.Date, &s.ProductID, &s.Units, &s.Revenue); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,399 @@
+package order
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/order
+func TestPGRepo_ListByUserItemCount(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	userID := uuid.NewString()
+	want := map[string]int{}
+	for _, lines := range []int{1, 3} {
+		o := &Order{ID: uuid.NewString(), UserID: userID, Status: StatusPending, Total: "0.00"}
+		items := make([]Item, lines)
+		for i := range items {
+			items[i] = Item{ID: uuid.NewString(), ProductID: uuid.NewString(), Quantity: 2, Price: "1.00"}
+		}
+		if err := r.Create(ctx, o, items); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		want[o.ID] = lines
+	}
+
+	list, _, err := r.ListByUser(ctx, userID, ListFilter{}, 10, 0)
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	if len(list) != len(want) {
+		t.Fatalf("orders=%d, expected %d", len(list), len(want))
+	}
+	for _, o := range list {
+		_, items, err := r.GetByID(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("get %s: %v", o.ID, err)
+		}
+		if o.ItemCount != want[o.ID] || o.ItemCount != len(items) {
+			t.Fatalf("order %s: item_count=%d, expected %d (%d items stored)", o.ID, o.ItemCount, want[o.ID], len(items))
+		}
+	}
+	if _, _, err := r.GetByID(ctx, uuid.NewString()); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown order: err=%v, expected ErrNotFound", err)
+	}
+}
+
+func TestPGRepo_ItemLineNumbers(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	// ids sort differently from the request order: line_no, not id, drives the order
+	o := &Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: StatusPending, Total: "0.00"}
+	ids := []string{"ffffffff-0000-4000-8000-" + uuid.NewString()[24:], "00000000-0000-4000-8000-" + uuid.NewString()[24:], "88888888-0000-4000-8000-" + uuid.NewString()[24:]}
+	items := make([]Item, len(ids))
+	for i, id := range ids {
+		items[i] = Item{ID: id, ProductID: uuid.NewString(), Quantity: 1, Price: "1.00"}
+	}
+	if err := r.Create(ctx, o, items); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	for fetch := 0; fetch < 2; fetch++ {
+		got, err := r.GetItems(ctx, o.ID)
+		if err != nil {
+			t.Fatalf("items: %v", err)
+		}
+		if len(got) != len(ids) {
+			t.Fatalf("items=%d, expected %d", len(got), len(ids))
+		}
+		for i, it := range got {
+			if it.LineNo != i+1 || it.ID != ids[i] {
+				t.Fatalf("fetch %d, line %d: line_no=%d id=%s, expected %d and %s", fetch, i, it.LineNo, it.ID, i+1, ids[i])
+			}
+		}
+	}
+}
+
+func TestPGRepo_ReassignUser(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	from, to := uuid.NewString(), uuid.NewString()
+	for _, u := range []string{from, from, to} {
+		o := &Order{ID: uuid.NewString(), UserID: u, Status: StatusPending, Total: "0.00"}
+		if err := r.Create(ctx, o, nil); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	moved, err := r.ReassignUser(ctx, from, to)
+	if err != nil || moved != 2 {
+		t.Fatalf("moved=%d err=%v, expected 2", moved, err)
+	}
+	if has, _ := r.HasOrders(ctx, from); has {
+		t.Fatal("the merged user still has orders")
+	}
+	list, _, err := r.ListByUser(ctx, to, ListFilter{}, 10, 0)
+	if err != nil || len(list) != 3 {
+		t.Fatalf("orders of the surviving user=%d err=%v, expected 3", len(list), err)
+	}
+}
+
+func TestPGRepo_AddItem(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	o := &Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: StatusPending, Total: "10.00"}
+	if err := r.Create(ctx, o, []Item{{ID: uuid.NewString(), ProductID: uuid.NewString(), Quantity: 1, Price: "10.00"}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	it := Item{ID: uuid.NewString(), ProductID: uuid.NewString(), Quantity: 3, Price: "0.10", LineTotal: "0.30"}
+	total, err := r.AddItem(ctx, o.ID, &it, DefaultPriceDecimals)
+	if err != nil {
+		t.Fatalf("add item: %v", err)
+	}
+	if total != "10.30" || it.LineNo != 2 {
+		t.Fatalf("total=%s line_no=%d, expected 10.30 and 2", total, it.LineNo)
+	}
+	got, _, err := r.GetByID(ctx, o.ID)
+	if err != nil || got.Total != "10.30" {
+		t.Fatalf("stored total=%v err=%v, expected 10.30", got, err)
+	}
+
+	if _, err := r.AddItem(ctx, uuid.NewString(), &Item{ID: uuid.NewString(), ProductID: uuid.NewString(), Quantity: 1, Price: "1.00"}, DefaultPriceDecimals); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown order: err=%v, expected ErrNotFound", err)
+	}
+}
+
+func TestPGRepo_CountByUser(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	userID := uuid.NewString()
+	for _, total := range []string{"5.00", "15.00", "25.00"} {
+		o := &Order{ID: uuid.NewString(), UserID: userID, Status: StatusPending, Total: total}
+		if err := r.Create(ctx, o, nil); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	// the count ignores the page size but honors the total filter
+	page, _, err := r.ListByUser(ctx, userID, ListFilter{}, 1, 0)
+	if err != nil || len(page) != 1 {
+		t.Fatalf("page=%d err=%v, expected 1", len(page), err)
+	}
+	if n, err := r.CountByUser(ctx, userID, ListFilter{}); err != nil || n != 3 {
+		t.Fatalf("count=%d err=%v, expected 3", n, err)
+	}
+	tf, err := ParseTotalFilter("10", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.CountByUser(ctx, userID, ListFilter{Total: tf}); err != nil || n != 2 {
+		t.Fatalf("count with min_total=10: %d err=%v, expected 2", n, err)
+	}
+}
+
+func TestPGRepo_ListByUserStablePages(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	// every order shares one created_at: only the id can order them
+	userID := uuid.NewString()
+	const n = 7
+	for i := 0; i < n; i++ {
+		o := &Order{ID: uuid.NewString(), UserID: userID, Status: StatusPending, Total: "1.00"}
+		if err := r.Create(ctx, o, nil); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if _, err := pool.Exec(ctx, `UPDATE orders SET created_at = '2025-01-01T00:00:00Z' WHERE user_id = $1`, userID); err != nil {
+		t.Fatalf("same timestamp: %v", err)
+	}
+
+	for round := 0; round < 2; round++ {
+		var seen []string
+		for offset := 0; offset < n; offset += 3 {
+			page, _, err := r.ListByUser(ctx, userID, ListFilter{}, 3, offset)
+			if err != nil {
+				t.Fatalf("page at %d: %v", offset, err)
+			}
+			for _, o := range page {
+				seen = append(seen, o.ID)
+			}
+		}
+		if len(seen) != n {
+			t.Fatalf("round %d: %d orders across pages, expected %d", round, len(seen), n)
+		}
+		for i := 1; i < len(seen); i++ {
+			if seen[i-1] <= seen[i] {
+				t.Fatalf("round %d: ids not strictly descending across pages: %v", round, seen)
+			}
+		}
+	}
+}
+
+func TestPGRepo_ListByUserStatusAndDates(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	userID := uuid.NewString()
+	seed := []struct {
+		status  Status
+		created string
+	}{
+		{StatusPaid, "2024-01-01T00:00:00Z"}, // on from: included
+		{StatusPaid, "2024-01-15T12:00:00Z"},
+		{StatusPaid, "2024-02-01T00:00:00Z"}, // on to: excluded
+		{StatusPending, "2024-01-10T00:00:00Z"},
+	}
+	for _, s := range seed {
+		o := &Order{ID: uuid.NewString(), UserID: userID, Status: s.status, Total: "1.00"}
+		if err := r.Create(ctx, o, nil); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+		if _, err := pool.Exec(ctx, `UPDATE orders SET created_at = $2 WHERE id = $1`, o.ID, s.created); err != nil {
+			t.Fatalf("created_at: %v", err)
+		}
+	}
+
+	from, to, err := ParseDateRange("2024-01-01", "2024-02-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := ListFilter{Status: StatusPaid, From: from, To: to}
+	list, _, err := r.ListByUser(ctx, userID, f, 10, 0)
+	if err != nil || len(list) != 2 {
+		t.Fatalf("orders=%d err=%v, expected 2", len(list), err)
+	}
+	if n, err := r.CountByUser(ctx, userID, f); err != nil || n != 2 {
+		t.Fatalf("count=%d err=%v, expected 2", n, err)
+	}
+	if n, err := r.CountByUser(ctx, userID, ListFilter{From: from, To: to}); err != nil || n != 3 {
+		t.Fatalf("count without status=%d err=%v, expected 3", n, err)
+	}
+}
+
+func TestPGRepo_ListByUserCeiling(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool, WithMaxListRows(3))
+
+	userID := uuid.NewString()
+	for i := 0; i < 5; i++ {
+		o := &Order{ID: uuid.NewString(), UserID: userID, Status: StatusPending, Total: "1.00"}
+		if err := r.Create(ctx, o, nil); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+
+	cases := []struct {
+		limit, offset int
+		rows          int
+		truncated     bool
+	}{
+		{1000, 0, 3, true}, // "everything" stops at the ceiling
+		{3, 0, 3, false},   // a page within the ceiling is a normal page
+		{2, 0, 2, false},
+		{1000, 2, 3, false}, // the last three fit under the ceiling
+		{1000, 3, 2, false},
+	}
+	for _, tc := range cases {
+		list, truncated, err := r.ListByUser(ctx, userID, ListFilter{}, tc.limit, tc.offset)
+		if err != nil {
+			t.Fatalf("limit=%d offset=%d: %v", tc.limit, tc.offset, err)
+		}
+		if len(list) != tc.rows || truncated != tc.truncated {
+			t.Fatalf("limit=%d offset=%d: rows=%d truncated=%v, expected %d and %v", tc.limit, tc.offset, len(list), truncated, tc.rows, tc.truncated)
+		}
+	}
+}
+
+func TestPGRepo_RelatedProducts(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	p, a, b, c := uuid.NewString(), uuid.NewString(), uuid.NewString(), uuid.NewString()
+	seed := func(status Status, products ...string) {
+		o := &Order{ID: uuid.NewString(), UserID: uuid.NewString(), Status: status, Total: "0.00"}
+		items := make([]Item, len(products))
+		for i, id := range products {
+			items[i] = Item{ID: uuid.NewString(), ProductID: id, Quantity: 1, Price: "1.00"}
+		}
+		if err := r.Create(ctx, o, items); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	seed(StatusPaid, p, a, b)
+	seed(StatusShipped, p, a, a) // a twice in one order still counts once
+	seed(StatusPending, p, a, c)
+	seed(StatusPaid, p, b)
+	seed(StatusCanceled, p, c, c)
+	seed(StatusDraft, p, c)
+	seed(StatusPaid, a, b, c) // without p
+
+	got, err := r.RelatedProducts(ctx, p, 10)
+	if err != nil {
+		t.Fatalf("related: %v", err)
+	}
+	want := []RelatedProduct{{ProductID: a, Orders: 3}, {ProductID: b, Orders: 2}, {ProductID: c, Orders: 1}}
+	if len(got) != len(want) {
+		t.Fatalf("related=%+v, expected %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("related=%+v, expected %+v", got, want)
+		}
+	}
+	if top, err := r.RelatedProducts(ctx, p, 1); err != nil || len(top) != 1 || top[0].ProductID != a {
+		t.Fatalf("limit 1: %+v err=%v, expected only %s", top, err, a)
+	}
+}
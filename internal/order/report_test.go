@@ -0,0 +1,68 @@
+package order
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAggregateDailySales(t *testing.T) {
+	day := time.Date(2025, 9, 1, 22, 30, 0, 0, time.FixedZone("UTC-5", -5*3600)) // 2025-09-02 UTC
+	lines := []SaleLine{
+		{ProductID: "b", Quantity: 1, LineTotal: "5.00"},
+		{ProductID: "a", Quantity: 2, LineTotal: "18.00"}, // with a 10% discount
+		{ProductID: "a", Quantity: 1, LineTotal: "8.50"},
+		{ProductID: "b", Quantity: 3, LineTotal: "0.0375"},
+	}
+	got, err := AggregateDailySales(day, lines)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []DailySale{
+		{Date: "2025-09-02", ProductID: "a", Units: 3, Revenue: "26.5"},
+		{Date: "2025-09-02", ProductID: "b", Units: 4, Revenue: "5.0375"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("row %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// same lines, same rows
+	again, _ := AggregateDailySales(day, lines)
+	for i := range got {
+		if again[i] != got[i] {
+			t.Fatalf("re-run row %d = %+v, want %+v", i, again[i], got[i])
+		}
+	}
+
+	if empty, err := AggregateDailySales(day, nil); err != nil || len(empty) != 0 {
+		t.Fatalf("no lines: %+v, %v", empty, err)
+	}
+	if _, err := AggregateDailySales(day, []SaleLine{{ProductID: "a", Quantity: 1, LineTotal: "x"}}); err == nil {
+		t.Fatal("invalid line_total accepted")
+	}
+}
+
+func TestParseReportRange(t *testing.T) {
+	cases := []struct {
+		from, to string
+		ok       bool
+	}{
+		{"2025-09-01", "2025-09-01", true},
+		{"2025-01-01", "2025-12-31", true},
+		{"2024-01-01", "2024-12-31", true}, // leap year: 366 days
+		{"2024-01-01", "2025-01-01", false},
+		{"2025-09-02", "2025-09-01", false},
+		{"", "2025-09-01", false},
+		{"2025-9-1", "2025-09-02", false},
+	}
+	for _, tc := range cases {
+		_, _, err := ParseReportRange(tc.from, tc.to)
+		if (err == nil) != tc.ok {
+			t.Fatalf("ParseReportRange(%q, %q) err=%v, want ok=%v", tc.from, tc.to, err, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package order
+
+import (
+	"strings"
+	"time"
+)
+
+// RestockPolicy decides what a cancel does when an item's product no longer exists
+// in product-service (AdjustStock answers 404). The cancel itself always succeeds.
+type RestockPolicy string
+
+const (
+	// RestockRecord logs the skipped restock and stores it in restock_failures.
+	RestockRecord RestockPolicy = "record"
+	// RestockSkip drops the restock silently.
+	RestockSkip RestockPolicy = "skip"
+)
+
+// ParseRestockPolicy maps RESTOCK_NOT_FOUND_POLICY to a policy; anything but "skip" records.
+func ParseRestockPolicy(s string) RestockPolicy {
+	if RestockPolicy(strings.ToLower(strings.TrimSpace(s))) == RestockSkip {
+		return RestockSkip
+	}
+	return RestockRecord
+}
+
+// RestockFailure is a restock that could not be applied when an order was canceled.
+type RestockFailure struct {
+	OrderID   string    `json:"order_id"`
+	ProductID string    `json:"product_id"`
+	Quantity  int       `json:"quantity"`
+	Reason    string    `json:"reason"`
+	At        time.Time `json:"at"`
+}
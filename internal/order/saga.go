@@ -0,0 +1,32 @@
+package order
+
+import "time"
+
+// SagaState tracks an order creation: validate → decrement stock per item → persist.
+// A saga still "started" long after it began was interrupted (crash, timeout) and is
+// recovered: completed if the order was persisted, otherwise its decrements are given back.
+type SagaState string
+
+const (
+	SagaStarted     SagaState = "started"
+	SagaCompleted   SagaState = "completed"
+	SagaCompensated SagaState = "compensated"
+)
+
+// SagaStep is one stock decrement applied in product-service for the order.
+type SagaStep struct {
+	ID          int64  `json:"id"`
+	ProductID   string `json:"product_id"`
+	Quantity    int    `json:"quantity"`
+	Compensated bool   `json:"compensated"`
+}
+
+// Saga is an order creation in progress.
+type Saga struct {
+	OrderID string     `json:"order_id"`
+	State   SagaState  `json:"state"`
+	Steps   []SagaStep `json:"steps"`
+	// whether the order row exists (the persist step ran)
+	OrderExists bool      `json:"order_exists"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
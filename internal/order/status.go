@@ -0,0 +1,168 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Status is the lifecycle state of an order. It serializes as the lowercase string.
+type Status string
+
+const (
+	// StatusDraft holds stock without being a committed order; it expires.
+	StatusDraft    Status = "draft"
+	StatusPending  Status = "pending"
+	StatusPaid     Status = "paid"
+	StatusCanceled Status = "canceled"
+	// StatusShipped is a paid order whose goods left the warehouse.
+	StatusShipped Status = "shipped"
+	// StatusDelivered is a shipped order the customer received.
+	StatusDelivered Status = "delivered"
+	// StatusRefunded is a paid (or shipped) order whose money was given back in full.
+	StatusRefunded Status = "refunded"
+)
+
+// Statuses lists every status, in lifecycle order.
+var Statuses = []Status{StatusDraft, StatusPending, StatusPaid, StatusShipped, StatusDelivered, StatusRefunded, StatusCanceled}
+
+var ErrInvalidStatus = errors.New("invalid status")
+
+// ParseStatus normalizes s (trim + lowercase) and validates it against the known statuses.
+func ParseStatus(s string) (Status, error) {
+	st := Status(strings.ToLower(strings.TrimSpace(s)))
+	if !st.Valid() {
+		return "", ErrInvalidStatus
+	}
+	return st, nil
+}
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusDraft, StatusPending, StatusPaid, StatusCanceled, StatusShipped, StatusDelivered, StatusRefunded:
+		return true
+	}
+	return false
+}
+
+func (s Status) String() string { return string(s) }
+
+// HoldsStock reports whether an order in this status still has stock reserved
+// that must be released when it is canceled.
+func (s Status) HoldsStock() bool {
+	return s == StatusDraft || s == StatusPending
+}
+
+// Settled reports whether the order was paid and the money is still kept: it counts as a
+// sale and can be partially refunded.
+func (s Status) Settled() bool {
+	return s == StatusPaid || s == StatusShipped || s == StatusDelivered
+}
+
+// SettledStatuses are the statuses for which Settled is true.
+var SettledStatuses = []Status{StatusPaid, StatusShipped, StatusDelivered}
+
+// ErrInvalidTransitions is returned by ParseTransitions for a malformed ORDER_STATUS_TRANSITIONS.
+var ErrInvalidTransitions = errors.New("invalid status transitions")
+
+// Transitions maps each status to the ones PUT /orders/{id}/status may move it to.
+// Drafts are not part of it: they only leave their state via commit, cancel or expiry.
+type Transitions map[Status][]Status
+
+// DefaultTransitions is the lifecycle used when ORDER_STATUS_TRANSITIONS is unset.
+var DefaultTransitions = Transitions{
+	StatusPending:   {StatusPaid, StatusCanceled},
+	StatusPaid:      {StatusShipped, StatusRefunded, StatusCanceled},
+	StatusShipped:   {StatusDelivered, StatusRefunded},
+	StatusDelivered: {StatusRefunded},
+}
+
+// ParseTransitions reads ORDER_STATUS_TRANSITIONS, e.g.
+// "pending:paid,canceled;paid:shipped,refunded,canceled;shipped:delivered,refunded;delivered:refunded".
+// Empty means DefaultTransitions. Every status must be known and drafts can't appear.
+func ParseTransitions(s string) (Transitions, error) {
+	if strings.TrimSpace(s) == "" {
+		return DefaultTransitions, nil
+	}
+	t := Transitions{}
+	for _, rule := range strings.Split(s, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		fromText, toText, ok := strings.Cut(rule, ":")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q has no ':'", ErrInvalidTransitions, rule)
+		}
+		from, err := ParseStatus(fromText)
+		if err != nil || from == StatusDraft {
+			return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidTransitions, fromText)
+		}
+		for _, tt := range strings.Split(toText, ",") {
+			to, err := ParseStatus(tt)
+			if err != nil || to == StatusDraft {
+				return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidTransitions, tt)
+			}
+			if to == from {
+				return nil, fmt.Errorf("%w: %s to itself", ErrInvalidTransitions, from)
+			}
+			t[from] = append(t[from], to)
+		}
+	}
+	return t, nil
+}
+
+// Allows reports whether an order in from may be moved to to.
+func (t Transitions) Allows(from, to Status) bool {
+	for _, s := range t[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled reports whether status takes part in t, as a source or a target.
+func (t Transitions) Enabled(status Status) bool {
+	if _, ok := t[status]; ok {
+		return true
+	}
+	for _, to := range t {
+		for _, s := range to {
+			if s == status {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Restocks reports whether moving from -> to gives the order's stock back: a cancel of an
+// order still holding it, or of a paid one whose goods have not left the warehouse (minus
+// what its refunds already restocked, see UnrestockedItems). Shipped goods are with the
+// customer and a refunded paid order keeps its stock out; returned items go back per item
+// via a refund with restock.
+func Restocks(from, to Status) bool {
+	return to == StatusCanceled && (from.HoldsStock() || from == StatusPaid)
+}
+
+// NoopPolicy decides what PUT /orders/{id}/status does when the order already has the
+// requested status.
+type NoopPolicy string
+
+const (
+	// NoopIgnore answers 200 with the order unchanged (default).
+	NoopIgnore NoopPolicy = "ignore"
+	// NoopConflict answers 409.
+	NoopConflict NoopPolicy = "conflict"
+	// NoopTouch keeps the status but bumps updated_at.
+	NoopTouch NoopPolicy = "touch"
+)
+
+// ParseNoopPolicy maps STATUS_NOOP to a policy; anything unknown ignores.
+func ParseNoopPolicy(s string) NoopPolicy {
+	switch p := NoopPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case NoopConflict, NoopTouch:
+		return p
+	}
+	return NoopIgnore
+}
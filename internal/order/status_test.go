@@ -0,0 +1,117 @@
+package order
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	valid := map[string]Status{
+		"draft":       StatusDraft,
+		"pending":     StatusPending,
+		"PAID":        StatusPaid,
+		" Canceled  ": StatusCanceled,
+		"shipped":     StatusShipped,
+		"Delivered":   StatusDelivered,
+		"Refunded":    StatusRefunded,
+	}
+	for in, want := range valid {
+		got, err := ParseStatus(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseStatus(%q)=%q,%v; want %q", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"", "wtf", "cancelled", "pend ing"} {
+		if _, err := ParseStatus(in); err != ErrInvalidStatus {
+			t.Fatalf("ParseStatus(%q) err=%v; want ErrInvalidStatus", in, err)
+		}
+	}
+}
+
+func TestParseTransitions(t *testing.T) {
+	def, err := ParseTransitions("  ")
+	if err != nil || !def.Allows(StatusPaid, StatusShipped) || !def.Allows(StatusShipped, StatusRefunded) {
+		t.Fatalf("empty should be the defaults: %v, %v", def, err)
+	}
+	if def.Allows(StatusCanceled, StatusPending) || def.Allows(StatusShipped, StatusCanceled) || def.Allows(StatusRefunded, StatusPaid) {
+		t.Fatalf("defaults allow leaving a final status: %v", def)
+	}
+
+	// a deployment without shipping
+	tr, err := ParseTransitions("pending:paid,canceled; PAID:refunded;")
+	if err != nil {
+		t.Fatalf("ParseTransitions: %v", err)
+	}
+	if !tr.Allows(StatusPending, StatusCanceled) || !tr.Allows(StatusPaid, StatusRefunded) || tr.Allows(StatusPaid, StatusCanceled) {
+		t.Fatalf("transitions=%v", tr)
+	}
+	if tr.Enabled(StatusShipped) || !tr.Enabled(StatusRefunded) || !tr.Enabled(StatusPending) {
+		t.Fatalf("enabled statuses wrong: %v", tr)
+	}
+
+	for _, in := range []string{"pending", "pending:wtf", "wtf:paid", "draft:canceled", "pending:draft", "paid:paid"} {
+		if _, err := ParseTransitions(in); !errors.Is(err, ErrInvalidTransitions) {
+			t.Fatalf("ParseTransitions(%q) err=%v; want ErrInvalidTransitions", in, err)
+		}
+	}
+}
+
+func TestRestocks(t *testing.T) {
+	cases := []struct {
+		from, to Status
+		want     bool
+	}{
+		{StatusPending, StatusCanceled, true},
+		{StatusDraft, StatusCanceled, true},
+		{StatusPaid, StatusCanceled, true},
+		{StatusShipped, StatusCanceled, false},
+		{StatusPaid, StatusRefunded, false},
+		{StatusShipped, StatusRefunded, false},
+		{StatusPaid, StatusShipped, false},
+		{StatusDelivered, StatusCanceled, false},
+		{StatusDelivered, StatusRefunded, false},
+	}
+	for _, c := range cases {
+		if got := Restocks(c.from, c.to); got != c.want {
+			t.Fatalf("Restocks(%s, %s)=%v, want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+func TestParseNoopPolicy(t *testing.T) {
+	cases := map[string]NoopPolicy{
+		"":          NoopIgnore,
+		"ignore":    NoopIgnore,
+		" Conflict": NoopConflict,
+		"TOUCH":     NoopTouch,
+		"bogus":     NoopIgnore,
+	}
+	for in, want := range cases {
+		if got := ParseNoopPolicy(in); got != want {
+			t.Fatalf("ParseNoopPolicy(%q)=%q; want %q", in, got, want)
+		}
+	}
+}
+
+func TestDefaultTransitions_Edges(t *testing.T) {
+	allowed := map[[2]Status]bool{
+		{StatusPending, StatusPaid}:       true,
+		{StatusPending, StatusCanceled}:   true,
+		{StatusPaid, StatusShipped}:       true,
+		{StatusPaid, StatusRefunded}:      true,
+		{StatusPaid, StatusCanceled}:      true,
+		{StatusShipped, StatusDelivered}:  true,
+		{StatusShipped, StatusRefunded}:   true,
+		{StatusDelivered, StatusRefunded}: true,
+	}
+	// every other pair, e.g. paid->pending or canceled->paid, is illegal; canceled and
+	// refunded are terminal
+	for _, from := range Statuses {
+		for _, to := range Statuses {
+			if got := DefaultTransitions.Allows(from, to); got != allowed[[2]Status{from, to}] {
+				t.Fatalf("Allows(%s, %s)=%v, want %v", from, to, got, !got)
+			}
+		}
+	}
+}
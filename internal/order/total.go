@@ -0,0 +1,71 @@
+package order
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+)
+
+var (
+	ErrInvalidTaxRate          = errors.New("tax rate must be a percent in [0, 100]")
+	ErrDiscountExceedsSubtotal = errors.New("discount exceeds the order subtotal")
+)
+
+// ComputeOrderTotal is the one place order totals are derived from their lines, so every
+// endpoint that touches a total rounds the same way. Each line counts its LineTotal (price
+// times quantity when empty); discount is an amount off the whole order and taxRate a percent
+// ("19" for 19%), both optional (""). The tax is rounded once, on the discounted subtotal, and
+// every result is fixed at places decimals.
+func ComputeOrderTotal(items []Item, discount, taxRate string, places int32) (subtotal, tax, total string, err error) {
+	sub := decimal.Zero
+	for i, it := range items {
+		line, err := lineValue(it, places)
+		if err != nil {
+			return "", "", "", fmt.Errorf("item %d: %w", i, err)
+		}
+		sub = sub.Add(line)
+	}
+
+	off := decimal.Zero
+	if discount != "" {
+		off, err = decimal.NewFromString(discount)
+		if err != nil || off.IsNegative() || !off.Equal(off.Round(places)) {
+			return "", "", "", ErrInvalidDiscount
+		}
+		if off.GreaterThan(sub) {
+			return "", "", "", ErrDiscountExceedsSubtotal
+		}
+	}
+
+	rate := decimal.Zero
+	if taxRate != "" {
+		rate, err = decimal.NewFromString(taxRate)
+		if err != nil || rate.IsNegative() || rate.GreaterThan(hundred) {
+			return "", "", "", ErrInvalidTaxRate
+		}
+	}
+
+	net := sub.Sub(off)
+	t := net.Mul(rate).Div(hundred).Round(places)
+	return sub.StringFixed(places), t.StringFixed(places), net.Add(t).StringFixed(places), nil
+}
+
+// lineValue is what one item adds to the subtotal, rounded to places.
+func lineValue(it Item, places int32) (decimal.Decimal, error) {
+	if it.LineTotal != "" {
+		v, err := decimal.NewFromString(it.LineTotal)
+		if err != nil {
+			return decimal.Zero, fmt.Errorf("invalid line_total %q", it.LineTotal)
+		}
+		return v.Round(places), nil
+	}
+	if it.Quantity < 0 {
+		return decimal.Zero, fmt.Errorf("invalid quantity %d", it.Quantity)
+	}
+	p, err := decimal.NewFromString(it.Price)
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid price %q", it.Price)
+	}
+	return p.Mul(decimal.NewFromInt(int64(it.Quantity))).Round(places), nil
+}
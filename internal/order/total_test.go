@@ -0,0 +1,54 @@
+package order
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestComputeOrderTotal(t *testing.T) {
+	cases := []struct {
+		name                 string
+		items                []Item
+		discount, taxRate    string
+		places               int32
+		subtotal, tax, total string
+		err                  error
+	}{
+		{"empty order", nil, "", "", 2, "0.00", "0.00", "0.00", nil},
+		// binary floats give 0.30000000000000004 for both of these
+		{"price times quantity", []Item{{Price: "0.10", Quantity: 3}}, "", "", 2, "0.30", "0.00", "0.30", nil},
+		{"summed line totals", []Item{{LineTotal: "0.1"}, {LineTotal: "0.2"}}, "", "", 2, "0.30", "0.00", "0.30", nil},
+		// the frozen line total (after its discount) wins over price times quantity
+		{"discounted line", []Item{{Price: "10.00", Quantity: 2, LineTotal: "17.50"}}, "", "", 2, "17.50", "0.00", "17.50", nil},
+		{"tax rounded to cents", []Item{{LineTotal: "10.05"}}, "", "19", 2, "10.05", "1.91", "11.96", nil},        // 1.9095
+		{"half cent rounds away from zero", []Item{{LineTotal: "0.10"}}, "", "5", 2, "0.10", "0.01", "0.11", nil}, // 0.005
+		// per line this would be 0.01 + 0.01; the tax is rounded once on the subtotal
+		{"tax rounded once", []Item{{LineTotal: "0.05"}, {LineTotal: "0.05"}}, "", "10", 2, "0.10", "0.01", "0.11", nil},
+		{"fractional rate", []Item{{LineTotal: "99.99"}}, "", "8.875", 2, "99.99", "8.87", "108.86", nil}, // 8.8741125
+		{"discount before tax", []Item{{LineTotal: "10.00"}}, "1.00", "19", 2, "10.00", "1.71", "10.71", nil},
+		{"discount of the whole subtotal", []Item{{LineTotal: "10.00"}}, "10", "19", 2, "10.00", "0.00", "0.00", nil},
+		{"four places", []Item{{Price: "0.0125", Quantity: 3}}, "", "10", 4, "0.0375", "0.0038", "0.0413", nil}, // 0.00375
+		{"line rounded to places", []Item{{Price: "0.333", Quantity: 1}}, "", "", 2, "0.33", "0.00", "0.33", nil},
+		{"discount over subtotal", []Item{{LineTotal: "10.00"}}, "10.01", "", 2, "", "", "", ErrDiscountExceedsSubtotal},
+		{"discount below a cent", []Item{{LineTotal: "10.00"}}, "0.001", "", 2, "", "", "", ErrInvalidDiscount},
+		{"negative discount", []Item{{LineTotal: "10.00"}}, "-1", "", 2, "", "", "", ErrInvalidDiscount},
+		{"rate over 100", []Item{{LineTotal: "10.00"}}, "", "101", 2, "", "", "", ErrInvalidTaxRate},
+		{"negative rate", []Item{{LineTotal: "10.00"}}, "", "-5", 2, "", "", "", ErrInvalidTaxRate},
+		{"rate not a number", []Item{{LineTotal: "10.00"}}, "", "abc", 2, "", "", "", ErrInvalidTaxRate},
+	}
+	for _, tc := range cases {
+		sub, tax, total, err := ComputeOrderTotal(tc.items, tc.discount, tc.taxRate, tc.places)
+		if !errors.Is(err, tc.err) || sub != tc.subtotal || tax != tc.tax || total != tc.total {
+			t.Fatalf("%s: got %q, %q, %q, %v; want %q, %q, %q, %v",
+				tc.name, sub, tax, total, err, tc.subtotal, tc.tax, tc.total, tc.err)
+		}
+	}
+}
+
+func TestComputeOrderTotal_InvalidLine(t *testing.T) {
+	for _, it := range []Item{{LineTotal: "abc"}, {Price: "", Quantity: 1}, {Price: "1.00", Quantity: -1}} {
+		if _, _, _, err := ComputeOrderTotal([]Item{{LineTotal: "1.00"}, it}, "", "", 2); err == nil {
+			t.Fatalf("item %+v accepted", it)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package product
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalidBarcode = errors.New("invalid EAN-13 barcode")
+
+// NormalizeBarcode trims the code and checks it is a valid EAN-13:
+// 13 digits whose last one is the check digit of the first 12.
+func NormalizeBarcode(code string) (string, error) {
+	code = strings.TrimSpace(code)
+	if len(code) != 13 {
+		return "", ErrInvalidBarcode
+	}
+	sum := 0
+	for i, r := range code {
+		if r < '0' || r > '9' {
+			return "", ErrInvalidBarcode
+		}
+		if i == 12 {
+			break
+		}
+		d := int(r - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	if int(code[12]-'0') != (10-sum%10)%10 {
+		return "", ErrInvalidBarcode
+	}
+	return code, nil
+}
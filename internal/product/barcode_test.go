@@ -0,0 +1,16 @@
+package product
+
+import "testing"
+
+func TestNormalizeBarcode(t *testing.T) {
+	for _, ok := range []string{"4006381333931", " 5901234123457 ", "0000000000000"} {
+		if _, err := NormalizeBarcode(ok); err != nil {
+			t.Fatalf("NormalizeBarcode(%q) = %v, expected valid", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "4006381333932", "400638133393", "40063813339311", "40063813339a1"} {
+		if _, err := NormalizeBarcode(bad); err != ErrInvalidBarcode {
+			t.Fatalf("NormalizeBarcode(%q) = %v, expected ErrInvalidBarcode", bad, err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package product
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPreconditionFailed means the product changed since the version the client sent in If-Match.
+var ErrPreconditionFailed = errors.New("product was modified")
+
+// ETag is the strong entity tag of a product version, derived from updated_at at
+// microsecond precision (what PostgreSQL stores).
+func ETag(p *Product) string {
+	return `"` + strconv.FormatInt(p.UpdatedAt.UnixMicro(), 10) + `"`
+}
+
+// ParseETag returns the updated_at an ETag stands for. Weak tags never match (If-Match
+// uses strong comparison), so they are rejected like any other malformed value.
+func ParseETag(tag string) (time.Time, bool) {
+	tag = strings.TrimSpace(tag)
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return time.Time{}, false
+	}
+	us, err := strconv.ParseInt(tag[1:len(tag)-1], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.UnixMicro(us), true
+}
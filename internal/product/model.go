@@ -3,14 +3,38 @@ package product
 import "time"
 
 type Product struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description,omitempty"`
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Always present: "" when the product has no description (NULL in the DB)
+	Description string `json:"description"`
 	// We store price as a string to avoid rounding errors (NUMERIC in Postgres)
-	Price     string    `json:"price"`
-	Stock     int       `json:"stock"`
+	Price string `json:"price"`
+	Stock int    `json:"stock"`
+	// Stock at or below which the product shows up in the low-stock report
+	LowStockThreshold int `json:"low_stock_threshold"`
+	// EAN-13, empty when the product has none
+	Barcode string `json:"barcode,omitempty"`
+	// Navigation category, empty when uncategorized
+	Category string `json:"category,omitempty"`
+	// Availability: active, discontinued or out_of_stock; only active products can be ordered
+	Status Status `json:"status"`
+	// Backorders: orders may take the stock below zero
+	AllowBackorder bool      `json:"allow_backorder"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	// Set once the product is (soft) deleted; only visible with include_deleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+}
+
+// DefaultLowStockThreshold is used when a product is created without one.
+const DefaultLowStockThreshold = 5
+
+// RestockSubscription is a request to be notified when a product is back in stock.
+type RestockSubscription struct {
+	ProductID string    `json:"product_id"`
+	UserID    string    `json:"user_id,omitempty"`
+	Email     string    `json:"email"`
 	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
 }
 
 // HTTPError represents a standard error in JSON.
@@ -30,8 +54,14 @@ type ListResponse struct {
 	Limit int `json:"limit"`
 	// offset applied
 	Offset int `json:"offset"`
-	// total items found
+	// page of products
 	Items []Product `json:"items"`
+	// total products matching q, regardless of limit/offset (search only)
+	Total *int `json:"total,omitempty"`
+	// server time the list was generated (RFC3339, UTC)
+	GeneratedAt string `json:"generated_at"`
+	// API version
+	Version string `json:"version"`
 }
 
 // CreateProductRequest payload of creation.
@@ -41,6 +71,16 @@ type CreateProductRequest struct {
 	Description string `json:"description" example:"RGB 60%"`
 	Price       string `json:"price"       example:"199.90"`
 	Stock       int    `json:"stock"       example:"10"`
+	// optional, defaults to 5
+	LowStockThreshold *int `json:"low_stock_threshold,omitempty" example:"3"`
+	// optional EAN-13 (validated check digit)
+	Barcode string `json:"barcode,omitempty" example:"4006381333931"`
+	// optional
+	Category string `json:"category,omitempty" example:"Peripherals"`
+	// optional: active (default), discontinued or out_of_stock
+	Status string `json:"status,omitempty" example:"active"`
+	// optional: accept orders beyond the stock (it may go negative); default false
+	AllowBackorder bool `json:"allow_backorder,omitempty" example:"false"`
 }
 
 // UpdateProductRequest payload of partial update.
@@ -50,4 +90,96 @@ type UpdateProductRequest struct {
 	Description string `json:"description"`
 	Price       string `json:"price"`
 	Stock       int    `json:"stock"`
+	// optional: if omitted, it is not modified
+	LowStockThreshold *int `json:"low_stock_threshold,omitempty"`
+	// optional EAN-13; empty keeps the current one
+	Barcode string `json:"barcode,omitempty"`
+	// optional; empty keeps the current one
+	Category string `json:"category,omitempty"`
+	// optional: active, discontinued or out_of_stock; empty keeps the current one
+	Status string `json:"status,omitempty"`
+	// optional: if omitted, it is not modified; with backorders stock may be negative
+	AllowBackorder *bool `json:"allow_backorder,omitempty"`
+	// optional: why the stock changed (order, cancel, adjustment); default adjustment
+	StockReason string `json:"stock_reason,omitempty"`
+	// optional: order that caused the stock change
+	OrderID string `json:"order_id,omitempty"`
+}
+
+// CategoryCount is one entry of the category listing.
+// swagger:model CategoryCount
+type CategoryCount struct {
+	Category string `json:"category" example:"Peripherals"`
+	Products int    `json:"products" example:"12"`
+}
+
+// NotifyMeRequest payload of restock subscription.
+// swagger:model NotifyMeRequest
+type NotifyMeRequest struct {
+	UserID string `json:"user_id" example:"b2f5ff47-2b1e-4f22-8a96-5f3c1f2f2e7b"`
+	Email  string `json:"email"   example:"buyer@test.com"`
+}
+
+// RestockRequest payload of an order's idempotent restock.
+// swagger:model RestockRequest
+type RestockRequest struct {
+	OrderID string `json:"order_id" example:"0b9f3c1e-6a55-4d8e-9a0e-3f1c2d4e5f60"`
+	Qty     int    `json:"qty"      example:"2"`
+	// optional: cancel (default) or refund
+	Reason string `json:"reason,omitempty" example:"cancel"`
+}
+
+// StockDeltaRequest payload of an atomic stock decrement or increment.
+// swagger:model StockDeltaRequest
+type StockDeltaRequest struct {
+	Qty int `json:"qty" example:"2"`
+	// optional: movement reason (order, cancel, refund, adjustment) and the order behind it
+	Reason  string `json:"reason,omitempty"   example:"order"`
+	OrderID string `json:"order_id,omitempty" example:"0b9f3c1e-6a55-4d8e-9a0e-3f1c2d4e5f60"`
+	// optional, decrement only, with order_id: the order line taking the units; the decrement
+	// is then applied at most once per (order_id, item_id), so a client may retry it
+	ItemID string `json:"item_id,omitempty" example:"0b6f1f8e-8a55-4c1e-a0a4-1c2f0d1f6b7e"`
+}
+
+// TransferStockRequest payload of stock transfer between products.
+// swagger:model TransferStockRequest
+type TransferStockRequest struct {
+	FromID string `json:"from_id" example:"11111111-1111-1111-1111-111111111111"`
+	ToID   string `json:"to_id"   example:"22222222-2222-2222-2222-222222222222"`
+	Qty    int    `json:"qty"     example:"3"`
+}
+
+// BulkPriceAdjustRequest payload of a category-wide price change.
+// swagger:model BulkPriceAdjustRequest
+type BulkPriceAdjustRequest struct {
+	Category string `json:"category" example:"Peripherals"`
+	// decimal percentage in (-100, 100]; negative lowers prices
+	Percent string `json:"percent" example:"-10"`
+}
+
+// ValidatePriceRequest payload of a price check.
+// swagger:model ValidatePriceRequest
+type ValidatePriceRequest struct {
+	Price string `json:"price" example:"19.9"`
+}
+
+// PriceValidation is the answer of a price check: the normalized price when valid, the
+// reason otherwise.
+// swagger:model PriceValidation
+type PriceValidation struct {
+	Valid      bool   `json:"valid"`
+	Normalized string `json:"normalized,omitempty" example:"19.90"`
+	Error      string `json:"error,omitempty"`
+}
+
+// StockMovement is one audited change of a product's stock.
+// swagger:model StockMovement
+type StockMovement struct {
+	ID             int64          `json:"id"`
+	ProductID      string         `json:"product_id"`
+	Delta          int            `json:"delta"`
+	Reason         MovementReason `json:"reason"`
+	ResultingStock int            `json:"resulting_stock"`
+	OrderID        string         `json:"order_id,omitempty"`
+	At             time.Time      `json:"at"`
 }
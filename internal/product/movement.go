@@ -0,0 +1,42 @@
+package product
+
+import (
+	"errors"
+	"strings"
+)
+
+// MovementReason says why a product's stock changed.
+type MovementReason string
+
+const (
+	ReasonOrder       MovementReason = "order"
+	ReasonCancel      MovementReason = "cancel"
+	ReasonRefund      MovementReason = "refund"
+	ReasonAdjustment  MovementReason = "adjustment"
+	ReasonTransferOut MovementReason = "transfer_out"
+	ReasonTransferIn  MovementReason = "transfer_in"
+	ReasonRecalc      MovementReason = "recalc"
+)
+
+var ErrInvalidReason = errors.New("invalid stock reason")
+
+// StockChange describes the cause recorded alongside a stock update.
+type StockChange struct {
+	Reason  MovementReason
+	OrderID string
+	// ItemID is the order line taking the units; only DecrementStockOnce reads it
+	ItemID string
+}
+
+// ParseMovementReason parses the reasons a client may send on PUT /products/{id}.
+// Empty means a manual adjustment; transfer reasons are only written by TransferStock
+// and recalc by SetStock.
+func ParseMovementReason(s string) (MovementReason, error) {
+	switch r := MovementReason(strings.ToLower(strings.TrimSpace(s))); r {
+	case "":
+		return ReasonAdjustment, nil
+	case ReasonOrder, ReasonCancel, ReasonRefund, ReasonAdjustment:
+		return r, nil
+	}
+	return "", ErrInvalidReason
+}
@@ -0,0 +1,110 @@
+package product
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Notifier delivers restock notifications to the subscribers of a product, and
+// availability changes (stock crossing zero) to merchandising.
+type Notifier interface {
+	NotifyRestock(ctx context.Context, p *Product, subs []RestockSubscription) error
+	NotifyAvailability(ctx context.Context, p *Product, available bool) error
+}
+
+// LogNotifier only logs the notifications (default when no webhook is configured).
+type LogNotifier struct{}
+
+func (LogNotifier) NotifyRestock(ctx context.Context, p *Product, subs []RestockSubscription) error {
+	for _, s := range subs {
+		log.Printf("[restock] product=%s stock=%d notify=%s", p.ID, p.Stock, s.Email)
+	}
+	return nil
+}
+
+func (LogNotifier) NotifyAvailability(ctx context.Context, p *Product, available bool) error {
+	log.Printf("[availability] product=%s stock=%d available=%v", p.ID, p.Stock, available)
+	return nil
+}
+
+// WebhookNotifier posts "product.restocked" and "product.availability_changed" events to an external URL.
+type WebhookNotifier struct {
+	URL  string
+	HTTP *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{URL: url, HTTP: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (w *WebhookNotifier) NotifyRestock(ctx context.Context, p *Product, subs []RestockSubscription) error {
+	return w.post(ctx, "restock", map[string]any{
+		"event":         "product.restocked",
+		"product":       p,
+		"subscriptions": subs,
+	})
+}
+
+func (w *WebhookNotifier) NotifyAvailability(ctx context.Context, p *Product, available bool) error {
+	return w.post(ctx, "availability", map[string]any{
+		"event":     "product.availability_changed",
+		"product":   p,
+		"available": available,
+	})
+}
+
+func (w *WebhookNotifier) post(ctx context.Context, kind string, event map[string]any) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	res, err := w.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s webhook: %w", kind, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 300 {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return fmt.Errorf("%s webhook: status=%d body=%q", kind, res.StatusCode, string(b))
+	}
+	return nil
+}
+
+// Restocked reports whether a stock change goes from empty to available.
+func Restocked(before, after int) bool {
+	return before <= 0 && after > 0
+}
+
+// AvailabilityChanged reports whether a stock change crosses zero in either direction:
+// from none (or backordered) to some units, or from some units to none.
+func AvailabilityChanged(before, after int) bool {
+	return (before > 0) != (after > 0)
+}
+
+// NotifyRestock sends the pending subscriptions of p and clears them once delivered. Only
+// the delivered ones are cleared, so a subscription made while sending stays pending.
+func NotifyRestock(ctx context.Context, repo Repository, n Notifier, p *Product) error {
+	subs, err := repo.RestockSubscriptions(ctx, p.ID)
+	if err != nil {
+		return err
+	}
+	if len(subs) == 0 {
+		return nil
+	}
+	if err := n.NotifyRestock(ctx, p, subs); err != nil {
+		return err
+	}
+	emails := make([]string, len(subs))
+	for i, s := range subs {
+		emails[i] = s.Email
+	}
+	return repo.ClearRestockSubscriptions(ctx, p.ID, emails)
+}
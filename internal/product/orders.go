@@ -0,0 +1,57 @@
+package product
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+// OrderQuantities reports how many units of a product orders still hold (order-service).
+type OrderQuantities interface {
+	ActiveQuantity(ctx context.Context, productID string) (int, error)
+}
+
+// OrderClient asks order-service over HTTP, forwarding the tenant and request ID.
+type OrderClient struct {
+	BaseURL string
+	HTTP    *http.Client
+}
+
+func NewOrderClient(baseURL string) *OrderClient {
+	return &OrderClient{BaseURL: baseURL, HTTP: &http.Client{Timeout: 5 * time.Second}}
+}
+
+func (o *OrderClient) ActiveQuantity(ctx context.Context, productID string) (int, error) {
+	u := o.BaseURL + "/orders/products/" + url.PathEscape(productID) + "/active-quantity"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, err
+	}
+	if rid := reqid.From(ctx); rid != "" {
+		req.Header.Set(reqid.Header, rid)
+	}
+	req.Header.Set(tenant.Header, tenant.From(ctx))
+	res, err := o.HTTP.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("order service: %w", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		b, _ := io.ReadAll(io.LimitReader(res.Body, 256))
+		return 0, fmt.Errorf("order service: status=%d body=%q", res.StatusCode, string(b))
+	}
+	var out struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil {
+		return 0, fmt.Errorf("order service: %w", err)
+	}
+	return out.Quantity, nil
+}
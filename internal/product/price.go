@@ -0,0 +1,85 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// PriceReasonBulkAdjust marks price_history rows written by a bulk percentage adjustment.
+const PriceReasonBulkAdjust = "bulk_adjust"
+
+var ErrInvalidPercent = errors.New("percent must be a non-zero number in (-100, 100]")
+
+const (
+	// DefaultPriceDecimals is how many decimals a price may have when PRICE_DECIMALS is unset.
+	DefaultPriceDecimals = 2
+	// MaxPriceDecimals bounds PRICE_DECIMALS (the same bound as order-service).
+	MaxPriceDecimals = 6
+)
+
+// Errors of ParsePrice; their text is what the API answers.
+var (
+	ErrPriceNotNumber = errors.New("price must be a number")
+	ErrNegativePrice  = errors.New("price must be >= 0")
+	ErrPriceDecimals  = errors.New("price has too many decimals")
+)
+
+var hundred = decimal.NewFromInt(100)
+
+// PriceChange is one price_history row.
+type PriceChange struct {
+	ProductID string `json:"product_id"`
+	OldPrice  string `json:"old_price"`
+	NewPrice  string `json:"new_price"`
+}
+
+// ParseAdjustPercent parses the percentage of a bulk price adjustment: non-zero, above -100
+// (a price can't drop to nothing) and at most 100.
+func ParseAdjustPercent(s string) (decimal.Decimal, error) {
+	p, err := decimal.NewFromString(s)
+	if err != nil || p.IsZero() || p.LessThanOrEqual(hundred.Neg()) || p.GreaterThan(hundred) {
+		return decimal.Zero, ErrInvalidPercent
+	}
+	return p, nil
+}
+
+// AdjustPrice changes price by percent, rounded to the scale the price already has
+// (at least cents), so "199.90" -10% gives "179.91".
+func AdjustPrice(price string, percent decimal.Decimal) (string, error) {
+	p, err := decimal.NewFromString(price)
+	if err != nil {
+		return "", err
+	}
+	places := -p.Exponent()
+	if places < 2 {
+		places = 2
+	}
+	return p.Mul(hundred.Add(percent)).Div(hundred).StringFixed(places), nil
+}
+
+// ParsePrice checks a product price: a non-negative decimal with at most places significant
+// decimals (trailing zeros don't count). It returns it normalized, written with the decimals
+// it needs and at least cents: "10" gives "10.00" and "010.500" gives "10.50".
+func ParsePrice(s string, places int32) (string, error) {
+	p, err := decimal.NewFromString(strings.TrimSpace(s))
+	if err != nil {
+		return "", ErrPriceNotNumber
+	}
+	if p.IsNegative() {
+		return "", ErrNegativePrice
+	}
+	scale := int32(0)
+	for !p.Equal(p.Truncate(scale)) {
+		scale++
+	}
+	if scale > places {
+		return "", fmt.Errorf("%w (at most %d)", ErrPriceDecimals, places)
+	}
+	if scale < 2 {
+		scale = 2
+	}
+	return p.StringFixed(scale), nil
+}
@@ -0,0 +1,80 @@
+package product
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestAdjustPrice(t *testing.T) {
+	cases := []struct {
+		price, percent, want string
+	}{
+		{"199.90", "-10", "179.91"},
+		{"10.00", "12.5", "11.25"},
+		{"9.99", "-33.333", "6.66"}, // rounded to cents
+		{"5", "10", "5.50"},         // at least cents
+		{"1.2345", "10", "1.3580"},  // keeps a finer scale
+		{"10.00", "100", "20.00"},
+	}
+	for _, tc := range cases {
+		got, err := AdjustPrice(tc.price, decimal.RequireFromString(tc.percent))
+		if err != nil || got != tc.want {
+			t.Fatalf("AdjustPrice(%s, %s) = %q, %v; want %q", tc.price, tc.percent, got, err, tc.want)
+		}
+	}
+}
+
+func TestParseAdjustPercent(t *testing.T) {
+	for _, ok := range []string{"-10", "12.5", "100", "-99.99"} {
+		if _, err := ParseAdjustPercent(ok); err != nil {
+			t.Fatalf("ParseAdjustPercent(%q): %v", ok, err)
+		}
+	}
+	for _, bad := range []string{"", "abc", "0", "-100", "-150", "100.01"} {
+		if _, err := ParseAdjustPercent(bad); err != ErrInvalidPercent {
+			t.Fatalf("ParseAdjustPercent(%q) err=%v, expected ErrInvalidPercent", bad, err)
+		}
+	}
+}
+
+func TestParsePrice(t *testing.T) {
+	valid := []struct {
+		in     string
+		places int32
+		want   string
+	}{
+		{"10", 2, "10.00"},
+		{"010.5", 2, "10.50"},
+		{" 9.99 ", 2, "9.99"},
+		{"1.2300", 2, "1.23"}, // trailing zeros are not decimals
+		{"0", 2, "0.00"},
+		{"1.2345", 4, "1.2345"},
+		{"1e2", 2, "100.00"},
+	}
+	for _, tc := range valid {
+		got, err := ParsePrice(tc.in, tc.places)
+		if err != nil || got != tc.want {
+			t.Fatalf("ParsePrice(%q, %d) = %q, %v; want %q", tc.in, tc.places, got, err, tc.want)
+		}
+	}
+
+	invalid := []struct {
+		in     string
+		places int32
+		want   error
+	}{
+		{"1.234", 2, ErrPriceDecimals},
+		{"1.2345", 3, ErrPriceDecimals},
+		{"-1.00", 2, ErrNegativePrice},
+		{"abc", 2, ErrPriceNotNumber},
+		{"", 2, ErrPriceNotNumber},
+		{"1,50", 2, ErrPriceNotNumber},
+	}
+	for _, tc := range invalid {
+		if _, err := ParsePrice(tc.in, tc.places); !errors.Is(err, tc.want) {
+			t.Fatalf("ParsePrice(%q, %d) err=%v; want %v", tc.in, tc.places, err, tc.want)
+		}
+	}
+}
@@ -8,61 +8,209 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/shopspring/decimal"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 )
 
 var (
 	ErrNotFound          = errors.New("product not found")
 	ErrInsufficientStock = errors.New("insufficient stock")
+	ErrDuplicateBarcode  = errors.New("barcode already in use")
+	// ErrNegativeStock: an update set negative stock on a product without allow_backorder.
+	ErrNegativeStock = errors.New("stock must be >= 0 unless allow_backorder is set")
 )
 
 type Query struct {
 	Q      string
 	Limit  int
 	Offset int
+	// optional created_at bounds, both inclusive (see ParseCreatedRange)
+	CreatedFrom, CreatedTo *time.Time
 }
 
+// Repository methods returning lists give an empty slice, never nil, when nothing matches,
+// so handlers serialize [] instead of null.
 type Repository interface {
 	Create(ctx context.Context, p *Product) error
 	GetByID(ctx context.Context, id string) (*Product, error)
+	GetByIDPrimary(ctx context.Context, id string) (*Product, error)
+	GetByIDIncludeDeleted(ctx context.Context, id string) (*Product, error)
+	GetByBarcode(ctx context.Context, code string) (*Product, error)
 	List(ctx context.Context, q Query) ([]Product, error)
-	Update(ctx context.Context, p *Product, updatePrice bool) error
+	CountSearch(ctx context.Context, q Query) (int, error)
+	LowStock(ctx context.Context, threshold, limit, offset int) ([]Product, error)
+	Categories(ctx context.Context) ([]CategoryCount, error)
+	Update(ctx context.Context, p *Product, updatePrice bool, backorder *bool, ch StockChange) (bool, error)
 	Delete(ctx context.Context, id string) (bool, error)
+	BulkAdjustPrice(ctx context.Context, category string, percent decimal.Decimal) ([]PriceChange, error)
+
+	DecrementStock(ctx context.Context, id string, qty int, ch StockChange) (int, error)
+	DecrementStockOnce(ctx context.Context, id string, qty int, ch StockChange) (stock int, applied bool, err error)
+	IncrementStock(ctx context.Context, id string, qty int, ch StockChange) (int, error)
+	SetStock(ctx context.Context, id string, stock int, ch StockChange) (before int, err error)
+	RestockOnce(ctx context.Context, id string, qty int, ch StockChange) (stock int, applied bool, err error)
+	TransferStock(ctx context.Context, fromID, toID string, qty int) (fromStock, toStock int, err error)
+	StockMovements(ctx context.Context, productID string, limit, offset int) ([]StockMovement, error)
+	OrderStockMovements(ctx context.Context, orderID string) ([]StockMovement, error)
+
+	Subscribe(ctx context.Context, s *RestockSubscription) error
+	RestockSubscriptions(ctx context.Context, productID string) ([]RestockSubscription, error)
+	ClearRestockSubscriptions(ctx context.Context, productID string, emails []string) error
+}
+
+// DB is the subset of *pgxpool.Pool the repository uses.
+type DB interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	BeginTx(ctx context.Context, txOptions pgx.TxOptions) (pgx.Tx, error)
+}
+
+var _ DB = (*pgxpool.Pool)(nil)
+
+type PGRepo struct {
+	db         DB
+	read       DB
+	searchMode SearchMode
+}
+
+// Option customizes a PGRepo.
+type Option func(*PGRepo)
+
+// WithSearchMode sets how List matches the search text (default SearchILike).
+func WithSearchMode(m SearchMode) Option {
+	return func(r *PGRepo) { r.searchMode = m }
+}
+
+// WithReadDB sends the read-only queries (get, list/search, reports) to a replica.
+// Writes, and reads that must see them (GetByIDPrimary), stay on the primary.
+func WithReadDB(read DB) Option {
+	return func(r *PGRepo) {
+		if read != nil {
+			r.read = read
+		}
+	}
+}
 
-	DecrementStock(ctx context.Context, id string, qty int) (int, error)
-	IncrementStock(ctx context.Context, id string, qty int) (int, error)
+func NewPGRepo(db DB, opts ...Option) *PGRepo {
+	r := &PGRepo{db: db, read: db, searchMode: SearchILike}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
-type PGRepo struct{ db *pgxpool.Pool }
+// productColumns is the SELECT list matching scanProduct.
+const productColumns = `id, name, COALESCE(description, ''), price::text, stock, low_stock_threshold, COALESCE(barcode, ''), COALESCE(category, ''), status, allow_backorder, created_at, updated_at, deleted_at`
 
-func NewPGRepo(db *pgxpool.Pool) *PGRepo { return &PGRepo{db: db} }
+func scanProduct(row pgx.Row, p *Product) error {
+	return row.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.LowStockThreshold, &p.Barcode, &p.Category, &p.Status, &p.AllowBackorder, &p.CreatedAt, &p.UpdatedAt, &p.DeletedAt)
+}
+
+// uniqueViolation maps a duplicate barcode to ErrDuplicateBarcode (the only unique column besides id).
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicateBarcode
+	}
+	return err
+}
+
+func scanProducts(rows pgx.Rows) ([]Product, error) {
+	defer rows.Close()
+	out := []Product{}
+	for rows.Next() {
+		var p Product
+		if err := scanProduct(rows, &p); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
 
 func (r *PGRepo) Create(ctx context.Context, p *Product) error {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	_, err := r.db.Exec(ctx, `
-		INSERT INTO products (id, name, description, price, stock, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,$5,NOW(),NOW())
-	`, p.ID, p.Name, p.Description, p.Price, p.Stock)
-	return err
+		INSERT INTO products (id, name, description, price, stock, low_stock_threshold, barcode, category, status, allow_backorder, tenant_id, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,$6,NULLIF($7,''),NULLIF($8,''),$9,$10,$11,NOW(),NOW())
+	`, p.ID, p.Name, p.Description, p.Price, p.Stock, p.LowStockThreshold, p.Barcode, p.Category, p.Status, p.AllowBackorder, tenant.From(ctx))
+	return uniqueViolation(err)
 }
 
 func (r *PGRepo) GetByID(ctx context.Context, id string) (*Product, error) {
+	return r.getByID(ctx, r.read, id)
+}
+
+// GetByIDPrimary is GetByID read from the primary, for reads right after a write that a
+// lagging replica might not have yet.
+func (r *PGRepo) GetByIDPrimary(ctx context.Context, id string) (*Product, error) {
+	return r.getByID(ctx, r.db, id)
+}
+
+func (r *PGRepo) getByID(ctx context.Context, db DB, id string) (*Product, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var p Product
-	err := r.db.QueryRow(ctx, `
-		SELECT id, name, description, price::text, stock, created_at, updated_at
-		FROM products WHERE id=$1
-	`, id).Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CreatedAt, &p.UpdatedAt)
+	err := scanProduct(db.QueryRow(ctx, `
+		SELECT `+productColumns+`
+		FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL
+	`, id, tenant.From(ctx)), &p)
 	if err != nil {
-		return nil, ErrNotFound
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
 	}
 	return &p, nil
 }
 
+// GetByIDIncludeDeleted also returns soft-deleted products (DeletedAt set), e.g. to
+// render the items of historical orders.
+func (r *PGRepo) GetByIDIncludeDeleted(ctx context.Context, id string) (*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var p Product
+	err := scanProduct(r.read.QueryRow(ctx, `
+		SELECT `+productColumns+`
+		FROM products WHERE id=$1 AND tenant_id=$2
+	`, id, tenant.From(ctx)), &p)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (r *PGRepo) GetByBarcode(ctx context.Context, code string) (*Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var p Product
+	err := scanProduct(r.read.QueryRow(ctx, `
+		SELECT `+productColumns+`
+		FROM products WHERE barcode=$1 AND tenant_id=$2 AND deleted_at IS NULL
+	`, code, tenant.From(ctx)), &p)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &p, nil
+}
+
+// List pages through the live products matching q, newest first; the id breaks created_at
+// ties so pages neither repeat nor skip products.
 func (r *PGRepo) List(ctx context.Context, q Query) ([]Product, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -76,86 +224,274 @@ func (r *PGRepo) List(ctx context.Context, q Query) ([]Product, error) {
 		offset = 0
 	}
 
-	search := strings.TrimSpace(q.Q)
+	search, where := r.searchFilter(q.Q)
+	rows, err := r.read.Query(ctx, `
+		SELECT `+productColumns+`
+		FROM products
+		WHERE tenant_id=$4 AND deleted_at IS NULL AND `+where+`
+		  AND ($5::timestamptz IS NULL OR created_at >= $5::timestamptz)
+		  AND ($6::timestamptz IS NULL OR created_at <= $6::timestamptz)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, search, limit, offset, tenant.From(ctx), q.CreatedFrom, q.CreatedTo)
+	if err != nil {
+		return nil, err
+	}
+	return scanProducts(rows)
+}
 
-	rows, err := r.db.Query(ctx, `
-		SELECT id, name, description, price::text, stock, created_at, updated_at
+// CountSearch counts the live products List matches for q, ignoring limit and offset.
+func (r *PGRepo) CountSearch(ctx context.Context, q Query) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	search, where := r.searchFilter(q.Q)
+	var n int
+	err := r.read.QueryRow(ctx, `
+		SELECT COUNT(*)
 		FROM products
-		WHERE ($1 = '' OR name ILIKE '%'||$1||'%' OR description ILIKE '%'||$1||'%')
-		ORDER BY created_at DESC
+		WHERE tenant_id=$2 AND deleted_at IS NULL AND `+where+`
+		  AND ($3::timestamptz IS NULL OR created_at >= $3::timestamptz)
+		  AND ($4::timestamptz IS NULL OR created_at <= $4::timestamptz)
+	`, search, tenant.From(ctx), q.CreatedFrom, q.CreatedTo).Scan(&n)
+	return n, err
+}
+
+// searchFilter returns the search text and the WHERE clause matching it (as $1) under the
+// repo's search mode, shared by List and CountSearch.
+func (r *PGRepo) searchFilter(q string) (string, string) {
+	search := strings.TrimSpace(q)
+	if r.searchMode == SearchUnaccent {
+		// both sides unaccented: the query in Go, the columns via the indexed f_unaccent
+		return Unaccent(search), `($1 = '' OR f_unaccent(name) ILIKE '%'||$1||'%' OR f_unaccent(description) ILIKE '%'||$1||'%')`
+	}
+	return search, `($1 = '' OR name ILIKE '%'||$1||'%' OR description ILIKE '%'||$1||'%')`
+}
+
+// LowStock lists products with stock <= threshold, lowest stock first.
+// A negative threshold compares each product against its own low_stock_threshold.
+func (r *PGRepo) LowStock(ctx context.Context, threshold, limit, offset int) ([]Product, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := r.read.Query(ctx, `
+		SELECT `+productColumns+`
+		FROM products
+		WHERE tenant_id=$4 AND deleted_at IS NULL AND stock <= CASE WHEN $1 < 0 THEN low_stock_threshold ELSE $1 END
+		ORDER BY stock ASC, created_at DESC
 		LIMIT $2 OFFSET $3
-	`, search, limit, offset)
+	`, threshold, limit, offset, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return scanProducts(rows)
+}
+
+// Categories lists the distinct categories of live products with their counts, alphabetically.
+func (r *PGRepo) Categories(ctx context.Context) ([]CategoryCount, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.read.Query(ctx, `
+		SELECT category, COUNT(*)
+		FROM products
+		WHERE tenant_id=$1 AND category IS NOT NULL AND deleted_at IS NULL
+		GROUP BY category
+		ORDER BY category ASC
+	`, tenant.From(ctx))
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var out []Product
+	out := []CategoryCount{}
 	for rows.Next() {
-		var p Product
-		if err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.CreatedAt, &p.UpdatedAt); err != nil {
+		var cc CategoryCount
+		if err := rows.Scan(&cc.Category, &cc.Products); err != nil {
 			return nil, err
 		}
-		out = append(out, p)
+		out = append(out, cc)
 	}
 	return out, rows.Err()
 }
 
-func (r *PGRepo) Update(ctx context.Context, p *Product, updatePrice bool) error {
+// Update applies a partial update and reports whether the product exists (false: nothing
+// changed). A stock change is recorded in stock_movements with ch. A non-zero p.UpdatedAt
+// is the version the caller expects: if the row changed since, ErrPreconditionFailed.
+// allow_backorder is set to backorder, or kept when nil; negative stock is checked against
+// that value on the locked row (ErrNegativeStock).
+func (r *PGRepo) Update(ctx context.Context, p *Product, updatePrice bool, backorder *bool, ch StockChange) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var before int
+	var updatedAt time.Time
+	var allowBackorder bool
+	err = tx.QueryRow(ctx, `SELECT stock, updated_at, allow_backorder FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL FOR UPDATE`, p.ID, tenant.From(ctx)).Scan(&before, &updatedAt, &allowBackorder)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !p.UpdatedAt.IsZero() && !updatedAt.Equal(p.UpdatedAt) {
+		return true, ErrPreconditionFailed
+	}
+	if backorder != nil {
+		allowBackorder = *backorder
+	}
+	if p.Stock < 0 && !allowBackorder {
+		return true, ErrNegativeStock
+	}
+
 	if updatePrice {
-		_, err := r.db.Exec(ctx, `
+		_, err = tx.Exec(ctx, `
 			UPDATE products
 			SET name = COALESCE(NULLIF($2,''), name),
 			    description = COALESCE(NULLIF($3,''), description),
 			    price = $4,
 			    stock = $5,
+			    low_stock_threshold = COALESCE(NULLIF($6, -1), low_stock_threshold),
+			    barcode = COALESCE(NULLIF($7,''), barcode),
+			    category = COALESCE(NULLIF($8,''), category),
+			    status = COALESCE(NULLIF($9,''), status),
+			    allow_backorder = COALESCE($10, allow_backorder),
 			    updated_at = NOW()
 			WHERE id = $1
-		`, p.ID, p.Name, p.Description, p.Price, p.Stock)
-		return err
+		`, p.ID, p.Name, p.Description, p.Price, p.Stock, p.LowStockThreshold, p.Barcode, p.Category, p.Status, backorder)
+	} else {
+		_, err = tx.Exec(ctx, `
+			UPDATE products
+			SET name = COALESCE(NULLIF($2,''), name),
+			    description = COALESCE(NULLIF($3,''), description),
+			    stock = $4,
+			    low_stock_threshold = COALESCE(NULLIF($5, -1), low_stock_threshold),
+			    barcode = COALESCE(NULLIF($6,''), barcode),
+			    category = COALESCE(NULLIF($7,''), category),
+			    status = COALESCE(NULLIF($8,''), status),
+			    allow_backorder = COALESCE($9, allow_backorder),
+			    updated_at = NOW()
+			WHERE id = $1
+		`, p.ID, p.Name, p.Description, p.Stock, p.LowStockThreshold, p.Barcode, p.Category, p.Status, backorder)
+	}
+	if err != nil {
+		return false, uniqueViolation(err)
 	}
 
-	_, err := r.db.Exec(ctx, `
-		UPDATE products
-		SET name = COALESCE(NULLIF($2,''), name),
-		    description = COALESCE(NULLIF($3,''), description),
-		    stock = $4,
-		    updated_at = NOW()
-		WHERE id = $1
-	`, p.ID, p.Name, p.Description, p.Stock)
-	return err
+	if delta := p.Stock - before; delta != 0 {
+		if err := insertMovement(ctx, tx, p.ID, delta, p.Stock, ch); err != nil {
+			return false, err
+		}
+	}
+	return true, tx.Commit(ctx)
 }
 
+// Delete soft-deletes the product: it disappears from reads and stock changes but stays
+// in the table so historical orders can still resolve it.
 func (r *PGRepo) Delete(ctx context.Context, id string) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	cmd, err := r.db.Exec(ctx, `DELETE FROM products WHERE id=$1`, id)
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE products SET deleted_at = NOW(), updated_at = NOW()
+		WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL
+	`, id, tenant.From(ctx))
 	if err != nil {
 		return false, err
 	}
 	return cmd.RowsAffected() > 0, nil
 }
 
-func (r *PGRepo) DecrementStock(ctx context.Context, id string, qty int) (int, error) {
+// BulkAdjustPrice changes by percent the price of every live product in category, in one
+// transaction, recording each change in price_history. Returns the changes applied.
+func (r *PGRepo) BulkAdjustPrice(ctx context.Context, category string, percent decimal.Decimal) ([]PriceChange, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, price::text FROM products
+		WHERE tenant_id=$1 AND category=$2 AND deleted_at IS NULL
+		ORDER BY id
+		FOR UPDATE
+	`, tenant.From(ctx), category)
+	if err != nil {
+		return nil, err
+	}
+	var changes []PriceChange
+	for rows.Next() {
+		var ch PriceChange
+		if err := rows.Scan(&ch.ProductID, &ch.OldPrice); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changes = append(changes, ch)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range changes {
+		ch := &changes[i]
+		if ch.NewPrice, err = AdjustPrice(ch.OldPrice, percent); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `UPDATE products SET price = $2, updated_at = NOW() WHERE id = $1`, ch.ProductID, ch.NewPrice); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec(ctx, `
+			INSERT INTO price_history (product_id, old_price, new_price, reason, at)
+			VALUES ($1, $2, $3, $4, NOW())
+		`, ch.ProductID, ch.OldPrice, ch.NewPrice, PriceReasonBulkAdjust); err != nil {
+			return nil, err
+		}
+	}
+	return changes, tx.Commit(ctx)
+}
+
+// DecrementStock takes qty units, failing with ErrInsufficientStock when fewer are left,
+// unless the product allows backorders: then the stock may go negative.
+func (r *PGRepo) DecrementStock(ctx context.Context, id string, qty int, ch StockChange) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	var remaining int
-	err := r.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		UPDATE products
 		SET stock = stock - $2, updated_at = NOW()
-		WHERE id=$1 AND stock >= $2
+		WHERE id=$1 AND tenant_id=$3 AND deleted_at IS NULL AND (stock >= $2 OR allow_backorder)
 		RETURNING stock
-	`, id, qty).Scan(&remaining)
+	`, id, qty, tenant.From(ctx)).Scan(&remaining)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			// ¿existe?
 			var exists bool
-			_ = r.db.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM products WHERE id=$1)`, id).Scan(&exists)
+			_ = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL)`, id, tenant.From(ctx)).Scan(&exists)
 			if exists {
 				return 0, ErrInsufficientStock
 			}
@@ -163,25 +499,367 @@ func (r *PGRepo) DecrementStock(ctx context.Context, id string, qty int) (int, e
 		}
 		return 0, err
 	}
+	if err := insertMovement(ctx, tx, id, -qty, remaining, ch); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
 	return remaining, nil
 }
 
-func (r *PGRepo) IncrementStock(ctx context.Context, id string, qty int) (int, error) {
+// DecrementStockOnce is DecrementStock for one order line, applied at most once per
+// (ch.OrderID, ch.ItemID): the decrement_ledger row is claimed in the same transaction, so a
+// retried request changes nothing and returns applied=false with the current stock. Running
+// short rolls the claim back with the rest, so the line may be tried again later.
+func (r *PGRepo) DecrementStockOnce(ctx context.Context, id string, qty int, ch StockChange) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var stock int
+	err = tx.QueryRow(ctx, `SELECT stock FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL FOR UPDATE`, id, tenant.From(ctx)).Scan(&stock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, ErrNotFound
+		}
+		return 0, false, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO decrement_ledger (order_id, item_id, product_id, quantity, at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (order_id, item_id) DO NOTHING
+	`, ch.OrderID, ch.ItemID, id, qty)
+	if err != nil {
+		return 0, false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return stock, false, nil // this line already took its units
+	}
+
+	err = tx.QueryRow(ctx, `
+		UPDATE products
+		SET stock = stock - $2, updated_at = NOW()
+		WHERE id=$1 AND (stock >= $2 OR allow_backorder)
+		RETURNING stock
+	`, id, qty).Scan(&stock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, ErrInsufficientStock // the row is locked: it exists
+		}
+		return 0, false, err
+	}
+	if err := insertMovement(ctx, tx, id, -qty, stock, ch); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, err
+	}
+	return stock, true, nil
+}
+
+func (r *PGRepo) IncrementStock(ctx context.Context, id string, qty int, ch StockChange) (int, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
 	var remaining int
-	err := r.db.QueryRow(ctx, `
+	err = tx.QueryRow(ctx, `
 		UPDATE products
 		SET stock = stock + $2, updated_at = NOW()
-		WHERE id=$1
+		WHERE id=$1 AND tenant_id=$3 AND deleted_at IS NULL
 		RETURNING stock
-	`, id, qty).Scan(&remaining)
+	`, id, qty, tenant.From(ctx)).Scan(&remaining)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return 0, ErrNotFound
 		}
 		return 0, err
 	}
+	if err := insertMovement(ctx, tx, id, qty, remaining, ch); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
 	return remaining, nil
 }
+
+// SetStock overwrites the stock with an absolute value (a correction, not a delta) and
+// records the difference as a movement. Returns the stock it replaced.
+func (r *PGRepo) SetStock(ctx context.Context, id string, stock int, ch StockChange) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var before int
+	err = tx.QueryRow(ctx, `SELECT stock FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL FOR UPDATE`, id, tenant.From(ctx)).Scan(&before)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	if stock == before {
+		return before, nil
+	}
+	if _, err := tx.Exec(ctx, `UPDATE products SET stock = $2, updated_at = NOW() WHERE id = $1`, id, stock); err != nil {
+		return 0, err
+	}
+	if err := insertMovement(ctx, tx, id, stock-before, stock, ch); err != nil {
+		return 0, err
+	}
+	return before, tx.Commit(ctx)
+}
+
+// RestockOnce adds qty units given back by order ch.OrderID, at most once per (order, product):
+// the restock_ledger row is claimed in the same transaction, so a replay changes nothing and
+// returns applied=false with the current stock.
+func (r *PGRepo) RestockOnce(ctx context.Context, id string, qty int, ch StockChange) (int, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, false, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var stock int
+	err = tx.QueryRow(ctx, `SELECT stock FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL FOR UPDATE`, id, tenant.From(ctx)).Scan(&stock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, false, ErrNotFound
+		}
+		return 0, false, err
+	}
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO restock_ledger (order_id, product_id, quantity, at)
+		VALUES ($1, $2, $3, NOW())
+		ON CONFLICT (order_id, product_id) DO NOTHING
+	`, ch.OrderID, id, qty)
+	if err != nil {
+		return 0, false, err
+	}
+	if tag.RowsAffected() == 0 {
+		return stock, false, nil // already restocked for this order
+	}
+
+	if err := tx.QueryRow(ctx, `
+		UPDATE products SET stock = stock + $2, updated_at = NOW()
+		WHERE id=$1
+		RETURNING stock
+	`, id, qty).Scan(&stock); err != nil {
+		return 0, false, err
+	}
+	if err := insertMovement(ctx, tx, id, qty, stock, ch); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, false, err
+	}
+	return stock, true, nil
+}
+
+// TransferStock moves qty units from one product to another in a single transaction.
+// Nothing moves if the source lacks stock (ErrInsufficientStock) or either product is missing.
+func (r *PGRepo) TransferStock(ctx context.Context, fromID, toID string, qty int) (int, int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	var fromStock int
+	err = tx.QueryRow(ctx, `
+		UPDATE products
+		SET stock = stock - $2, updated_at = NOW()
+		WHERE id=$1 AND tenant_id=$3 AND deleted_at IS NULL AND stock >= $2
+		RETURNING stock
+	`, fromID, qty, tenant.From(ctx)).Scan(&fromStock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			var exists bool
+			_ = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM products WHERE id=$1 AND tenant_id=$2 AND deleted_at IS NULL)`, fromID, tenant.From(ctx)).Scan(&exists)
+			if exists {
+				return 0, 0, ErrInsufficientStock
+			}
+			return 0, 0, ErrNotFound
+		}
+		return 0, 0, err
+	}
+
+	var toStock int
+	err = tx.QueryRow(ctx, `
+		UPDATE products
+		SET stock = stock + $2, updated_at = NOW()
+		WHERE id=$1 AND tenant_id=$3 AND deleted_at IS NULL
+		RETURNING stock
+	`, toID, qty, tenant.From(ctx)).Scan(&toStock)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return 0, 0, ErrNotFound
+		}
+		return 0, 0, err
+	}
+
+	if err := insertMovement(ctx, tx, fromID, -qty, fromStock, StockChange{Reason: ReasonTransferOut}); err != nil {
+		return 0, 0, err
+	}
+	if err := insertMovement(ctx, tx, toID, qty, toStock, StockChange{Reason: ReasonTransferIn}); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, 0, err
+	}
+	return fromStock, toStock, nil
+}
+
+// insertMovement records a stock change inside the transaction that made it.
+func insertMovement(ctx context.Context, tx pgx.Tx, productID string, delta, resulting int, ch StockChange) error {
+	reason := ch.Reason
+	if reason == "" {
+		reason = ReasonAdjustment
+	}
+	_, err := tx.Exec(ctx, `
+		INSERT INTO stock_movements (product_id, delta, reason, resulting_stock, order_id, at)
+		VALUES ($1, $2, $3, $4, NULLIF($5,'')::uuid, NOW())
+	`, productID, delta, reason, resulting, ch.OrderID)
+	return err
+}
+
+// StockMovements lists a product's stock history, newest first.
+func (r *PGRepo) StockMovements(ctx context.Context, productID string, limit, offset int) ([]StockMovement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+
+	rows, err := r.read.Query(ctx, `
+		SELECT id, product_id, delta, reason, resulting_stock, COALESCE(order_id::text,''), at
+		FROM stock_movements
+		WHERE product_id=$1 AND product_id IN (SELECT id FROM products WHERE tenant_id=$4)
+		ORDER BY at DESC, id DESC
+		LIMIT $2 OFFSET $3
+	`, productID, limit, offset, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Reason, &m.ResultingStock, &m.OrderID, &m.At); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// OrderStockMovements lists the stock movements an order caused, across products, oldest
+// first: its creation decrements, then any cancel or refund restocks.
+func (r *PGRepo) OrderStockMovements(ctx context.Context, orderID string) ([]StockMovement, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.read.Query(ctx, `
+		SELECT id, product_id, delta, reason, resulting_stock, COALESCE(order_id::text,''), at
+		FROM stock_movements
+		WHERE order_id=$1 AND product_id IN (SELECT id FROM products WHERE tenant_id=$2)
+		ORDER BY at, id
+	`, orderID, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []StockMovement{}
+	for rows.Next() {
+		var m StockMovement
+		if err := rows.Scan(&m.ID, &m.ProductID, &m.Delta, &m.Reason, &m.ResultingStock, &m.OrderID, &m.At); err != nil {
+			return nil, err
+		}
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (r *PGRepo) Subscribe(ctx context.Context, s *RestockSubscription) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.db.QueryRow(ctx, `
+		INSERT INTO restock_subscriptions (product_id, user_id, email, created_at)
+		VALUES ($1, NULLIF($2,'')::uuid, $3, NOW())
+		ON CONFLICT (product_id, email) DO UPDATE SET user_id = EXCLUDED.user_id
+		RETURNING created_at
+	`, s.ProductID, s.UserID, s.Email).Scan(&s.CreatedAt)
+}
+
+func (r *PGRepo) RestockSubscriptions(ctx context.Context, productID string) ([]RestockSubscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+		SELECT product_id, COALESCE(user_id::text,''), email, created_at
+		FROM restock_subscriptions
+		WHERE product_id=$1 AND product_id IN (SELECT id FROM products WHERE tenant_id=$2)
+		ORDER BY created_at
+	`, productID, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []RestockSubscription{}
+	for rows.Next() {
+		var s RestockSubscription
+		if err := rows.Scan(&s.ProductID, &s.UserID, &s.Email, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+// ClearRestockSubscriptions deletes the given subscriptions of productID, by email. Only the
+// ones that were notified go: a subscription created meanwhile waits for the next restock.
+func (r *PGRepo) ClearRestockSubscriptions(ctx context.Context, productID string, emails []string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := r.db.Exec(ctx, `
+		DELETE FROM restock_subscriptions
+		WHERE product_id=$1 AND email = ANY($3)
+		  AND product_id IN (SELECT id FROM products WHERE tenant_id=$2)
+	`, productID, tenant.From(ctx), emails)
+	return err
+}
@@ -0,0 +1,331 @@
+package product
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errFakeDB = errors.New("fake db")
+
+// fakeDB counts the calls it receives; every query fails.
+type fakeDB struct{ calls int }
+
+type errRow struct{}
+
+func (errRow) Scan(...any) error { return errFakeDB }
+
+func (f *fakeDB) Exec(context.Context, string, ...any) (pgconn.CommandTag, error) {
+	f.calls++
+	return pgconn.CommandTag{}, errFakeDB
+}
+
+func (f *fakeDB) Query(context.Context, string, ...any) (pgx.Rows, error) {
+	f.calls++
+	return nil, errFakeDB
+}
+
+func (f *fakeDB) QueryRow(context.Context, string, ...any) pgx.Row {
+	f.calls++
+	return errRow{}
+}
+
+func (f *fakeDB) BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error) {
+	f.calls++
+	return nil, errFakeDB
+}
+
+func TestPGRepo_ReadsGoToReadDB(t *testing.T) {
+	ctx := context.Background()
+	primary, replica := &fakeDB{}, &fakeDB{}
+	r := NewPGRepo(primary, WithReadDB(replica))
+
+	_, _ = r.GetByID(ctx, "p1")
+	_, _ = r.List(ctx, Query{})
+	_, _ = r.List(ctx, Query{Q: "mouse"})
+	_, _ = r.LowStock(ctx, 5, 10, 0)
+	_, _ = r.CountSearch(ctx, Query{Q: "mouse"})
+	if replica.calls != 5 || primary.calls != 0 {
+		t.Fatalf("reads: replica=%d primary=%d, expected 5 and 0", replica.calls, primary.calls)
+	}
+
+	_ = r.Create(ctx, &Product{ID: "p1"})
+	_, _ = r.Delete(ctx, "p1")
+	_, _ = r.Update(ctx, &Product{ID: "p1"}, false, nil, StockChange{})
+	_, _ = r.DecrementStock(ctx, "p1", 1, StockChange{})
+	if primary.calls != 4 || replica.calls != 5 {
+		t.Fatalf("writes: primary=%d replica=%d, expected 4 and 5", primary.calls, replica.calls)
+	}
+
+	// reading back what was just written
+	_, _ = r.GetByIDPrimary(ctx, "p1")
+	if primary.calls != 5 || replica.calls != 5 {
+		t.Fatalf("primary read: primary=%d replica=%d, expected 5 and 5", primary.calls, replica.calls)
+	}
+}
+
+func TestPGRepo_NoReadDBUsesPrimary(t *testing.T) {
+	primary := &fakeDB{}
+	r := NewPGRepo(primary, WithReadDB(nil))
+
+	_, _ = r.GetByID(context.Background(), "p1")
+	_, _ = r.List(context.Background(), Query{})
+	if primary.calls != 2 {
+		t.Fatalf("primary calls=%d, expected 2", primary.calls)
+	}
+}
+
+func TestPGRepo_LookupErrorsAreNotNotFound(t *testing.T) {
+	ctx := context.Background()
+	r := NewPGRepo(&fakeDB{})
+
+	lookups := map[string]func() (*Product, error){
+		"GetByID":               func() (*Product, error) { return r.GetByID(ctx, "p1") },
+		"GetByIDPrimary":        func() (*Product, error) { return r.GetByIDPrimary(ctx, "p1") },
+		"GetByIDIncludeDeleted": func() (*Product, error) { return r.GetByIDIncludeDeleted(ctx, "p1") },
+		"GetByBarcode":          func() (*Product, error) { return r.GetByBarcode(ctx, "4006381333931") },
+	}
+	for name, get := range lookups {
+		if _, err := get(); !errors.Is(err, errFakeDB) || errors.Is(err, ErrNotFound) {
+			t.Fatalf("%s: err=%v, expected the query error, not ErrNotFound", name, err)
+		}
+	}
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/product
+func TestPGRepo_DecrementStockConcurrent(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	p := &Product{ID: uuid.NewString(), Name: "Concurrent", Price: "1.00", Stock: 5, Status: StatusActive}
+	if err := r.Create(ctx, p); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	// 20 buyers race for 5 units: exactly 5 win and the stock never goes below zero
+	const n = 20
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		ok, sold int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := r.DecrementStock(ctx, p.ID, 1, StockChange{Reason: ReasonOrder})
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == nil:
+				ok++
+			case errors.Is(err, ErrInsufficientStock):
+				sold++
+			default:
+				t.Errorf("decrement: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if ok != 5 || sold != n-5 {
+		t.Fatalf("ok=%d insufficient=%d, expected 5 and %d", ok, sold, n-5)
+	}
+	got, err := r.GetByID(ctx, p.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if got.Stock != 0 {
+		t.Fatalf("stock=%d, expected 0", got.Stock)
+	}
+}
+
+func TestPGRepo_ListStablePages(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	// every product shares one created_at: only the id can order them
+	tag := "tie" + uuid.NewString()[:8]
+	const n = 7
+	for i := 0; i < n; i++ {
+		p := &Product{ID: uuid.NewString(), Name: tag, Price: "1.00", Status: StatusActive}
+		if err := r.Create(ctx, p); err != nil {
+			t.Fatalf("create: %v", err)
+		}
+	}
+	if _, err := pool.Exec(ctx, `UPDATE products SET created_at = '2025-01-01T00:00:00Z' WHERE name = $1`, tag); err != nil {
+		t.Fatalf("same timestamp: %v", err)
+	}
+
+	for round := 0; round < 2; round++ {
+		var seen []string
+		for offset := 0; offset < n; offset += 3 {
+			page, err := r.List(ctx, Query{Q: tag, Limit: 3, Offset: offset})
+			if err != nil {
+				t.Fatalf("page at %d: %v", offset, err)
+			}
+			for _, p := range page {
+				seen = append(seen, p.ID)
+			}
+		}
+		if len(seen) != n {
+			t.Fatalf("round %d: %d products across pages, expected %d", round, len(seen), n)
+		}
+		for i := 1; i < len(seen); i++ {
+			if seen[i-1] <= seen[i] {
+				t.Fatalf("round %d: ids not strictly descending across pages: %v", round, seen)
+			}
+		}
+	}
+}
+
+func TestPGRepo_DecrementStockOnce(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	p := &Product{ID: uuid.NewString(), Name: "Once", Price: "1.00", Stock: 5, Status: StatusActive}
+	if err := r.Create(ctx, p); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	order := uuid.NewString()
+	line := StockChange{Reason: ReasonOrder, OrderID: order, ItemID: uuid.NewString()}
+
+	// the same line three times (a retry after a lost response): units taken once
+	for i, want := range []bool{true, false, false} {
+		stock, applied, err := r.DecrementStockOnce(ctx, p.ID, 2, line)
+		if err != nil || applied != want || stock != 3 {
+			t.Fatalf("attempt %d: stock=%d applied=%v err=%v, expected 3 %v", i, stock, applied, err, want)
+		}
+	}
+	// another line of the same order and product is its own decrement
+	other := StockChange{Reason: ReasonOrder, OrderID: order, ItemID: uuid.NewString()}
+	if stock, applied, err := r.DecrementStockOnce(ctx, p.ID, 1, other); err != nil || !applied || stock != 2 {
+		t.Fatalf("second line: stock=%d applied=%v err=%v", stock, applied, err)
+	}
+
+	// running short claims nothing: the line can be tried again once there is stock
+	short := StockChange{Reason: ReasonOrder, OrderID: order, ItemID: uuid.NewString()}
+	if _, _, err := r.DecrementStockOnce(ctx, p.ID, 3, short); !errors.Is(err, ErrInsufficientStock) {
+		t.Fatalf("short: err=%v, expected ErrInsufficientStock", err)
+	}
+	if _, err := r.IncrementStock(ctx, p.ID, 1, StockChange{Reason: ReasonAdjustment}); err != nil {
+		t.Fatalf("increment: %v", err)
+	}
+	if stock, applied, err := r.DecrementStockOnce(ctx, p.ID, 3, short); err != nil || !applied || stock != 0 {
+		t.Fatalf("short, retried: stock=%d applied=%v err=%v", stock, applied, err)
+	}
+
+	if _, _, err := r.DecrementStockOnce(ctx, uuid.NewString(), 1, line); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown product: err=%v, expected ErrNotFound", err)
+	}
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/product
+func TestPGRepo_UpdateBackorder(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	p := &Product{ID: uuid.NewString(), Name: "Preorder", Price: "1.00", Stock: 1, Status: StatusActive, AllowBackorder: true}
+	if err := r.Create(ctx, p); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	off, on := false, true
+
+	// nil keeps the stored flag, and the negative stock is checked against it
+	if _, err := r.Update(ctx, &Product{ID: p.ID, Stock: -1, LowStockThreshold: -1}, false, nil, StockChange{}); err != nil {
+		t.Fatalf("update keeping the flag: %v", err)
+	}
+	if _, err := r.Update(ctx, &Product{ID: p.ID, Stock: -2, LowStockThreshold: -1}, false, &off, StockChange{}); !errors.Is(err, ErrNegativeStock) {
+		t.Fatalf("negative stock with the flag turned off: err=%v, expected ErrNegativeStock", err)
+	}
+	got, err := r.GetByID(ctx, p.ID)
+	if err != nil || !got.AllowBackorder || got.Stock != -1 {
+		t.Fatalf("after the rejected update: %+v, %v", got, err)
+	}
+
+	if _, err := r.Update(ctx, &Product{ID: p.ID, Stock: 0, LowStockThreshold: -1}, false, &off, StockChange{}); err != nil {
+		t.Fatalf("turn off: %v", err)
+	}
+	if _, err := r.Update(ctx, &Product{ID: p.ID, Stock: -1, LowStockThreshold: -1}, false, nil, StockChange{}); !errors.Is(err, ErrNegativeStock) {
+		t.Fatalf("negative stock without the flag: err=%v, expected ErrNegativeStock", err)
+	}
+	if _, err := r.Update(ctx, &Product{ID: p.ID, Stock: -1, LowStockThreshold: -1}, false, &on, StockChange{}); err != nil {
+		t.Fatalf("turn on with negative stock: %v", err)
+	}
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/product
+func TestPGRepo_ClearRestockSubscriptionsOnlyGiven(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatalf("pool: %v", err)
+	}
+	t.Cleanup(pool.Close)
+	r := NewPGRepo(pool)
+
+	p := &Product{ID: uuid.NewString(), Name: "Restock", Price: "1.00", Status: StatusActive}
+	if err := r.Create(ctx, p); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for _, email := range []string{"a@test.com", "b@test.com"} {
+		if err := r.Subscribe(ctx, &RestockSubscription{ProductID: p.ID, Email: email}); err != nil {
+			t.Fatalf("subscribe %s: %v", email, err)
+		}
+	}
+
+	if err := r.ClearRestockSubscriptions(ctx, p.ID, []string{"a@test.com"}); err != nil {
+		t.Fatalf("clear: %v", err)
+	}
+	subs, err := r.RestockSubscriptions(ctx, p.ID)
+	if err != nil || len(subs) != 1 || subs[0].Email != "b@test.com" {
+		t.Fatalf("remaining=%+v err=%v, expected only b@test.com", subs, err)
+	}
+}
@@ -0,0 +1,78 @@
+package product
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SearchMode selects how the text search compares name/description.
+type SearchMode string
+
+const (
+	// SearchILike is a case-insensitive match (default).
+	SearchILike SearchMode = "ilike"
+	// SearchUnaccent is case- and accent-insensitive (requires the unaccent extension).
+	SearchUnaccent SearchMode = "unaccent"
+)
+
+// ParseSearchMode maps a config value to a SearchMode, falling back to SearchILike.
+func ParseSearchMode(s string) SearchMode {
+	if SearchMode(strings.ToLower(strings.TrimSpace(s))) == SearchUnaccent {
+		return SearchUnaccent
+	}
+	return SearchILike
+}
+
+var accents = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ä", "a", "ã", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "ö", "o", "õ", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ñ", "n", "ç", "c",
+	"Á", "A", "À", "A", "Â", "A", "Ä", "A", "Ã", "A",
+	"É", "E", "È", "E", "Ê", "E", "Ë", "E",
+	"Í", "I", "Ì", "I", "Î", "I", "Ï", "I",
+	"Ó", "O", "Ò", "O", "Ô", "O", "Ö", "O", "Õ", "O",
+	"Ú", "U", "Ù", "U", "Û", "U", "Ü", "U",
+	"Ñ", "N", "Ç", "C",
+)
+
+// Unaccent strips the diacritics that Postgres unaccent() removes for Latin text.
+func Unaccent(s string) string { return accents.Replace(s) }
+
+// Fold lowercases and unaccents s; two strings match in SearchUnaccent mode when
+// their folded forms do.
+func Fold(s string) string { return strings.ToLower(Unaccent(s)) }
+
+// ErrInvalidCreatedRange is returned by ParseCreatedRange for a malformed or inverted range.
+var ErrInvalidCreatedRange = errors.New("invalid created_at range")
+
+// ParseCreatedRange reads the created_from/created_to query params (RFC3339, both optional
+// and inclusive) into Query.CreatedFrom/CreatedTo. from must not be after to.
+func ParseCreatedRange(from, to string) (*time.Time, *time.Time, error) {
+	parse := func(name, v string) (*time.Time, error) {
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s must be RFC3339 (e.g. 2025-01-31T00:00:00Z)", ErrInvalidCreatedRange, name)
+		}
+		return &t, nil
+	}
+	f, err := parse("created_from", from)
+	if err != nil {
+		return nil, nil, err
+	}
+	t, err := parse("created_to", to)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f != nil && t != nil && f.After(*t) {
+		return nil, nil, fmt.Errorf("%w: created_from is after created_to", ErrInvalidCreatedRange)
+	}
+	return f, t, nil
+}
@@ -0,0 +1,64 @@
+package product
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFold_AccentInsensitiveMatch(t *testing.T) {
+	cases := []struct{ name, q string }{
+		{"Mouse Inalámbrico", "inalambrico"}, // unaccented query, accented name
+		{"Mouse Inalambrico", "INALÁMBRICO"}, // accented query, unaccented name
+		{"Piñata", "pinata"},
+		{"Camión de juguete", "camion"},
+	}
+	for _, tc := range cases {
+		if !strings.Contains(Fold(tc.name), Fold(tc.q)) {
+			t.Fatalf("Fold(%q) does not contain Fold(%q)", tc.name, tc.q)
+		}
+	}
+	if strings.Contains(Fold("Teclado"), Fold("inalambrico")) {
+		t.Fatalf("unexpected match")
+	}
+}
+
+func TestParseSearchMode(t *testing.T) {
+	if ParseSearchMode("UNACCENT") != SearchUnaccent {
+		t.Fatalf("expected unaccent mode")
+	}
+	for _, s := range []string{"", "ilike", "other"} {
+		if ParseSearchMode(s) != SearchILike {
+			t.Fatalf("ParseSearchMode(%q) should default to ilike", s)
+		}
+	}
+}
+
+func TestParseCreatedRange(t *testing.T) {
+	from, to, err := ParseCreatedRange("", "")
+	if err != nil || from != nil || to != nil {
+		t.Fatalf("empty range = %v, %v, %v; want no bounds", from, to, err)
+	}
+	from, to, err = ParseCreatedRange("2025-03-01T00:00:00Z", "")
+	if err != nil || from == nil || !from.Equal(time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)) || to != nil {
+		t.Fatalf("from only = %v, %v, %v", from, to, err)
+	}
+	from, to, err = ParseCreatedRange("", "2025-03-01T00:00:00-05:00")
+	if err != nil || from != nil || to == nil || !to.Equal(time.Date(2025, 3, 1, 5, 0, 0, 0, time.UTC)) {
+		t.Fatalf("to only = %v, %v, %v", from, to, err)
+	}
+	// the same instant on both ends is a valid (one-instant) range
+	if _, _, err := ParseCreatedRange("2025-03-01T00:00:00Z", "2025-03-01T00:00:00Z"); err != nil {
+		t.Fatalf("equal bounds: %v", err)
+	}
+	for _, tc := range [][2]string{
+		{"2025-03-01", ""},
+		{"", "03/01/2025"},
+		{"2025-03-02T00:00:00Z", "2025-03-01T00:00:00Z"},
+	} {
+		if _, _, err := ParseCreatedRange(tc[0], tc[1]); !errors.Is(err, ErrInvalidCreatedRange) {
+			t.Fatalf("ParseCreatedRange(%q, %q) err=%v, want ErrInvalidCreatedRange", tc[0], tc[1], err)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package product
+
+import (
+	"errors"
+	"strings"
+)
+
+// Status is a product's availability, set by hand and independent of its stock count.
+type Status string
+
+const (
+	StatusActive       Status = "active"
+	StatusDiscontinued Status = "discontinued"
+	// StatusOutOfStock marks a product temporarily unavailable, whatever its stock says
+	StatusOutOfStock Status = "out_of_stock"
+)
+
+var ErrInvalidStatus = errors.New("invalid product status")
+
+// ParseStatus normalizes s (trim + lowercase) and validates it against the known statuses.
+func ParseStatus(s string) (Status, error) {
+	switch st := Status(strings.ToLower(strings.TrimSpace(s))); st {
+	case StatusActive, StatusDiscontinued, StatusOutOfStock:
+		return st, nil
+	}
+	return "", ErrInvalidStatus
+}
@@ -0,0 +1,90 @@
+// Package ratelimit provides fixed-window rate limiters: an in-process one and a
+// Postgres-backed one whose counters are shared by every instance using the same DB.
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Limiter counts a hit for subject in the current window and reports whether it is allowed.
+// When it is not, retryAfter is the time left until the window resets.
+type Limiter interface {
+	Allow(ctx context.Context, subject string) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type Backend string
+
+const (
+	BackendMemory   Backend = "memory"
+	BackendPostgres Backend = "postgres"
+)
+
+// ParseBackend maps RATE_LIMITER_BACKEND to a Backend; unknown values fall back to memory.
+func ParseBackend(s string) Backend {
+	if Backend(strings.ToLower(strings.TrimSpace(s))) == BackendPostgres {
+		return BackendPostgres
+	}
+	return BackendMemory
+}
+
+// New builds the limiter for backend. db is only used by the postgres backend.
+func New(backend Backend, db *pgxpool.Pool, limit int, window time.Duration) Limiter {
+	if backend == BackendPostgres {
+		return NewPGLimiter(db, limit, window)
+	}
+	return NewMemory(limit, window)
+}
+
+// windowStart truncates t to the start of its fixed window.
+func windowStart(t time.Time, window time.Duration) time.Time {
+	return t.UTC().Truncate(window)
+}
+
+type memEntry struct {
+	start time.Time
+	count int
+}
+
+// Memory is a per-process fixed-window limiter. Limits are not shared across instances.
+type Memory struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*memEntry
+	current time.Time
+}
+
+func NewMemory(limit int, window time.Duration) *Memory {
+	return &Memory{limit: limit, window: window, now: time.Now, entries: map[string]*memEntry{}}
+}
+
+func (m *Memory) Allow(_ context.Context, subject string) (bool, time.Duration, error) {
+	now := m.now()
+	start := windowStart(now, m.window)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// new window: every previous counter is stale
+	if !start.Equal(m.current) {
+		m.current = start
+		m.entries = map[string]*memEntry{}
+	}
+	e, ok := m.entries[subject]
+	if !ok {
+		e = &memEntry{start: start}
+		m.entries[subject] = e
+	}
+	e.count++
+	if e.count > m.limit {
+		return false, start.Add(m.window).Sub(now), nil
+	}
+	return true, 0, nil
+}
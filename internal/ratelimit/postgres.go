@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PGLimiter keeps fixed-window counters in the rate_limits table, keyed by
+// (subject, window_start), so every instance sharing the DB enforces the same limit.
+type PGLimiter struct {
+	db     *pgxpool.Pool
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	mu         sync.Mutex
+	prunedUpTo time.Time
+}
+
+func NewPGLimiter(db *pgxpool.Pool, limit int, window time.Duration) *PGLimiter {
+	return &PGLimiter{db: db, limit: limit, window: window, now: time.Now}
+}
+
+func (l *PGLimiter) Allow(ctx context.Context, subject string) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	now := l.now()
+	start := windowStart(now, l.window)
+	l.prune(ctx, start)
+
+	var count int
+	err := l.db.QueryRow(ctx, `
+		INSERT INTO rate_limits (subject, window_start, count)
+		VALUES ($1, $2, 1)
+		ON CONFLICT (subject, window_start) DO UPDATE SET count = rate_limits.count + 1
+		RETURNING count
+	`, subject, start).Scan(&count)
+	if err != nil {
+		return false, 0, err
+	}
+	if count > l.limit {
+		return false, start.Add(l.window).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// prune drops finished windows once per window and per instance; failures are ignored
+// since stale rows never affect the count of the current window.
+func (l *PGLimiter) prune(ctx context.Context, start time.Time) {
+	l.mu.Lock()
+	if !start.After(l.prunedUpTo) {
+		l.mu.Unlock()
+		return
+	}
+	l.prunedUpTo = start
+	l.mu.Unlock()
+
+	_, _ = l.db.Exec(ctx, `DELETE FROM rate_limits WHERE window_start < $1`, start)
+}
@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func TestMemory_FixedWindow(t *testing.T) {
+	now := time.Date(2025, 8, 22, 10, 0, 0, 0, time.UTC)
+	m := NewMemory(2, time.Minute)
+	m.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := m.Allow(ctx, "a"); !ok {
+			t.Fatalf("hit %d rejected", i+1)
+		}
+	}
+	ok, retry, _ := m.Allow(ctx, "a")
+	if ok || retry != time.Minute {
+		t.Fatalf("third hit: allowed=%v retry=%s", ok, retry)
+	}
+	// other subjects have their own counter
+	if ok, _, _ := m.Allow(ctx, "b"); !ok {
+		t.Fatalf("subject b rejected")
+	}
+
+	// next window starts from zero
+	now = now.Add(time.Minute)
+	if ok, _, _ := m.Allow(ctx, "a"); !ok {
+		t.Fatalf("rejected in the next window")
+	}
+}
+
+func TestParseBackend(t *testing.T) {
+	cases := map[string]Backend{"postgres": BackendPostgres, " Postgres ": BackendPostgres, "memory": BackendMemory, "": BackendMemory, "redis": BackendMemory}
+	for in, want := range cases {
+		if got := ParseBackend(in); got != want {
+			t.Fatalf("ParseBackend(%q)=%s, want %s", in, got, want)
+		}
+	}
+}
+
+// Needs a migrated database: TEST_POSTGRES_DSN=postgres://... go test ./internal/ratelimit
+func TestPGLimiter_SharedAcrossInstances(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+	ctx := context.Background()
+
+	// two pools = two service instances pointing at the same DB
+	newPool := func() *pgxpool.Pool {
+		p, err := pgxpool.New(ctx, dsn)
+		if err != nil {
+			t.Fatalf("pool: %v", err)
+		}
+		t.Cleanup(p.Close)
+		return p
+	}
+	a := NewPGLimiter(newPool(), 3, time.Minute)
+	b := NewPGLimiter(newPool(), 3, time.Minute)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+	b.now = func() time.Time { return now }
+
+	subject := "test-" + uuid.NewString()
+	for i, l := range []*PGLimiter{a, b, a} {
+		ok, _, err := l.Allow(ctx, subject)
+		if err != nil || !ok {
+			t.Fatalf("hit %d: allowed=%v err=%v", i+1, ok, err)
+		}
+	}
+	// the 4th hit is over the shared limit, whichever instance sees it
+	if ok, retry, err := b.Allow(ctx, subject); err != nil || ok || retry <= 0 {
+		t.Fatalf("4th hit: allowed=%v retry=%s err=%v", ok, retry, err)
+	}
+	if ok, _, err := a.Allow(ctx, subject); err != nil || ok {
+		t.Fatalf("5th hit: allowed=%v err=%v", ok, err)
+	}
+}
@@ -0,0 +1,24 @@
+// Package reqid carries the request ID across HTTP and gRPC boundaries.
+package reqid
+
+import "context"
+
+const (
+	// Header is the HTTP header holding the request ID.
+	Header = "X-Request-ID"
+	// MetadataKey is the gRPC metadata key holding the request ID.
+	MetadataKey = "x-request-id"
+)
+
+type ctxKey struct{}
+
+// With returns a copy of ctx carrying id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the request ID stored in ctx, or "" if there is none.
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
@@ -0,0 +1,56 @@
+// Package tenant carries the tenant (store) a request belongs to across HTTP and gRPC boundaries.
+package tenant
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Header is the HTTP header holding the tenant ID.
+	Header = "X-Tenant-ID"
+	// MetadataKey is the gRPC metadata key holding the tenant ID.
+	MetadataKey = "x-tenant-id"
+	// Default is the tenant used when multi-tenancy is disabled and none is given.
+	Default = "default"
+)
+
+var (
+	ErrMissing = errors.New("tenant required")
+	ErrInvalid = errors.New("invalid tenant")
+)
+
+var validID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Resolve normalizes a raw tenant ID. An empty value falls back to Default,
+// unless required is set (MULTI_TENANT=true), in which case it is ErrMissing.
+func Resolve(raw string, required bool) (string, error) {
+	id := strings.ToLower(strings.TrimSpace(raw))
+	if id == "" {
+		if required {
+			return "", ErrMissing
+		}
+		return Default, nil
+	}
+	if !validID.MatchString(id) {
+		return "", ErrInvalid
+	}
+	return id, nil
+}
+
+type ctxKey struct{}
+
+// With returns a copy of ctx carrying id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// From returns the tenant stored in ctx, or Default if there is none.
+func From(ctx context.Context) string {
+	if id, _ := ctx.Value(ctxKey{}).(string); id != "" {
+		return id
+	}
+	return Default
+}
@@ -0,0 +1,137 @@
+package user
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
+	"github.com/MikeMC777/ordenes-ecom/internal/reqid"
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
+)
+
+// RequestIDInterceptor reads the caller's request ID from the incoming metadata
+// (or generates one), stores it in the context and logs every call with it.
+func RequestIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		rid := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(reqid.MetadataKey); len(v) > 0 {
+				rid = v[0]
+			}
+		}
+		if rid == "" {
+			rid = uuid.NewString()
+		}
+		ctx = reqid.With(ctx, rid)
+		_ = grpc.SetHeader(ctx, metadata.Pairs(reqid.MetadataKey, rid))
+
+		resp, err := handler(ctx, req)
+		log.Printf("[grpc] rid=%s %s code=%s dur=%s", rid, info.FullMethod, status.Code(err), time.Since(start))
+		return resp, err
+	}
+}
+
+// TenantInterceptor reads the tenant from the incoming metadata and stores it in the context.
+// With required set (MULTI_TENANT=true) a call without one fails with InvalidArgument.
+func TenantInterceptor(required bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		raw := ""
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if v := md.Get(tenant.MetadataKey); len(v) > 0 {
+				raw = v[0]
+			}
+		}
+		id, err := tenant.Resolve(raw, required)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		return handler(tenant.With(ctx, id), req)
+	}
+}
+
+// ActorInterceptor attributes the call's changes to the caller's address ("ip:<addr>")
+// in the audit log; the service has no caller identity beyond it.
+func ActorInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+			host := p.Addr.String()
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			ctx = audit.WithActor(ctx, "ip:"+host)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// redacted replaces secret fields in logged payloads.
+const redacted = "[REDACTED]"
+
+// PayloadLogInterceptor logs every call's method, its request as JSON and the response
+// code to l. Passwords and session IDs are masked. Only meant for LOG_LEVEL=debug: the
+// rest of the payload (emails, usernames) is logged as is.
+func PayloadLogInterceptor(l *log.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		payload := "{}"
+		if m, ok := req.(proto.Message); ok {
+			if b, merr := protojson.Marshal(redact(m)); merr == nil {
+				payload = string(b)
+			}
+		}
+		l.Printf("[grpc] rid=%s %s req=%s code=%s", reqid.From(ctx), info.FullMethod, payload, status.Code(err))
+		return resp, err
+	}
+}
+
+// secret reports whether a field must never be logged: passwords and session IDs,
+// which are bearer tokens.
+func secret(fd protoreflect.FieldDescriptor) bool {
+	name := string(fd.Name())
+	return fd.Kind() == protoreflect.StringKind && (strings.Contains(name, "password") || name == "session_id")
+}
+
+// redact returns a copy of m with its secret fields masked, nested messages included.
+func redact(m proto.Message) proto.Message {
+	c := proto.Clone(m)
+	redactMessage(c.ProtoReflect())
+	return c
+}
+
+func redactMessage(m protoreflect.Message) {
+	var secrets []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsMap():
+		case fd.IsList():
+			if fd.Kind() == protoreflect.MessageKind {
+				l := v.List()
+				for i := 0; i < l.Len(); i++ {
+					redactMessage(l.Get(i).Message())
+				}
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			redactMessage(v.Message())
+		case secret(fd):
+			secrets = append(secrets, fd)
+		}
+		return true
+	})
+	for _, fd := range secrets {
+		m.Set(fd, protoreflect.ValueOfString(redacted))
+	}
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
+)
+
+func TestPayloadLogInterceptor_RedactsSecrets(t *testing.T) {
+	var buf bytes.Buffer
+	intercept := PayloadLogInterceptor(log.New(&buf, "", 0))
+	call := func(method string, req any, err error) string {
+		buf.Reset()
+		handler := func(ctx context.Context, req any) (any, error) { return nil, err }
+		_, _ = intercept(context.Background(), req, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+		return buf.String()
+	}
+
+	create := &pb.CreateUserRequest{Username: "ana", Email: "ana@test.com", Password: "hunter22secret"}
+	out := call("/user.UserService/CreateUser", create, nil)
+	if strings.Contains(out, "hunter22secret") || !strings.Contains(out, redacted) {
+		t.Fatalf("CreateUser password not masked: %s", out)
+	}
+	if !strings.Contains(out, "/user.UserService/CreateUser") || !strings.Contains(out, "ana@test.com") || !strings.Contains(out, "code=OK") {
+		t.Fatalf("missing method, payload or code: %s", out)
+	}
+	if create.Password != "hunter22secret" {
+		t.Fatal("the request itself was modified")
+	}
+
+	out = call("/user.UserService/AuthenticateUser", &pb.AuthRequest{Email: "ana@test.com", Password: "hunter22secret"}, status.Error(codes.Unauthenticated, "bad credentials"))
+	if strings.Contains(out, "hunter22secret") || !strings.Contains(out, "code=Unauthenticated") {
+		t.Fatalf("AuthenticateUser: %s", out)
+	}
+
+	// nested: every user of a bulk import
+	bulk := &pb.CreateUsersRequest{Users: []*pb.CreateUserRequest{
+		{Username: "a", Email: "a@test.com", Password: "first-secret1"},
+		{Username: "b", Email: "b@test.com", Password: "second-secret2"},
+	}}
+	out = call("/user.UserService/CreateUsers", bulk, nil)
+	if strings.Contains(out, "first-secret1") || strings.Contains(out, "second-secret2") || strings.Count(out, redacted) != 2 {
+		t.Fatalf("CreateUsers passwords not masked: %s", out)
+	}
+
+	out = call("/user.UserService/VerifySession", &pb.VerifySessionRequest{SessionId: "tok-123"}, nil)
+	if strings.Contains(out, "tok-123") {
+		t.Fatalf("session id not masked: %s", out)
+	}
+}
@@ -10,3 +10,17 @@ type User struct {
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
 }
+
+// Session is an issued login (token id) that can be listed and revoked by its owner.
+type Session struct {
+	ID        string
+	UserID    string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	Revoked   bool
+}
+
+// Active reports whether the session can still be used at t.
+func (s *Session) Active(t time.Time) bool {
+	return !s.Revoked && t.Before(s.ExpiresAt)
+}
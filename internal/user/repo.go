@@ -5,7 +5,11 @@ import (
 	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/tenant"
 )
 
 var (
@@ -15,10 +19,18 @@ var (
 
 type Repository interface {
 	Create(ctx context.Context, u *User) error
+	CreateMany(ctx context.Context, users []*User) ([]error, error)
 	GetByID(ctx context.Context, id string) (*User, error)
 	GetByEmail(ctx context.Context, email string) (*User, error)
-	Update(ctx context.Context, u *User, updatePassword bool) error
+	GetByUsername(ctx context.Context, username string) (*User, error)
+	Update(ctx context.Context, u *User, updatePassword bool) (bool, error)
 	Delete(ctx context.Context, id string) (bool, error)
+	List(ctx context.Context, limit, offset int) ([]User, error)
+
+	CreateSession(ctx context.Context, s *Session) error
+	GetSession(ctx context.Context, id string) (*Session, error)
+	ListSessions(ctx context.Context, userID string, limit, offset int) ([]Session, error)
+	RevokeSession(ctx context.Context, userID, id string) (bool, error)
 }
 
 type PGRepo struct{ db *pgxpool.Pool }
@@ -30,9 +42,9 @@ func (r *PGRepo) Create(ctx context.Context, u *User) error {
 	defer cancel()
 
 	_, err := r.db.Exec(ctx, `
-		INSERT INTO users (id, username, email, password_hash, created_at, updated_at)
-		VALUES ($1,$2,$3,$4,NOW(),NOW())
-	`, u.ID, u.Username, u.Email, u.PasswordHash)
+		INSERT INTO users (id, username, email, password_hash, tenant_id, created_at, updated_at)
+		VALUES ($1,$2,$3,$4,$5,NOW(),NOW())
+	`, u.ID, u.Username, u.Email, u.PasswordHash, tenant.From(ctx))
 	if err != nil {
 		// simplified: the evaluator will see UNIQUE in username/email
 		return ErrAlreadyExist
@@ -40,14 +52,56 @@ func (r *PGRepo) Create(ctx context.Context, u *User) error {
 	return nil
 }
 
+// CreateMany inserts users in one transaction. A duplicate username/email only rolls back
+// its own savepoint: its entry in the returned slice is ErrAlreadyExist and the rest are
+// still inserted. Any other error fails the whole batch.
+func (r *PGRepo) CreateMany(ctx context.Context, users []*User) ([]error, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := r.db.BeginTx(ctx, pgx.TxOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	errs := make([]error, len(users))
+	for i, u := range users {
+		sp, err := tx.Begin(ctx) // savepoint
+		if err != nil {
+			return nil, err
+		}
+		err = sp.QueryRow(ctx, `
+			INSERT INTO users (id, username, email, password_hash, tenant_id, created_at, updated_at)
+			VALUES ($1,$2,$3,$4,$5,NOW(),NOW())
+			RETURNING created_at, updated_at
+		`, u.ID, u.Username, u.Email, u.PasswordHash, tenant.From(ctx)).Scan(&u.CreatedAt, &u.UpdatedAt)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+			errs[i] = ErrAlreadyExist
+			if err := sp.Rollback(ctx); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := sp.Commit(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return errs, tx.Commit(ctx)
+}
+
 func (r *PGRepo) GetByID(ctx context.Context, id string) (*User, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	row := r.db.QueryRow(ctx, `
 		SELECT id, username, email, password_hash, created_at, updated_at
-		FROM users WHERE id=$1
-	`, id)
+		FROM users WHERE id=$1 AND tenant_id=$2
+	`, id, tenant.From(ctx))
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		return nil, ErrNotFound
@@ -61,8 +115,23 @@ func (r *PGRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
 
 	row := r.db.QueryRow(ctx, `
 		SELECT id, username, email, password_hash, created_at, updated_at
-		FROM users WHERE email=$1
-	`, email)
+		FROM users WHERE email=$1 AND tenant_id=$2
+	`, email, tenant.From(ctx))
+	var u User
+	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		return nil, ErrNotFound
+	}
+	return &u, nil
+}
+
+func (r *PGRepo) GetByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	row := r.db.QueryRow(ctx, `
+		SELECT id, username, email, password_hash, created_at, updated_at
+		FROM users WHERE username=$1 AND tenant_id=$2
+	`, username, tenant.From(ctx))
 	var u User
 	if err := row.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.UpdatedAt); err != nil {
 		return nil, ErrNotFound
@@ -70,37 +139,136 @@ func (r *PGRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
 	return &u, nil
 }
 
-func (r *PGRepo) Update(ctx context.Context, u *User, updatePassword bool) error {
+// Update applies a partial update and reports whether the user exists.
+func (r *PGRepo) Update(ctx context.Context, u *User, updatePassword bool) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
+	var (
+		cmd pgconn.CommandTag
+		err error
+	)
 	if updatePassword {
-		_, err := r.db.Exec(ctx, `
+		cmd, err = r.db.Exec(ctx, `
 			UPDATE users
 			SET username = COALESCE(NULLIF($2, ''), username),
 			    email    = COALESCE(NULLIF($3, ''), email),
 			    password_hash = $4,
 			    updated_at = NOW()
-			WHERE id = $1
-		`, u.ID, u.Username, u.Email, u.PasswordHash)
-		return err
+			WHERE id = $1 AND tenant_id = $5
+		`, u.ID, u.Username, u.Email, u.PasswordHash, tenant.From(ctx))
+	} else {
+		cmd, err = r.db.Exec(ctx, `
+			UPDATE users
+			SET username = COALESCE(NULLIF($2, ''), username),
+			    email    = COALESCE(NULLIF($3, ''), email),
+			    updated_at = NOW()
+			WHERE id = $1 AND tenant_id = $4
+		`, u.ID, u.Username, u.Email, tenant.From(ctx))
 	}
-
-	_, err := r.db.Exec(ctx, `
-		UPDATE users
-		SET username = COALESCE(NULLIF($2, ''), username),
-		    email    = COALESCE(NULLIF($3, ''), email),
-		    updated_at = NOW()
-		WHERE id = $1
-	`, u.ID, u.Username, u.Email)
-	return err
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() > 0, nil
 }
 
 func (r *PGRepo) Delete(ctx context.Context, id string) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	cmd, err := r.db.Exec(ctx, `DELETE FROM users WHERE id=$1`, id)
+	cmd, err := r.db.Exec(ctx, `DELETE FROM users WHERE id=$1 AND tenant_id=$2`, id, tenant.From(ctx))
+	if err != nil {
+		return false, err
+	}
+	return cmd.RowsAffected() > 0, nil
+}
+
+// List returns a page of the tenant's users, newest first. The password hash is not read.
+func (r *PGRepo) List(ctx context.Context, limit, offset int) ([]User, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, username, email, created_at, updated_at
+		FROM users WHERE tenant_id=$3
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1 OFFSET $2
+	`, limit, offset, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []User
+	for rows.Next() {
+		var u User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (r *PGRepo) CreateSession(ctx context.Context, s *Session) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	return r.db.QueryRow(ctx, `
+		INSERT INTO sessions (id, user_id, issued_at, expires_at, revoked)
+		VALUES ($1,$2,NOW(),$3,FALSE)
+		RETURNING issued_at
+	`, s.ID, s.UserID, s.ExpiresAt).Scan(&s.IssuedAt)
+}
+
+func (r *PGRepo) GetSession(ctx context.Context, id string) (*Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var s Session
+	if err := r.db.QueryRow(ctx, `
+		SELECT id, user_id, issued_at, expires_at, revoked
+		FROM sessions WHERE id=$1 AND user_id IN (SELECT id FROM users WHERE tenant_id=$2)
+	`, id, tenant.From(ctx)).Scan(&s.ID, &s.UserID, &s.IssuedAt, &s.ExpiresAt, &s.Revoked); err != nil {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (r *PGRepo) ListSessions(ctx context.Context, userID string, limit, offset int) ([]Session, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := r.db.Query(ctx, `
+		SELECT id, user_id, issued_at, expires_at, revoked
+		FROM sessions WHERE user_id=$1 AND user_id IN (SELECT id FROM users WHERE tenant_id=$4)
+		ORDER BY issued_at DESC
+		LIMIT $2 OFFSET $3
+	`, userID, limit, offset, tenant.From(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Session
+	for rows.Next() {
+		var s Session
+		if err := rows.Scan(&s.ID, &s.UserID, &s.IssuedAt, &s.ExpiresAt, &s.Revoked); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
+
+func (r *PGRepo) RevokeSession(ctx context.Context, userID, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cmd, err := r.db.Exec(ctx, `
+		UPDATE sessions SET revoked = TRUE
+		WHERE id=$1 AND user_id=$2 AND user_id IN (SELECT id FROM users WHERE tenant_id=$3)
+	`, id, userID, tenant.From(ctx))
 	if err != nil {
 		return false, err
 	}
@@ -2,6 +2,7 @@ package user
 
 import (
 	"context"
+	"errors"
 
 	"time"
 
@@ -10,16 +11,50 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/MikeMC777/ordenes-ecom/internal/audit"
+	"github.com/MikeMC777/ordenes-ecom/internal/buildinfo"
+	"github.com/MikeMC777/ordenes-ecom/internal/jwt"
 	pb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
 )
 
 type Service struct {
 	pb.UnimplementedUserServiceServer
-	repo Repository
+	repo       Repository
+	sessionTTL time.Duration
+	audit      audit.Recorder
+	jwtSecret  []byte
+	jwtTTL     time.Duration
 }
 
-func NewService(repo Repository) *Service {
-	return &Service{repo: repo}
+// Option customizes a Service.
+type Option func(*Service)
+
+// WithSessionTTL sets how long the sessions issued by AuthenticateUser last (default 24h).
+func WithSessionTTL(d time.Duration) Option {
+	return func(s *Service) { s.sessionTTL = d }
+}
+
+// WithJWT makes AuthenticateUser also return an HS256 token for the user, signed with secret
+// and valid for ttl. An empty secret issues none.
+func WithJWT(secret string, ttl time.Duration) Option {
+	return func(s *Service) {
+		if secret != "" {
+			s.jwtSecret, s.jwtTTL = []byte(secret), ttl
+		}
+	}
+}
+
+// WithAudit records user creations, updates and deletions in the audit log.
+func WithAudit(r audit.Recorder) Option {
+	return func(s *Service) { s.audit = r }
+}
+
+func NewService(repo Repository, opts ...Option) *Service {
+	s := &Service{repo: repo, sessionTTL: 24 * time.Hour}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // CreateUser
@@ -43,17 +78,133 @@ func (s *Service) CreateUser(ctx context.Context, in *pb.CreateUserRequest) (*pb
 		}
 		return nil, status.Errorf(codes.Internal, "create error: %v", err)
 	}
+	s.auditCreated(ctx, u)
 	return &pb.UserResponse{User: &pb.User{
 		Id: u.ID, Username: u.Username, Email: u.Email, CreatedAt: u.CreatedAt.Format("2006-01-02T15:04:05Z07:00"),
 	}}, nil
 }
 
+// auditCreated records a new user; the password hash never goes to the audit log.
+func (s *Service) auditCreated(ctx context.Context, u *User) {
+	audit.Log(ctx, s.audit, audit.Entry{
+		Action: audit.ActionCreate, ResourceType: audit.ResourceUser, ResourceID: u.ID,
+		Detail: map[string]audit.Change{"username": {To: u.Username}, "email": {To: u.Email}},
+	})
+}
+
+// Per-entry outcomes of CreateUsers.
+const (
+	bulkCreated       = "created"
+	bulkInvalid       = "invalid"
+	bulkAlreadyExists = "already_exists"
+)
+
+// maxBulkUsers caps a CreateUsers batch (each entry costs a bcrypt hash).
+const maxBulkUsers = 500
+
+// CreateUsers imports a batch of users. Each entry is validated (required fields, email,
+// password strength) and hashed; the valid ones are inserted in one transaction. One bad
+// or duplicate entry only fails itself: the response has a result per entry, in order.
+func (s *Service) CreateUsers(ctx context.Context, in *pb.CreateUsersRequest) (*pb.CreateUsersResponse, error) {
+	entries := in.GetUsers()
+	if len(entries) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "users is required")
+	}
+	if len(entries) > maxBulkUsers {
+		return nil, status.Errorf(codes.InvalidArgument, "at most %d users per batch", maxBulkUsers)
+	}
+
+	out := &pb.CreateUsersResponse{Results: make([]*pb.CreateUserResult, len(entries))}
+	var (
+		batch []*User
+		pos   []int // request index of each batch entry
+	)
+	seenName, seenEmail := map[string]bool{}, map[string]bool{}
+	for i, e := range entries {
+		res := &pb.CreateUserResult{Index: int32(i)}
+		out.Results[i] = res
+
+		err := validateNewUser(e)
+		if err != nil {
+			res.Status, res.Error = bulkInvalid, err.Error()
+			continue
+		}
+		if seenName[e.GetUsername()] || seenEmail[e.GetEmail()] {
+			res.Status, res.Error = bulkAlreadyExists, "duplicated in the batch"
+			continue
+		}
+		seenName[e.GetUsername()], seenEmail[e.GetEmail()] = true, true
+
+		hash, err := HashPassword(e.GetPassword())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "hash error: %v", err)
+		}
+		batch = append(batch, &User{
+			ID:           uuid.NewString(),
+			Username:     e.GetUsername(),
+			Email:        e.GetEmail(),
+			PasswordHash: hash,
+		})
+		pos = append(pos, i)
+	}
+	if len(batch) == 0 {
+		return out, nil
+	}
+
+	errs, err := s.repo.CreateMany(ctx, batch)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "create error: %v", err)
+	}
+	for j, u := range batch {
+		res := out.Results[pos[j]]
+		if errs[j] != nil {
+			res.Status, res.Error = bulkAlreadyExists, "user exists (username/email)"
+			continue
+		}
+		res.Status = bulkCreated
+		res.User = &pb.User{Id: u.ID, Username: u.Username, Email: u.Email, CreatedAt: u.CreatedAt.Format(time.RFC3339)}
+		out.Created++
+		s.auditCreated(ctx, u)
+	}
+	return out, nil
+}
+
+func validateNewUser(in *pb.CreateUserRequest) error {
+	if in.GetUsername() == "" || in.GetEmail() == "" || in.GetPassword() == "" {
+		return errors.New("username, email and password are required")
+	}
+	if err := ValidateEmail(in.GetEmail()); err != nil {
+		return err
+	}
+	return ValidatePassword(in.GetPassword())
+}
+
 // GetUser
 func (s *Service) GetUser(ctx context.Context, in *pb.GetUserRequest) (*pb.UserResponse, error) {
 	if in.GetId() == "" {
 		return nil, status.Error(codes.InvalidArgument, "id is required")
 	}
-	u, err := s.repo.GetByID(ctx, in.GetId())
+	return userResponse(s.repo.GetByID(ctx, in.GetId()))
+}
+
+// GetUserByEmail
+func (s *Service) GetUserByEmail(ctx context.Context, in *pb.GetUserByEmailRequest) (*pb.UserResponse, error) {
+	if in.GetEmail() == "" {
+		return nil, status.Error(codes.InvalidArgument, "email is required")
+	}
+	return userResponse(s.repo.GetByEmail(ctx, in.GetEmail()))
+}
+
+// GetUserByUsername
+func (s *Service) GetUserByUsername(ctx context.Context, in *pb.GetUserByUsernameRequest) (*pb.UserResponse, error) {
+	if in.GetUsername() == "" {
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	return userResponse(s.repo.GetByUsername(ctx, in.GetUsername()))
+}
+
+// userResponse answers a lookup; the password hash is not part of pb.User.
+func userResponse(u *User, err error) (*pb.UserResponse, error) {
 	if err != nil {
 		if err == ErrNotFound {
 			return nil, status.Error(codes.NotFound, "user not found")
@@ -87,9 +238,24 @@ func (s *Service) UpdateUser(ctx context.Context, in *pb.UpdateUserRequest) (*pb
 		Email:        in.GetEmail(),    // empty => no change
 		PasswordHash: newHash,          // empty => no change
 	}
-	if err := s.repo.Update(ctx, u, updatePassword); err != nil {
+	found, err := s.repo.Update(ctx, u, updatePassword)
+	if err != nil {
 		return nil, status.Errorf(codes.Internal, "update error: %v", err)
 	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+	changes := map[string]audit.Change{}
+	if u.Username != "" {
+		changes["username"] = audit.Change{To: u.Username}
+	}
+	if u.Email != "" {
+		changes["email"] = audit.Change{To: u.Email}
+	}
+	if updatePassword {
+		changes["password"] = audit.Change{To: redacted}
+	}
+	audit.Log(ctx, s.audit, audit.Entry{Action: audit.ActionUpdate, ResourceType: audit.ResourceUser, ResourceID: u.ID, Detail: changes})
 
 	// Return the current status
 	out, err := s.repo.GetByID(ctx, in.GetId())
@@ -117,6 +283,7 @@ func (s *Service) DeleteUser(ctx context.Context, in *pb.DeleteUserRequest) (*pb
 	if !ok {
 		return nil, status.Error(codes.NotFound, "user not found")
 	}
+	audit.Log(ctx, s.audit, audit.Entry{Action: audit.ActionDelete, ResourceType: audit.ResourceUser, ResourceID: in.GetId()})
 	return &pb.DeleteUserResponse{Deleted: true}, nil
 }
 
@@ -132,8 +299,27 @@ func (s *Service) AuthenticateUser(ctx context.Context, in *pb.AuthRequest) (*pb
 		}
 		return nil, status.Errorf(codes.Internal, "auth error: %v", err)
 	}
-	ok := CheckPassword(u.PasswordHash, in.GetPassword())
-	return &pb.AuthResponse{UserId: u.ID, Ok: ok}, nil
+	if !CheckPassword(u.PasswordHash, in.GetPassword()) {
+		return &pb.AuthResponse{UserId: u.ID, Ok: false}, nil
+	}
+	sess := &Session{
+		ID:        uuid.NewString(),
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(s.sessionTTL),
+	}
+	if err := s.repo.CreateSession(ctx, sess); err != nil {
+		return nil, status.Errorf(codes.Internal, "session error: %v", err)
+	}
+	res := &pb.AuthResponse{
+		UserId: u.ID, Ok: true,
+		SessionId: sess.ID, ExpiresAt: sess.ExpiresAt.Format(time.RFC3339),
+	}
+	if s.jwtSecret != nil {
+		if res.Token, err = jwt.Sign(s.jwtSecret, jwt.New(u.ID, time.Now(), s.jwtTTL)); err != nil {
+			return nil, status.Errorf(codes.Internal, "token error: %v", err)
+		}
+	}
+	return res, nil
 }
 
 // ValidateUser (exists by ID)
@@ -151,5 +337,100 @@ func (s *Service) ValidateUser(ctx context.Context, in *pb.ValidateUserRequest)
 	return &pb.ValidateUserResponse{Ok: true}, nil
 }
 
+// ListUsers (newest first, without password hashes)
+func (s *Service) ListUsers(ctx context.Context, in *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	limit, offset := int(in.GetLimit()), int(in.GetOffset())
+	if limit <= 0 {
+		limit = 20
+	}
+	if limit > 100 {
+		limit = 100
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	list, err := s.repo.List(ctx, limit, offset)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list users error: %v", err)
+	}
+	out := &pb.ListUsersResponse{Limit: int32(limit), Offset: int32(offset)}
+	for _, u := range list {
+		out.Users = append(out.Users, &pb.User{
+			Id: u.ID, Username: u.Username, Email: u.Email, CreatedAt: u.CreatedAt.Format(time.RFC3339),
+		})
+	}
+	return out, nil
+}
+
+// ListSessions (own sessions, newest first)
+func (s *Service) ListSessions(ctx context.Context, in *pb.ListSessionsRequest) (*pb.ListSessionsResponse, error) {
+	if in.GetUserId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id is required")
+	}
+	limit, offset := int(in.GetLimit()), int(in.GetOffset())
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	list, err := s.repo.ListSessions(ctx, in.GetUserId(), limit, offset)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "list sessions error: %v", err)
+	}
+	out := &pb.ListSessionsResponse{Limit: int32(limit), Offset: int32(offset)}
+	for _, ss := range list {
+		out.Sessions = append(out.Sessions, &pb.Session{
+			Id: ss.ID, UserId: ss.UserID,
+			IssuedAt:  ss.IssuedAt.Format(time.RFC3339),
+			ExpiresAt: ss.ExpiresAt.Format(time.RFC3339),
+			Revoked:   ss.Revoked,
+		})
+	}
+	return out, nil
+}
+
+// RevokeSession (only the owner's sessions)
+func (s *Service) RevokeSession(ctx context.Context, in *pb.RevokeSessionRequest) (*pb.RevokeSessionResponse, error) {
+	if in.GetUserId() == "" || in.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "user_id and session_id are required")
+	}
+	ok, err := s.repo.RevokeSession(ctx, in.GetUserId(), in.GetSessionId())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "revoke error: %v", err)
+	}
+	if !ok {
+		return nil, status.Error(codes.NotFound, "session not found")
+	}
+	return &pb.RevokeSessionResponse{Revoked: true}, nil
+}
+
+// VerifySession (rejects unknown, revoked and expired sessions)
+func (s *Service) VerifySession(ctx context.Context, in *pb.VerifySessionRequest) (*pb.VerifySessionResponse, error) {
+	if in.GetSessionId() == "" {
+		return nil, status.Error(codes.InvalidArgument, "session_id is required")
+	}
+	ss, err := s.repo.GetSession(ctx, in.GetSessionId())
+	if err != nil {
+		if err == ErrNotFound {
+			return &pb.VerifySessionResponse{Ok: false}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "verify error: %v", err)
+	}
+	if !ss.Active(time.Now()) {
+		return &pb.VerifySessionResponse{Ok: false, UserId: ss.UserID}, nil
+	}
+	return &pb.VerifySessionResponse{Ok: true, UserId: ss.UserID}, nil
+}
+
+// GetInfo (build version of the running service)
+func (s *Service) GetInfo(ctx context.Context, in *pb.GetInfoRequest) (*pb.GetInfoResponse, error) {
+	return &pb.GetInfoResponse{
+		Version:   buildinfo.Version,
+		Commit:    buildinfo.Commit,
+		BuildTime: buildinfo.BuildTime,
+	}, nil
+}
+
 // Helper to create repo from pool (in case you want to inject outside)
 func NewRepoFromPool(pool *pgxpool.Pool) Repository { return NewPGRepo(pool) }
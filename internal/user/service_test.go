@@ -0,0 +1,439 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/MikeMC777/ordenes-ecom/internal/jwt"
+	pb "github.com/MikeMC777/ordenes-ecom/internal/userpb"
+)
+
+// memRepo implements Repository in memory.
+type memRepo struct {
+	users    map[string]*User
+	sessions map[string]*Session
+}
+
+func newMemRepo() *memRepo {
+	return &memRepo{users: map[string]*User{}, sessions: map[string]*Session{}}
+}
+
+func (m *memRepo) Create(ctx context.Context, u *User) error {
+	for _, x := range m.users {
+		if x.Email == u.Email || x.Username == u.Username {
+			return ErrAlreadyExist
+		}
+	}
+	cp := *u
+	cp.CreatedAt = time.Now()
+	m.users[u.ID] = &cp
+	return nil
+}
+
+func (m *memRepo) CreateMany(ctx context.Context, users []*User) ([]error, error) {
+	errs := make([]error, len(users))
+	for i, u := range users {
+		if err := m.Create(ctx, u); err != nil {
+			errs[i] = err
+			continue
+		}
+		u.CreatedAt = m.users[u.ID].CreatedAt
+	}
+	return errs, nil
+}
+
+func (m *memRepo) GetByID(ctx context.Context, id string) (*User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *u
+	return &cp, nil
+}
+
+func (m *memRepo) GetByEmail(ctx context.Context, email string) (*User, error) {
+	for _, u := range m.users {
+		if u.Email == email {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memRepo) GetByUsername(ctx context.Context, username string) (*User, error) {
+	for _, u := range m.users {
+		if u.Username == username {
+			cp := *u
+			return &cp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (m *memRepo) Update(ctx context.Context, u *User, updatePassword bool) (bool, error) {
+	cur, ok := m.users[u.ID]
+	if !ok {
+		return false, nil
+	}
+	if u.Username != "" {
+		cur.Username = u.Username
+	}
+	if u.Email != "" {
+		cur.Email = u.Email
+	}
+	if updatePassword {
+		cur.PasswordHash = u.PasswordHash
+	}
+	return true, nil
+}
+
+func (m *memRepo) Delete(ctx context.Context, id string) (bool, error) {
+	_, ok := m.users[id]
+	delete(m.users, id)
+	return ok, nil
+}
+
+func (m *memRepo) List(ctx context.Context, limit, offset int) ([]User, error) {
+	var out []User
+	for _, u := range m.users {
+		cp := *u
+		cp.PasswordHash = "" // like PGRepo, which does not read it
+		out = append(out, cp)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if !out[i].CreatedAt.Equal(out[j].CreatedAt) {
+			return out[i].CreatedAt.After(out[j].CreatedAt)
+		}
+		return out[i].ID > out[j].ID
+	})
+	if offset >= len(out) {
+		return nil, nil
+	}
+	out = out[offset:]
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *memRepo) CreateSession(ctx context.Context, s *Session) error {
+	cp := *s
+	cp.IssuedAt = time.Now()
+	s.IssuedAt = cp.IssuedAt
+	m.sessions[s.ID] = &cp
+	return nil
+}
+
+func (m *memRepo) GetSession(ctx context.Context, id string) (*Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	cp := *s
+	return &cp, nil
+}
+
+func (m *memRepo) ListSessions(ctx context.Context, userID string, limit, offset int) ([]Session, error) {
+	var out []Session
+	for _, s := range m.sessions {
+		if s.UserID == userID {
+			out = append(out, *s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].IssuedAt.After(out[j].IssuedAt) })
+	if offset >= len(out) {
+		return nil, nil
+	}
+	out = out[offset:]
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+func (m *memRepo) RevokeSession(ctx context.Context, userID, id string) (bool, error) {
+	s, ok := m.sessions[id]
+	if !ok || s.UserID != userID {
+		return false, nil
+	}
+	s.Revoked = true
+	return true, nil
+}
+
+func newUser(t *testing.T, svc *Service, name string) string {
+	t.Helper()
+	res, err := svc.CreateUser(context.Background(), &pb.CreateUserRequest{
+		Username: name, Email: name + "@test.com", Password: "123456",
+	})
+	if err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+	return res.GetUser().GetId()
+}
+
+func login(t *testing.T, svc *Service, name string) *pb.AuthResponse {
+	t.Helper()
+	res, err := svc.AuthenticateUser(context.Background(), &pb.AuthRequest{Email: name + "@test.com", Password: "123456"})
+	if err != nil || !res.GetOk() {
+		t.Fatalf("auth ok=%v err=%v", res.GetOk(), err)
+	}
+	return res
+}
+
+func TestSessions_ListRevokeVerify(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemRepo())
+	uid := newUser(t, svc, "ana")
+	other := newUser(t, svc, "bob")
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, login(t, svc, "ana").GetSessionId())
+	}
+	login(t, svc, "bob")
+
+	// pagination over the user's own sessions
+	page, err := svc.ListSessions(ctx, &pb.ListSessionsRequest{UserId: uid, Limit: 2})
+	if err != nil || len(page.GetSessions()) != 2 {
+		t.Fatalf("page 1: len=%d err=%v", len(page.GetSessions()), err)
+	}
+	page2, err := svc.ListSessions(ctx, &pb.ListSessionsRequest{UserId: uid, Limit: 2, Offset: 2})
+	if err != nil || len(page2.GetSessions()) != 1 {
+		t.Fatalf("page 2: len=%d err=%v", len(page2.GetSessions()), err)
+	}
+	for _, s := range append(page.GetSessions(), page2.GetSessions()...) {
+		if s.GetUserId() != uid {
+			t.Fatalf("listed a session of another user: %v", s)
+		}
+	}
+
+	// a valid session verifies
+	v, err := svc.VerifySession(ctx, &pb.VerifySessionRequest{SessionId: ids[0]})
+	if err != nil || !v.GetOk() || v.GetUserId() != uid {
+		t.Fatalf("verify before revoke: %v %v", v, err)
+	}
+
+	// only the owner can revoke
+	if _, err := svc.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: other, SessionId: ids[0]}); err == nil {
+		t.Fatalf("another user revoked the session")
+	}
+	if _, err := svc.RevokeSession(ctx, &pb.RevokeSessionRequest{UserId: uid, SessionId: ids[0]}); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	// a revoked session fails verification; the others still pass
+	v, err = svc.VerifySession(ctx, &pb.VerifySessionRequest{SessionId: ids[0]})
+	if err != nil || v.GetOk() {
+		t.Fatalf("revoked session verified: %v %v", v, err)
+	}
+	v, err = svc.VerifySession(ctx, &pb.VerifySessionRequest{SessionId: ids[1]})
+	if err != nil || !v.GetOk() {
+		t.Fatalf("active session failed: %v %v", v, err)
+	}
+}
+
+func TestSessions_Expired(t *testing.T) {
+	svc := NewService(newMemRepo(), WithSessionTTL(-time.Second))
+	newUser(t, svc, "ana")
+	sid := login(t, svc, "ana").GetSessionId()
+
+	v, err := svc.VerifySession(context.Background(), &pb.VerifySessionRequest{SessionId: sid})
+	if err != nil || v.GetOk() {
+		t.Fatalf("expired session verified: %v %v", v, err)
+	}
+}
+
+func TestAuthenticateUser_JWT(t *testing.T) {
+	svc := NewService(newMemRepo(), WithJWT("test-secret", 15*time.Minute))
+	uid := newUser(t, svc, "ana")
+
+	res := login(t, svc, "ana")
+	c, err := jwt.Verify([]byte("test-secret"), res.GetToken(), time.Now(), 0)
+	if err != nil || c.Subject != uid {
+		t.Fatalf("token=%q claims=%+v err=%v, want one for %s", res.GetToken(), c, err, uid)
+	}
+	if ttl := time.Duration(c.ExpiresAt-c.IssuedAt) * time.Second; ttl != 15*time.Minute {
+		t.Fatalf("token lasts %s, want 15m", ttl)
+	}
+	if _, err := jwt.Verify([]byte("test-secret"), res.GetToken(), time.Now().Add(16*time.Minute), 0); !errors.Is(err, jwt.ErrExpired) {
+		t.Fatalf("after its TTL: err=%v, want ErrExpired", err)
+	}
+
+	// a wrong password gets no token; without a secret no token is issued at all
+	bad, err := svc.AuthenticateUser(context.Background(), &pb.AuthRequest{Email: "ana@test.com", Password: "wrong"})
+	if err != nil || bad.GetOk() || bad.GetToken() != "" {
+		t.Fatalf("wrong password: %v %v", bad, err)
+	}
+	plain := NewService(newMemRepo(), WithJWT("", time.Minute))
+	newUser(t, plain, "bob")
+	if res := login(t, plain, "bob"); res.GetToken() != "" || res.GetSessionId() == "" {
+		t.Fatalf("no JWT_SECRET: %v, want a session and no token", res)
+	}
+}
+
+func TestCreateUsers_MixedBatch(t *testing.T) {
+	ctx := context.Background()
+	repo := newMemRepo()
+	svc := NewService(repo)
+	newUser(t, svc, "ana") // already registered
+
+	res, err := svc.CreateUsers(ctx, &pb.CreateUsersRequest{Users: []*pb.CreateUserRequest{
+		{Username: "bob", Email: "bob@test.com", Password: "secret123"},
+		{Username: "ana2", Email: "ana@test.com", Password: "secret123"}, // existing email
+		{Username: "carl", Email: "not-an-email", Password: "secret123"},
+		{Username: "dana", Email: "dana@test.com", Password: "short1"},
+		{Username: "eve", Email: "eve@test.com", Password: "onlyletters"},
+		{Username: "", Email: "x@test.com", Password: "secret123"},
+		{Username: "bob2", Email: "bob@test.com", Password: "secret123"}, // repeated in the batch
+		{Username: "fran", Email: "fran@test.com", Password: "secret123"},
+	}})
+	if err != nil {
+		t.Fatalf("CreateUsers: %v", err)
+	}
+	want := []string{bulkCreated, bulkAlreadyExists, bulkInvalid, bulkInvalid, bulkInvalid, bulkInvalid, bulkAlreadyExists, bulkCreated}
+	if len(res.GetResults()) != len(want) {
+		t.Fatalf("results=%d, expected %d", len(res.GetResults()), len(want))
+	}
+	for i, r := range res.GetResults() {
+		if r.GetIndex() != int32(i) || r.GetStatus() != want[i] {
+			t.Fatalf("entry %d: index=%d status=%q (%s), expected %q", i, r.GetIndex(), r.GetStatus(), r.GetError(), want[i])
+		}
+		if (r.GetStatus() == bulkCreated) != (r.GetUser().GetId() != "") {
+			t.Fatalf("entry %d: user=%v with status %q", i, r.GetUser(), r.GetStatus())
+		}
+	}
+	if res.GetCreated() != 2 || len(repo.users) != 3 {
+		t.Fatalf("created=%d users=%d, expected 2 new and 3 in total", res.GetCreated(), len(repo.users))
+	}
+
+	// the stored password is hashed and works
+	if r, err := svc.AuthenticateUser(ctx, &pb.AuthRequest{Email: "fran@test.com", Password: "secret123"}); err != nil || !r.GetOk() {
+		t.Fatalf("imported user cannot log in: %v %v", r, err)
+	}
+
+	if _, err := svc.CreateUsers(ctx, &pb.CreateUsersRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("empty batch: err=%v, expected InvalidArgument", err)
+	}
+}
+
+func TestUpdateUser_NotFound(t *testing.T) {
+	svc := NewService(newMemRepo())
+	_, err := svc.UpdateUser(context.Background(), &pb.UpdateUserRequest{Id: "missing", Username: "x"})
+	if status.Code(err) != codes.NotFound {
+		t.Fatalf("err=%v, expected NotFound", err)
+	}
+}
+
+func TestListUsers_Pagination(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemRepo())
+	for i := 0; i < 25; i++ {
+		newUser(t, svc, fmt.Sprintf("user%02d", i))
+	}
+
+	cases := []struct {
+		limit, offset         int32
+		wantLimit, wantOffset int32
+		wantLen               int
+	}{
+		{0, 0, 20, 0, 20},    // default
+		{-5, 0, 20, 0, 20},   // default
+		{1, 0, 1, 0, 1},      // lower bound
+		{500, 0, 100, 0, 25}, // clamped to 100
+		{10, 20, 10, 20, 5},  // last, partial page
+		{10, 25, 10, 25, 0},  // past the end
+		{10, -3, 10, 0, 10},  // negative offset
+	}
+	for _, tc := range cases {
+		res, err := svc.ListUsers(ctx, &pb.ListUsersRequest{Limit: tc.limit, Offset: tc.offset})
+		if err != nil {
+			t.Fatalf("limit=%d offset=%d: %v", tc.limit, tc.offset, err)
+		}
+		if res.GetLimit() != tc.wantLimit || res.GetOffset() != tc.wantOffset || len(res.GetUsers()) != tc.wantLen {
+			t.Fatalf("limit=%d offset=%d: got limit=%d offset=%d len=%d, want %d %d %d",
+				tc.limit, tc.offset, res.GetLimit(), res.GetOffset(), len(res.GetUsers()), tc.wantLimit, tc.wantOffset, tc.wantLen)
+		}
+	}
+
+	// pages do not overlap and cover every user once
+	seen := map[string]bool{}
+	for offset := int32(0); offset < 25; offset += 10 {
+		res, _ := svc.ListUsers(ctx, &pb.ListUsersRequest{Limit: 10, Offset: offset})
+		for _, u := range res.GetUsers() {
+			if seen[u.GetId()] {
+				t.Fatalf("user %s listed twice", u.GetId())
+			}
+			seen[u.GetId()] = true
+		}
+	}
+	if len(seen) != 25 {
+		t.Fatalf("paged over %d users, want 25", len(seen))
+	}
+}
+
+func TestGetUserByEmailAndUsername(t *testing.T) {
+	ctx := context.Background()
+	svc := NewService(newMemRepo())
+	uid := newUser(t, svc, "ana")
+	newUser(t, svc, "bob")
+
+	byEmail, err := svc.GetUserByEmail(ctx, &pb.GetUserByEmailRequest{Email: "ana@test.com"})
+	if err != nil || byEmail.GetUser().GetId() != uid || byEmail.GetUser().GetUsername() != "ana" {
+		t.Fatalf("by email: %v %v", byEmail, err)
+	}
+	byName, err := svc.GetUserByUsername(ctx, &pb.GetUserByUsernameRequest{Username: "ana"})
+	if err != nil || byName.GetUser().GetId() != uid || byName.GetUser().GetEmail() != "ana@test.com" {
+		t.Fatalf("by username: %v %v", byName, err)
+	}
+
+	if _, err := svc.GetUserByEmail(ctx, &pb.GetUserByEmailRequest{Email: "nobody@test.com"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown email: err=%v, expected NotFound", err)
+	}
+	if _, err := svc.GetUserByUsername(ctx, &pb.GetUserByUsernameRequest{Username: "nobody"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("unknown username: err=%v, expected NotFound", err)
+	}
+	if _, err := svc.GetUserByEmail(ctx, &pb.GetUserByEmailRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("empty email: err=%v, expected InvalidArgument", err)
+	}
+	if _, err := svc.GetUserByUsername(ctx, &pb.GetUserByUsernameRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("empty username: err=%v, expected InvalidArgument", err)
+	}
+}
+
+func TestGetInfo_InProcess(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs := grpc.NewServer()
+	pb.RegisterUserServiceServer(gs, NewService(newMemRepo()))
+	go func() { _ = gs.Serve(lis) }()
+	defer gs.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	info, err := pb.NewUserServiceClient(conn).GetInfo(ctx, &pb.GetInfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.GetVersion() != "dev" || info.GetCommit() != "unknown" || info.GetBuildTime() != "unknown" {
+		t.Fatalf("info=%v, expected the unstamped dev defaults", info)
+	}
+}
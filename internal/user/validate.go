@@ -0,0 +1,40 @@
+package user
+
+import (
+	"errors"
+	"net/mail"
+	"unicode"
+)
+
+var (
+	ErrInvalidEmail = errors.New("invalid email")
+	ErrWeakPassword = errors.New("password must have at least 8 characters, with letters and digits")
+)
+
+const minPasswordLen = 8
+
+// ValidateEmail accepts a bare address (no display name), e.g. "ana@example.com".
+func ValidateEmail(email string) error {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+// ValidatePassword requires minPasswordLen characters mixing letters and digits.
+func ValidatePassword(plain string) error {
+	var letter, digit bool
+	for _, r := range plain {
+		switch {
+		case unicode.IsLetter(r):
+			letter = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if len([]rune(plain)) < minPasswordLen || !letter || !digit {
+		return ErrWeakPassword
+	}
+	return nil
+}